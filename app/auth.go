@@ -4,11 +4,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"time"
 
-	"github.com/AmirSoleimani/VoucherCodeGenerator/vcgen"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	fb "github.com/huandu/facebook"
@@ -74,6 +74,9 @@ func (e *External) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := user.ID
+	e.claimDeviceProgressForUser(
+		r.Context(), userID, r.Context().Value("device_unique_id").(string), ProgressClaimSource_Login,
+	)
 	user, err = e.GetUserByID(user.ID, r.Context().Value("device_unique_id").(string))
 	if err != nil {
 		e.writeError(
@@ -164,6 +167,9 @@ func (e *External) HandleSocialLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := user.ID
+	e.claimDeviceProgressForUser(
+		r.Context(), userID, r.Context().Value("device_unique_id").(string), ProgressClaimSource_SocialLogin,
+	)
 	user, err = e.GetUserByID(user.ID, r.Context().Value("device_unique_id").(string))
 	if err != nil {
 		e.writeError(
@@ -200,6 +206,14 @@ func (e *External) HandleSocialSignUp(w http.ResponseWriter, r *http.Request) {
 	accessToken := mux.Vars(r)["access_token"]
 	accessSecret := mux.Vars(r)["access_secret"]
 
+	// the referral code and invite token come in the body like for HandleSignUp,
+	// the rest is from the social network
+	signUp := &NewUser{}
+	if err := json.NewDecoder(r.Body).Decode(signUp); err != nil && err != io.EOF {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+
 	var socialNetwork SocialNetwork
 	socialNetwork.Scan(mux.Vars(r)["social_network"])
 
@@ -299,7 +313,7 @@ func (e *External) HandleSocialSignUp(w http.ResponseWriter, r *http.Request) {
 		Password:     string(hashedPassword),
 		FirstName:    firstName,
 		LastName:     lastName,
-		ReferralCode: "",
+		ReferralCode: signUp.ReferralCode,
 	})
 	if err != nil {
 		l.Error("creating player")
@@ -311,6 +325,40 @@ func (e *External) HandleSocialSignUp(w http.ResponseWriter, r *http.Request) {
 	}
 	l2 := l.WithField("user_id", user.ID)
 
+	// while the waitlist gate is up only invited people can sign up
+	if ok, err := e.redeemWaitlistInvite(tx, signUp.InviteToken, user.ID); err != nil {
+		e.writeError(
+			w, r, http.StatusInternalServerError,
+			errors.Wrap(err, "redeeming waitlist invite"),
+		)
+		return
+	} else if !ok {
+		e.writeError(
+			w, r, http.StatusForbidden,
+			fmt.Errorf("a valid waitlist invite is needed to sign up"),
+		)
+		return
+	}
+
+	if reason, err := applyReferralCode(tx, signUp.ReferralCode, user.ID); err != nil {
+		e.writeError(
+			w, r, http.StatusInternalServerError,
+			errors.Wrap(err, "applying referral code"),
+		)
+		return
+	} else if reason != "" {
+		e.writeError(w, r, http.StatusBadRequest, errors.New(reason))
+		return
+	}
+
+	if err := queueWelcomeEmail(tx, user); err != nil {
+		e.writeError(
+			w, r, http.StatusInternalServerError,
+			errors.Wrap(err, "queueing welcome email"),
+		)
+		return
+	}
+
 	// generate and inject referral code for user
 	referralCode, err := GenerateReferralCode(tx, user.ID)
 	if err != nil {
@@ -344,6 +392,11 @@ func (e *External) HandleSocialSignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// attach anything done before signing up on this device
+	e.claimDeviceProgressForUser(
+		r.Context(), user.ID, r.Context().Value("device_unique_id").(string), ProgressClaimSource_SocialSignUp,
+	)
+
 	if err := e.createAndWriteJWTTokens(w, r, user, true); err != nil {
 		e.writeError(
 			w, r, http.StatusInternalServerError,
@@ -419,6 +472,40 @@ func (e *External) HandleSignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// while the waitlist gate is up only invited people can sign up
+	if ok, err := e.redeemWaitlistInvite(tx, newUser.InviteToken, user.ID); err != nil {
+		e.writeError(
+			w, r, http.StatusInternalServerError,
+			errors.Wrap(err, "redeeming waitlist invite"),
+		)
+		return
+	} else if !ok {
+		e.writeError(
+			w, r, http.StatusForbidden,
+			fmt.Errorf("a valid waitlist invite is needed to sign up"),
+		)
+		return
+	}
+
+	if reason, err := applyReferralCode(tx, newUser.ReferralCode, user.ID); err != nil {
+		e.writeError(
+			w, r, http.StatusInternalServerError,
+			errors.Wrap(err, "applying referral code"),
+		)
+		return
+	} else if reason != "" {
+		e.writeError(w, r, http.StatusBadRequest, errors.New(reason))
+		return
+	}
+
+	if err := queueWelcomeEmail(tx, user); err != nil {
+		e.writeError(
+			w, r, http.StatusInternalServerError,
+			errors.Wrap(err, "queueing welcome email"),
+		)
+		return
+	}
+
 	// commit changes
 	if err := tx.Commit(); err != nil {
 		e.writeError(
@@ -458,6 +545,11 @@ func (e *External) HandleSignUp(w http.ResponseWriter, r *http.Request) {
 		}).Error("unable to add user id to waitlist")
 	}
 
+	// attach anything done before signing up on this device
+	e.claimDeviceProgressForUser(
+		r.Context(), user.ID, r.Context().Value("device_unique_id").(string), ProgressClaimSource_SignUp,
+	)
+
 	if err := e.createAndWriteJWTTokens(w, r, user, true); err != nil {
 		e.writeError(
 			w, r, http.StatusInternalServerError,
@@ -657,50 +749,16 @@ func (e *External) HandleForgotPassword(w http.ResponseWriter, r *http.Request)
 
 	if token == "" {
 		// Generate password reset token
-		vcPrefix := vcgen.New(
-			&vcgen.Generator{
-				Count:   1,
-				Pattern: "######",
-			},
-		)
-		res, err := vcPrefix.Run()
+		token, err = GenerateUniqueCode(e.dao.DB, PasswordResetTokenFormat, func(code string) error {
+			return CreatePasswordReset(e.dao.DB, user.ID, code)
+		})
 		if err != nil {
-			e.writeError(
-				w, r, http.StatusInternalServerError,
-				fmt.Errorf(
-					"unable to generate password reset token",
-				),
-			)
-			return
-		}
-		if res == nil {
-			e.writeError(
-				w, r, http.StatusInternalServerError,
-				fmt.Errorf(
-					"no password reset tokens returned from library",
-				),
-			)
-			return
-		}
-		generatedRefCodes := *res
-		if len(generatedRefCodes) != 1 {
-			e.writeError(
-				w, r, http.StatusInternalServerError,
-				fmt.Errorf(
-					"invalid number of generated password reset tokens",
-				),
-			)
-			return
-		}
-		token = generatedRefCodes[0]
-
-		if err := CreatePasswordReset(e.dao.DB, user.ID, token); err != nil {
 			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "unable to create password reset token"))
 			return
 		}
 	}
 
-	m := NewMailer(e.log)
+	m := NewMailer(e.log, e.email, e.emailUnsubscribeKey, e.baseURL)
 	if err := m.SendForgotPassword(r.Context(), user.Email, token); err != nil {
 		e.writeError(w, r, http.StatusInternalServerError, err)
 		return
@@ -731,7 +789,8 @@ func (e *External) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reset, err := GetPasswordReset(e.dao.ReadDB, user.ID, pR.Token)
+	// reset tokens are typed in, tolerate a lower case keyboard
+	reset, err := GetPasswordReset(e.dao.ReadDB, user.ID, NormalizeCode(pR.Token))
 	if err != nil && err == sql.ErrNoRows {
 		e.writeError(
 			w, r, http.StatusBadRequest,
@@ -783,3 +842,13 @@ func (e *External) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
 func isActiveResetToken(r *PasswordReset) bool {
 	return !time.Now().After(r.CreatedAt.Add(passwordResetTokenExpiration))
 }
+
+// queueWelcomeEmail queues the email greeting a new user, it goes out with
+// the rest of the queue so sign ups don't wait on the mailer.
+func queueWelcomeEmail(q Q, user *User) error {
+	return CreateEmail(
+		q, &user.ID, user.Email, templateInfo[EmailType_Welcome].name,
+		HStoreMap{"FirstName": user.FirstName},
+		EmailType_Welcome, EmailStatus_Pending,
+	)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 
 	fb "github.com/huandu/facebook"
@@ -335,6 +336,12 @@ func TestHandleSocialSignUpTwitterHappyPath(t *testing.T) {
 		fmt.Sprintf("u.email = '%s'", email),
 		1,
 	)
+	// welcome email queued
+	f.ExpectRowCountWhere(
+		"ggwp.emails",
+		fmt.Sprintf("email_address = '%s' AND type = 'WELCOME' AND status = 'PENDING'", email),
+		1,
+	)
 	// auth headers set
 	f.ExpectAuthHeaders(rr)
 	// user obj returned
@@ -381,4 +388,57 @@ func TestHandleSignUpHappyPath(t *testing.T) {
 		fmt.Sprintf("u.email = '%s'", email),
 		1,
 	)
+
+	// welcome email queued
+	f.ExpectRowCountWhere(
+		"ggwp.emails",
+		fmt.Sprintf("email_address = '%s' AND type = 'WELCOME' AND status = 'PENDING'", email),
+		1,
+	)
+}
+
+func TestHandleForgotPassword(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	email := "forgetful.user@ggwpacademy.com"
+	f.GetAuthToken(email)
+
+	rr := f.UnAuthedRequest(http.MethodPost, "/user/password/forgotten", `{"email_address": "`+email+`"}`)
+	f.ExpectStatus(rr, http.StatusOK)
+
+	// asking again sends the same token
+	rr = f.UnAuthedRequest(http.MethodPost, "/user/password/forgotten", `{"email_address": "`+email+`"}`)
+	f.ExpectStatus(rr, http.StatusOK)
+
+	sent := f.Email.EmailsTo(email)
+	f.ExpectDeepEq(len(sent), 2)
+	f.ExpectDeepEq(sent[0].Subject, "Reset your password")
+	f.ExpectDeepEq(sent[0].Text, sent[1].Text)
+	var token string
+	f.ExpectNoError(f.DAO.DB.Get(&token, `SELECT token FROM ggwp.user_password_reset`))
+	f.ExpectDeepEq(strings.Contains(sent[0].Text, token), true)
+	f.ExpectDeepEq(strings.Contains(sent[0].HTML, token), true)
+	f.ExpectDeepEq(external.PasswordResetTokenFormat.Valid(token), true)
+
+	// unknown addresses get nothing, without telling
+	rr = f.UnAuthedRequest(http.MethodPost, "/user/password/forgotten", `{"email_address": "nobody@ggwpacademy.com"}`)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectDeepEq(len(f.Email.Emails()), 2)
+
+	rr = f.UnAuthedRequest(
+		http.MethodPost,
+		"/user/password/reset",
+		fmt.Sprintf(
+			`{"email_address": %q, "password_reset_token": %q, "new_password": "new-password"}`,
+			email, strings.ToLower(token),
+		),
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.UnAuthedRequest(
+		http.MethodPost,
+		"/user/login",
+		fmt.Sprintf(`{"email": %q, "password": "new-password"}`, email),
+	)
+	f.ExpectStatus(rr, http.StatusOK)
 }
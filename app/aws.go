@@ -68,7 +68,26 @@ func (a *AWS) UploadFileToS3(
 	size := fileHeader.Size
 	buffer := make([]byte, size)
 	file.Read(buffer)
-	filePath := aws.String(generateS3FilePath(createdFile))
+
+	return a.UploadFile(createdFile, buffer, fileHeader.Header.Get("Content-Type"))
+}
+
+func (a *AWS) UploadFile(
+	createdFile *File,
+	buffer []byte,
+	contentType string,
+) (string, error) {
+	key := generateS3FilePath(createdFile)
+	if err := a.UploadFileAt(key, buffer, contentType); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// UploadFileAt uploads a file under the given key.
+func (a *AWS) UploadFileAt(key string, buffer []byte, contentType string) error {
+	filePath := aws.String(key)
 
 	// config settings: this is where you choose the bucket,
 	// filename, content-type and storage class of the file
@@ -80,12 +99,26 @@ func (a *AWS) UploadFileToS3(
 			Key:           filePath,
 			ACL:           aws.String(s3.BucketCannedACLPrivate), // could be private if you want it to be access by only authorized users
 			Body:          bytes.NewReader(buffer),
-			ContentLength: aws.Int64(int64(size)),
-			ContentType:   aws.String(fileHeader.Header.Get("Content-Type")),
+			ContentLength: aws.Int64(int64(len(buffer))),
+			ContentType:   aws.String(contentType),
+		},
+	); err != nil {
+		return errors.Wrap(err, "uploading file to s3")
+	}
+
+	return nil
+}
+
+func (a *AWS) DeleteFile(key string) error {
+	a.log.Infof("deleting file from s3 - %s", key)
+	if _, err := s3.New(a.session).DeleteObject(
+		&s3.DeleteObjectInput{
+			Bucket: aws.String(os.Getenv("AWS_BUCKET")),
+			Key:    aws.String(key),
 		},
 	); err != nil {
-		return "", errors.Wrap(err, "uploading file to s3")
+		return errors.Wrap(err, "deleting file from s3")
 	}
 
-	return *filePath, nil
+	return nil
 }
@@ -0,0 +1,148 @@
+package external
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+func (e *External) calendarFeedURL(token string) string {
+	return e.apiURL(fmt.Sprintf("/calendar/%s.ics", token))
+}
+
+// UserCalendar gives the calendar of a user: the periods of their recurring
+// goals, the deadlines of their goals and the modules about to unlock. Goals
+// are laid out in the user's timezone.
+func UserCalendar(goals []*UserGoal, unlocks []*ModuleUnlock, loc *time.Location, now time.Time) *Calendar {
+	c := &Calendar{Name: "GGWP Academy", Events: []*CalendarEvent{}}
+	for _, g := range goals {
+		c.Events = append(c.Events, goalCalendarEvents(g, loc, now)...)
+	}
+	for _, m := range unlocks {
+		unlocksAt := m.UnlocksAt
+		c.Events = append(c.Events, &CalendarEvent{
+			UID:     calendarUID("module-unlock", m.ModuleID),
+			Summary: "New module: " + m.Name,
+			Start:   &unlocksAt,
+			Stamp:   now,
+		})
+	}
+	return c
+}
+
+// goalCalendarEvents gives the events of a goal still being worked on. A
+// recurring goal repeats its period until its deadline.
+func goalCalendarEvents(g *UserGoal, loc *time.Location, now time.Time) []*CalendarEvent {
+	if !g.IsActive || (g.CompletedAt != nil && g.CompletedAt.Valid) {
+		return nil
+	}
+
+	stamp := now
+	if g.UpdatedAt != nil && g.UpdatedAt.Valid {
+		stamp = g.UpdatedAt.Time
+	}
+	deadline := goalDay(g.Deadline, loc)
+
+	events := []*CalendarEvent{}
+	if rate := GoalRate(g.Rate); rate != "" {
+		created := FromTime(stamp.In(loc))
+		if d := goalDay(g.CreatedAt, loc); d != nil {
+			created = *d
+		}
+		start, end := goalPeriodOf(g, loc, created)
+
+		rrule, per := "FREQ=DAILY", "day"
+		if rate == GoalRate_Weekly {
+			rrule, per = "FREQ=WEEKLY", "week"
+		}
+		if deadline != nil {
+			rrule += icalUntil(*deadline)
+		}
+		events = append(events, &CalendarEvent{
+			UID:         calendarUID("goal", g.ID),
+			Summary:     g.Description,
+			Description: fmt.Sprintf("Target of %s per %s", g.Value, per),
+			Date:        &start,
+			EndDate:     end,
+			RRule:       rrule,
+			Stamp:       stamp,
+		})
+	}
+	if deadline != nil {
+		events = append(events, &CalendarEvent{
+			UID:     calendarUID("goal-deadline", g.ID),
+			Summary: "Goal deadline: " + g.Description,
+			Date:    deadline,
+			Stamp:   stamp,
+		})
+	}
+
+	return events
+}
+
+func (e *External) HandleGetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	f, err := EnsureCalendarFeed(e.dao.DB, r.Context().Value("user_id").(int))
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting calendar feed"))
+		return
+	}
+	f.URL = e.calendarFeedURL(f.Token)
+
+	e.returnJSON(w, f)
+}
+
+// HandleRegenerateCalendarFeedToken replaces the token of the calendar feed,
+// calendars subscribed with the previous url stop receiving updates.
+func (e *External) HandleRegenerateCalendarFeedToken(w http.ResponseWriter, r *http.Request) {
+	token, err := newSecretToken()
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "generating token"))
+		return
+	}
+	f, err := SetCalendarFeedToken(e.dao.DB, r.Context().Value("user_id").(int), token)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "setting calendar feed token"))
+		return
+	}
+	f.URL = e.calendarFeedURL(f.Token)
+
+	e.returnJSON(w, f)
+}
+
+// HandleGetCalendar serves the calendar feed of a user to calendar apps, the
+// secret token in the url stands in for logging in.
+func (e *External) HandleGetCalendar(w http.ResponseWriter, r *http.Request) {
+	f, err := GetCalendarFeedByToken(e.dao.ReadDB, mux.Vars(r)["token"])
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown calendar"))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting calendar feed"))
+		return
+	}
+
+	timezone, err := GetUserTimezone(e.dao.ReadDB, f.UserID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting user timezone"))
+		return
+	}
+	goals, err := GetGoalsByUserID(e.dao.ReadDB, f.UserID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goals"))
+		return
+	}
+	now := time.Now()
+	unlocks, err := GetUpcomingModuleUnlocks(e.dao.ReadDB, now)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting module unlocks"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	w.Write(UserCalendar(goals, unlocks, UserLocation(timezone), now).Encode())
+}
@@ -0,0 +1,127 @@
+package external
+
+import "time"
+
+// EnsureCalendarFeed gives the calendar feed of a user, created with a new
+// token when they never asked for one.
+func EnsureCalendarFeed(q Q, userID int) (*CalendarFeed, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.calendar_feeds
+			(
+				user_id, token, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, NOW(), NOW()
+			)
+			ON CONFLICT (user_id) DO NOTHING
+		`,
+		userID,
+		token,
+	); err != nil {
+		return nil, err
+	}
+
+	var f CalendarFeed
+	if err := q.Get(
+		&f,
+		`
+			SELECT
+				user_id,
+				token,
+				updated_at
+			FROM
+				ggwp.calendar_feeds
+			WHERE
+				user_id = $1
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// GetCalendarFeedByToken gives the calendar feed a token belongs to.
+func GetCalendarFeedByToken(q Q, token string) (*CalendarFeed, error) {
+	var f CalendarFeed
+	if err := q.Get(
+		&f,
+		`
+			SELECT
+				user_id,
+				token,
+				updated_at
+			FROM
+				ggwp.calendar_feeds
+			WHERE
+				token = $1
+		`,
+		token,
+	); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// SetCalendarFeedToken creates the calendar feed of a user or replaces its
+// token, which revokes the previous one.
+func SetCalendarFeedToken(q Q, userID int, token string) (*CalendarFeed, error) {
+	var f CalendarFeed
+	if err := q.Get(
+		&f,
+		`
+			INSERT INTO ggwp.calendar_feeds
+			(
+				user_id, token, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, NOW(), NOW()
+			)
+			ON CONFLICT (user_id) DO UPDATE
+			SET
+				token = EXCLUDED.token,
+				updated_at = NOW()
+			RETURNING user_id, token, updated_at
+		`,
+		userID,
+		token,
+	); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// GetUpcomingModuleUnlocks gives the active modules which unlock after a time.
+func GetUpcomingModuleUnlocks(q Q, after time.Time) ([]*ModuleUnlock, error) {
+	var m []*ModuleUnlock
+	if err := q.Select(
+		&m,
+		`
+			SELECT
+				id module_id,
+				name,
+				unlocks_at
+			FROM
+				ggwp.modules
+			WHERE
+				is_active
+				AND unlocks_at > $1
+			ORDER BY unlocks_at, id
+		`,
+		after,
+	); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
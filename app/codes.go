@@ -0,0 +1,205 @@
+package external
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// CodeAlphabet is the characters codes are made of.
+type CodeAlphabet string
+
+const (
+	CodeAlphabet_Numeric CodeAlphabet = "0123456789"
+	// upper case letters and digits, without 0, 1, I, L and O which are easily
+	// mistaken for each other when read out or typed in
+	CodeAlphabet_Unambiguous CodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+)
+
+// CodeFormat describes the codes handed out to people: referral and waitlist
+// codes, and password reset tokens.
+type CodeFormat struct {
+	Prefix   string
+	Alphabet CodeAlphabet
+	// the number of random characters, not counting the check character
+	Length int
+	// appends a check character which catches typos
+	Checksum bool
+}
+
+var (
+	ReferralCodeFormat = CodeFormat{
+		Prefix: "WELC-", Alphabet: CodeAlphabet_Unambiguous, Length: 6, Checksum: true,
+	}
+	WaitlistCodeFormat = CodeFormat{
+		Prefix: "WAIT-", Alphabet: CodeAlphabet_Unambiguous, Length: 6, Checksum: true,
+	}
+	PasswordResetTokenFormat = CodeFormat{
+		Alphabet: CodeAlphabet_Unambiguous, Length: 9, Checksum: true,
+	}
+)
+
+// the most codes generated for a single code before giving up on collisions
+var CodeMaxAttempts = 5
+
+// Generate gives a new random code. It uses crypto/rand as some codes, like
+// password reset tokens, stand in for credentials.
+func (f CodeFormat) Generate() (string, error) {
+	n := big.NewInt(int64(len(f.Alphabet)))
+	body := make([]byte, f.Length)
+	for i := range body {
+		c, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return "", errors.Wrap(err, "reading random")
+		}
+		body[i] = f.Alphabet[c.Int64()]
+	}
+
+	code := string(body)
+	if f.Checksum {
+		check, _ := f.Alphabet.checkCharacter(code)
+		code += string(check)
+	}
+	return f.Prefix + code, nil
+}
+
+// Valid tells whether a code could have been generated with the format, which
+// with a checksum tells typos apart from codes which don't exist.
+func (f CodeFormat) Valid(code string) bool {
+	if !strings.HasPrefix(code, f.Prefix) {
+		return false
+	}
+	body := strings.TrimPrefix(code, f.Prefix)
+
+	length := f.Length
+	if f.Checksum {
+		length++
+	}
+	if len(body) != length {
+		return false
+	}
+	for i := 0; i < len(body); i++ {
+		if strings.IndexByte(string(f.Alphabet), body[i]) < 0 {
+			return false
+		}
+	}
+	if !f.Checksum {
+		return true
+	}
+
+	check, ok := f.Alphabet.checkCharacter(body[:f.Length])
+	return ok && check == body[f.Length]
+}
+
+// Mistyped tells whether a code has the shape of the format without being
+// valid, so is most likely a typo of a real code rather than a code made some
+// other way, like a vanity code.
+func (f CodeFormat) Mistyped(code string) bool {
+	if !f.Checksum || !strings.HasPrefix(code, f.Prefix) {
+		return false
+	}
+	if len(code) != len(f.Prefix)+f.Length+1 {
+		return false
+	}
+	return !f.Valid(code)
+}
+
+// NormalizeCode tidies up a code typed in by someone, codes are upper case.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// checkCharacter gives the check character of s, a weighted sum of its
+// characters modulo the size of the alphabet. With a prime sized alphabet,
+// like the unambiguous one, it catches any single mistyped character and any
+// two swapped characters.
+func (a CodeAlphabet) checkCharacter(s string) (byte, bool) {
+	sum := 0
+	for i := 0; i < len(s); i++ {
+		p := strings.IndexByte(string(a), s[i])
+		if p < 0 {
+			return 0, false
+		}
+		sum += (i + 1) * p
+	}
+
+	return a[sum%len(a)], true
+}
+
+// GenerateUniqueCode generates codes until store saves one without violating
+// a unique constraint, up to CodeMaxAttempts times. Inside a transaction every
+// attempt runs in a savepoint so a collision doesn't abort the transaction.
+func GenerateUniqueCode(q Q, f CodeFormat, store func(code string) error) (string, error) {
+	for attempt := 1; ; attempt++ {
+		code, err := f.Generate()
+		if err != nil {
+			return "", errors.Wrap(err, "generating code")
+		}
+
+		err = withSavepoint(q, "generate_unique_code", func() error {
+			return store(code)
+		})
+		if err == nil {
+			return code, nil
+		}
+		if !isUniqueViolation(err) {
+			return "", err
+		}
+		if attempt >= CodeMaxAttempts {
+			return "", errors.Wrapf(err, "no unique code after %d attempts", attempt)
+		}
+	}
+}
+
+// randomBytes gives n bytes from crypto/rand, for tokens and ids which must
+// not be guessed.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newSecretToken gives a random token for links which work without logging in.
+func newSecretToken() (string, error) {
+	b, err := randomBytes(24)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// withSavepoint runs f, inside a transaction a failing f only rolls back what
+// it did.
+func withSavepoint(q Q, name string, f func() error) error {
+	if _, ok := q.(*sqlx.Tx); !ok {
+		return f()
+	}
+
+	if _, err := q.Exec(fmt.Sprintf("SAVEPOINT %s", name)); err != nil {
+		return errors.Wrap(err, "creating savepoint")
+	}
+	if err := f(); err != nil {
+		if _, rbErr := q.Exec(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name)); rbErr != nil {
+			return errors.Wrap(rbErr, "rolling back to savepoint")
+		}
+		return err
+	}
+	if _, err := q.Exec(fmt.Sprintf("RELEASE SAVEPOINT %s", name)); err != nil {
+		return errors.Wrap(err, "releasing savepoint")
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
@@ -0,0 +1,140 @@
+package external_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+	"github.com/lib/pq"
+)
+
+func TestCodeFormat(t *testing.T) {
+	h := TestHelper{t}
+
+	formats := []external.CodeFormat{
+		external.ReferralCodeFormat,
+		external.WaitlistCodeFormat,
+		external.PasswordResetTokenFormat,
+		{Prefix: "PIN-", Alphabet: external.CodeAlphabet_Numeric, Length: 4},
+	}
+	for _, f := range formats {
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			code, err := f.Generate()
+			h.ExpectNoError(err)
+			if !strings.HasPrefix(code, f.Prefix) {
+				t.Fatalf("code %q is missing prefix %q", code, f.Prefix)
+			}
+			if !f.Valid(code) {
+				t.Fatalf("generated code %q is not valid", code)
+			}
+			seen[code] = true
+		}
+		if len(seen) < 90 {
+			t.Fatalf("only %d distinct codes out of 100 for %q", len(seen), f.Prefix)
+		}
+	}
+}
+
+func TestCodeFormatCatchesTypos(t *testing.T) {
+	h := TestHelper{t}
+
+	f := external.ReferralCodeFormat
+	code, err := f.Generate()
+	h.ExpectNoError(err)
+	body := code[len(f.Prefix):]
+
+	// every single character mistyped is caught
+	for i := 0; i < len(body); i++ {
+		for _, c := range external.CodeAlphabet_Unambiguous {
+			if byte(c) == body[i] {
+				continue
+			}
+			typo := f.Prefix + body[:i] + string(c) + body[i+1:]
+			if f.Valid(typo) {
+				t.Fatalf("typo %q of %q is valid", typo, code)
+			}
+		}
+	}
+
+	// as is any swap of two characters
+	for i := 0; i < len(body); i++ {
+		for j := i + 1; j < len(body); j++ {
+			if body[i] == body[j] {
+				continue
+			}
+			swapped := []byte(body)
+			swapped[i], swapped[j] = swapped[j], swapped[i]
+			if f.Valid(f.Prefix + string(swapped)) {
+				t.Fatalf("swap %q of %q is valid", swapped, code)
+			}
+		}
+	}
+
+	// ambiguous characters are never part of a code
+	h.ExpectDeepEq(f.Valid(f.Prefix+"0"+body[1:]), false)
+	h.ExpectDeepEq(f.Valid(code[:len(code)-1]), false)
+	h.ExpectDeepEq(f.Valid("WAIT-"+body), false)
+	h.ExpectDeepEq(external.NormalizeCode(" welc-abc "), "WELC-ABC")
+
+	// only codes shaped like generated ones can be typos of them
+	h.ExpectDeepEq(f.Mistyped(f.Prefix+"0"+body[1:]), true)
+	h.ExpectDeepEq(f.Mistyped(code), false)
+	h.ExpectDeepEq(f.Mistyped("WELC-123456"), false)
+	h.ExpectDeepEq(f.Mistyped("WELC-SUMMERSALE"), false)
+	h.ExpectDeepEq(f.Mistyped("WAIT-"+body), false)
+}
+
+func TestGenerateUniqueCodeRetriesCollisions(t *testing.T) {
+	h := TestHelper{t}
+
+	var tried []string
+	code, err := external.GenerateUniqueCode(nil, external.WaitlistCodeFormat, func(code string) error {
+		tried = append(tried, code)
+		if len(tried) < 3 {
+			return &pq.Error{Code: "23505"}
+		}
+		return nil
+	})
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(len(tried), 3)
+	h.ExpectDeepEq(code, tried[2])
+
+	tried = nil
+	_, err = external.GenerateUniqueCode(nil, external.WaitlistCodeFormat, func(code string) error {
+		tried = append(tried, code)
+		return &pq.Error{Code: "23505"}
+	})
+	h.ExpectErrorContains(err, "no unique code after 5 attempts")
+	h.ExpectDeepEq(len(tried), external.CodeMaxAttempts)
+
+	// anything but a collision is not retried
+	tried = nil
+	_, err = external.GenerateUniqueCode(nil, external.WaitlistCodeFormat, func(code string) error {
+		tried = append(tried, code)
+		return fmt.Errorf("connection refused")
+	})
+	h.ExpectErrorContains(err, "connection refused")
+	h.ExpectDeepEq(len(tried), 1)
+}
+
+func TestGeneratedCodesAreUnique(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	first := f.GetAuthToken("first-reset@ggwpacademy.com")
+	second := f.GetAuthToken("second-reset@ggwpacademy.com")
+
+	// GenerateUniqueCode relies on the database turning duplicates down
+	f.ExpectNoError(external.CreatePasswordReset(f.DAO.DB, first.UserID, "RSET-ABCD2345X"))
+	f.ExpectErrorContains(
+		external.CreatePasswordReset(f.DAO.DB, second.UserID, "RSET-ABCD2345X"),
+		"user_password_reset_token_idx",
+	)
+	f.ExpectNoError(external.CreateReferralCode(f.DAO.DB, first.UserID, "WELC-123456"))
+	f.ExpectErrorContains(
+		external.CreateReferralCode(f.DAO.DB, second.UserID, "WELC-123456"),
+		"referral_codes_referral_code_idx",
+	)
+}
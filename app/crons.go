@@ -12,13 +12,43 @@ var (
 	MISSING_WAITLIST_CODES_SLEEP = 1 * time.Minute
 	QUEUE_WAITLIST_EMAILS_SLEEP  = 5 * time.Minute
 
-	SEND_EMAILS_SLEEP = 5 * time.Minute
+	PURGE_UNCONFIRMED_WAITLIST_SLEEP = 1 * time.Hour
+	UNCONFIRMED_WAITLIST_RETENTION   = 7 * 24 * time.Hour
+
+	COMPACT_PROGRESS_EVENTS_SLEEP = 10 * time.Minute
+	PROGRESS_EVENTS_RETENTION     = 24 * time.Hour
+
+	PUSH_XAPI_STATEMENTS_SLEEP = 1 * time.Minute
+	XAPI_PUSH_BATCH_SIZE       = 50
+	XAPI_PUSH_MAX_ATTEMPTS     = 10
+	XAPI_PUSH_BACKOFF          = 1 * time.Minute
+	XAPI_PUSH_MAX_BACKOFF      = 6 * time.Hour
+
+	QUEUE_STREAK_REMINDERS_SLEEP = 15 * time.Minute
+	CLOSE_GOAL_PERIODS_SLEEP     = 1 * time.Hour
+	QUEUE_GOAL_REMINDERS_SLEEP   = 15 * time.Minute
+
+	SEND_EMAILS_SLEEP      = 1 * time.Minute
+	EMAIL_SEND_BATCH_SIZE  = 100
+	EMAIL_SEND_CONCURRENCY = 5
+	EMAIL_SEND_TIMEOUT     = 1 * time.Minute
+	// on top of the time a batch can take to send, for the bookkeeping
+	EMAIL_PROCESSING_LEASE_MARGIN = 5 * time.Minute
+	EMAIL_SEND_MAX_ATTEMPTS       = 8
+	EMAIL_SEND_BACKOFF            = 1 * time.Minute
+	EMAIL_SEND_MAX_BACKOFF        = 6 * time.Hour
 )
 
 func (e *External) RunCrons() {
 	go e.processMissingReferralCodes()
 	go e.processMissingWaitlistCodes()
 	go e.queueWaitlistEmails()
+	go e.purgeUnconfirmedWaitlist()
+	go e.compactProgressEvents()
+	go e.pushXAPIStatements()
+	go e.queueStreakReminders()
+	go e.closeGoalPeriods()
+	go e.queueGoalReminders()
 
 	go e.sendEmails()
 }
@@ -66,91 +96,155 @@ func (e *External) processMissingWaitlistCodes() {
 	}
 }
 
-// queue waitlist emails (every 1 minute)
+// queue waitlist emails (every 5 minutes)
 func (e *External) queueWaitlistEmails() {
 	for {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		defer cancel()
-
-		tx, err := e.dao.GetTx(ctx)
+		queued, err := e.QueueWaitlistEmails(ctx)
+		cancel()
 		if err != nil {
-			e.log.Error("creating transaction")
-			return
+			e.log.WithError(err).Error("queueing waitlist emails")
 		}
-		defer tx.Rollback()
+		e.log.WithField("count", queued).Info("done queueing waitlist emails")
+
+		time.Sleep(QUEUE_WAITLIST_EMAILS_SLEEP)
+	}
+}
 
-		items, err := GetWaitlistItemsWithoutAQueuedEmail(tx)
+// delete waitlist entries never confirmed (every 1 hour)
+func (e *External) purgeUnconfirmedWaitlist() {
+	for {
+		purged, err := e.PurgeUnconfirmedWaitlist(time.Now())
 		if err != nil {
-			e.log.WithError(err).Error("getting waitlist items without a queued email")
-			return
-		}
-
-		e.log.WithField("count", len(items)).Info("starting to process emails not queued up for the waitlist")
-		for _, i := range items {
-			if err := CreateEmail(
-				tx,
-				nil,
-				i.EmailAddress,
-				// templateInfo[EmailType_Waitlist]["name"],
-				"waitlist",
-				HStoreMap{
-					"WaitlistCode": i.OwnerWaitlistCode,
-				},
-				EmailType_Waitlist,
-				EmailStatus_Pending,
-			); err != nil {
-				e.log.
-					WithField("email_address", i.EmailAddress).
-					WithError(err).
-					Error("creating email for waitlist user")
-			}
+			e.log.WithError(err).Error("purging unconfirmed waitlist")
 		}
+		e.log.WithField("count", purged).Info("done purging unconfirmed waitlist")
 
-		if err := tx.Commit(); err != nil {
-			e.log.Info("commiting changes")
-			return
+		time.Sleep(PURGE_UNCONFIRMED_WAITLIST_SLEEP)
+	}
+}
+
+// send queued emails (every 1 minute)
+func (e *External) sendEmails() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		claimed, err := e.SendPendingEmails(ctx)
+		cancel()
+		if err != nil {
+			e.log.WithError(err).Error("sending pending emails")
 		}
+		e.log.WithField("count", claimed).Info("done sending pending emails")
 
-		e.log.WithField("count", len(items)).Info("done processing emails for waitlist users not queued")
-		time.Sleep(QUEUE_WAITLIST_EMAILS_SLEEP)
+		// keep going while there is a backlog
+		if claimed < EMAIL_SEND_BATCH_SIZE {
+			time.Sleep(SEND_EMAILS_SLEEP)
+		}
 	}
 }
 
-// send emails (every 5 minute)
-func (e *External) sendEmails() {
+// fold any leftover progress events and drop folded ones (every 10 minutes)
+func (e *External) compactProgressEvents() {
 	for {
-		items, err := GetPendingEmails(e.dao.ReadDB)
+		keys, err := GetUnfoldedProgressEventKeys(e.dao.ReadDB)
 		if err != nil {
-			e.log.WithError(err).Error("getting pending emails")
-			return
-		}
-
-		e.log.WithField("count", len(items)).Info("starting to process unsent emails")
-		m := NewMailer(e.log)
-		for _, i := range items {
-			l := e.log.
-				WithFields(
-					logrus.Fields{
-						"email_address": i.EmailAddress,
-						"type":          i.Type,
-					},
-				)
-			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-			defer cancel()
-
-			l.Info("sending")
-			if err := m.SendEmail(ctx, *i); err != nil {
-				l.WithError(err).Error("sending email for user")
+			e.log.WithError(err).Error("getting unfolded progress event keys")
+		}
+
+		e.log.WithField("count", len(keys)).Info("starting to fold pending progress events")
+		for _, k := range keys {
+			l := e.log.WithFields(logrus.Fields{
+				"user_id":          k.UserID.Int64,
+				"device_unique_id": k.DeviceUniqueID.String,
+				"module_file_id":   k.ModuleFileID,
+			})
+			tx, err := e.dao.GetTx(context.Background())
+			if err != nil {
+				l.WithError(err).Error("creating transaction")
 				continue
 			}
-			l.Info("marking as sent")
-			if err := MarkEmailAsSent(e.dao.DB, i.ID); err != nil {
-				l.WithError(err).Error("marking email as sent")
+			if _, err := e.foldModuleFileProgress(tx, k.UserID, k.DeviceUniqueID, k.ModuleFileID); err != nil {
+				l.WithError(err).Error("folding progress events")
+				tx.Rollback()
+				continue
+			}
+			if err := tx.Commit(); err != nil {
+				l.WithError(err).Error("commiting folded progress events")
 			}
-			l.Info("finished processing")
 		}
 
-		e.log.Info("done processing unsent emails")
-		time.Sleep(SEND_EMAILS_SLEEP)
+		deleted, err := DeleteFoldedProgressEvents(e.dao.DB, time.Now().Add(-PROGRESS_EVENTS_RETENTION))
+		if err != nil {
+			e.log.WithError(err).Error("deleting folded progress events")
+		}
+		e.log.WithField("deleted", deleted).Info("done compacting progress events")
+
+		time.Sleep(COMPACT_PROGRESS_EVENTS_SLEEP)
+	}
+}
+
+// push pending xapi statements to the external lrs, if one is configured (every 1 minute)
+func (e *External) pushXAPIStatements() {
+	if e.lrs == nil {
+		e.log.Info("no lrs configured, not pushing xapi statements")
+		return
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		pushed, err := e.PushXAPIStatements(ctx)
+		cancel()
+		if err != nil {
+			e.log.WithError(err).Error("pushing xapi statements")
+		}
+
+		// keep going while there is a backlog
+		if pushed < XAPI_PUSH_BATCH_SIZE {
+			time.Sleep(PUSH_XAPI_STATEMENTS_SLEEP)
+		}
+	}
+}
+
+// queue emails for streaks about to be lost (every 15 minutes)
+func (e *External) queueStreakReminders() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		queued, err := e.QueueStreakReminders(ctx, time.Now())
+		cancel()
+		if err != nil {
+			e.log.WithError(err).Error("queueing streak reminders")
+		}
+		e.log.WithField("count", queued).Info("done queueing streak reminders")
+
+		time.Sleep(QUEUE_STREAK_REMINDERS_SLEEP)
+	}
+}
+
+// record the ended periods of recurring goals (every 1 hour)
+func (e *External) closeGoalPeriods() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		closed, err := e.CloseGoalPeriods(ctx, time.Now())
+		cancel()
+		if err != nil {
+			e.log.WithError(err).Error("closing goal periods")
+		}
+		e.log.WithField("count", closed).Info("done closing goal periods")
+
+		time.Sleep(CLOSE_GOAL_PERIODS_SLEEP)
+	}
+}
+
+// queue reminders of goals due soon or periods about to close (every 15 minutes)
+func (e *External) queueGoalReminders() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		queued, err := e.QueueGoalReminders(ctx, time.Now())
+		cancel()
+		if err != nil {
+			e.log.WithError(err).Error("queueing goal reminders")
+		}
+		e.log.WithField("count", queued).Info("done queueing goal reminders")
+
+		time.Sleep(QUEUE_GOAL_REMINDERS_SLEEP)
 	}
 }
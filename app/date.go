@@ -116,6 +116,11 @@ func (d Date) AddDays(days int) Date {
 	return d + Date(days)
 }
 
+// StartOfWeek gives the date that is the Monday of the current week.
+func (d Date) StartOfWeek() Date {
+	return d - Date((int(d.Weekday())+6)%7)
+}
+
 // StartOfMonth gives the date that is the 1st day of the current month.
 func (d Date) StartOfMonth() Date {
 	t := d.Time()
@@ -223,3 +228,73 @@ func (d *Date) Scan(src interface{}) error {
 func (d Date) Value() (driver.Value, error) {
 	return d.String(), nil
 }
+
+// ClockTime represents a time of day, without any date or timezone. It's
+// stored as the number of minutes since midnight.
+type ClockTime int
+
+const minutesPerDay = 24 * 60
+
+// ClockTimeOf gives the time of day of a time.Time, in its location.
+func ClockTimeOf(t time.Time) ClockTime {
+	return ClockTime(t.Hour()*60 + t.Minute())
+}
+
+// ParseClockTime creates a ClockTime from its HH:MM representation.
+func ParseClockTime(str string) (ClockTime, error) {
+	t, err := time.Parse("15:04", str)
+	if err != nil {
+		return 0, err
+	}
+	return ClockTimeOf(t), nil
+}
+
+// Valid reports whether the time is within a day.
+func (c ClockTime) Valid() bool {
+	return c >= 0 && c < minutesPerDay
+}
+
+// Between reports whether the time is within [start, end), a range going past
+// midnight when end is before start.
+func (c ClockTime) Between(start, end ClockTime) bool {
+	if start <= end {
+		return c >= start && c < end
+	}
+	return c >= start || c < end
+}
+
+// String returns the HH:MM representation.
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", int(c)/60, int(c)%60)
+}
+
+// MarshalJSON marshals the time into a JSON string in HH:MM format.
+func (c ClockTime) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + c.String() + `"`), nil
+}
+
+// UnmarshalJSON unmarshals a JSON string in the HH:MM format into a time.
+func (c *ClockTime) UnmarshalJSON(p []byte) error {
+	if len(p) < 2 || p[0] != '"' || p[len(p)-1] != '"' {
+		return fmt.Errorf("could not unmarshal JSON into ClockTime: value is not a string")
+	}
+	var err error
+	*c, err = ParseClockTime(string(p)[1 : len(p)-1])
+	return err
+}
+
+// Scan implements the sql.Scanner interface, reading minutes since midnight.
+func (c *ClockTime) Scan(src interface{}) error {
+	m, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("can not scan as ClockTime: %T", src)
+	}
+	*c = ClockTime(m)
+	return nil
+}
+
+// Value implements the driver.Valuer interface, sending minutes since
+// midnight.
+func (c ClockTime) Value() (driver.Value, error) {
+	return int64(c), nil
+}
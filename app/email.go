@@ -2,45 +2,278 @@ package external
 
 import (
 	"context"
+	"database/sql"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
+const (
+	failedEmailsDefaultLimit = 100
+	failedEmailsMaxLimit     = 1000
+)
+
+// HandlePreviewEmail renders an email type with sample data for the browser,
+// the html by default or the text with format=text.
 func (e *External) HandlePreviewEmail(w http.ResponseWriter, r *http.Request) {
 	t := EmailType(strings.ToUpper(mux.Vars(r)["type"]))
-	if t == "" {
-		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid email type: %s", t))
+	if _, ok := templateInfo[t]; !ok {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid email type: %s", string(t)))
 		return
 	}
 
-	userID := r.Context().Value("user_id").(int)
-	if userID <= 0 {
-		e.writeError(w, r, http.StatusForbidden, fmt.Errorf("missing user_id in context"))
+	rendered, err := RenderEmailPreview(t)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "rendering %s preview", t))
 		return
 	}
 
-	user, err := GetUserByID(e.dao.ReadDB, userID)
-	if err != nil {
-		e.writeError(w, r, http.StatusForbidden, errors.Wrapf(err, "unknown user"))
+	w.Header().Set("X-Email-Subject", rendered.Subject)
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, rendered.Text)
 		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, previewHTML(rendered))
+}
+
+// previewHTML swaps the cid: references of an email for data URIs, as
+// browsers only know about inlines inside an email.
+func previewHTML(m *RenderedEmail) string {
+	html := m.HTML
+	for _, i := range m.Inlines {
+		html = strings.Replace(
+			html,
+			"cid:"+i.Name,
+			fmt.Sprintf(
+				"data:%s;base64,%s",
+				mime.TypeByExtension(path.Ext(i.Name)),
+				base64.StdEncoding.EncodeToString(i.Content),
+			),
+			-1,
+		)
+	}
+	return html
+}
 
-	m := NewMailer(e.log)
-	switch t {
+// refreshEmailVars fills in the vars of a queued email which have to be
+// current when it goes out, like the place in the waitlist.
+func refreshEmailVars(q Q, m *Email) error {
+	switch m.Type {
 	case EmailType_Waitlist:
-		code := "FAKE-123456"
-		if err := m.SendWaitlistEmail(context.Background(), user.Email, code); err != nil {
-			e.writeError(w, r, http.StatusInternalServerError, err)
+		s, err := GetWaitlistStatus(q, m.TemplateVars["WaitlistCode"], waitlistReferralJump())
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "getting waitlist status")
+		}
+		if m.TemplateVars == nil {
+			m.TemplateVars = HStoreMap{}
+		}
+		m.TemplateVars["Position"] = strconv.Itoa(s.Position)
+	}
+
+	return nil
+}
+
+// emailProcessingLease gives how long a batch of emails is claimed for, longer
+// than sending the whole batch can take so no other sender claims them while
+// they're still being sent.
+func emailProcessingLease() time.Duration {
+	rounds := (EMAIL_SEND_BATCH_SIZE + EMAIL_SEND_CONCURRENCY - 1) / EMAIL_SEND_CONCURRENCY
+	return time.Duration(rounds)*EMAIL_SEND_TIMEOUT + EMAIL_PROCESSING_LEASE_MARGIN
+}
+
+// SendPendingEmails claims a batch of emails due to be sent and sends them,
+// EMAIL_SEND_CONCURRENCY at a time. It gives how many emails it claimed.
+func (e *External) SendPendingEmails(ctx context.Context) (int, error) {
+	if n, err := FailAbandonedEmails(e.dao.DB, EMAIL_SEND_MAX_ATTEMPTS); err != nil {
+		return 0, errors.Wrap(err, "failing abandoned emails")
+	} else if n > 0 {
+		e.log.WithField("count", n).Error("emails abandoned while sending ran out of attempts")
+	}
+
+	claimToken, err := newSecretToken()
+	if err != nil {
+		return 0, errors.Wrap(err, "generating claim token")
+	}
+	emails, err := ClaimPendingEmails(
+		e.dao.DB, claimToken, EMAIL_SEND_BATCH_SIZE, EMAIL_SEND_MAX_ATTEMPTS, emailProcessingLease(),
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "claiming pending emails")
+	}
+
+	m := NewMailer(e.log, e.email, e.emailUnsubscribeKey, e.baseURL)
+	sem := make(chan struct{}, EMAIL_SEND_CONCURRENCY)
+	wg := sync.WaitGroup{}
+	for _, i := range emails {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i *Email) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			e.sendClaimedEmail(ctx, m, claimToken, i)
+		}(i)
+	}
+	wg.Wait()
+
+	return len(emails), nil
+}
+
+// sendClaimedEmail sends an email, unless its address is suppressed, and
+// records how it went. A failed send is retried later until it runs out of
+// attempts.
+func (e *External) sendClaimedEmail(ctx context.Context, m *Mailer, claimToken string, i *Email) {
+	l := e.log.WithFields(logrus.Fields{
+		"email_id":      i.ID,
+		"email_address": i.EmailAddress,
+		"type":          i.Type,
+		"attempt":       i.Attempts,
+	})
+
+	reason, sendErr := emailSuppressedBecause(e.dao.DB, i)
+	if sendErr == nil && reason != "" {
+		if held, err := MarkEmailSuppressed(e.dao.DB, i.ID, claimToken, reason); err != nil {
+			l.WithError(err).Error("marking email as suppressed")
+			return
+		} else if !held {
+			l.Warn("lost the claim of a suppressed email")
 			return
 		}
-	default:
-		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("no way to handle preview for email template %s", t))
+		l.WithField("reason", reason).Info("not sending suppressed email")
+		return
+	}
+
+	if sendErr == nil {
+		sendErr = refreshEmailVars(e.dao.ReadDB, i)
+	}
+	if sendErr == nil {
+		sendCtx, cancel := context.WithTimeout(ctx, EMAIL_SEND_TIMEOUT)
+		sendErr = m.SendEmail(sendCtx, *i)
+		cancel()
+	}
+	if sendErr != nil {
+		status, held, err := MarkEmailSendFailed(
+			e.dao.DB, i.ID, claimToken, sendErr.Error(),
+			EMAIL_SEND_MAX_ATTEMPTS, EMAIL_SEND_BACKOFF, EMAIL_SEND_MAX_BACKOFF,
+		)
+		if err != nil {
+			l.WithError(err).Error("marking email as failed")
+		} else if !held {
+			// another sender claimed it since, its outcome stands
+			l.Warn("lost the claim of a failed email")
+		}
+		l.WithError(sendErr).WithField("status", status).Error("sending email")
+		return
+	}
+
+	if held, err := MarkEmailAsSent(e.dao.DB, i.ID, claimToken); err != nil {
+		// the lease runs out and the email goes out again, better than never
+		l.WithError(err).Error("marking email as sent")
+		return
+	} else if !held {
+		l.Warn("lost the claim of a sent email")
+		return
+	}
+	l.Info("sent email")
+}
+
+// emailSuppressedBecause gives why an email can't be sent, if it can't: its
+// address is on the suppression list or its user unsubscribed from its
+// category.
+func emailSuppressedBecause(q Q, i *Email) (string, error) {
+	s, err := GetEmailSuppression(q, i.EmailAddress)
+	if err == nil && s.Suppresses(i.Type) {
+		return fmt.Sprintf("address suppressed: %s", s.Reason), nil
+	} else if err != nil && err != sql.ErrNoRows {
+		return "", errors.Wrap(err, "getting email suppression")
+	}
+
+	category := templateInfo[i.Type].category
+	if category == EmailCategory_Transactional {
+		return "", nil
+	}
+	subscribed, err := IsSubscribedToEmailCategory(q, i.UserID, i.EmailAddress, category)
+	if err != nil {
+		return "", errors.Wrap(err, "getting email preferences")
+	}
+	if !subscribed {
+		return fmt.Sprintf("unsubscribed from %s emails", category), nil
+	}
+	return "", nil
+}
+
+// HandleGetFailedEmails gives the emails which ran out of attempts.
+func (e *External) HandleGetFailedEmails(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query().Get("limit"), failedEmailsDefaultLimit, failedEmailsMaxLimit)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid limit"))
+		return
+	}
+	offset, err := queryInt(r.URL.Query().Get("offset"), 0, math.MaxInt32)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid offset"))
+		return
+	}
+
+	emails, err := GetFailedEmails(e.dao.ReadDB, limit, offset)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting failed emails"))
+		return
+	}
+	if emails == nil {
+		emails = []*Email{}
+	}
+
+	e.returnJSON(w, emails)
+}
+
+// HandleRetryFailedEmail queues a failed email again, with all its attempts.
+func (e *External) HandleRetryFailedEmail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	found, err := RetryFailedEmail(e.dao.DB, id)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "retrying failed email"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown failed email: %d", id))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
+
+// HandleDiscardFailedEmail gives up on sending a failed email.
+func (e *External) HandleDiscardFailedEmail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	found, err := DiscardFailedEmail(e.dao.DB, id)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "discarding failed email"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown failed email: %d", id))
 		return
 	}
 
-	e.returnJSON(w, "email sent to your email address")
+	e.returnJSON(w, nil)
 }
@@ -1,5 +1,11 @@
 package external
 
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
 func CreateEmail(
 	q Q,
 	userID *int,
@@ -33,30 +39,109 @@ func CreateEmail(
 	return nil
 }
 
-func GetPendingEmails(
-	q Q,
-) ([]*Email, error) {
-	var e []*Email
-	if err := q.Select(
-		&e,
+// HasRecentEmail tells whether an email of a type to an address is yet to be
+// sent or was queued after since.
+func HasRecentEmail(q Q, emailAddress string, t EmailType, since time.Time) (bool, error) {
+	var recent bool
+	if err := q.Get(
+		&recent,
 		`
-			SELECT
-				id,
-				user_id,
-				email_address,
-				template_name,
-				template_vars,
-				type,
-				status,
-				created_at,
-				updated_at,
-				sent_at
+			SELECT EXISTS (
+				SELECT 1
+				FROM ggwp.emails
+				WHERE
+					lower(email_address) = lower($1)
+					AND type = $2
+					AND (
+						status IN ('PENDING', 'PROCESSING')
+						OR created_at > $3
+					)
+			)
+		`,
+		emailAddress,
+		t,
+		since,
+	); err != nil {
+		return false, err
+	}
+
+	return recent, nil
+}
+
+const emailColumns = `
+	id,
+	user_id,
+	email_address,
+	template_name,
+	template_vars,
+	type,
+	status,
+	attempts,
+	next_attempt_at,
+	last_error,
+	delivered_at,
+	opened_at,
+	open_count,
+	clicked_at,
+	click_count,
+	bounced_at,
+	complained_at,
+	unsubscribed_at,
+	created_at,
+	updated_at,
+	sent_at
+`
+
+func selectFromEmailsWhere(where string) string {
+	return fmt.Sprintf(
+		`
+			SELECT %s
 			FROM
 				ggwp.emails
-			WHERE
-				status = $1
+			%s
 		`,
+		emailColumns,
+		where,
+	)
+}
+
+// ClaimPendingEmails marks up to limit emails due to be sent as PROCESSING
+// for lease under claimToken, counting the attempt, and gives them. Rows
+// claimed by another sender are skipped, those it didn't finish with before
+// its lease ran out are claimed again while they have attempts left.
+func ClaimPendingEmails(q Q, claimToken string, limit, maxAttempts int, lease time.Duration) ([]*Email, error) {
+	var e []*Email
+	if err := q.Select(
+		&e,
+		fmt.Sprintf(
+			`
+				UPDATE ggwp.emails
+				SET
+					status = $3,
+					claim_token = $6,
+					attempts = attempts + 1,
+					processing_expires_at = NOW() + $2 * INTERVAL '1 second',
+					updated_at = NOW()
+				WHERE id IN (
+					SELECT id
+					FROM ggwp.emails
+					WHERE
+						(status = $4 AND next_attempt_at <= NOW())
+						OR (status = $3 AND processing_expires_at <= NOW() AND attempts < $5)
+					ORDER BY next_attempt_at, id
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING %s
+			`,
+			emailColumns,
+		),
+		limit,
+		lease.Seconds(),
+		EmailStatus_Processing,
 		EmailStatus_Pending,
+		maxAttempts,
+		claimToken,
 	); err != nil {
 		return nil, err
 	}
@@ -64,21 +149,229 @@ func GetPendingEmails(
 	return e, nil
 }
 
+// FailAbandonedEmails gives up on emails whose sender never finished with
+// them, once they've had maxAttempts.
+func FailAbandonedEmails(q Q, maxAttempts int) (int64, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.emails
+			SET
+				status = $2,
+				last_error = 'abandoned while sending',
+				claim_token = NULL,
+				processing_expires_at = NULL,
+				updated_at = NOW()
+			WHERE status = $3
+				AND processing_expires_at <= NOW()
+				AND attempts >= $1
+		`,
+		maxAttempts,
+		EmailStatus_Failed,
+		EmailStatus_Processing,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// MarkEmailAsSent records a claimed email was sent. It gives false when the
+// claim was lost, the email is someone else's to record then.
 func MarkEmailAsSent(
 	q Q,
 	id int,
-) error {
-	if _, err := q.Exec(
+	claimToken string,
+) (bool, error) {
+	res, err := q.Exec(
 		`
 			UPDATE ggwp.emails
-			SET status = $1, sent_at = NOW()
+			SET
+				status = $1,
+				sent_at = NOW(),
+				last_error = NULL,
+				claim_token = NULL,
+				processing_expires_at = NULL,
+				updated_at = NOW()
 			WHERE id = $2
+				AND status = $3
+				AND claim_token = $4
 		`,
 		EmailStatus_Sent,
 		id,
+		EmailStatus_Processing,
+		claimToken,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// MarkEmailSendFailed records a failed send of a claimed email. The email is
+// retried after a wait doubling with every attempt up to maxBackoff, and
+// FAILED once it has had maxAttempts. It gives the status the email ended up
+// with, or false when the claim was lost.
+func MarkEmailSendFailed(
+	q Q,
+	id int,
+	claimToken,
+	lastError string,
+	maxAttempts int,
+	backoff,
+	maxBackoff time.Duration,
+) (EmailStatus, bool, error) {
+	var status EmailStatus
+	if err := q.Get(
+		&status,
+		`
+			UPDATE ggwp.emails
+			SET
+				status = CASE WHEN attempts >= $3 THEN $6 ELSE $7 END,
+				last_error = $2,
+				next_attempt_at = NOW() + LEAST(
+					$4 * POWER(2, GREATEST(attempts - 1, 0)),
+					$5
+				) * INTERVAL '1 second',
+				claim_token = NULL,
+				processing_expires_at = NULL,
+				updated_at = NOW()
+			WHERE id = $1
+				AND status = $8
+				AND claim_token = $9
+			RETURNING status
+		`,
+		id,
+		lastError,
+		maxAttempts,
+		backoff.Seconds(),
+		maxBackoff.Seconds(),
+		EmailStatus_Failed,
+		EmailStatus_Pending,
+		EmailStatus_Processing,
+		claimToken,
+	); err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return status, true, nil
+}
+
+// GetFailedEmails gives the emails which ran out of attempts, most recently
+// failed first.
+func GetFailedEmails(q Q, limit, offset int) ([]*Email, error) {
+	var e []*Email
+	if err := q.Select(
+		&e,
+		selectFromEmailsWhere(
+			`
+				WHERE status = $1
+				ORDER BY updated_at DESC, id DESC
+				LIMIT $2
+				OFFSET $3
+			`,
+		),
+		EmailStatus_Failed,
+		limit,
+		offset,
 	); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return e, nil
+}
+
+// RetryFailedEmail queues a failed email again with a fresh set of attempts.
+func RetryFailedEmail(q Q, id int) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.emails
+			SET
+				status = $2,
+				attempts = 0,
+				next_attempt_at = NOW(),
+				updated_at = NOW()
+			WHERE id = $1
+				AND status = $3
+		`,
+		id,
+		EmailStatus_Pending,
+		EmailStatus_Failed,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// DiscardFailedEmail gives up on a failed email. It stays around so whatever
+// queued it doesn't queue it again.
+func DiscardFailedEmail(q Q, id int) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.emails
+			SET
+				status = $2,
+				updated_at = NOW()
+			WHERE id = $1
+				AND status = $3
+		`,
+		id,
+		EmailStatus_Discarded,
+		EmailStatus_Failed,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// MarkEmailSuppressed records a claimed email wasn't sent as its address is
+// on the suppression list or its user unsubscribed.
+func MarkEmailSuppressed(q Q, id int, claimToken, reason string) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.emails
+			SET
+				status = $2,
+				last_error = $3,
+				claim_token = NULL,
+				processing_expires_at = NULL,
+				updated_at = NOW()
+			WHERE id = $1
+				AND status = $4
+				AND claim_token = $5
+		`,
+		id,
+		EmailStatus_Suppressed,
+		reason,
+		EmailStatus_Processing,
+		claimToken,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
 }
@@ -0,0 +1,218 @@
+package external
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how old a webhook signature can be, older ones could be replayed
+var MAILGUN_WEBHOOK_MAX_AGE = 15 * time.Minute
+
+// the addresses events put on the suppression list
+var emailEventSuppressions = map[EmailEventType]EmailSuppressionReason{
+	EmailEventType_Bounced:      EmailSuppressionReason_Bounce,
+	EmailEventType_Complained:   EmailSuppressionReason_Complaint,
+	EmailEventType_Unsubscribed: EmailSuppressionReason_Unsubscribe,
+}
+
+func mailgunWebhookSigningKey() string {
+	return os.Getenv("MAIL_GUN_WEBHOOK_SIGNING_KEY")
+}
+
+// MailgunWebhook is the body of the webhooks Mailgun calls with delivery
+// events.
+type MailgunWebhook struct {
+	Signature MailgunSignature `json:"signature"`
+	EventData MailgunEventData `json:"event-data"`
+}
+
+type MailgunSignature struct {
+	Timestamp string `json:"timestamp"`
+	Token     string `json:"token"`
+	Signature string `json:"signature"`
+}
+
+type MailgunEventData struct {
+	ID        string  `json:"id"`
+	Event     string  `json:"event"`
+	Timestamp float64 `json:"timestamp"`
+	Recipient string  `json:"recipient"`
+	// permanent or temporary, for failed events
+	Severity       string                 `json:"severity"`
+	Reason         string                 `json:"reason"`
+	URL            string                 `json:"url"`
+	UserVariables  map[string]interface{} `json:"user-variables"`
+	DeliveryStatus struct {
+		Code        int    `json:"code"`
+		Message     string `json:"message"`
+		Description string `json:"description"`
+	} `json:"delivery-status"`
+}
+
+// VerifyMailgunSignature checks a webhook was signed by Mailgun with the
+// signing key, recently.
+func VerifyMailgunSignature(key string, s MailgunSignature, now time.Time) error {
+	if key == "" {
+		return fmt.Errorf("no webhook signing key configured")
+	}
+	timestamp, err := strconv.ParseInt(s.Timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %q", s.Timestamp)
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > MAILGUN_WEBHOOK_MAX_AGE || age < -MAILGUN_WEBHOOK_MAX_AGE {
+		return fmt.Errorf("signature timestamp too far from now: %s", age)
+	}
+	signature, err := hex.DecodeString(s.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(s.Timestamp + s.Token))
+	if !hmac.Equal(signature, h.Sum(nil)) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// EmailEvent gives the event Mailgun reported, or false for events which
+// aren't kept like accepted ones. Temporary failures are kept as FAILED,
+// permanent ones as BOUNCED.
+func (d *MailgunEventData) EmailEvent() (*EmailEvent, bool) {
+	ev := &EmailEvent{
+		ProviderEventID: d.ID,
+		EmailAddress:    strings.ToLower(d.Recipient),
+	}
+	switch d.Event {
+	case "delivered":
+		ev.Event = EmailEventType_Delivered
+	case "failed":
+		ev.Event = EmailEventType_Failed
+		if d.Severity == "permanent" {
+			ev.Event = EmailEventType_Bounced
+		}
+	case "bounced":
+		// from the legacy webhooks
+		ev.Event = EmailEventType_Bounced
+	case "complained":
+		ev.Event = EmailEventType_Complained
+	case "opened":
+		ev.Event = EmailEventType_Opened
+	case "clicked":
+		ev.Event = EmailEventType_Clicked
+	case "unsubscribed":
+		ev.Event = EmailEventType_Unsubscribed
+	default:
+		return nil, false
+	}
+
+	seconds, fraction := math.Modf(d.Timestamp)
+	ev.OccurredAt = time.Unix(int64(seconds), int64(fraction*1e9)).UTC()
+	if v, ok := d.UserVariables[EmailIDVariable]; ok {
+		if id, err := strconv.Atoi(fmt.Sprint(v)); err == nil {
+			ev.EmailID = &id
+		}
+	}
+	if d.Severity != "" {
+		ev.Severity = &d.Severity
+	}
+	reason := d.Reason
+	for _, r := range []string{d.DeliveryStatus.Description, d.DeliveryStatus.Message} {
+		if r != "" {
+			reason = r
+			break
+		}
+	}
+	if reason != "" {
+		ev.Reason = &reason
+	}
+	if d.URL != "" {
+		ev.URL = &d.URL
+	}
+	return ev, true
+}
+
+// Suppresses tells whether an email of type t can't go to the address, only
+// transactional emails go to people who unsubscribed.
+func (s *EmailSuppression) Suppresses(t EmailType) bool {
+	return s.Reason != EmailSuppressionReason_Unsubscribe || templateInfo[t].category != EmailCategory_Transactional
+}
+
+// HandleMailgunWebhook takes in the delivery events of the emails sent. Events
+// not kept are acknowledged too, otherwise Mailgun keeps retrying them.
+func (e *External) HandleMailgunWebhook(w http.ResponseWriter, r *http.Request) {
+	wh := &MailgunWebhook{}
+	if err := json.NewDecoder(r.Body).Decode(wh); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	if err := VerifyMailgunSignature(mailgunWebhookSigningKey(), wh.Signature, time.Now()); err != nil {
+		e.writeError(w, r, http.StatusForbidden, errors.Wrap(err, "verifying webhook"))
+		return
+	}
+
+	ev, ok := wh.EventData.EmailEvent()
+	if !ok {
+		e.returnJSON(w, nil)
+		return
+	}
+	if ev.ProviderEventID == "" || ev.EmailAddress == "" {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing event id or recipient"))
+		return
+	}
+
+	if _, err := e.RecordEmailEvent(r.Context(), ev); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording email event"))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
+
+// RecordEmailEvent keeps an event, rolls it up on its email and suppresses
+// the address for bounces, complaints and unsubscribes. Events seen before
+// are skipped, it gives whether the event was new.
+func (e *External) RecordEmailEvent(ctx context.Context, ev *EmailEvent) (bool, error) {
+	tx, err := e.dao.GetTx(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "begin tx")
+	}
+	defer tx.Rollback()
+
+	created, err := CreateEmailEvent(tx, ev)
+	if err != nil {
+		return false, errors.Wrap(err, "creating email event")
+	}
+	if !created {
+		return false, nil
+	}
+
+	if ev.EmailID != nil {
+		if err := ApplyEmailEvent(tx, *ev.EmailID, ev.Event, ev.OccurredAt); err != nil {
+			return false, errors.Wrapf(err, "applying event to email %d", *ev.EmailID)
+		}
+	}
+	if reason, ok := emailEventSuppressions[ev.Event]; ok {
+		if err := SuppressEmailAddress(tx, ev.EmailAddress, reason, &ev.ID); err != nil {
+			return false, errors.Wrap(err, "suppressing email address")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "commiting email event")
+	}
+
+	return true, nil
+}
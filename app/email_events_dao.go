@@ -0,0 +1,152 @@
+package external
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreateEmailEvent records an event once, it gives false for an event
+// recorded before. The event is left without an email if it refers to one
+// which doesn't exist.
+func CreateEmailEvent(q Q, ev *EmailEvent) (bool, error) {
+	if err := q.Get(
+		ev,
+		`
+			INSERT INTO ggwp.email_events
+			(
+				provider_event_id, email_id, email_address, event, severity, reason, url, occurred_at
+			)
+			VALUES
+			(
+				$1, (SELECT id FROM ggwp.emails WHERE id = $2), $3, $4, $5, $6, $7, $8
+			)
+			ON CONFLICT (provider_event_id) DO NOTHING
+			RETURNING
+				id,
+				provider_event_id,
+				email_id,
+				email_address,
+				event,
+				severity,
+				reason,
+				url,
+				occurred_at
+		`,
+		ev.ProviderEventID,
+		ev.EmailID,
+		ev.EmailAddress,
+		ev.Event,
+		ev.Severity,
+		ev.Reason,
+		ev.URL,
+		ev.OccurredAt,
+	); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// emailEventColumns are the columns of ggwp.emails keeping when an event first
+// happened, and how many times it did.
+var emailEventColumns = map[EmailEventType]struct {
+	at    string
+	count string
+}{
+	EmailEventType_Delivered:    {at: "delivered_at"},
+	EmailEventType_Opened:       {at: "opened_at", count: "open_count"},
+	EmailEventType_Clicked:      {at: "clicked_at", count: "click_count"},
+	EmailEventType_Bounced:      {at: "bounced_at"},
+	EmailEventType_Complained:   {at: "complained_at"},
+	EmailEventType_Unsubscribed: {at: "unsubscribed_at"},
+}
+
+// ApplyEmailEvent rolls an event up on the email it is about.
+func ApplyEmailEvent(q Q, emailID int, event EmailEventType, at time.Time) error {
+	columns, ok := emailEventColumns[event]
+	if !ok {
+		return nil
+	}
+	count := ""
+	if columns.count != "" {
+		count = fmt.Sprintf("%[1]s = %[1]s + 1,", columns.count)
+	}
+
+	if _, err := q.Exec(
+		fmt.Sprintf(
+			`
+				UPDATE ggwp.emails
+				SET
+					%[1]s = LEAST(%[1]s, $2),
+					%[2]s
+					updated_at = NOW()
+				WHERE id = $1
+			`,
+			columns.at,
+			count,
+		),
+		emailID,
+		at,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SuppressEmailAddress stops emails going to an address. An unsubscribe is
+// replaced by a bounce or complaint coming after, which suppress more.
+func SuppressEmailAddress(q Q, emailAddress string, reason EmailSuppressionReason, eventID *int) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.email_suppressions
+			(
+				email_address, reason, email_event_id
+			)
+			VALUES
+			(
+				lower($1), $2, $3
+			)
+			ON CONFLICT (email_address) DO UPDATE
+			SET
+				reason = EXCLUDED.reason,
+				email_event_id = EXCLUDED.email_event_id
+			WHERE ggwp.email_suppressions.reason = $4
+				AND EXCLUDED.reason <> $4
+		`,
+		emailAddress,
+		reason,
+		eventID,
+		EmailSuppressionReason_Unsubscribe,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetEmailSuppression(q Q, emailAddress string) (*EmailSuppression, error) {
+	var s EmailSuppression
+	if err := q.Get(
+		&s,
+		`
+			SELECT
+				email_address,
+				reason,
+				email_event_id,
+				created_at
+			FROM
+				ggwp.email_suppressions
+			WHERE
+				email_address = lower($1)
+		`,
+		emailAddress,
+	); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,285 @@
+package external_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+const testWebhookSigningKey = "key-webhook-signing-test"
+
+func signMailgun(key, timestamp, token string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(timestamp + token))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// mailgunWebhook gives a captured webhook payload from testdata, signed with
+// key just now and about the email with id.
+func mailgunWebhook(h *TestHelper, name, key string, emailID int) string {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "mailgun", name+".json"))
+	h.ExpectNoError(err)
+	var payload map[string]map[string]interface{}
+	h.ExpectNoError(json.Unmarshal(raw, &payload))
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	token := "a8ce0edb2dd8301dee6c2405235584e45aa91d1e9f979f3de0"
+	payload["signature"] = map[string]interface{}{
+		"timestamp": timestamp,
+		"token":     token,
+		"signature": signMailgun(key, timestamp, token),
+	}
+	payload["event-data"]["user-variables"] = map[string]interface{}{
+		external.EmailIDVariable: strconv.Itoa(emailID),
+	}
+
+	body, err := json.Marshal(payload)
+	h.ExpectNoError(err)
+	return string(body)
+}
+
+func TestVerifyMailgunSignature(t *testing.T) {
+	h := TestHelper{t}
+
+	now := time.Unix(1529006854, 0)
+	token := "a8ce0edb2dd8301dee6c2405235584e45aa91d1e9f979f3de0"
+	valid := external.MailgunSignature{
+		Timestamp: "1529006854",
+		Token:     token,
+		Signature: signMailgun(testWebhookSigningKey, "1529006854", token),
+	}
+	h.ExpectNoError(external.VerifyMailgunSignature(testWebhookSigningKey, valid, now))
+	h.ExpectNoError(external.VerifyMailgunSignature(testWebhookSigningKey, valid, now.Add(10*time.Minute)))
+
+	h.ExpectErrorContains(
+		external.VerifyMailgunSignature("another-key", valid, now),
+		"invalid signature",
+	)
+	h.ExpectErrorContains(
+		external.VerifyMailgunSignature("", valid, now),
+		"no webhook signing key configured",
+	)
+	h.ExpectErrorContains(
+		external.VerifyMailgunSignature(testWebhookSigningKey, valid, now.Add(time.Hour)),
+		"signature timestamp too far from now",
+	)
+
+	tampered := valid
+	tampered.Token = "another-token"
+	h.ExpectErrorContains(
+		external.VerifyMailgunSignature(testWebhookSigningKey, tampered, now),
+		"invalid signature",
+	)
+	tampered = valid
+	tampered.Signature = "not hex"
+	h.ExpectErrorContains(
+		external.VerifyMailgunSignature(testWebhookSigningKey, tampered, now),
+		"invalid signature",
+	)
+}
+
+func TestMailgunEventDataEmailEvent(t *testing.T) {
+	h := TestHelper{t}
+
+	str := func(s string) *string { return &s }
+	one := 1
+	for _, test := range []struct {
+		name     string
+		expected *external.EmailEvent
+	}{
+		{
+			name: "delivered",
+			expected: &external.EmailEvent{
+				ProviderEventID: "CPgfbmQMTCKtHW6uIWtuVe",
+				EmailID:         &one,
+				EmailAddress:    "alice@example.com",
+				Event:           external.EmailEventType_Delivered,
+				Reason:          str("OK"),
+				OccurredAt:      time.Unix(1521472262, 908181000).UTC(),
+			},
+		},
+		{
+			name: "failed_permanent",
+			expected: &external.EmailEvent{
+				ProviderEventID: "G9Bn5sl1TC6nu79C8C0bwg",
+				EmailID:         &one,
+				EmailAddress:    "alice@example.com",
+				Event:           external.EmailEventType_Bounced,
+				Severity:        str("permanent"),
+				Reason:          str("550 5.1.1 The email account that you tried to reach does not exist."),
+				OccurredAt:      time.Unix(1521233195, 375624000).UTC(),
+			},
+		},
+		{
+			name: "failed_temporary",
+			expected: &external.EmailEvent{
+				ProviderEventID: "Fs7-5t81S2ylE0p3kmf5Wg",
+				EmailID:         &one,
+				EmailAddress:    "alice@example.com",
+				Event:           external.EmailEventType_Failed,
+				Severity:        str("temporary"),
+				Reason:          str("4.2.2 The email account that you tried to reach is over quota."),
+				OccurredAt:      time.Unix(1521233195, 375624000).UTC(),
+			},
+		},
+		{
+			name: "clicked",
+			expected: &external.EmailEvent{
+				ProviderEventID: "Ase7i2zsRYeDXztHGENqRB",
+				EmailID:         &one,
+				EmailAddress:    "alice@example.com",
+				Event:           external.EmailEventType_Clicked,
+				URL:             str("https://www.ggwpacademy.com"),
+				OccurredAt:      time.Unix(1521243346, 211373000).UTC(),
+			},
+		},
+		{
+			name:     "accepted",
+			expected: nil,
+		},
+	} {
+		raw, err := ioutil.ReadFile(filepath.Join("testdata", "mailgun", test.name+".json"))
+		h.ExpectNoError(err)
+		var wh external.MailgunWebhook
+		h.ExpectNoError(json.Unmarshal(raw, &wh))
+
+		ev, ok := wh.EventData.EmailEvent()
+		h.ExpectDeepEq(ok, test.expected != nil)
+		if ev != nil {
+			// sub microsecond float noise
+			ev.OccurredAt = ev.OccurredAt.Round(time.Microsecond)
+		}
+		h.ExpectDeepEq(ev, test.expected)
+	}
+}
+
+func TestHandleMailgunWebhook(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	os.Setenv("MAIL_GUN_WEBHOOK_SIGNING_KEY", testWebhookSigningKey)
+	defer os.Unsetenv("MAIL_GUN_WEBHOOK_SIGNING_KEY")
+
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB,
+		nil,
+		"alice@example.com",
+		"welcome",
+		external.HStoreMap{"FirstName": "Alice"},
+		external.EmailType_Welcome,
+		external.EmailStatus_Sent,
+	))
+	var id int
+	f.ExpectNoError(f.DAO.DB.Get(&id, `SELECT id FROM ggwp.emails`))
+
+	post := func(name, key string) int {
+		rr := f.UnAuthedRequest(http.MethodPost, "/email/webhooks/mailgun", mailgunWebhook(f.TestHelper, name, key, id))
+		return rr.Code
+	}
+
+	// signed with the wrong key
+	f.ExpectDeepEq(post("delivered", "another-key"), http.StatusForbidden)
+	f.ExpectRowCount("ggwp.email_events", 0)
+
+	f.ExpectDeepEq(post("delivered", testWebhookSigningKey), http.StatusOK)
+	f.ExpectDeepEq(post("opened", testWebhookSigningKey), http.StatusOK)
+	f.ExpectDeepEq(post("clicked", testWebhookSigningKey), http.StatusOK)
+	// retried by mailgun
+	f.ExpectDeepEq(post("opened", testWebhookSigningKey), http.StatusOK)
+	// not kept, but acknowledged
+	f.ExpectDeepEq(post("accepted", testWebhookSigningKey), http.StatusOK)
+	f.ExpectDeepEq(post("failed_temporary", testWebhookSigningKey), http.StatusOK)
+
+	f.ExpectRowCount("ggwp.email_events", 4)
+	f.ExpectRowCountWhere(
+		"ggwp.emails",
+		"delivered_at IS NOT NULL AND open_count = 1 AND click_count = 1 AND bounced_at IS NULL",
+		1,
+	)
+	f.ExpectRowCount("ggwp.email_suppressions", 0)
+
+	f.ExpectDeepEq(post("unsubscribed", testWebhookSigningKey), http.StatusOK)
+	s, err := external.GetEmailSuppression(f.DAO.DB, "Alice@example.com")
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(s.Reason, external.EmailSuppressionReason_Unsubscribe)
+
+	// a bounce suppresses more than an unsubscribe
+	f.ExpectDeepEq(post("failed_permanent", testWebhookSigningKey), http.StatusOK)
+	s, err = external.GetEmailSuppression(f.DAO.DB, "alice@example.com")
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(s.Reason, external.EmailSuppressionReason_Bounce)
+	f.ExpectRowCountWhere("ggwp.emails", "bounced_at IS NOT NULL AND unsubscribed_at IS NOT NULL", 1)
+
+	// but not the other way around
+	f.ExpectNoError(external.SuppressEmailAddress(
+		f.DAO.DB, "alice@example.com", external.EmailSuppressionReason_Unsubscribe, nil,
+	))
+	s, err = external.GetEmailSuppression(f.DAO.DB, "alice@example.com")
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(s.Reason, external.EmailSuppressionReason_Bounce)
+}
+
+func TestSendPendingEmailsSuppressed(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	address := "unsubscribed@ggwpacademy.com"
+	f.ExpectNoError(external.SuppressEmailAddress(
+		f.DAO.DB, address, external.EmailSuppressionReason_Unsubscribe, nil,
+	))
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, nil, address, "welcome",
+		external.HStoreMap{"FirstName": "Sam"},
+		external.EmailType_Welcome, external.EmailStatus_Pending,
+	))
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, nil, address, "waitlist_confirmation",
+		external.HStoreMap{"ConfirmURL": "https://api.ggwpacademy.com/confirm"},
+		external.EmailType_WaitlistConfirmation, external.EmailStatus_Pending,
+	))
+
+	claimed, err := f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(claimed, 2)
+
+	// only what they asked for goes out
+	sent := f.Email.EmailsTo(address)
+	f.ExpectDeepEq(len(sent), 1)
+	f.ExpectDeepEq(sent[0].Subject, "Confirm your spot on the waitlist")
+	f.ExpectRowCountWhere(
+		"ggwp.emails",
+		fmt.Sprintf("type = '%s' AND status = 'SUPPRESSED'", external.EmailType_Welcome),
+		1,
+	)
+
+	// sent emails carry their id for the delivery events
+	var id int
+	f.ExpectNoError(f.DAO.DB.Get(&id, `SELECT id FROM ggwp.emails WHERE status = 'SENT'`))
+	f.ExpectDeepEq(sent[0].Variables, map[string]string{external.EmailIDVariable: strconv.Itoa(id)})
+
+	// bounced addresses get nothing at all
+	f.Email.Reset()
+	f.ExpectNoError(external.SuppressEmailAddress(
+		f.DAO.DB, address, external.EmailSuppressionReason_Bounce, nil,
+	))
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, nil, address, "waitlist_confirmation",
+		external.HStoreMap{"ConfirmURL": "https://api.ggwpacademy.com/confirm"},
+		external.EmailType_WaitlistConfirmation, external.EmailStatus_Pending,
+	))
+	_, err = f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(f.Email.Emails()), 0)
+}
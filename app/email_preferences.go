@@ -0,0 +1,245 @@
+package external
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the categories of email people can turn off
+var subscribableEmailCategories = []EmailCategory{
+	EmailCategory_Waitlist,
+	EmailCategory_Reminders,
+	EmailCategory_Marketing,
+}
+
+// SignEmailUnsubscribe gives the signature of an unsubscribe link, so links
+// work without logging in but can't be made up for someone else's address.
+func SignEmailUnsubscribe(key, emailAddress string, category EmailCategory) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte("email-unsubscribe\n" + strings.ToLower(emailAddress) + "\n" + string(category)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyEmailUnsubscribe checks an unsubscribe link was made by us.
+func VerifyEmailUnsubscribe(key, emailAddress string, category EmailCategory, signature string) error {
+	if key == "" {
+		return fmt.Errorf("no unsubscribe key configured")
+	}
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+	expected, _ := hex.DecodeString(SignEmailUnsubscribe(key, emailAddress, category))
+	if !hmac.Equal(given, expected) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// EmailUnsubscribeURL gives the link emails of a category carry to stop them
+// going to an address, also used for the List-Unsubscribe header.
+func EmailUnsubscribeURL(baseURL, key, emailAddress string, category EmailCategory) string {
+	v := url.Values{}
+	v.Set("address", strings.ToLower(emailAddress))
+	v.Set("category", string(category))
+	v.Set("signature", SignEmailUnsubscribe(key, emailAddress, category))
+	return apiURL(baseURL, "/email/unsubscribe?"+v.Encode())
+}
+
+func isSubscribableEmailCategory(c EmailCategory) bool {
+	for _, s := range subscribableEmailCategories {
+		if c == s {
+			return true
+		}
+	}
+	return false
+}
+
+func notificationSettingsOf(prefs map[EmailCategory]bool) *NotificationSettings {
+	s := &NotificationSettings{Email: make(map[EmailCategory]bool, len(subscribableEmailCategories))}
+	for _, c := range subscribableEmailCategories {
+		subscribed, ok := prefs[c]
+		s.Email[c] = !ok || subscribed
+	}
+	return s
+}
+
+func (e *External) HandleGetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	prefs, err := GetUserEmailPreferences(e.dao.ReadDB, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting email preferences"))
+		return
+	}
+
+	e.returnJSON(w, notificationSettingsOf(prefs))
+}
+
+// HandleUpdateNotificationSettings changes the categories given, the others
+// are left as they are.
+func (e *External) HandleUpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	s := &NotificationSettings{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid request"))
+		return
+	}
+	for c := range s.Email {
+		if !isSubscribableEmailCategory(c) {
+			e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("can't change email category: %s", string(c)))
+			return
+		}
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	for c, subscribed := range s.Email {
+		if err := SetUserEmailPreference(tx, userID, c, subscribed); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "setting %s email preference", c))
+			return
+		}
+	}
+	prefs, err := GetUserEmailPreferences(tx, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting email preferences"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting email preferences"))
+		return
+	}
+
+	e.returnJSON(w, notificationSettingsOf(prefs))
+}
+
+// emailUnsubscribePage asks people who followed an unsubscribe link to
+// confirm, and tells them once they did.
+var emailUnsubscribePage = htmltemplate.Must(htmltemplate.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Unsubscribe - GGWP Academy</title>
+  </head>
+  <body style="font-family: Helvetica, Arial, sans-serif; text-align: center; padding: 48px 16px;">
+    {{if .Unsubscribed}}
+    <p>{{.Address}} won't get {{.Category}} emails from GGWP Academy any more.</p>
+    {{else}}
+    <p>Stop sending {{.Category}} emails to {{.Address}}?</p>
+    <form method="POST" action="{{.Action}}">
+      <button type="submit" style="background-color: #000000; color: #ffffff; padding: 12px 24px; border: 0; border-radius: 4px;">Unsubscribe</button>
+    </form>
+    {{end}}
+  </body>
+</html>
+`))
+
+type emailUnsubscribePageVars struct {
+	Address      string
+	Category     string
+	Action       string
+	Unsubscribed bool
+}
+
+// verifiedEmailUnsubscribe gives the address and category of a valid
+// unsubscribe link, or writes the error.
+func (e *External) verifiedEmailUnsubscribe(w http.ResponseWriter, r *http.Request) (string, EmailCategory, bool) {
+	query := r.URL.Query()
+	address := query.Get("address")
+	category := EmailCategory(query.Get("category"))
+	if address == "" || !isSubscribableEmailCategory(category) {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing address or category"))
+		return "", "", false
+	}
+	if err := VerifyEmailUnsubscribe(e.emailUnsubscribeKey, address, category, query.Get("signature")); err != nil {
+		e.writeError(w, r, http.StatusForbidden, errors.Wrap(err, "verifying unsubscribe link"))
+		return "", "", false
+	}
+	return address, category, true
+}
+
+func (e *External) writeEmailUnsubscribePage(w http.ResponseWriter, vars emailUnsubscribePageVars) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := emailUnsubscribePage.Execute(w, vars); err != nil {
+		e.log.WithError(err).Error("writing unsubscribe page")
+	}
+}
+
+// HandleEmailUnsubscribeConfirm is where unsubscribe links in emails lead. It
+// changes nothing, link scanners and prefetchers follow links too, but asks
+// to confirm with a POST.
+func (e *External) HandleEmailUnsubscribeConfirm(w http.ResponseWriter, r *http.Request) {
+	address, category, ok := e.verifiedEmailUnsubscribe(w, r)
+	if !ok {
+		return
+	}
+
+	e.writeEmailUnsubscribePage(w, emailUnsubscribePageVars{
+		Address:  address,
+		Category: strings.ToLower(string(category)),
+		Action:   r.URL.RequestURI(),
+	})
+}
+
+// HandleEmailUnsubscribe unsubscribes with the POST of the confirmation page
+// or of mail clients offering one-click unsubscribes (RFC 8058), it needs no
+// login. Users stop getting the category, addresses without a user, like
+// waitlist entries, stop getting anything but transactional emails.
+func (e *External) HandleEmailUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	address, category, ok := e.verifiedEmailUnsubscribe(w, r)
+	if !ok {
+		return
+	}
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := GetUserByEmail(tx, address)
+	switch {
+	case err == sql.ErrNoRows:
+		if err := SuppressEmailAddress(tx, address, EmailSuppressionReason_Unsubscribe, nil); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "suppressing email address"))
+			return
+		}
+	case err != nil:
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting user"))
+		return
+	default:
+		if err := SetUserEmailPreference(tx, user.ID, category, false); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "setting email preference"))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting unsubscribe"))
+		return
+	}
+
+	e.writeEmailUnsubscribePage(w, emailUnsubscribePageVars{
+		Address:      address,
+		Category:     strings.ToLower(string(category)),
+		Unsubscribed: true,
+	})
+}
@@ -0,0 +1,81 @@
+package external
+
+// GetUserEmailPreferences gives the categories a user turned off or back on,
+// categories without a preference are subscribed to.
+func GetUserEmailPreferences(q Q, userID int) (map[EmailCategory]bool, error) {
+	var rows []struct {
+		Category   EmailCategory `json:"category"`
+		Subscribed bool          `json:"subscribed"`
+	}
+	if err := q.Select(
+		&rows,
+		`
+			SELECT category, subscribed
+			FROM ggwp.user_email_preferences
+			WHERE user_id = $1
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	prefs := make(map[EmailCategory]bool, len(rows))
+	for _, r := range rows {
+		prefs[r.Category] = r.Subscribed
+	}
+	return prefs, nil
+}
+
+func SetUserEmailPreference(q Q, userID int, category EmailCategory, subscribed bool) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.user_email_preferences
+			(
+				user_id, category, subscribed, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, NOW()
+			)
+			ON CONFLICT (user_id, category) DO UPDATE
+			SET
+				subscribed = EXCLUDED.subscribed,
+				updated_at = NOW()
+		`,
+		userID,
+		category,
+		subscribed,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsSubscribedToEmailCategory tells whether an email of a category can go to
+// an address, which is up to the user it was queued for or the user with the
+// address.
+func IsSubscribedToEmailCategory(q Q, userID *int, emailAddress string, category EmailCategory) (bool, error) {
+	var subscribed bool
+	if err := q.Get(
+		&subscribed,
+		`
+			SELECT NOT EXISTS (
+				SELECT 1
+				FROM ggwp.user_email_preferences p
+				JOIN ggwp.users u ON u.id = p.user_id
+				WHERE
+					(u.id = $1 OR u.email = lower($2))
+					AND p.category = $3
+					AND NOT p.subscribed
+			)
+		`,
+		userID,
+		emailAddress,
+		category,
+	); err != nil {
+		return false, err
+	}
+
+	return subscribed, nil
+}
@@ -0,0 +1,288 @@
+package external_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+const testUnsubscribeKey = "unsubscribe-test-key"
+
+// unsubscribePath gives the path of the unsubscribe link for an address, as
+// UnAuthedRequest takes it.
+func unsubscribePath(h *TestHelper, address string, category external.EmailCategory) string {
+	u, err := url.Parse(external.EmailUnsubscribeURL(testBaseURL, testUnsubscribeKey, address, category))
+	h.ExpectNoError(err)
+	return strings.TrimPrefix(u.RequestURI(), "/api/v0.1")
+}
+
+func TestVerifyEmailUnsubscribe(t *testing.T) {
+	h := TestHelper{t}
+
+	signature := external.SignEmailUnsubscribe(testUnsubscribeKey, "Sam@ggwpacademy.com", external.EmailCategory_Marketing)
+	// addresses are compared lowercased
+	h.ExpectNoError(external.VerifyEmailUnsubscribe(
+		testUnsubscribeKey, "sam@ggwpacademy.com", external.EmailCategory_Marketing, signature,
+	))
+	h.ExpectErrorContains(external.VerifyEmailUnsubscribe(
+		testUnsubscribeKey, "sam@ggwpacademy.com", external.EmailCategory_Reminders, signature,
+	), "invalid signature")
+	h.ExpectErrorContains(external.VerifyEmailUnsubscribe(
+		testUnsubscribeKey, "other@ggwpacademy.com", external.EmailCategory_Marketing, signature,
+	), "invalid signature")
+	h.ExpectErrorContains(external.VerifyEmailUnsubscribe(
+		"another-key", "sam@ggwpacademy.com", external.EmailCategory_Marketing, signature,
+	), "invalid signature")
+	h.ExpectErrorContains(external.VerifyEmailUnsubscribe(
+		testUnsubscribeKey, "sam@ggwpacademy.com", external.EmailCategory_Marketing, "not hex",
+	), "invalid signature")
+	h.ExpectErrorContains(external.VerifyEmailUnsubscribe(
+		"", "sam@ggwpacademy.com", external.EmailCategory_Marketing, signature,
+	), "no unsubscribe key configured")
+
+	u, err := url.Parse(external.EmailUnsubscribeURL(testBaseURL, testUnsubscribeKey, "Sam@ggwpacademy.com", external.EmailCategory_Marketing))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(u.Path, "/api/v0.1/email/unsubscribe")
+	h.ExpectDeepEq(u.Query().Get("address"), "sam@ggwpacademy.com")
+	h.ExpectDeepEq(u.Query().Get("category"), "MARKETING")
+	h.ExpectDeepEq(u.Query().Get("signature"), signature)
+}
+
+func TestNotificationSettings(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("notifications@ggwpacademy.com")
+
+	// everything is on to begin with
+	rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/notifications", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	s := &external.NotificationSettings{}
+	f.Bind(rr, s)
+	f.ExpectDeepEq(s.Email, map[external.EmailCategory]bool{
+		external.EmailCategory_Waitlist:  true,
+		external.EmailCategory_Reminders: true,
+		external.EmailCategory_Marketing: true,
+	})
+
+	// categories left out stay as they are
+	rr = f.AuthedRequest(
+		http.MethodPut, "/api/v0.1/user/self/notifications",
+		`{"email": {"MARKETING": false}}`, auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	s = &external.NotificationSettings{}
+	f.Bind(rr, s)
+	f.ExpectDeepEq(s.Email, map[external.EmailCategory]bool{
+		external.EmailCategory_Waitlist:  true,
+		external.EmailCategory_Reminders: true,
+		external.EmailCategory_Marketing: false,
+	})
+
+	rr = f.AuthedRequest(
+		http.MethodPut, "/api/v0.1/user/self/notifications",
+		`{"email": {"REMINDERS": false, "MARKETING": true}}`, auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/notifications", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	s = &external.NotificationSettings{}
+	f.Bind(rr, s)
+	f.ExpectDeepEq(s.Email, map[external.EmailCategory]bool{
+		external.EmailCategory_Waitlist:  true,
+		external.EmailCategory_Reminders: false,
+		external.EmailCategory_Marketing: true,
+	})
+
+	// transactional emails can't be turned off
+	for _, body := range []string{
+		`{"email": {"TRANSACTIONAL": false}}`,
+		`{"email": {"NEWSLETTER": false}}`,
+	} {
+		rr = f.AuthedRequest(http.MethodPut, "/api/v0.1/user/self/notifications", body, auth.AccessToken)
+		f.ExpectStatus(rr, http.StatusBadRequest)
+	}
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/notifications", "", "")
+	f.ExpectStatus(rr, http.StatusForbidden)
+}
+
+func TestHandleEmailUnsubscribe(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+	h := &TestHelper{t}
+
+	address := "unsubscribe@ggwpacademy.com"
+	auth := f.GetAuthToken(address)
+
+	// mail clients POST to the link for one-click unsubscribes
+	rr := f.UnAuthedRequest(
+		http.MethodPost,
+		unsubscribePath(h, address, external.EmailCategory_Reminders),
+		"List-Unsubscribe=One-Click",
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectBodyContains(rr, "get reminders emails from GGWP Academy any more")
+
+	// following the link only asks to confirm, scanners follow links too
+	marketing := unsubscribePath(h, address, external.EmailCategory_Marketing)
+	rr = f.UnAuthedRequest(http.MethodGet, marketing, "")
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectBodyContains(rr, `<form method="POST"`)
+	f.ExpectRowCountWhere("ggwp.user_email_preferences", "category = 'MARKETING'", 0)
+	rr = f.UnAuthedRequest(http.MethodPost, marketing, "")
+	f.ExpectStatus(rr, http.StatusOK)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/notifications", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	s := &external.NotificationSettings{}
+	f.Bind(rr, s)
+	f.ExpectDeepEq(s.Email, map[external.EmailCategory]bool{
+		external.EmailCategory_Waitlist:  true,
+		external.EmailCategory_Reminders: false,
+		external.EmailCategory_Marketing: false,
+	})
+	// users keep getting the categories they didn't unsubscribe from
+	f.ExpectRowCount("ggwp.email_suppressions", 0)
+
+	// addresses without a user are suppressed
+	rr = f.UnAuthedRequest(http.MethodPost, unsubscribePath(h, "waitlisted@ggwpacademy.com", external.EmailCategory_Waitlist), "")
+	f.ExpectStatus(rr, http.StatusOK)
+	suppression, err := external.GetEmailSuppression(f.DAO.DB, "waitlisted@ggwpacademy.com")
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(suppression.Reason, external.EmailSuppressionReason_Unsubscribe)
+
+	// links can't be made up
+	forged := strings.Replace(
+		unsubscribePath(h, "someone@ggwpacademy.com", external.EmailCategory_Marketing),
+		"someone", "victim", 1,
+	)
+	rr = f.UnAuthedRequest(http.MethodPost, forged, "")
+	f.ExpectStatus(rr, http.StatusForbidden)
+	rr = f.UnAuthedRequest(http.MethodGet, forged, "")
+	f.ExpectStatus(rr, http.StatusForbidden)
+	rr = f.UnAuthedRequest(http.MethodPost, "/email/unsubscribe?address=someone@ggwpacademy.com&category=TRANSACTIONAL", "")
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	f.ExpectRowCount("ggwp.email_suppressions", 1)
+}
+
+func TestSendPendingEmailsUnsubscribed(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	address := "preferences@ggwpacademy.com"
+	auth := f.GetAuthToken(address)
+	f.ExpectNoError(external.SetUserEmailPreference(f.DAO.DB, auth.UserID, external.EmailCategory_Reminders, false))
+
+	userID := auth.UserID
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, &userID, address, "streak_at_risk",
+		external.HStoreMap{"FirstName": "Test", "CurrentStreak": "3"},
+		external.EmailType_StreakAtRisk, external.EmailStatus_Pending,
+	))
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, &userID, address, "welcome",
+		external.HStoreMap{"FirstName": "Test"},
+		external.EmailType_Welcome, external.EmailStatus_Pending,
+	))
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, &userID, address, "forgot_password",
+		external.HStoreMap{"Token": "ABCD2345X"},
+		external.EmailType_ForgotPassword, external.EmailStatus_Pending,
+	))
+
+	claimed, err := f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(claimed, 3)
+
+	f.ExpectRowCountWhere(
+		"ggwp.emails",
+		"type = 'STREAK_AT_RISK' AND status = 'SUPPRESSED' AND last_error = 'unsubscribed from REMINDERS emails'",
+		1,
+	)
+	sent := map[string]*external.OutgoingEmail{}
+	for _, m := range f.Email.EmailsTo(address) {
+		sent[m.Subject] = m
+	}
+	f.ExpectDeepEq(len(sent), 2)
+
+	// emails people didn't ask for can be unsubscribed from in one click
+	welcome := sent["Welcome to GGWP Academy"]
+	unsubscribeURL := external.EmailUnsubscribeURL(testBaseURL, testUnsubscribeKey, address, external.EmailCategory_Marketing)
+	f.ExpectDeepEq(welcome.Headers, map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	})
+	f.ExpectDeepEq(strings.Contains(welcome.Text, unsubscribeURL), true)
+
+	reset := sent["Reset your password"]
+	f.ExpectDeepEq(len(reset.Headers), 0)
+}
+
+func TestQueueGoalRemindersUnsubscribed(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+	h := &TestHelper{t}
+
+	for _, address := range []string{"reminded@ggwpacademy.com", "unreminded@ggwpacademy.com"} {
+		auth := f.GetAuthToken(address)
+		rr := f.AuthedRequest(
+			http.MethodPost,
+			"/api/v0.1/user/self/goals",
+			`{"description": "100 shots a day", "value": 100, "rate": "DAILY"}`,
+			auth.AccessToken,
+		)
+		f.ExpectStatus(rr, http.StatusOK)
+	}
+
+	// goal reminders stop with the unsubscribe link of the reminder emails
+	rr := f.UnAuthedRequest(
+		http.MethodPost,
+		unsubscribePath(h, "unreminded@ggwpacademy.com", external.EmailCategory_Reminders),
+		"",
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+
+	queued, err := f.Server.QueueGoalReminders(context.Background(), time.Date(2024, 5, 14, 20, 0, 0, 0, time.UTC))
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(queued, 1)
+	f.ExpectRowCountWhere("ggwp.emails", "type = 'GOAL_REMINDER' AND email_address = 'reminded@ggwpacademy.com'", 1)
+	f.ExpectRowCountWhere("ggwp.emails", "type = 'GOAL_REMINDER' AND email_address = 'unreminded@ggwpacademy.com'", 0)
+}
+
+func TestQueueWaitlistEmailsUnsubscribed(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	for i, address := range []string{"queued@ggwpacademy.com", "suppressed@ggwpacademy.com"} {
+		_, err := f.DAO.DB.Exec(
+			`
+				INSERT INTO ggwp.waitlist
+				(
+					email_address, owner_waitlist_code, confirmed_at, created_at, updated_at
+				)
+				VALUES
+				(
+					$1, $2, NOW(), NOW(), NOW()
+				)
+			`,
+			address,
+			fmt.Sprintf("WAIT-00000%d", i+1),
+		)
+		f.ExpectNoError(err)
+	}
+	f.ExpectNoError(external.SuppressEmailAddress(
+		f.DAO.DB, "suppressed@ggwpacademy.com", external.EmailSuppressionReason_Unsubscribe, nil,
+	))
+
+	queued, err := f.Server.QueueWaitlistEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(queued, 1)
+	f.ExpectRowCountWhere("ggwp.emails", "email_address = 'queued@ggwpacademy.com'", 1)
+}
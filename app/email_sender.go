@@ -0,0 +1,335 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v3"
+	"github.com/pkg/errors"
+)
+
+// EmailSender delivers emails, through Mailgun in production.
+type EmailSender interface {
+	Send(ctx context.Context, m *OutgoingEmail) error
+}
+
+// OutgoingEmail is a rendered email ready to be sent.
+type OutgoingEmail struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Inlines []EmailInline
+	Tags    []string
+	// come back with the delivery events of the email
+	Variables map[string]string
+	// extra headers, like List-Unsubscribe
+	Headers map[string]string
+}
+
+// EmailInline is an image the html refers to as cid:<name>.
+type EmailInline struct {
+	Name    string
+	Content []byte
+}
+
+// MIME gives the email as an RFC 5322 message, the html and text as
+// alternatives along with the inline images.
+func (m *OutgoingEmail) MIME(now time.Time) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	messageID, err := newSecretToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating message id")
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(m.From, "@"); i >= 0 {
+		domain = m.From[i+1:]
+	}
+
+	related := multipart.NewWriter(buf)
+	alternative := multipart.NewWriter(buf)
+	headers := [][2]string{
+		{"From", m.From},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", messageID, domain)},
+		{"MIME-Version", "1.0"},
+	}
+	if len(m.Tags) > 0 {
+		headers = append(headers, [2]string{"X-Tags", strings.Join(m.Tags, ", ")})
+	}
+	if len(m.Variables) > 0 {
+		// the variables Mailgun picks up from emails relayed through it
+		variables, err := json.Marshal(m.Variables)
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding variables")
+		}
+		headers = append(headers, [2]string{"X-Mailgun-Variables", string(variables)})
+	}
+	names := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		headers = append(headers, [2]string{k, m.Headers[k]})
+	}
+	if len(m.Inlines) > 0 {
+		headers = append(headers, [2]string{"Content-Type", "multipart/related; boundary=" + related.Boundary()})
+	} else {
+		headers = append(headers, [2]string{"Content-Type", "multipart/alternative; boundary=" + alternative.Boundary()})
+	}
+	for _, h := range headers {
+		fmt.Fprintf(buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+
+	if len(m.Inlines) > 0 {
+		// the alternatives are nested in the first part
+		if _, err := related.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"multipart/alternative; boundary=" + alternative.Boundary()},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		if part.body == "" {
+			continue
+		}
+		w, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, i := range m.Inlines {
+		w, err := related.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.TypeByExtension(filepath.Ext(i.Name))},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {"<" + i.Name + ">"},
+			"Content-Disposition":       {fmt.Sprintf("inline; filename=%q", i.Name)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(i.Content)
+		for len(encoded) > 76 {
+			fmt.Fprintf(w, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(w, "%s\r\n", encoded)
+	}
+	if len(m.Inlines) > 0 {
+		if err := related.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MailgunSender sends emails through Mailgun, with open and click tracking.
+type MailgunSender struct {
+	MG mailgun.Mailgun
+}
+
+func (s *MailgunSender) Send(ctx context.Context, m *OutgoingEmail) error {
+	message := s.MG.NewMessage(m.From, m.Subject, m.Text, m.To)
+	if m.HTML != "" {
+		message.SetHtml(m.HTML)
+	}
+	for _, i := range m.Inlines {
+		message.AddReaderInline(i.Name, ioutil.NopCloser(bytes.NewReader(i.Content)))
+	}
+	for _, t := range m.Tags {
+		message.AddTag(t)
+	}
+	for k, v := range m.Variables {
+		if err := message.AddVariable(k, v); err != nil {
+			return errors.Wrapf(err, "adding variable %s", k)
+		}
+	}
+	for k, v := range m.Headers {
+		message.AddHeader(k, v)
+	}
+
+	// add tracking
+	message.SetTracking(true)
+	message.SetTrackingClicks(true)
+	message.SetTrackingOpens(true)
+
+	if _, _, err := s.MG.Send(ctx, message); err != nil {
+		return errors.Wrap(err, "sending through mailgun")
+	}
+	return nil
+}
+
+// SMTPSender sends emails to an SMTP server, authenticating when a username
+// is set.
+type SMTPSender struct {
+	// host:port of the server
+	Addr     string
+	Username string
+	Password string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, m *OutgoingEmail) error {
+	msg, err := m.MIME(time.Now())
+	if err != nil {
+		return errors.Wrap(err, "building message")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, m.From, []string{m.To}, msg)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.Wrap(err, "sending through smtp")
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// EmailSink keeps the emails sent instead of delivering them, for local
+// development and tests. With a Dir every email is also written to that
+// Maildir, where any mail client can open it.
+type EmailSink struct {
+	Dir string
+
+	mu     sync.Mutex
+	emails []*OutgoingEmail
+	err    error
+}
+
+func NewEmailSink(dir string) *EmailSink {
+	return &EmailSink{Dir: dir}
+}
+
+func (s *EmailSink) Send(ctx context.Context, m *OutgoingEmail) error {
+	s.mu.Lock()
+	failure := s.err
+	s.mu.Unlock()
+	if failure != nil {
+		return failure
+	}
+
+	if s.Dir != "" {
+		if err := s.writeMaildir(m); err != nil {
+			return errors.Wrap(err, "writing to maildir")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := *m
+	s.emails = append(s.emails, &sent)
+	return nil
+}
+
+// writeMaildir delivers a message the way Maildir expects, written to tmp
+// then moved into new.
+func (s *EmailSink) writeMaildir(m *OutgoingEmail) error {
+	now := time.Now()
+	msg, err := m.MIME(now)
+	if err != nil {
+		return err
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(s.Dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	unique, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", now.Unix(), unique[:16], strings.Replace(hostname, "/", "_", -1))
+
+	tmp := filepath.Join(s.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, msg, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.Dir, "new", name))
+}
+
+// Emails gives every email sent so far, oldest first.
+func (s *EmailSink) Emails() []*OutgoingEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*OutgoingEmail{}, s.emails...)
+}
+
+// EmailsTo gives the emails sent to an address, oldest first.
+func (s *EmailSink) EmailsTo(address string) []*OutgoingEmail {
+	var to []*OutgoingEmail
+	for _, m := range s.Emails() {
+		if strings.EqualFold(m.To, address) {
+			to = append(to, m)
+		}
+	}
+	return to
+}
+
+// FailWith makes every send fail with err, until called with nil.
+func (s *EmailSink) FailWith(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Reset forgets the emails sent so far.
+func (s *EmailSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emails = nil
+}
@@ -0,0 +1,160 @@
+package external_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestOutgoingEmailMIME(t *testing.T) {
+	h := TestHelper{t}
+
+	m := &external.OutgoingEmail{
+		From:    "GGWP Academy <no-reply@ggwpacademy.com>",
+		To:      "someone@ggwpacademy.com",
+		Subject: "Héllo",
+		Text:    "plain body",
+		HTML:    `<img src="cid:logo.png"> html body`,
+		Inlines: []external.EmailInline{{Name: "logo.png", Content: []byte("not really a png")}},
+		Tags:    []string{"waitlist"},
+		Variables: map[string]string{
+			"email_id": "42",
+		},
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<https://api.ggwpacademy.com/unsubscribe>",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+	raw, err := m.MIME(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	h.ExpectNoError(err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(msg.Header.Get("To"), "someone@ggwpacademy.com")
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(subject, "Héllo")
+	h.ExpectDeepEq(msg.Header.Get("X-Tags"), "waitlist")
+	h.ExpectDeepEq(msg.Header.Get("X-Mailgun-Variables"), `{"email_id":"42"}`)
+	h.ExpectDeepEq(msg.Header.Get("List-Unsubscribe"), "<https://api.ggwpacademy.com/unsubscribe>")
+	h.ExpectDeepEq(msg.Header.Get("List-Unsubscribe-Post"), "List-Unsubscribe=One-Click")
+	date, err := msg.Header.Date()
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(date.Unix(), time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Unix())
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(mediaType, "multipart/related")
+	related := multipart.NewReader(msg.Body, params["boundary"])
+
+	// the alternatives come first
+	part, err := related.NextPart()
+	h.ExpectNoError(err)
+	mediaType, params, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(mediaType, "multipart/alternative")
+	alternative := multipart.NewReader(part, params["boundary"])
+	var bodies []string
+	for {
+		p, err := alternative.NextPart()
+		if err != nil {
+			break
+		}
+		// quoted-printable is decoded by the reader
+		b, err := ioutil.ReadAll(p)
+		h.ExpectNoError(err)
+		bodies = append(bodies, p.Header.Get("Content-Type")+": "+string(b))
+	}
+	h.ExpectDeepEq(bodies, []string{
+		"text/plain; charset=utf-8: plain body",
+		`text/html; charset=utf-8: <img src="cid:logo.png"> html body`,
+	})
+
+	// then the images
+	part, err = related.NextPart()
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(part.Header.Get("Content-ID"), "<logo.png>")
+	h.ExpectDeepEq(part.Header.Get("Content-Type"), "image/png")
+	content, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(string(content), "not really a png")
+	_, err = related.NextPart()
+	h.ExpectDeepEq(err != nil, true)
+}
+
+func TestOutgoingEmailMIMEWithoutInlines(t *testing.T) {
+	h := TestHelper{t}
+
+	m := &external.OutgoingEmail{
+		From:    "no-reply@ggwpacademy.com",
+		To:      "someone@ggwpacademy.com",
+		Subject: "Forgot Password",
+		Text:    "Your password reset token is: \"ABC\"",
+	}
+	raw, err := m.MIME(time.Now())
+	h.ExpectNoError(err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	h.ExpectNoError(err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(mediaType, "multipart/alternative")
+
+	alternative := multipart.NewReader(msg.Body, params["boundary"])
+	part, err := alternative.NextPart()
+	h.ExpectNoError(err)
+	b, err := ioutil.ReadAll(part)
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(string(b), m.Text)
+	// no html part
+	_, err = alternative.NextPart()
+	h.ExpectDeepEq(err != nil, true)
+}
+
+func TestEmailSink(t *testing.T) {
+	h := TestHelper{t}
+
+	dir, err := ioutil.TempDir("", "maildir")
+	h.ExpectNoError(err)
+	defer os.RemoveAll(dir)
+
+	s := external.NewEmailSink(dir)
+	for _, to := range []string{"a@ggwpacademy.com", "b@ggwpacademy.com", "A@ggwpacademy.com"} {
+		h.ExpectNoError(s.Send(context.Background(), &external.OutgoingEmail{
+			From:    "no-reply@ggwpacademy.com",
+			To:      to,
+			Subject: "Hello",
+			Text:    "body",
+		}))
+	}
+
+	h.ExpectDeepEq(len(s.Emails()), 3)
+	h.ExpectDeepEq(len(s.EmailsTo("a@ggwpacademy.com")), 2)
+
+	// delivered to new, nothing left in tmp
+	delivered, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(len(delivered), 3)
+	pending, err := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(len(pending), 0)
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "new", delivered[0].Name()))
+	h.ExpectNoError(err)
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(msg.Header.Get("Subject"), "Hello")
+
+	s.Reset()
+	h.ExpectDeepEq(len(s.Emails()), 0)
+}
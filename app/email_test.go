@@ -0,0 +1,244 @@
+package external_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestHandlePreviewEmail(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("email.admin@ggwpacademy.com")
+
+	// admins only
+	rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/email/preview?type=welcome", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusForbidden)
+	f.MakeAdmin(auth.UserID)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/email/preview?type=welcome", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectDeepEq(rr.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	f.ExpectDeepEq(rr.Header().Get("X-Email-Subject"), "Welcome to GGWP Academy")
+	f.ExpectBodyContains(rr, "Hi Alex,")
+	// images are inlined for the browser
+	f.ExpectBodyContains(rr, `src="data:image/png;base64,`)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/email/preview?type=FORGOT_PASSWORD&format=text", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectDeepEq(rr.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	f.ExpectBodyContains(rr, "ABCD2345X")
+
+	// previews don't send anything
+	f.ExpectDeepEq(len(f.Email.Emails()), 0)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/email/preview?type=nope", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+}
+
+func TestSendPendingEmails(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	for i := 0; i < 12; i++ {
+		f.ExpectNoError(external.CreateEmail(
+			f.DAO.DB,
+			nil,
+			fmt.Sprintf("outbox.%d@ggwpacademy.com", i),
+			"welcome",
+			external.HStoreMap{"FirstName": "Sam"},
+			external.EmailType_Welcome,
+			external.EmailStatus_Pending,
+		))
+	}
+
+	sent, err := f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(sent, 12)
+	f.ExpectDeepEq(len(f.Email.Emails()), 12)
+	f.ExpectDeepEq(f.Email.EmailsTo("outbox.3@ggwpacademy.com")[0].Subject, "Welcome to GGWP Academy")
+	f.ExpectRowCountWhere("ggwp.emails", "status = 'SENT' AND attempts = 1 AND sent_at IS NOT NULL", 12)
+
+	// sent emails aren't claimed again
+	sent, err = f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(sent, 0)
+	f.ExpectDeepEq(len(f.Email.Emails()), 12)
+}
+
+func TestSendPendingEmailsRetries(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB,
+		nil,
+		"bouncy@ggwpacademy.com",
+		"welcome",
+		external.HStoreMap{"FirstName": "Sam"},
+		external.EmailType_Welcome,
+		external.EmailStatus_Pending,
+	))
+	var id int
+	f.ExpectNoError(f.DAO.DB.Get(&id, `SELECT id FROM ggwp.emails`))
+
+	f.Email.FailWith(fmt.Errorf("mailbox unavailable"))
+	for attempt := 1; attempt <= external.EMAIL_SEND_MAX_ATTEMPTS; attempt++ {
+		sent, err := f.Server.SendPendingEmails(context.Background())
+		f.ExpectNoError(err)
+		f.ExpectDeepEq(sent, 1)
+
+		// backing off, not due until later
+		sent, err = f.Server.SendPendingEmails(context.Background())
+		f.ExpectNoError(err)
+		f.ExpectDeepEq(sent, 0)
+
+		_, err = f.DAO.DB.Exec(`UPDATE ggwp.emails SET next_attempt_at = NOW() - INTERVAL '1 second'`)
+		f.ExpectNoError(err)
+	}
+
+	// out of attempts
+	f.ExpectRowCountWhere(
+		"ggwp.emails",
+		fmt.Sprintf("status = 'FAILED' AND attempts = %d AND last_error LIKE '%%mailbox unavailable'", external.EMAIL_SEND_MAX_ATTEMPTS),
+		1,
+	)
+	sent, err := f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(sent, 0)
+
+	auth := f.GetAuthToken("outbox.admin@ggwpacademy.com")
+	f.MakeAdmin(auth.UserID)
+
+	rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/email/failed", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var failed []*external.Email
+	f.Bind(rr, &failed)
+	f.ExpectDeepEq(len(failed), 1)
+	f.ExpectDeepEq(failed[0].ID, id)
+	f.ExpectDeepEq(failed[0].Status, external.EmailStatus_Failed)
+
+	// retrying queues it with all its attempts
+	f.Email.FailWith(nil)
+	rr = f.AuthedRequest(http.MethodPost, fmt.Sprintf("/api/v0.1/email/failed/%d/retry", id), "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.AuthedRequest(http.MethodPost, fmt.Sprintf("/api/v0.1/email/failed/%d/retry", id), "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+
+	sent, err = f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(sent, 1)
+	f.ExpectDeepEq(len(f.Email.EmailsTo("bouncy@ggwpacademy.com")), 1)
+	f.ExpectRowCountWhere("ggwp.emails", "status = 'SENT' AND attempts = 1", 1)
+}
+
+func TestDiscardFailedEmail(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB,
+		nil,
+		"gone@ggwpacademy.com",
+		"welcome",
+		external.HStoreMap{},
+		external.EmailType_Welcome,
+		external.EmailStatus_Failed,
+	))
+	var id int
+	f.ExpectNoError(f.DAO.DB.Get(&id, `SELECT id FROM ggwp.emails`))
+
+	auth := f.GetAuthToken("outbox.admin@ggwpacademy.com")
+	f.MakeAdmin(auth.UserID)
+
+	rr := f.AuthedRequest(http.MethodDelete, fmt.Sprintf("/api/v0.1/email/failed/%d", id), "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCountWhere("ggwp.emails", "status = 'DISCARDED'", 1)
+
+	// only failed emails can be discarded
+	rr = f.AuthedRequest(http.MethodDelete, fmt.Sprintf("/api/v0.1/email/failed/%d", id), "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+
+	sent, err := f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(sent, 0)
+}
+
+func TestSendPendingEmailsLostClaim(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, nil, "claimed@ggwpacademy.com", "welcome",
+		external.HStoreMap{"FirstName": "Sam"},
+		external.EmailType_Welcome, external.EmailStatus_Pending,
+	))
+	expire := func() {
+		_, err := f.DAO.DB.Exec(`UPDATE ggwp.emails SET processing_expires_at = NOW() - INTERVAL '1 second'`)
+		f.ExpectNoError(err)
+	}
+
+	// a slow sender's lease runs out and another one claims the email
+	slow, err := external.ClaimPendingEmails(f.DAO.DB, "slow", 10, external.EMAIL_SEND_MAX_ATTEMPTS, time.Minute)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(slow), 1)
+	f.ExpectDeepEq(slow[0].Attempts, 1)
+	expire()
+	fast, err := external.ClaimPendingEmails(f.DAO.DB, "fast", 10, external.EMAIL_SEND_MAX_ATTEMPTS, time.Minute)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(fast), 1)
+	f.ExpectDeepEq(fast[0].Attempts, 2)
+
+	held, err := external.MarkEmailAsSent(f.DAO.DB, fast[0].ID, "fast")
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(held, true)
+
+	// the slow sender failing late doesn't queue it again
+	_, held, err = external.MarkEmailSendFailed(
+		f.DAO.DB, slow[0].ID, "slow", "timed out",
+		external.EMAIL_SEND_MAX_ATTEMPTS, time.Minute, time.Hour,
+	)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(held, false)
+	held, err = external.MarkEmailAsSent(f.DAO.DB, slow[0].ID, "slow")
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(held, false)
+	f.ExpectRowCountWhere("ggwp.emails", "status = 'SENT' AND last_error IS NULL", 1)
+}
+
+func TestSendPendingEmailsAbandoned(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	f.ExpectNoError(external.CreateEmail(
+		f.DAO.DB, nil, "crashy@ggwpacademy.com", "welcome",
+		external.HStoreMap{"FirstName": "Sam"},
+		external.EmailType_Welcome, external.EmailStatus_Pending,
+	))
+
+	// every sender claiming it dies before recording anything
+	for attempt := 1; attempt <= external.EMAIL_SEND_MAX_ATTEMPTS; attempt++ {
+		claimed, err := external.ClaimPendingEmails(
+			f.DAO.DB, fmt.Sprintf("crash-%d", attempt), 10, external.EMAIL_SEND_MAX_ATTEMPTS, time.Minute,
+		)
+		f.ExpectNoError(err)
+		f.ExpectDeepEq(len(claimed), 1)
+		_, err = f.DAO.DB.Exec(`UPDATE ggwp.emails SET processing_expires_at = NOW() - INTERVAL '1 second'`)
+		f.ExpectNoError(err)
+	}
+
+	sent, err := f.Server.SendPendingEmails(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(sent, 0)
+	f.ExpectDeepEq(len(f.Email.Emails()), 0)
+	f.ExpectRowCountWhere(
+		"ggwp.emails",
+		fmt.Sprintf("status = 'FAILED' AND attempts = %d AND last_error = 'abandoned while sending'", external.EMAIL_SEND_MAX_ATTEMPTS),
+		1,
+	)
+}
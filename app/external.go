@@ -16,7 +16,15 @@ type External struct {
 	log      *logrus.Entry
 	facebook Facebook
 	twitter  Twitter
-	Router   *mux.Router
+	lrs      LRS
+	email    EmailSender
+	// signs the unsubscribe links of emails
+	emailUnsubscribeKey string
+	// public url of the web app, the api is served under /api/v0.1
+	baseURL string
+	// signing up needs an invite off the waitlist
+	waitlistGateEnabled bool
+	Router              *mux.Router
 }
 
 func New(
@@ -24,15 +32,36 @@ func New(
 	dao *PostgresDAO,
 	facebook Facebook,
 	twitter Twitter,
+	lrs LRS,
+	email EmailSender,
+	emailUnsubscribeKey string,
+	baseURL string,
+	waitlistGateEnabled bool,
 ) *External {
 	return &External{
 		dao:      dao,
 		log:      log,
 		facebook: facebook,
 		twitter:  twitter,
+		lrs:      lrs,
+		email:    email,
+
+		emailUnsubscribeKey: emailUnsubscribeKey,
+		baseURL:             baseURL,
+		waitlistGateEnabled: waitlistGateEnabled,
 	}
 }
 
+// apiURL gives the public url of a path of this api, for links in emails and
+// feeds.
+func (e *External) apiURL(path string) string {
+	return apiURL(e.baseURL, path)
+}
+
+func apiURL(baseURL, path string) string {
+	return baseURL + "/api/v0.1" + path
+}
+
 func (e *External) returnJSON(w http.ResponseWriter, a interface{}) {
 	json, err := json.Marshal(
 		struct {
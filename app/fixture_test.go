@@ -3,6 +3,7 @@ package external_test
 import (
 	"net/http"
 	"os"
+	"strconv"
 	"testing"
 
 	external "github.com/johankaito/api.external/app"
@@ -17,9 +18,23 @@ type Fixture struct {
 	router   http.Handler
 	Facebook *FakeFacebookClient
 	Twitter  *FakeTwitterClient
+	LRS      *LRSStandIn
+	Email    *external.EmailSink
 }
 
+const testBaseURL = "https://www.ggwpacademy.com"
+
 func NewFixture(t *testing.T) *Fixture {
+	return newFixture(t, false)
+}
+
+// NewWaitlistGatedFixture gives a fixture where signing up needs an invite off
+// the waitlist.
+func NewWaitlistGatedFixture(t *testing.T) *Fixture {
+	return newFixture(t, true)
+}
+
+func newFixture(t *testing.T, waitlistGateEnabled bool) *Fixture {
 	if os.Getenv("PARALLEL_TESTS") != "" {
 		t.Parallel()
 	}
@@ -30,8 +45,13 @@ func NewFixture(t *testing.T) *Fixture {
 
 	facebook := &FakeFacebookClient{}
 	twitter := &FakeTwitterClient{}
+	lrs := NewLRSStandIn()
+	email := external.NewEmailSink("")
 	dao := NewTestDAO(t)
-	server := external.New(logger, dao, facebook, twitter)
+	server := external.New(
+		logger, dao, facebook, twitter, lrs.Client(), email,
+		testUnsubscribeKey, testBaseURL, waitlistGateEnabled,
+	)
 	testHelper := &TestHelper{T: t}
 
 	handler, router, err := external.Router(server, logger, []string{})
@@ -48,11 +68,18 @@ func NewFixture(t *testing.T) *Fixture {
 		Logger:     logger,
 		Facebook:   facebook,
 		Twitter:    twitter,
+		LRS:        lrs,
+		Email:      email,
 	}
 
 	return f
 }
 
+func (f *Fixture) Close() {
+	f.LRS.Close()
+	f.DAOFixture.Close()
+}
+
 func (f *Fixture) InsertUser(
 	newUser external.NewUser,
 ) int {
@@ -165,3 +192,63 @@ func (f *Fixture) InsertSocial(
 	)
 	f.ExpectNoError(err)
 }
+
+func (f *Fixture) MakeAdmin(userID int) {
+	_, err := f.DAO.DB.Exec(
+		`
+			UPDATE ggwp.users
+			SET user_admin_level = 'admin'
+			WHERE id = $1
+		`,
+		userID,
+	)
+	f.ExpectNoError(err)
+}
+
+// InsertModuleFile creates an active module owned by userID with a single
+// file, in a category of its own.
+func (f *Fixture) InsertModuleFile(userID int) *external.ModuleFile {
+	var categoryID int
+	err := f.DAO.DB.Get(
+		&categoryID,
+		`
+			INSERT INTO ggwp.module_categories
+			(
+				name, description, created_at, updated_at
+			)
+			VALUES
+			(
+				'Test', 'Test category', NOW(), NOW()
+			)
+			RETURNING id
+		`,
+	)
+	f.ExpectNoError(err)
+
+	m := &external.Module{
+		UserID:      userID,
+		Name:        "Test module",
+		Description: "Test module",
+		CategoryID:  categoryID,
+		Ranking:     1,
+		IsActive:    true,
+	}
+	f.ExpectNoError(external.CreateModule(f.DAO.DB, m))
+
+	file, err := external.CreateFile(f.DAO.DB, &external.File{
+		UserID:    external.NewNullInt64(int64(userID)),
+		Name:      external.NewNullString("video"),
+		Extension: external.NewNullString("mp4"),
+		Type:      external.NewNullString("video"),
+		Size:      external.NewNullInt64(1024),
+	})
+	f.ExpectNoError(err)
+
+	mf := &external.ModuleFile{
+		ModuleID: m.ID,
+		Ranking:  1,
+		FileID:   strconv.FormatInt(file.ID.Int64, 10),
+	}
+	f.ExpectNoError(external.CreateModuleFile(f.DAO.DB, mf))
+	return mf
+}
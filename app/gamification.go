@@ -0,0 +1,280 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// number of ledger entries returned with the achievements
+var achievementsRecentXPLimit = 20
+
+// RecordXPEvent rewards a user for a domain event according to its rule and
+// unlocks any badge the event makes them eligible for. A source is rewarded
+// only once, recording it again does nothing. The module is the one the event
+// happened in, if any, for its leaderboards.
+func RecordXPEvent(q Q, userID int, t XPEventType, sourceKey string, moduleID int) (*XPEntry, error) {
+	if userID <= 0 {
+		return nil, nil
+	}
+
+	var xp int
+	rule, err := GetXPRule(q, t)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "getting xp rule")
+	} else if err == nil && rule.IsActive {
+		xp = rule.XP
+	}
+
+	entry := &XPEntry{
+		UserID:    userID,
+		EventType: t,
+		SourceKey: sourceKey,
+		XP:        xp,
+	}
+	created, err := CreateXPEntry(q, entry)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating xp entry")
+	}
+	if !created {
+		return nil, nil
+	}
+
+	if err := unlockBadges(q, userID); err != nil {
+		return nil, errors.Wrap(err, "unlocking badges")
+	}
+	if err := AddLeaderboardScore(
+		q, userID, LeaderboardMetric_XP, moduleID, float64(xp), time.Now(),
+	); err != nil {
+		return nil, errors.Wrap(err, "adding xp to leaderboards")
+	}
+
+	return entry, nil
+}
+
+func unlockBadges(q Q, userID int) error {
+	badges, err := GetActiveBadgesNotEarnedByUserID(q, userID)
+	if err != nil {
+		return errors.Wrap(err, "getting badges not earned")
+	}
+	if len(badges) == 0 {
+		return nil
+	}
+
+	totals, err := GetXPTotalsByUserID(q, userID)
+	if err != nil {
+		return errors.Wrap(err, "getting xp totals")
+	}
+	levels, err := GetLevels(q)
+	if err != nil {
+		return errors.Wrap(err, "getting levels")
+	}
+	level, _ := LevelForXP(levels, totals.TotalXP)
+
+	for _, b := range badges {
+		if !BadgeUnlocked(b, totals, level) {
+			continue
+		}
+		if err := CreateUserBadge(q, userID, b.ID); err != nil {
+			return errors.Wrapf(err, "awarding badge %s", b.Code)
+		}
+	}
+
+	return nil
+}
+
+// LevelForXP gives the highest level reached with an amount of xp and the one
+// after it, levels must be sorted by the xp they require.
+func LevelForXP(levels []*Level, xp int) (current, next *Level) {
+	for _, l := range levels {
+		if l.XPRequired > xp {
+			return current, l
+		}
+		current = l
+	}
+	return current, nil
+}
+
+// BadgeUnlocked evaluates the unlock condition of a badge.
+func BadgeUnlocked(b *Badge, totals *XPTotals, level *Level) bool {
+	switch b.ConditionType {
+	case BadgeConditionType_EventCount:
+		if b.EventType == nil {
+			return false
+		}
+		return totals.EventCounts[*b.EventType] >= b.Threshold
+	case BadgeConditionType_TotalXP:
+		return totals.TotalXP >= b.Threshold
+	case BadgeConditionType_Level:
+		return level != nil && level.Level >= b.Threshold
+	}
+	return false
+}
+
+func GetAchievements(q Q, userID int) (*Achievements, error) {
+	totals, err := GetXPTotalsByUserID(q, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting xp totals")
+	}
+	levels, err := GetLevels(q)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting levels")
+	}
+	badges, err := GetUserBadgesByUserID(q, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting user badges")
+	}
+	recent, err := GetRecentXPEntriesByUserID(q, userID, achievementsRecentXPLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting recent xp")
+	}
+
+	a := &Achievements{
+		TotalXP:  totals.TotalXP,
+		Badges:   badges,
+		RecentXP: recent,
+	}
+	a.Level, a.NextLevel = LevelForXP(levels, totals.TotalXP)
+	if a.NextLevel != nil {
+		a.XPToNextLevel = a.NextLevel.XPRequired - totals.TotalXP
+	}
+
+	return a, nil
+}
+
+func (e *External) HandleGetAchievements(w http.ResponseWriter, r *http.Request) {
+	a, err := GetAchievements(e.dao.ReadDB, r.Context().Value("user_id").(int))
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	e.returnJSON(w, a)
+}
+
+func (e *External) HandleGetGamificationConfig(w http.ResponseWriter, r *http.Request) {
+	c, err := getGamificationConfig(e.dao.ReadDB)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	e.returnJSON(w, c)
+}
+
+// HandleUpdateGamificationConfig upserts the given rules and badges, levels
+// are replaced as a whole when given.
+func (e *External) HandleUpdateGamificationConfig(w http.ResponseWriter, r *http.Request) {
+	c := &GamificationConfig{}
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	if err := validateGamificationConfig(c); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	for _, rule := range c.Rules {
+		if err := UpsertXPRule(tx, rule); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "saving xp rule %s", rule.EventType))
+			return
+		}
+	}
+	if len(c.Levels) > 0 {
+		if err := ReplaceLevels(tx, c.Levels); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "saving levels"))
+			return
+		}
+	}
+	for _, b := range c.Badges {
+		if err := UpsertBadge(tx, b); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "saving badge %s", b.Code))
+			return
+		}
+	}
+
+	updated, err := getGamificationConfig(tx)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting gamification config"))
+		return
+	}
+
+	e.returnJSON(w, updated)
+}
+
+func getGamificationConfig(q Q) (*GamificationConfig, error) {
+	rules, err := GetXPRules(q)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting xp rules")
+	}
+	levels, err := GetLevels(q)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting levels")
+	}
+	badges, err := GetBadges(q)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting badges")
+	}
+
+	return &GamificationConfig{Rules: rules, Levels: levels, Badges: badges}, nil
+}
+
+func validateGamificationConfig(c *GamificationConfig) error {
+	for _, r := range c.Rules {
+		if r.EventType.String() == "" {
+			return fmt.Errorf("unknown xp event type: %q", r.EventType)
+		}
+		if r.XP < 0 {
+			return fmt.Errorf("negative xp for %s", r.EventType)
+		}
+	}
+
+	levels := map[int]bool{}
+	var hasStart bool
+	for _, l := range c.Levels {
+		if l.Level <= 0 || levels[l.Level] {
+			return fmt.Errorf("invalid or duplicate level: %d", l.Level)
+		}
+		levels[l.Level] = true
+		if l.XPRequired == 0 {
+			hasStart = true
+		}
+	}
+	if len(c.Levels) > 0 && !hasStart {
+		return fmt.Errorf("one level has to require 0 xp")
+	}
+
+	for _, b := range c.Badges {
+		if b.Code == "" || b.Name == "" {
+			return fmt.Errorf("badges need a code and a name")
+		}
+		switch b.ConditionType {
+		case BadgeConditionType_EventCount:
+			if b.EventType == nil || b.EventType.String() == "" {
+				return fmt.Errorf("badge %s needs a known event type", b.Code)
+			}
+		case BadgeConditionType_TotalXP, BadgeConditionType_Level:
+		default:
+			return fmt.Errorf("badge %s has unknown condition type: %q", b.Code, b.ConditionType)
+		}
+	}
+
+	return nil
+}
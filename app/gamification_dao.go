@@ -0,0 +1,364 @@
+package external
+
+func GetXPRule(q Q, t XPEventType) (*XPRule, error) {
+	var r XPRule
+	if err := q.Get(
+		&r,
+		`
+			SELECT
+				event_type,
+				xp,
+				is_active,
+				created_at,
+				updated_at
+			FROM
+				ggwp.xp_rules
+			WHERE
+				event_type = $1
+		`,
+		t,
+	); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func GetXPRules(q Q) ([]*XPRule, error) {
+	var r []*XPRule
+	if err := q.Select(
+		&r,
+		`
+			SELECT
+				event_type,
+				xp,
+				is_active,
+				created_at,
+				updated_at
+			FROM
+				ggwp.xp_rules
+			ORDER BY
+				event_type
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func UpsertXPRule(q Q, r *XPRule) error {
+	if _, err := q.NamedExec(
+		`
+			INSERT INTO ggwp.xp_rules
+			(
+				event_type, xp, is_active, created_at, updated_at
+			)
+			VALUES
+			(
+				:event_type, :xp, :is_active, NOW(), NOW()
+			)
+			ON CONFLICT (event_type) DO UPDATE
+			SET
+				xp = EXCLUDED.xp,
+				is_active = EXCLUDED.is_active,
+				updated_at = NOW()
+		`,
+		r,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetLevels(q Q) ([]*Level, error) {
+	var l []*Level
+	if err := q.Select(
+		&l,
+		`
+			SELECT
+				level,
+				name,
+				xp_required
+			FROM
+				ggwp.levels
+			ORDER BY
+				xp_required, level
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func ReplaceLevels(q Q, levels []*Level) error {
+	if _, err := q.Exec(`DELETE FROM ggwp.levels`); err != nil {
+		return err
+	}
+	for _, l := range levels {
+		if _, err := q.NamedExec(
+			`
+				INSERT INTO ggwp.levels
+				(
+					level, name, xp_required
+				)
+				VALUES
+				(
+					:level, :name, :xp_required
+				)
+			`,
+			l,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func selectFromBadgesWhere(where string) string {
+	return `
+		SELECT
+			b.id,
+			b.code,
+			b.name,
+			b.description,
+			b.condition_type,
+			b.event_type,
+			b.threshold,
+			b.is_active,
+			b.created_at,
+			b.updated_at
+		FROM
+			ggwp.badges b
+	` + where
+}
+
+func GetBadges(q Q) ([]*Badge, error) {
+	var b []*Badge
+	if err := q.Select(
+		&b,
+		selectFromBadgesWhere(`
+			ORDER BY b.id
+		`),
+	); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func GetActiveBadgesNotEarnedByUserID(q Q, userID int) ([]*Badge, error) {
+	var b []*Badge
+	if err := q.Select(
+		&b,
+		selectFromBadgesWhere(`
+			WHERE b.is_active
+				AND NOT EXISTS (
+					SELECT 1
+					FROM ggwp.user_badges uB
+					WHERE uB.badge_id = b.id
+						AND uB.user_id = $1
+				)
+			ORDER BY b.id
+		`),
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func UpsertBadge(q Q, b *Badge) error {
+	if err := q.Get(
+		&b.ID,
+		`
+			INSERT INTO ggwp.badges
+			(
+				code, name, description, condition_type, event_type, threshold,
+				is_active, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, $5, $6, $7, NOW(), NOW()
+			)
+			ON CONFLICT (code) DO UPDATE
+			SET
+				name = EXCLUDED.name,
+				description = EXCLUDED.description,
+				condition_type = EXCLUDED.condition_type,
+				event_type = EXCLUDED.event_type,
+				threshold = EXCLUDED.threshold,
+				is_active = EXCLUDED.is_active,
+				updated_at = NOW()
+			RETURNING id
+		`,
+		b.Code,
+		b.Name,
+		b.Description,
+		b.ConditionType,
+		b.EventType,
+		b.Threshold,
+		b.IsActive,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetUserBadgesByUserID(q Q, userID int) ([]*UserBadge, error) {
+	var b []*UserBadge
+	if err := q.Select(
+		&b,
+		`
+			SELECT
+				b.id,
+				b.code,
+				b.name,
+				b.description,
+				b.condition_type,
+				b.event_type,
+				b.threshold,
+				b.is_active,
+				b.created_at,
+				b.updated_at,
+				uB.earned_at
+			FROM
+				ggwp.user_badges uB
+			JOIN
+				ggwp.badges b
+				ON b.id = uB.badge_id
+			WHERE
+				uB.user_id = $1
+			ORDER BY
+				uB.earned_at, b.id
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func CreateUserBadge(q Q, userID, badgeID int) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.user_badges
+			(
+				user_id, badge_id, earned_at
+			)
+			VALUES
+			(
+				$1, $2, NOW()
+			)
+			ON CONFLICT (user_id, badge_id) DO NOTHING
+		`,
+		userID,
+		badgeID,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateXPEntry adds an entry to the ledger, it returns false when the source
+// was already rewarded.
+func CreateXPEntry(q Q, e *XPEntry) (bool, error) {
+	rows, err := q.NamedQuery(
+		`
+			INSERT INTO ggwp.xp_ledger
+			(
+				user_id, event_type, source_key, xp, created_at
+			)
+			VALUES
+			(
+				:user_id, :event_type, :source_key, :xp, NOW()
+			)
+			ON CONFLICT (user_id, event_type, source_key) DO NOTHING
+			RETURNING id
+		`,
+		e,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	if err := rows.Scan(&e.ID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func GetXPTotalsByUserID(q Q, userID int) (*XPTotals, error) {
+	var rows []struct {
+		EventType XPEventType `db:"event_type"`
+		Count     int         `db:"count"`
+		XP        int         `db:"xp"`
+	}
+	if err := q.Select(
+		&rows,
+		`
+			SELECT
+				event_type,
+				COUNT(*) count,
+				COALESCE(SUM(xp), 0) xp
+			FROM
+				ggwp.xp_ledger
+			WHERE
+				user_id = $1
+			GROUP BY
+				event_type
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	t := &XPTotals{EventCounts: map[XPEventType]int{}}
+	for _, r := range rows {
+		t.TotalXP += r.XP
+		t.EventCounts[r.EventType] = r.Count
+	}
+
+	return t, nil
+}
+
+func GetRecentXPEntriesByUserID(q Q, userID, limit int) ([]*XPEntry, error) {
+	var e []*XPEntry
+	if err := q.Select(
+		&e,
+		`
+			SELECT
+				id,
+				user_id,
+				event_type,
+				source_key,
+				xp,
+				created_at
+			FROM
+				ggwp.xp_ledger
+			WHERE
+				user_id = $1
+			ORDER BY
+				created_at DESC, id DESC
+			LIMIT $2
+		`,
+		userID,
+		limit,
+	); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
@@ -0,0 +1,65 @@
+package external_test
+
+import (
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+	"github.com/shopspring/decimal"
+)
+
+var testLevels = []*external.Level{
+	{Level: 1, Name: "Rookie", XPRequired: 0},
+	{Level: 2, Name: "Amateur", XPRequired: 100},
+	{Level: 3, Name: "Semi-Pro", XPRequired: 300},
+}
+
+func TestLevelForXP(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	current, next := external.LevelForXP(testLevels, 0)
+	h.ExpectDeepEq(current, testLevels[0])
+	h.ExpectDeepEq(next, testLevels[1])
+
+	current, next = external.LevelForXP(testLevels, 100)
+	h.ExpectDeepEq(current, testLevels[1])
+	h.ExpectDeepEq(next, testLevels[2])
+
+	current, next = external.LevelForXP(testLevels, 5000)
+	h.ExpectDeepEq(current, testLevels[2])
+	h.ExpectDeepEq(next, (*external.Level)(nil))
+}
+
+func TestBadgeUnlocked(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	quizPassed := external.XPEventType_QuizPassedFirstTake
+	totals := &external.XPTotals{
+		TotalXP: 150,
+		EventCounts: map[external.XPEventType]int{
+			external.XPEventType_QuizPassedFirstTake: 3,
+		},
+	}
+
+	for _, tc := range []struct {
+		badge    *external.Badge
+		unlocked bool
+	}{
+		{&external.Badge{ConditionType: external.BadgeConditionType_EventCount, EventType: &quizPassed, Threshold: 3}, true},
+		{&external.Badge{ConditionType: external.BadgeConditionType_EventCount, EventType: &quizPassed, Threshold: 5}, false},
+		{&external.Badge{ConditionType: external.BadgeConditionType_EventCount, Threshold: 1}, false},
+		{&external.Badge{ConditionType: external.BadgeConditionType_TotalXP, Threshold: 150}, true},
+		{&external.Badge{ConditionType: external.BadgeConditionType_Level, Threshold: 2}, true},
+		{&external.Badge{ConditionType: external.BadgeConditionType_Level, Threshold: 3}, false},
+	} {
+		h.ExpectDeepEq(external.BadgeUnlocked(tc.badge, totals, testLevels[1]), tc.unlocked)
+	}
+}
+
+func TestQuizPassed(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	h.ExpectDeepEq(external.QuizPassed(&external.Quiz{}, 4, 5), true)
+	h.ExpectDeepEq(external.QuizPassed(&external.Quiz{}, 3, 5), false)
+	h.ExpectDeepEq(external.QuizPassed(&external.Quiz{PassingGrade: decimal.New(60, 0)}, 3, 5), true)
+	h.ExpectDeepEq(external.QuizPassed(&external.Quiz{PassingGrade: decimal.New(60, 0)}, 0, 0), false)
+}
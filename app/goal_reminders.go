@@ -0,0 +1,184 @@
+package external
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// reminders go out this evening unless users choose otherwise
+	DefaultGoalReminderTime = ClockTime(18 * 60)
+	// goals are reminded of when their deadline is within this many days
+	GoalDeadlineReminderDays = 1
+)
+
+// GoalReminderDue gives the reminder due for a goal at a time in the user's
+// timezone, if any. Reminders are only due from the user's reminder time on
+// and never during their quiet hours. A deadline within reach takes
+// precedence over the closing of a recurring period, which is due on the last
+// day of the period. The key identifies the reminder so it is sent once.
+func GoalReminderDue(g *UserGoal, s *GoalReminderSettings, local time.Time) (GoalReminderKind, Date, bool) {
+	now := ClockTimeOf(local)
+	if now < s.ReminderTime {
+		return "", 0, false
+	}
+	if s.QuietHoursStart != nil && s.QuietHoursEnd != nil && now.Between(*s.QuietHoursStart, *s.QuietHoursEnd) {
+		return "", 0, false
+	}
+
+	today := FromTime(local)
+	if g.Deadline != nil && g.Deadline.Valid {
+		if g.Deadline.Time.After(local) {
+			deadline := FromTime(g.Deadline.Time.In(local.Location()))
+			if int(deadline-today) <= GoalDeadlineReminderDays {
+				return GoalReminderKind_Deadline, deadline, true
+			}
+		}
+	}
+
+	switch GoalRate(g.Rate) {
+	case GoalRate_Daily:
+		return GoalReminderKind_PeriodClosing, today, true
+	case GoalRate_Weekly:
+		start := today.StartOfWeek()
+		if today == start+6 {
+			return GoalReminderKind_PeriodClosing, start, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// QueueGoalReminders queues the reminder emails due for goals approaching
+// their deadline or whose recurring period is about to close unfinished.
+func (e *External) QueueGoalReminders(ctx context.Context, now time.Time) (int, error) {
+	tx, err := e.dao.GetTx(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin tx")
+	}
+	defer tx.Rollback()
+
+	candidates, err := GetGoalReminderCandidates(tx)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting goal reminder candidates")
+	}
+
+	var queued int
+	for _, c := range candidates {
+		g := c.UserGoal
+		loc := UserLocation(c.Timezone)
+		kind, key, ok := GoalReminderDue(g, c.GoalReminderSettings, now.In(loc))
+		if !ok {
+			continue
+		}
+
+		progress := "0"
+		period, err := GetGoalPeriod(tx, g.ID, key)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, errors.Wrapf(err, "getting period of goal %d", g.ID)
+		} else if err == nil {
+			if kind == GoalReminderKind_PeriodClosing && period.Status == GoalPeriodStatus_Completed {
+				continue
+			}
+			period.setProgress()
+			progress = period.Progress.String()
+		}
+
+		created, err := CreateGoalReminder(tx, g.ID, kind, key)
+		if err != nil {
+			return 0, errors.Wrapf(err, "creating reminder of goal %d", g.ID)
+		}
+		if !created {
+			continue
+		}
+
+		vars := HStoreMap{
+			"FirstName":       c.FirstName,
+			"Kind":            kind.String(),
+			"GoalDescription": g.Description,
+			"Progress":        progress,
+		}
+		if kind == GoalReminderKind_Deadline {
+			vars["Deadline"] = key.String()
+		}
+		userID := g.UserID
+		if err := CreateEmail(
+			tx,
+			&userID,
+			c.Email,
+			templateInfo[EmailType_GoalReminder].name,
+			vars,
+			EmailType_GoalReminder,
+			EmailStatus_Pending,
+		); err != nil {
+			return 0, errors.Wrapf(err, "creating reminder email of goal %d", g.ID)
+		}
+		queued++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commiting goal reminders")
+	}
+
+	return queued, nil
+}
+
+func (e *External) HandleGetGoalReminderSettings(w http.ResponseWriter, r *http.Request) {
+	s, err := GetGoalReminderSettings(e.dao.ReadDB, r.Context().Value("user_id").(int))
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal reminder settings"))
+		return
+	}
+
+	e.returnJSON(w, s)
+}
+
+// HandleUpdateGoalReminderSettings changes the settings given, quiet hours are
+// removed with null start and end.
+func (e *External) HandleUpdateGoalReminderSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := EnsureGoalReminderSettings(tx, userID); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating goal reminder settings"))
+		return
+	}
+	s, err := GetGoalReminderSettings(tx, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal reminder settings"))
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid request"))
+		return
+	}
+	s.UserID = userID
+	if (s.QuietHoursStart == nil) != (s.QuietHoursEnd == nil) {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("quiet hours need both a start and an end"))
+		return
+	}
+
+	if err := UpdateGoalReminderSettings(tx, s); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "updating goal reminder settings"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting goal reminder settings"))
+		return
+	}
+
+	e.returnJSON(w, s)
+}
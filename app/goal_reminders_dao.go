@@ -0,0 +1,185 @@
+package external
+
+// GetGoalReminderSettings gives the settings of a user, the defaults when they
+// never changed them.
+func GetGoalReminderSettings(q Q, userID int) (*GoalReminderSettings, error) {
+	var s []*GoalReminderSettings
+	if err := q.Select(
+		&s,
+		`
+			SELECT
+				user_id,
+				reminder_time,
+				quiet_hours_start,
+				quiet_hours_end,
+				updated_at
+			FROM
+				ggwp.goal_reminder_settings
+			WHERE
+				user_id = $1
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+	if len(s) == 0 {
+		return &GoalReminderSettings{UserID: userID, ReminderTime: DefaultGoalReminderTime}, nil
+	}
+
+	return s[0], nil
+}
+
+// EnsureGoalReminderSettings creates the default settings of a user if needed.
+func EnsureGoalReminderSettings(q Q, userID int) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.goal_reminder_settings
+			(
+				user_id, reminder_time, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, NOW(), NOW()
+			)
+			ON CONFLICT (user_id) DO NOTHING
+		`,
+		userID,
+		DefaultGoalReminderTime,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func UpdateGoalReminderSettings(q Q, s *GoalReminderSettings) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.goal_reminder_settings
+			SET
+				reminder_time = $2,
+				quiet_hours_start = $3,
+				quiet_hours_end = $4,
+				updated_at = NOW()
+			WHERE
+				user_id = $1
+		`,
+		s.UserID,
+		s.ReminderTime,
+		s.QuietHoursStart,
+		s.QuietHoursEnd,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetGoalReminderCandidates gives the open goals which have a deadline coming
+// up or are recurring, for users who did not unsubscribe from reminder emails.
+func GetGoalReminderCandidates(q Q) ([]*GoalReminderCandidate, error) {
+	var c []*GoalReminderCandidate
+	if err := q.Select(
+		&c,
+		`
+			SELECT
+				g.id "goal.id",
+				g.user_id "goal.user_id",
+				g.description "goal.description",
+				g.value "goal.value",
+				g.rate "goal.rate",
+				g.deadline "goal.deadline",
+				g.completed_at "goal.completed_at",
+				g.is_active "goal.is_active",
+				g.template_id "goal.template_id",
+				g.created_at "goal.created_at",
+				g.updated_at "goal.updated_at",
+				g.user_id "settings.user_id",
+				COALESCE(s.reminder_time, $1) "settings.reminder_time",
+				s.quiet_hours_start "settings.quiet_hours_start",
+				s.quiet_hours_end "settings.quiet_hours_end",
+				s.updated_at "settings.updated_at",
+				u.email,
+				u.timezone,
+				COALESCE(p.first_name, '') first_name
+			FROM
+				ggwp.user_goals g
+			JOIN
+				ggwp.users u
+				ON u.id = g.user_id
+			LEFT JOIN
+				ggwp.players p
+				ON p.user_id = u.id
+			LEFT JOIN
+				ggwp.goal_reminder_settings s
+				ON s.user_id = g.user_id
+			WHERE
+				g.is_active
+				AND g.completed_at IS NULL
+				AND u.is_active
+				AND NOT EXISTS (
+					SELECT 1
+					FROM ggwp.user_email_preferences eP
+					WHERE eP.user_id = u.id
+						AND eP.category = $2
+						AND NOT eP.subscribed
+				)
+				AND (
+					g.deadline BETWEEN NOW() AND NOW() + INTERVAL '3 days'
+					OR UPPER(TRIM(g.rate)) IN ('DAILY', 'DAY', 'PER_DAY', 'WEEKLY', 'WEEK', 'PER_WEEK')
+				)
+		`,
+		DefaultGoalReminderTime,
+		EmailCategory_Reminders,
+	); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func GetGoalPeriod(q Q, goalID int, periodStart Date) (*GoalPeriod, error) {
+	var p GoalPeriod
+	if err := q.Get(
+		&p,
+		selectFromGoalPeriods+`
+			WHERE goal_id = $1 AND period_start = $2
+		`,
+		goalID,
+		periodStart,
+	); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// CreateGoalReminder records a reminder as sent, it returns false when it
+// already was.
+func CreateGoalReminder(q Q, goalID int, kind GoalReminderKind, key Date) (bool, error) {
+	res, err := q.Exec(
+		`
+			INSERT INTO ggwp.goal_reminders
+			(
+				goal_id, kind, reminder_key, created_at
+			)
+			VALUES
+			(
+				$1, $2, $3, NOW()
+			)
+			ON CONFLICT (goal_id, kind, reminder_key) DO NOTHING
+		`,
+		goalID,
+		kind,
+		key,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
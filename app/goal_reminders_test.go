@@ -0,0 +1,78 @@
+package external_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func clockTime(t *testing.T, s string) *external.ClockTime {
+	c, err := external.ParseClockTime(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestClockTime(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	c := clockTime(t, "18:30")
+	h.ExpectDeepEq(int(*c), 18*60+30)
+	b, err := json.Marshal(c)
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(string(b), `"18:30"`)
+
+	var parsed external.ClockTime
+	h.ExpectNoError(json.Unmarshal([]byte(`"07:05"`), &parsed))
+	h.ExpectDeepEq(parsed.String(), "07:05")
+
+	// quiet hours going past midnight
+	h.ExpectDeepEq(clockTime(t, "23:00").Between(*clockTime(t, "22:00"), *clockTime(t, "07:00")), true)
+	h.ExpectDeepEq(clockTime(t, "06:59").Between(*clockTime(t, "22:00"), *clockTime(t, "07:00")), true)
+	h.ExpectDeepEq(clockTime(t, "07:00").Between(*clockTime(t, "22:00"), *clockTime(t, "07:00")), false)
+	h.ExpectDeepEq(clockTime(t, "13:00").Between(*clockTime(t, "12:00"), *clockTime(t, "14:00")), true)
+}
+
+func TestGoalReminderDue(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	settings := &external.GoalReminderSettings{ReminderTime: *clockTime(t, "18:00")}
+	// a sunday evening
+	evening := time.Date(2020, 3, 8, 19, 0, 0, 0, time.UTC)
+
+	weekly := &external.UserGoal{ID: 1, Rate: "WEEKLY"}
+	kind, key, ok := external.GoalReminderDue(weekly, settings, evening)
+	h.ExpectDeepEq(ok, true)
+	h.ExpectDeepEq(kind, external.GoalReminderKind_PeriodClosing)
+	h.ExpectDeepEq(key.String(), "2020-03-02")
+
+	// too early, or not the last day of the week
+	_, _, ok = external.GoalReminderDue(weekly, settings, evening.Add(-2*time.Hour))
+	h.ExpectDeepEq(ok, false)
+	_, _, ok = external.GoalReminderDue(weekly, settings, evening.AddDate(0, 0, -1))
+	h.ExpectDeepEq(ok, false)
+
+	// deadline tomorrow
+	oneOff := &external.UserGoal{ID: 2, Deadline: goalTime(evening.Add(20 * time.Hour))}
+	kind, key, ok = external.GoalReminderDue(oneOff, settings, evening)
+	h.ExpectDeepEq(ok, true)
+	h.ExpectDeepEq(kind, external.GoalReminderKind_Deadline)
+	h.ExpectDeepEq(key.String(), "2020-03-09")
+
+	// deadline too far away
+	oneOff.Deadline = goalTime(evening.AddDate(0, 0, 5))
+	_, _, ok = external.GoalReminderDue(oneOff, settings, evening)
+	h.ExpectDeepEq(ok, false)
+
+	// quiet hours
+	quiet := &external.GoalReminderSettings{
+		ReminderTime:    *clockTime(t, "18:00"),
+		QuietHoursStart: clockTime(t, "18:30"),
+		QuietHoursEnd:   clockTime(t, "08:00"),
+	}
+	_, _, ok = external.GoalReminderDue(weekly, quiet, evening)
+	h.ExpectDeepEq(ok, false)
+}
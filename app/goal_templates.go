@@ -0,0 +1,164 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// GoalFromTemplate fills what the goal leaves out with the defaults of the
+// template, the deadline comes from its suggested duration.
+func GoalFromTemplate(g *UserGoal, t *GoalTemplate, now time.Time) {
+	g.TemplateID = &t.ID
+	if g.Description == "" {
+		g.Description = t.Name
+	}
+	if g.Value.IsZero() {
+		g.Value = t.DefaultValue
+	}
+	if g.Rate == "" {
+		g.Rate = t.DefaultRate
+	}
+	if (g.Deadline == nil || !g.Deadline.Valid) && t.SuggestedDurationDays > 0 {
+		g.Deadline = &NullTime{NullTime: pq.NullTime{
+			Time:  now.AddDate(0, 0, t.SuggestedDurationDays),
+			Valid: true,
+		}}
+	}
+}
+
+func validateGoalTemplate(t *GoalTemplate) error {
+	if t.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if t.DefaultValue.IsNegative() {
+		return fmt.Errorf("negative default value")
+	}
+	if t.DefaultRate != "" {
+		if t.DefaultRate = GoalRate(t.DefaultRate); t.DefaultRate == "" {
+			return fmt.Errorf("default rate has to be %s or %s", GoalRate_Daily, GoalRate_Weekly)
+		}
+	}
+	if t.SuggestedDurationDays < 0 {
+		return fmt.Errorf("negative suggested duration")
+	}
+	return nil
+}
+
+// HandleGetGoalTemplates gives every template along with its stats.
+func (e *External) HandleGetGoalTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := GetGoalTemplates(e.dao.ReadDB)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal templates"))
+		return
+	}
+	stats, err := GetGoalTemplateStats(e.dao.ReadDB)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal template stats"))
+		return
+	}
+
+	templateIDToStats := map[int]*GoalTemplateStats{}
+	for _, s := range stats {
+		templateIDToStats[s.TemplateID] = s
+	}
+	for _, t := range templates {
+		t.Stats = templateIDToStats[t.ID]
+	}
+
+	e.returnJSON(w, templates)
+}
+
+func (e *External) HandleCreateGoalTemplate(w http.ResponseWriter, r *http.Request) {
+	t := &GoalTemplate{IsActive: true}
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	if err := validateGoalTemplate(t); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := CreateGoalTemplate(e.dao.DB, t); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating goal template"))
+		return
+	}
+
+	e.returnJSON(w, t)
+}
+
+// HandleUpdateGoalTemplate changes the fields given.
+func (e *External) HandleUpdateGoalTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing template id"))
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	t, err := GetGoalTemplateByID(tx, templateID)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal template: %d", templateID))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal template"))
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	t.ID = templateID
+	if err := validateGoalTemplate(t); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := UpdateGoalTemplate(tx, t); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "updating goal template"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting goal template"))
+		return
+	}
+
+	e.returnJSON(w, t)
+}
+
+// HandleDeleteGoalTemplate deactivates a template, goals created from it keep
+// their link for the stats.
+func (e *External) HandleDeleteGoalTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing template id"))
+		return
+	}
+
+	found, err := DeactivateGoalTemplate(e.dao.DB, templateID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "deactivating goal template"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal template: %d", templateID))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
@@ -0,0 +1,183 @@
+package external
+
+func selectFromGoalTemplatesWhere(where string) string {
+	return `
+		SELECT
+			id,
+			name,
+			description,
+			quantitative,
+			default_value,
+			default_rate,
+			unit,
+			suggested_duration_days,
+			is_active,
+			created_at,
+			updated_at
+		FROM ggwp.user_goal_templates
+	` + where
+}
+
+// GetAllGoalTemplates gives the templates users can pick from.
+func GetAllGoalTemplates(q Q) ([]*GoalTemplate, error) {
+	var t []*GoalTemplate
+	if err := q.Select(
+		&t,
+		selectFromGoalTemplatesWhere(`
+			WHERE is_active
+			ORDER BY id
+		`),
+	); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// GetGoalTemplates gives every template, including deactivated ones.
+func GetGoalTemplates(q Q) ([]*GoalTemplate, error) {
+	var t []*GoalTemplate
+	if err := q.Select(
+		&t,
+		selectFromGoalTemplatesWhere(`
+			ORDER BY id
+		`),
+	); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func GetGoalTemplateByID(q Q, ID int) (*GoalTemplate, error) {
+	var t GoalTemplate
+	if err := q.Get(
+		&t,
+		selectFromGoalTemplatesWhere(`
+			WHERE id = $1
+		`),
+		ID,
+	); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func CreateGoalTemplate(q Q, t *GoalTemplate) error {
+	if err := q.Get(
+		&t.ID,
+		`
+			INSERT INTO ggwp.user_goal_templates
+			(
+				name, description, quantitative, default_value, default_rate, unit,
+				suggested_duration_days, is_active, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW()
+			)
+			RETURNING id
+		`,
+		t.Name,
+		t.Description,
+		t.Quantitative,
+		t.DefaultValue,
+		t.DefaultRate,
+		t.Unit,
+		t.SuggestedDurationDays,
+		t.IsActive,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func UpdateGoalTemplate(q Q, t *GoalTemplate) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.user_goal_templates
+			SET
+				name = $2,
+				description = $3,
+				quantitative = $4,
+				default_value = $5,
+				default_rate = $6,
+				unit = $7,
+				suggested_duration_days = $8,
+				is_active = $9,
+				updated_at = NOW()
+			WHERE
+				id = $1
+		`,
+		t.ID,
+		t.Name,
+		t.Description,
+		t.Quantitative,
+		t.DefaultValue,
+		t.DefaultRate,
+		t.Unit,
+		t.SuggestedDurationDays,
+		t.IsActive,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeactivateGoalTemplate hides a template from users, goals created from it
+// keep referencing it.
+func DeactivateGoalTemplate(q Q, ID int) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.user_goal_templates
+			SET is_active = FALSE, updated_at = NOW()
+			WHERE id = $1
+		`,
+		ID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetGoalTemplateStats gives how many goals were created from each template
+// and how many of them were completed.
+func GetGoalTemplateStats(q Q) ([]*GoalTemplateStats, error) {
+	var s []*GoalTemplateStats
+	if err := q.Select(
+		&s,
+		`
+			SELECT
+				t.id template_id,
+				COUNT(g.id) adoptions,
+				COUNT(DISTINCT g.user_id) users,
+				COUNT(g.completed_at) completed,
+				CASE
+					WHEN COUNT(g.id) = 0 THEN 0
+					ELSE ROUND(COUNT(g.completed_at) * 100.0 / COUNT(g.id), 2)
+				END completion_rate
+			FROM
+				ggwp.user_goal_templates t
+			LEFT JOIN
+				ggwp.user_goals g
+				ON g.template_id = t.id
+			GROUP BY
+				t.id
+			ORDER BY
+				t.id
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
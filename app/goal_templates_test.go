@@ -0,0 +1,230 @@
+package external_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+	"github.com/shopspring/decimal"
+)
+
+func TestGoalFromTemplate(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	template := &external.GoalTemplate{
+		ID:                    4,
+		Name:                  "Free throws",
+		DefaultValue:          decimal.New(200, 0),
+		DefaultRate:           external.GoalRate_Weekly,
+		Unit:                  "shots",
+		SuggestedDurationDays: 28,
+	}
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	g := &external.UserGoal{}
+	external.GoalFromTemplate(g, template, now)
+	h.ExpectDeepEq(*g.TemplateID, 4)
+	h.ExpectDeepEq(g.Description, "Free throws")
+	h.ExpectDeepEq(g.Value.String(), "200")
+	h.ExpectDeepEq(g.Rate, external.GoalRate_Weekly)
+	h.ExpectDeepEq(g.Deadline.Time, now.AddDate(0, 0, 28))
+
+	// what the user sets wins
+	g = &external.UserGoal{Description: "100 a day", Value: decimal.New(100, 0), Rate: external.GoalRate_Daily}
+	external.GoalFromTemplate(g, template, now)
+	h.ExpectDeepEq(g.Description, "100 a day")
+	h.ExpectDeepEq(g.Value.String(), "100")
+	h.ExpectDeepEq(g.Rate, external.GoalRate_Daily)
+}
+
+func TestGoalTemplatesAdmin(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	admin := f.GetAuthToken("goal-templates-admin@ggwpacademy.com")
+	f.MakeAdmin(admin.UserID)
+	user := f.GetAuthToken("goal-templates@ggwpacademy.com")
+
+	// only admins manage templates
+	for _, req := range []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/api/v0.1/goals/templates", ""},
+		{http.MethodPost, "/api/v0.1/goals/templates", `{"name": "Free throws"}`},
+		{http.MethodPut, "/api/v0.1/goals/templates/1", `{"name": "Free throws"}`},
+		{http.MethodDelete, "/api/v0.1/goals/templates/1", ""},
+	} {
+		rr := f.AuthedRequest(req.method, req.path, req.body, user.AccessToken)
+		f.ExpectStatus(rr, http.StatusForbidden)
+		rr = f.AuthedRequest(req.method, req.path, req.body, "")
+		f.ExpectStatus(rr, http.StatusForbidden)
+	}
+
+	rr := f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/goals/templates",
+		`{"name": "Free throws", "default_value": 200, "default_rate": "weekly", "unit": "shots", "suggested_duration_days": 28}`,
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	created := &external.GoalTemplate{}
+	f.Bind(rr, created)
+	f.ExpectDeepEq(created.DefaultRate, external.GoalRate_Weekly)
+	f.ExpectDeepEq(created.IsActive, true)
+
+	for _, body := range []string{
+		`{"default_value": 200}`,
+		`{"name": "Free throws", "default_value": -1}`,
+		`{"name": "Free throws", "default_rate": "HOURLY"}`,
+		`{"name": "Free throws", "suggested_duration_days": -1}`,
+		`not json`,
+	} {
+		rr = f.AuthedRequest(http.MethodPost, "/api/v0.1/goals/templates", body, admin.AccessToken)
+		f.ExpectStatus(rr, http.StatusBadRequest)
+	}
+
+	// updates change the fields given
+	path := fmt.Sprintf("/api/v0.1/goals/templates/%d", created.ID)
+	rr = f.AuthedRequest(http.MethodPut, path, `{"unit": "baskets"}`, admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	updated, err := external.GetGoalTemplateByID(f.DAO.DB, created.ID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(updated.Name, "Free throws")
+	f.ExpectDeepEq(updated.Unit, "baskets")
+	f.ExpectDeepEq(updated.SuggestedDurationDays, 28)
+	rr = f.AuthedRequest(http.MethodPut, path, `{"name": ""}`, admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	rr = f.AuthedRequest(http.MethodPut, "/api/v0.1/goals/templates/999999", `{"unit": "baskets"}`, admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+
+	// deleting deactivates, admins still see the template
+	rr = f.AuthedRequest(http.MethodDelete, path, "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.AuthedRequest(http.MethodDelete, "/api/v0.1/goals/templates/999999", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+	f.ExpectRowCountWhere("ggwp.user_goal_templates", fmt.Sprintf("id = %d AND NOT is_active", created.ID), 1)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/goals/templates", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var all []*external.GoalTemplate
+	f.Bind(rr, &all)
+	found := false
+	for _, template := range all {
+		if template.ID == created.ID {
+			found = true
+			f.ExpectDeepEq(template.IsActive, false)
+			f.ExpectDeepEq(template.Stats.Adoptions, 0)
+		}
+	}
+	f.ExpectDeepEq(found, true)
+
+	// users don't
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/user/goals/templates", "", user.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var active []*external.GoalTemplate
+	f.Bind(rr, &active)
+	for _, template := range active {
+		if template.ID == created.ID {
+			t.Errorf("deactivated template %d offered to users", created.ID)
+		}
+	}
+}
+
+func TestGoalCreateFromTemplate(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("goal-from-template@ggwpacademy.com")
+	template := &external.GoalTemplate{
+		Name:                  "Free throws",
+		DefaultValue:          decimal.New(200, 0),
+		DefaultRate:           external.GoalRate_Weekly,
+		SuggestedDurationDays: 28,
+		IsActive:              true,
+	}
+	f.ExpectNoError(external.CreateGoalTemplate(f.DAO.DB, template))
+
+	body := fmt.Sprintf(`{"template_id": %d}`, template.ID)
+	rr := f.AuthedRequest(http.MethodPost, "/api/v0.1/user/self/goals", body, auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCountWhere(
+		"ggwp.user_goals",
+		fmt.Sprintf(
+			"user_id = %d AND template_id = %d AND description = 'Free throws' AND value = 200 AND rate = 'WEEKLY' AND deadline IS NOT NULL",
+			auth.UserID, template.ID,
+		),
+		1,
+	)
+
+	// inactive and unknown templates can't be picked
+	found, err := external.DeactivateGoalTemplate(f.DAO.DB, template.ID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(found, true)
+	for _, body := range []string{body, `{"template_id": 999999}`} {
+		rr = f.AuthedRequest(http.MethodPost, "/api/v0.1/user/self/goals", body, auth.AccessToken)
+		f.ExpectStatus(rr, http.StatusBadRequest)
+	}
+	f.ExpectRowCountWhere("ggwp.user_goals", fmt.Sprintf("user_id = %d", auth.UserID), 1)
+}
+
+func TestGetGoalTemplateStats(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	templates := make([]*external.GoalTemplate, 3)
+	for i := range templates {
+		templates[i] = &external.GoalTemplate{Name: fmt.Sprintf("Template %d", i), IsActive: true}
+		f.ExpectNoError(external.CreateGoalTemplate(f.DAO.DB, templates[i]))
+	}
+
+	// one user with two goals of the first template, one completed, and
+	// another with a goal of the first and the second
+	first := f.GetAuthToken("goal-stats-1@ggwpacademy.com")
+	second := f.GetAuthToken("goal-stats-2@ggwpacademy.com")
+	for _, g := range []struct {
+		userID, template int
+		completed        bool
+	}{
+		{first.UserID, 0, true},
+		{first.UserID, 0, false},
+		{second.UserID, 0, false},
+		{second.UserID, 1, true},
+	} {
+		_, err := f.DAO.DB.Exec(
+			`
+				INSERT INTO ggwp.user_goals
+				(
+					user_id, description, value, rate, template_id, completed_at, is_active, created_at, updated_at
+				)
+				VALUES
+				(
+					$1, 'goal', 1, 'DAILY', $2, CASE WHEN $3 THEN NOW() END, TRUE, NOW(), NOW()
+				)
+			`,
+			g.userID,
+			templates[g.template].ID,
+			g.completed,
+		)
+		f.ExpectNoError(err)
+	}
+
+	stats, err := external.GetGoalTemplateStats(f.DAO.DB)
+	f.ExpectNoError(err)
+	templateIDToStats := map[int]*external.GoalTemplateStats{}
+	for _, s := range stats {
+		templateIDToStats[s.TemplateID] = s
+	}
+
+	s := templateIDToStats[templates[0].ID]
+	f.ExpectDeepEq([]int{s.Adoptions, s.Users, s.Completed}, []int{3, 2, 1})
+	f.ExpectDeepEq(s.CompletionRate.String(), "33.33")
+	s = templateIDToStats[templates[1].ID]
+	f.ExpectDeepEq([]int{s.Adoptions, s.Users, s.Completed}, []int{1, 1, 1})
+	f.ExpectDeepEq(s.CompletionRate.String(), "100")
+	// templates nobody picked are there too
+	s = templateIDToStats[templates[2].ID]
+	f.ExpectDeepEq([]int{s.Adoptions, s.Users, s.Completed}, []int{0, 0, 0})
+	f.ExpectDeepEq(s.CompletionRate.String(), "0")
+}
@@ -0,0 +1,347 @@
+package external
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	GoalRate_Daily  = "DAILY"
+	GoalRate_Weekly = "WEEKLY"
+)
+
+// GoalRate normalises the free form rate of a goal. Recurring goals are either
+// daily or weekly, anything else is a one off goal.
+func GoalRate(rate string) string {
+	switch strings.ToUpper(strings.TrimSpace(rate)) {
+	case GoalRate_Daily, "DAY", "PER_DAY":
+		return GoalRate_Daily
+	case GoalRate_Weekly, "WEEK", "PER_WEEK":
+		return GoalRate_Weekly
+	}
+	return ""
+}
+
+// validGoalRate normalises the rate given for a goal, an empty rate makes a
+// one off goal but any other rate has to be daily or weekly.
+func validGoalRate(rate string) (string, error) {
+	if strings.TrimSpace(rate) == "" {
+		return "", nil
+	}
+	if r := GoalRate(rate); r != "" {
+		return r, nil
+	}
+	return "", fmt.Errorf("rate has to be %s, %s or left out", GoalRate_Daily, GoalRate_Weekly)
+}
+
+func goalDay(t *NullTime, loc *time.Location) *Date {
+	if t == nil || !t.Valid {
+		return nil
+	}
+	d := FromTime(t.Time.In(loc))
+	return &d
+}
+
+// goalPeriodOf gives the period of a goal a day falls in. One off goals have a
+// single period from their creation up to their deadline, if any.
+func goalPeriodOf(g *UserGoal, loc *time.Location, d Date) (Date, *Date) {
+	var end Date
+	switch GoalRate(g.Rate) {
+	case GoalRate_Daily:
+		end = d
+		return d, &end
+	case GoalRate_Weekly:
+		start := d.StartOfWeek()
+		end = start + 6
+		return start, &end
+	}
+
+	start := d
+	if created := goalDay(g.CreatedAt, loc); created != nil {
+		start = *created
+	}
+	return start, goalDay(g.Deadline, loc)
+}
+
+func newGoalPeriod(g *UserGoal, start Date, end *Date) *GoalPeriod {
+	p := &GoalPeriod{
+		GoalID:      g.ID,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Target:      g.Value,
+		Status:      GoalPeriodStatus_InProgress,
+	}
+	p.setProgress()
+	return p
+}
+
+func (p *GoalPeriod) setProgress() {
+	if !p.Target.IsPositive() {
+		p.Progress = decimal.Zero
+		return
+	}
+	p.Progress = p.Total.Mul(decimal.New(100, 0)).DivRound(p.Target, 2)
+}
+
+// AddQuantity checks a quantity into the period, it returns true when it made
+// the period reach its target.
+func (p *GoalPeriod) AddQuantity(quantity decimal.Decimal, at time.Time) bool {
+	p.Total = p.Total.Add(quantity)
+	p.setProgress()
+	if p.Status == GoalPeriodStatus_Completed ||
+		!p.Target.IsPositive() ||
+		p.Total.LessThan(p.Target) {
+		return false
+	}
+
+	p.Status = GoalPeriodStatus_Completed
+	p.CompletedAt = &at
+	return true
+}
+
+// FillGoalHistory gives every period of a goal from its creation up to today,
+// or its deadline, newest first. Periods without any check in are added and
+// the ones which ended short of their target are missed.
+func FillGoalHistory(g *UserGoal, loc *time.Location, periods []*GoalPeriod, today Date) []*GoalPeriod {
+	stored := map[Date]*GoalPeriod{}
+	for _, p := range periods {
+		p.setProgress()
+		stored[p.PeriodStart] = p
+	}
+
+	first := today
+	if created := goalDay(g.CreatedAt, loc); created != nil && *created < today {
+		first = *created
+	}
+	last := today
+	if deadline := goalDay(g.Deadline, loc); deadline != nil && *deadline < today {
+		last = *deadline
+	}
+
+	history := []*GoalPeriod{}
+	for start, end := goalPeriodOf(g, loc, first); start <= last; {
+		p, ok := stored[start]
+		if !ok {
+			p = newGoalPeriod(g, start, end)
+		}
+		if p.Status == GoalPeriodStatus_InProgress && p.PeriodEnd != nil && *p.PeriodEnd < today {
+			p.Status = GoalPeriodStatus_Missed
+		}
+		history = append([]*GoalPeriod{p}, history...)
+
+		// one off goals have a single period
+		if GoalRate(g.Rate) == "" {
+			break
+		}
+		start, end = goalPeriodOf(g, loc, *end+1)
+	}
+
+	return history
+}
+
+func (e *External) HandleGoalCheckIn(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	goalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing goal id"))
+		return
+	}
+
+	c := &GoalCheckIn{}
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	if !c.Quantity.IsPositive() {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("quantity has to be positive"))
+		return
+	}
+	now := time.Now()
+	if c.CheckedInAt == nil {
+		c.CheckedInAt = &now
+	} else if c.CheckedInAt.After(now) {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("checked_in_at is in the future"))
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	g, err := GetGoalByID(tx, goalID, userID)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal: %d", goalID))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal"))
+		return
+	}
+	if !g.IsActive {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("goal %d is archived", goalID))
+		return
+	}
+	timezone, err := GetUserTimezone(tx, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting user timezone"))
+		return
+	}
+	loc := UserLocation(timezone)
+
+	day := FromTime(c.CheckedInAt.In(loc))
+	if created := goalDay(g.CreatedAt, loc); created != nil && day < *created {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("check in before the goal was created"))
+		return
+	}
+	if deadline := goalDay(g.Deadline, loc); deadline != nil && day > *deadline {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("check in after the goal deadline"))
+		return
+	}
+
+	start, end := goalPeriodOf(g, loc, day)
+	p, err := GetGoalPeriodForUpdate(tx, goalID, userID, start)
+	if err == sql.ErrNoRows {
+		p = newGoalPeriod(g, start, end)
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal period"))
+		return
+	}
+
+	c.GoalID = goalID
+	c.PeriodStart = start
+	if err := CreateGoalCheckIn(tx, c, userID); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating goal check in"))
+		return
+	}
+	completed := p.AddQuantity(c.Quantity, *c.CheckedInAt)
+	if _, err := UpsertGoalPeriod(tx, p, userID); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "saving goal period"))
+		return
+	}
+
+	// one off goals are done once their only period is
+	if completed && GoalRate(g.Rate) == "" && (g.CompletedAt == nil || !g.CompletedAt.Valid) {
+		if _, err := CompleteGoal(tx, goalID, userID); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "completing goal"))
+			return
+		}
+		if err := recordGoalCompletion(tx, goalID, userID); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording goal completion"))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting goal check in"))
+		return
+	}
+
+	e.returnJSON(w, p)
+}
+
+func (e *External) HandleGetGoalHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	goalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing goal id"))
+		return
+	}
+
+	g, err := GetGoalByID(e.dao.ReadDB, goalID, userID)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal: %d", goalID))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal"))
+		return
+	}
+	timezone, err := GetUserTimezone(e.dao.ReadDB, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting user timezone"))
+		return
+	}
+	periods, err := GetGoalPeriodsByGoalID(e.dao.ReadDB, goalID, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal periods"))
+		return
+	}
+
+	loc := UserLocation(timezone)
+	e.returnJSON(w, &GoalHistory{
+		Goal:    g,
+		Periods: FillGoalHistory(g, loc, periods, TodayIn(loc)),
+	})
+}
+
+// CloseGoalPeriods records the periods of recurring goals which ended, missed
+// when short of their target. A goal failing is logged and left for the next
+// run, the others are still closed.
+func (e *External) CloseGoalPeriods(ctx context.Context, now time.Time) (int, error) {
+	goals, err := GetOpenRecurringGoals(e.dao.ReadDB)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting recurring goals")
+	}
+
+	var closed int
+	for _, g := range goals {
+		n, err := e.closeRecurringGoalPeriods(ctx, g, now)
+		if err != nil {
+			e.log.WithError(err).WithFields(logrus.Fields{
+				"goal_id": g.ID,
+				"user_id": g.UserID,
+			}).Error("closing goal periods")
+			continue
+		}
+		closed += n
+	}
+
+	return closed, nil
+}
+
+func (e *External) closeRecurringGoalPeriods(ctx context.Context, g *RecurringGoal, now time.Time) (int, error) {
+	tx, err := e.dao.GetTx(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin tx")
+	}
+	defer tx.Rollback()
+
+	periods, err := GetGoalPeriodsByGoalID(tx, g.ID, g.UserID)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting goal periods")
+	}
+	stored := map[Date]GoalPeriodStatus{}
+	for _, p := range periods {
+		stored[p.PeriodStart] = p.Status
+	}
+
+	var closed int
+	loc := UserLocation(g.Timezone)
+	for _, p := range FillGoalHistory(g.UserGoal, loc, periods, FromTime(now.In(loc))) {
+		if p.Status != GoalPeriodStatus_Missed || stored[p.PeriodStart] == GoalPeriodStatus_Missed {
+			continue
+		}
+		if _, err := UpsertGoalPeriod(tx, p, g.UserID); err != nil {
+			return 0, errors.Wrap(err, "saving goal period")
+		}
+		closed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commiting goal periods")
+	}
+
+	return closed, nil
+}
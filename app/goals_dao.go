@@ -0,0 +1,160 @@
+package external
+
+// CreateGoalCheckIn checks in to a goal of the user, sql.ErrNoRows if the user
+// has no such goal.
+func CreateGoalCheckIn(q Q, c *GoalCheckIn, userID int) error {
+	c.UserID = userID
+	if err := q.Get(
+		&c.ID,
+		`
+			INSERT INTO ggwp.goal_checkins
+			(
+				goal_id, user_id, quantity, note, period_start, checked_in_at, created_at
+			)
+			SELECT
+				g.id, g.user_id, $3, $4, $5, $6, NOW()
+			FROM
+				ggwp.user_goals g
+			WHERE
+				g.id = $1
+				AND g.user_id = $2
+			RETURNING id
+		`,
+		c.GoalID,
+		userID,
+		c.Quantity,
+		c.Note,
+		c.PeriodStart,
+		c.CheckedInAt,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+const selectFromGoalPeriods = `
+	SELECT
+		p.goal_id,
+		p.period_start,
+		p.period_end,
+		p.total,
+		p.target,
+		p.status,
+		p.completed_at,
+		p.updated_at
+	FROM
+		ggwp.goal_periods p
+	JOIN
+		ggwp.user_goals g
+		ON g.id = p.goal_id
+`
+
+func GetGoalPeriodForUpdate(q Q, goalID, userID int, periodStart Date) (*GoalPeriod, error) {
+	var p GoalPeriod
+	if err := q.Get(
+		&p,
+		selectFromGoalPeriods+`
+			WHERE p.goal_id = $1 AND g.user_id = $2 AND p.period_start = $3
+			FOR UPDATE OF p
+		`,
+		goalID,
+		userID,
+		periodStart,
+	); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func GetGoalPeriodsByGoalID(q Q, goalID, userID int) ([]*GoalPeriod, error) {
+	var p []*GoalPeriod
+	if err := q.Select(
+		&p,
+		selectFromGoalPeriods+`
+			WHERE p.goal_id = $1 AND g.user_id = $2
+			ORDER BY p.period_start DESC
+		`,
+		goalID,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// UpsertGoalPeriod saves a period of a goal of the user, false if the user has
+// no such goal.
+func UpsertGoalPeriod(q Q, p *GoalPeriod, userID int) (bool, error) {
+	return execGoalUpdate(
+		q,
+		`
+			INSERT INTO ggwp.goal_periods
+			(
+				goal_id, period_start, period_end, total, target, status, completed_at,
+				created_at, updated_at
+			)
+			SELECT
+				g.id, $3, $4, $5, $6, $7, $8, NOW(), NOW()
+			FROM
+				ggwp.user_goals g
+			WHERE
+				g.id = $1
+				AND g.user_id = $2
+			ON CONFLICT (goal_id, period_start) DO UPDATE
+			SET
+				total = EXCLUDED.total,
+				status = EXCLUDED.status,
+				completed_at = EXCLUDED.completed_at,
+				updated_at = NOW()
+		`,
+		p.GoalID,
+		userID,
+		p.PeriodStart,
+		p.PeriodEnd,
+		p.Total,
+		p.Target,
+		p.Status,
+		p.CompletedAt,
+	)
+}
+
+// GetOpenRecurringGoals gives the active daily and weekly goals along with the
+// timezone of their user.
+func GetOpenRecurringGoals(q Q) ([]*RecurringGoal, error) {
+	var g []*RecurringGoal
+	if err := q.Select(
+		&g,
+		`
+			SELECT
+				g.id "goal.id",
+				g.user_id "goal.user_id",
+				g.description "goal.description",
+				g.value "goal.value",
+				g.rate "goal.rate",
+				g.deadline "goal.deadline",
+				g.completed_at "goal.completed_at",
+				g.is_active "goal.is_active",
+				g.template_id "goal.template_id",
+				g.created_at "goal.created_at",
+				g.updated_at "goal.updated_at",
+				u.timezone
+			FROM
+				ggwp.user_goals g
+			JOIN
+				ggwp.users u
+				ON u.id = g.user_id
+			WHERE
+				g.is_active
+				AND g.completed_at IS NULL
+				AND UPPER(TRIM(g.rate)) IN ('DAILY', 'DAY', 'PER_DAY', 'WEEKLY', 'WEEK', 'PER_WEEK')
+				AND (g.deadline IS NULL OR g.deadline > NOW() - INTERVAL '8 days')
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
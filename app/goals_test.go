@@ -0,0 +1,279 @@
+package external_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+func goalTime(t time.Time) *external.NullTime {
+	return &external.NullTime{NullTime: pq.NullTime{Time: t, Valid: true}}
+}
+
+func TestGoalRate(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	h.ExpectDeepEq(external.GoalRate("daily"), external.GoalRate_Daily)
+	h.ExpectDeepEq(external.GoalRate(" Week "), external.GoalRate_Weekly)
+	h.ExpectDeepEq(external.GoalRate(""), "")
+	h.ExpectDeepEq(external.GoalRate("fortnightly"), "")
+}
+
+func TestGoalPeriodAddQuantity(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	p := &external.GoalPeriod{
+		Target: decimal.New(200, 0),
+		Status: external.GoalPeriodStatus_InProgress,
+	}
+	h.ExpectDeepEq(p.AddQuantity(decimal.New(50, 0), time.Now()), false)
+	h.ExpectDeepEq(p.Progress.String(), "25")
+	h.ExpectDeepEq(p.AddQuantity(decimal.New(150, 0), time.Now()), true)
+	h.ExpectDeepEq(p.Status, external.GoalPeriodStatus_Completed)
+	// going over the target completes only once
+	h.ExpectDeepEq(p.AddQuantity(decimal.New(100, 0), time.Now()), false)
+	h.ExpectDeepEq(p.Progress.String(), "150")
+}
+
+func TestFillGoalHistoryWeekly(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	// created on a wednesday, three weeks ago in sydney
+	g := &external.UserGoal{
+		ID:        1,
+		Value:     decimal.New(200, 0),
+		Rate:      "weekly",
+		CreatedAt: goalTime(time.Date(2020, 3, 3, 20, 0, 0, 0, time.UTC)),
+	}
+	loc := external.UserLocation("Australia/Sydney")
+	completed := &external.GoalPeriod{
+		GoalID:      1,
+		PeriodStart: external.MustFromString("2020-03-09"),
+		Total:       decimal.New(210, 0),
+		Target:      decimal.New(200, 0),
+		Status:      external.GoalPeriodStatus_Completed,
+	}
+
+	history := external.FillGoalHistory(g, loc, []*external.GoalPeriod{completed}, external.MustFromString("2020-03-18"))
+
+	h.ExpectDeepEq(len(history), 3)
+	h.ExpectDeepEq(history[0].PeriodStart.String(), "2020-03-16")
+	h.ExpectDeepEq(history[0].Status, external.GoalPeriodStatus_InProgress)
+	h.ExpectDeepEq(history[1].Status, external.GoalPeriodStatus_Completed)
+	h.ExpectDeepEq(history[1].Progress.String(), "105")
+	h.ExpectDeepEq(history[2].PeriodStart.String(), "2020-03-02")
+	h.ExpectDeepEq(history[2].PeriodEnd.String(), "2020-03-08")
+	h.ExpectDeepEq(history[2].Status, external.GoalPeriodStatus_Missed)
+}
+
+func TestFillGoalHistoryOneOff(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	g := &external.UserGoal{
+		ID:        1,
+		Value:     decimal.New(10, 0),
+		CreatedAt: goalTime(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)),
+		Deadline:  goalTime(time.Date(2020, 3, 5, 12, 0, 0, 0, time.UTC)),
+	}
+
+	history := external.FillGoalHistory(g, time.UTC, nil, external.MustFromString("2020-03-04"))
+	h.ExpectDeepEq(len(history), 1)
+	h.ExpectDeepEq(history[0].PeriodStart.String(), "2020-03-01")
+	h.ExpectDeepEq(history[0].Status, external.GoalPeriodStatus_InProgress)
+
+	history = external.FillGoalHistory(g, time.UTC, nil, external.MustFromString("2020-03-06"))
+	h.ExpectDeepEq(len(history), 1)
+	h.ExpectDeepEq(history[0].Status, external.GoalPeriodStatus_Missed)
+}
+
+func TestGoalOwnership(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	owner := f.GetAuthToken("owner.user@ggwpacademy.com")
+	other := f.GetAuthToken("other.user@ggwpacademy.com")
+
+	rr := f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/user/self/goals",
+		`{"description": "100 shots a day", "value": 100, "rate": "DAILY"}`,
+		owner.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	var goalID int
+	f.ExpectNoError(f.DAO.DB.Get(&goalID, `SELECT id FROM ggwp.user_goals WHERE user_id = $1`, owner.UserID))
+
+	for _, req := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPut, "/complete", ""},
+		{http.MethodPut, "/incomplete", ""},
+		{http.MethodPut, "", `{"description": "nothing"}`},
+		{http.MethodDelete, "", ""},
+		{http.MethodPost, "/checkins", `{"quantity": 10}`},
+		{http.MethodGet, "/history", ""},
+	} {
+		rr := f.AuthedRequest(
+			req.method,
+			fmt.Sprintf("/api/v0.1/user/self/goals/%d%s", goalID, req.path),
+			req.body,
+			other.AccessToken,
+		)
+		f.ExpectStatus(rr, http.StatusNotFound)
+	}
+
+	f.ExpectRowCountWhere(
+		"ggwp.user_goals",
+		fmt.Sprintf(
+			`id = %d AND description = '100 shots a day' AND is_active AND completed_at IS NULL`,
+			goalID,
+		),
+		1,
+	)
+	f.ExpectRowCountWhere("ggwp.goal_checkins", fmt.Sprintf("goal_id = %d", goalID), 0)
+
+	// the other user doesn't see it either
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/goals", "", other.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var goals []*external.UserGoal
+	f.Bind(rr, &goals)
+	f.ExpectDeepEq(len(goals), 0)
+
+	// nor can reach its check ins and periods
+	today := external.FromTime(time.Now())
+	f.ExpectDeepEq(
+		external.CreateGoalCheckIn(f.DAO.DB, &external.GoalCheckIn{
+			GoalID:      goalID,
+			Quantity:    decimal.NewFromFloat(10),
+			PeriodStart: today,
+		}, other.UserID),
+		sql.ErrNoRows,
+	)
+	period := &external.GoalPeriod{
+		GoalID:      goalID,
+		PeriodStart: today,
+		Total:       decimal.NewFromFloat(10),
+		Target:      decimal.NewFromFloat(100),
+		Status:      external.GoalPeriodStatus_InProgress,
+	}
+	saved, err := external.UpsertGoalPeriod(f.DAO.DB, period, other.UserID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(saved, false)
+	f.ExpectRowCountWhere("ggwp.goal_periods", fmt.Sprintf("goal_id = %d", goalID), 0)
+
+	saved, err = external.UpsertGoalPeriod(f.DAO.DB, period, owner.UserID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(saved, true)
+	periods, err := external.GetGoalPeriodsByGoalID(f.DAO.DB, goalID, other.UserID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(periods), 0)
+	_, err = external.GetGoalPeriodForUpdate(f.DAO.DB, goalID, other.UserID, today)
+	f.ExpectDeepEq(err, sql.ErrNoRows)
+	periods, err = external.GetGoalPeriodsByGoalID(f.DAO.DB, goalID, owner.UserID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(periods), 1)
+}
+
+func TestGoalUpdateAndArchive(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("test.user@ggwpacademy.com")
+
+	// mistyped rates don't turn into one off goals
+	rr := f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/user/self/goals",
+		`{"description": "100 shots a day", "value": 100, "rate": "dialy"}`,
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/user/self/goals",
+		`{"description": "100 shots a day", "value": 100, "rate": "daily"}`,
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	var goalID int
+	f.ExpectNoError(f.DAO.DB.Get(&goalID, `SELECT id FROM ggwp.user_goals WHERE user_id = $1`, auth.UserID))
+	url := fmt.Sprintf("/api/v0.1/user/self/goals/%d", goalID)
+
+	rr = f.AuthedRequest(http.MethodPut, url, `{"value": 150}`, auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	g := &external.UserGoal{}
+	f.Bind(rr, g)
+	f.ExpectDeepEq(g.Description, "100 shots a day")
+	f.ExpectDeepEq(g.Value.String(), "150")
+	f.ExpectDeepEq(g.Rate, "DAILY")
+
+	rr = f.AuthedRequest(http.MethodPut, url, `{"description": ""}`, auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	rr = f.AuthedRequest(http.MethodPut, url, `{"rate": "wekly"}`, auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	f.ExpectRowCountWhere("ggwp.user_goals", fmt.Sprintf("id = %d AND rate = 'DAILY'", goalID), 1)
+
+	rr = f.AuthedRequest(http.MethodDelete, url, "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCountWhere("ggwp.user_goals", fmt.Sprintf("id = %d AND NOT is_active", goalID), 1)
+
+	// archived goals can't be changed any more
+	rr = f.AuthedRequest(http.MethodDelete, url, "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+	rr = f.AuthedRequest(http.MethodPut, url+"/complete", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+	rr = f.AuthedRequest(http.MethodPut, url, `{"value": 200}`, auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+
+	var goals []*external.UserGoal
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/goals", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, &goals)
+	f.ExpectDeepEq(len(goals), 0)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/goals/archived", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, &goals)
+	f.ExpectDeepEq(len(goals), 1)
+	f.ExpectDeepEq(goals[0].ID, goalID)
+}
+
+func TestGoalCheckInBackdated(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("backdated.user@ggwpacademy.com")
+
+	rr := f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/user/self/goals",
+		`{"description": "100 shots a day", "value": 100, "rate": "DAILY"}`,
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	var goalID int
+	f.ExpectNoError(f.DAO.DB.Get(&goalID, `SELECT id FROM ggwp.user_goals WHERE user_id = $1`, auth.UserID))
+	_, err := f.DAO.DB.Exec(`UPDATE ggwp.user_goals SET created_at = NOW() - INTERVAL '3 days' WHERE id = $1`, goalID)
+	f.ExpectNoError(err)
+
+	// periods completed by a backdated check in were completed back then
+	checkedInAt := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		fmt.Sprintf("/api/v0.1/user/self/goals/%d/checkins", goalID),
+		fmt.Sprintf(`{"quantity": 100, "checked_in_at": %q}`, checkedInAt.Format(time.RFC3339)),
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	p := &external.GoalPeriod{}
+	f.Bind(rr, p)
+	f.ExpectDeepEq(p.Status, external.GoalPeriodStatus_Completed)
+	f.ExpectDeepEq(p.CompletedAt.Equal(checkedInAt), true)
+}
@@ -0,0 +1,130 @@
+package external
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// iCalendar (RFC 5545) formats
+const (
+	icalDateFormat = "20060102"
+	icalTimeFormat = "20060102T150405Z"
+	// lines longer than this many octets are folded
+	icalLineLength = 75
+)
+
+// Calendar is an iCalendar (RFC 5545) calendar, as served to calendar apps
+// subscribing to a feed.
+type Calendar struct {
+	Name   string
+	Events []*CalendarEvent
+}
+
+// CalendarEvent is a single event of a calendar. All day events span the days
+// from Date up to and including EndDate, timed events start at Start. Either
+// Date or Start is set.
+type CalendarEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Date        *Date
+	EndDate     *Date
+	Start       *time.Time
+	// RRule is the recurrence rule of the event, e.g. FREQ=DAILY
+	RRule string
+	// Stamp is when the event was last changed
+	Stamp time.Time
+}
+
+// Encode gives the calendar as an iCalendar stream, with CRLF line endings
+// and long lines folded.
+func (c *Calendar) Encode() []byte {
+	var b bytes.Buffer
+	line := func(name, value string) {
+		b.WriteString(icalFold(name + ":" + value))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//GGWP Academy//Calendar//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", icalEscape(c.Name))
+	}
+	for _, ev := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", ev.UID)
+		line("DTSTAMP", ev.Stamp.UTC().Format(icalTimeFormat))
+		if ev.Date != nil {
+			end := *ev.Date
+			if ev.EndDate != nil {
+				end = *ev.EndDate
+			}
+			line("DTSTART;VALUE=DATE", ev.Date.Time().Format(icalDateFormat))
+			// the end of all day events is exclusive
+			line("DTEND;VALUE=DATE", (end + 1).Time().Format(icalDateFormat))
+		} else if ev.Start != nil {
+			line("DTSTART", ev.Start.UTC().Format(icalTimeFormat))
+		}
+		if ev.RRule != "" {
+			line("RRULE", ev.RRule)
+		}
+		line("SUMMARY", icalEscape(ev.Summary))
+		if ev.Description != "" {
+			line("DESCRIPTION", icalEscape(ev.Description))
+		}
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+
+	return b.Bytes()
+}
+
+// icalUntil gives the UNTIL part of a recurrence rule ending on a day.
+func icalUntil(d Date) string {
+	return ";UNTIL=" + d.Time().Format(icalDateFormat)
+}
+
+// icalEscape escapes a text value.
+func icalEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", "",
+	).Replace(s)
+}
+
+// icalFold folds a content line into lines of at most 75 octets, without
+// splitting multi byte characters. Continuation lines start with a space.
+func icalFold(s string) string {
+	if len(s) <= icalLineLength {
+		return s
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := utf8.RuneLen(r)
+		if n+size > icalLineLength {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
+
+// calendarUID gives a globally unique identifier of an event.
+func calendarUID(kind string, id int) string {
+	return fmt.Sprintf("%s-%d@ggwpacademy.com", kind, id)
+}
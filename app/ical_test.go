@@ -0,0 +1,149 @@
+package external_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+	"github.com/shopspring/decimal"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// expectGolden compares got with the golden file, go test -update rewrites it.
+func expectGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
+
+func TestCalendarEncode(t *testing.T) {
+	start := time.Date(2020, 3, 2, 9, 30, 0, 0, time.FixedZone("AEDT", 11*60*60))
+	day := external.MustFromString("2020-03-05")
+	c := &external.Calendar{
+		Name: "Practice; drills, and more",
+		Events: []*external.CalendarEvent{
+			{
+				UID:         "goal-1@ggwpacademy.com",
+				Summary:     "Shoot 100 free throws, then 50 three pointers; no excuses",
+				Description: "Line one\nLine two with a \\ backslash and a long tail so that it needs folding — über lang",
+				Date:        &day,
+				Stamp:       start,
+			},
+			{
+				UID:     "module-unlock-2@ggwpacademy.com",
+				Summary: "New module: Defence",
+				Start:   &start,
+				Stamp:   start,
+			},
+		},
+	}
+
+	expectGolden(t, "calendar_encode.ics", c.Encode())
+}
+
+func TestUserCalendar(t *testing.T) {
+	loc, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2020, 3, 4, 22, 0, 0, 0, time.UTC) // Thursday 5th in Sydney
+	updated := time.Date(2020, 3, 6, 1, 0, 0, 0, time.UTC)
+	now := time.Date(2020, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	goals := []*external.UserGoal{
+		{
+			ID:          1,
+			Description: "100 shots a day",
+			Value:       decimal.New(100, 0),
+			Rate:        "daily",
+			Deadline:    goalTime(time.Date(2020, 3, 31, 12, 0, 0, 0, time.UTC)),
+			IsActive:    true,
+			CreatedAt:   goalTime(created),
+			UpdatedAt:   goalTime(updated),
+		},
+		{
+			ID:          2,
+			Description: "3 runs a week",
+			Value:       decimal.New(3, 0),
+			Rate:        "WEEKLY",
+			IsActive:    true,
+			CreatedAt:   goalTime(created),
+			UpdatedAt:   goalTime(updated),
+		},
+		{
+			ID:          3,
+			Description: "Finish the shooting module",
+			Deadline:    goalTime(time.Date(2020, 4, 1, 13, 30, 0, 0, time.UTC)),
+			IsActive:    true,
+			CreatedAt:   goalTime(created),
+			UpdatedAt:   goalTime(updated),
+		},
+		// completed and archived goals are left out
+		{
+			ID:          4,
+			Description: "Done already",
+			Deadline:    goalTime(time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)),
+			CompletedAt: goalTime(updated),
+			IsActive:    true,
+			CreatedAt:   goalTime(created),
+		},
+		{
+			ID:          5,
+			Description: "Archived",
+			Rate:        "DAILY",
+			CreatedAt:   goalTime(created),
+		},
+	}
+	unlocks := []*external.ModuleUnlock{
+		{ModuleID: 7, Name: "Rebounding", UnlocksAt: time.Date(2020, 3, 20, 8, 0, 0, 0, time.UTC)},
+	}
+
+	expectGolden(t, "user_calendar.ics", external.UserCalendar(goals, unlocks, loc, now).Encode())
+}
+
+func TestCalendarFeedTokenRegeneration(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("test.user@ggwpacademy.com")
+
+	rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/calendar", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	feed := &external.CalendarFeed{}
+	f.Bind(rr, feed)
+
+	rr = f.UnAuthedRequest(http.MethodGet, "/calendar/"+feed.Token+".ics", "")
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectBodyContains(rr, "BEGIN:VCALENDAR")
+
+	rr = f.AuthedRequest(http.MethodPost, "/api/v0.1/user/self/calendar/token", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	regenerated := &external.CalendarFeed{}
+	f.Bind(rr, regenerated)
+	if regenerated.Token == feed.Token {
+		t.Fatalf("token was not regenerated")
+	}
+
+	// the previous url is revoked
+	rr = f.UnAuthedRequest(http.MethodGet, "/calendar/"+feed.Token+".ics", "")
+	f.ExpectStatus(rr, http.StatusNotFound)
+	rr = f.UnAuthedRequest(http.MethodGet, "/calendar/"+regenerated.Token+".ics", "")
+	f.ExpectStatus(rr, http.StatusOK)
+}
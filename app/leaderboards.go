@@ -0,0 +1,210 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	leaderboardDefaultLimit = 10
+	leaderboardMaxLimit     = 100
+	// entries returned above and below the caller
+	leaderboardDefaultAround = 2
+	leaderboardMaxAround     = 10
+
+	leaderboardPeriods = []LeaderboardPeriod{
+		LeaderboardPeriod_Weekly,
+		LeaderboardPeriod_Monthly,
+		LeaderboardPeriod_AllTime,
+	}
+)
+
+// Start gives the first day of the period a day is in. Weeks start on Monday
+// and all time boards start on the epoch.
+func (l LeaderboardPeriod) Start(d Date) Date {
+	switch l {
+	case LeaderboardPeriod_Weekly:
+		return d.StartOfWeek()
+	case LeaderboardPeriod_Monthly:
+		return d.StartOfMonth()
+	}
+	return 0
+}
+
+// AddLeaderboardScore adds to the score of a user on the global boards and,
+// for activity within a module, on the boards of the module and its category,
+// for every period. Team boards rank the global scores of their members.
+func AddLeaderboardScore(q Q, userID int, m LeaderboardMetric, moduleID int, delta float64, at time.Time) error {
+	if userID <= 0 || delta == 0 {
+		return nil
+	}
+
+	scopes := map[LeaderboardScope]int{LeaderboardScope_Global: 0}
+	if moduleID > 0 {
+		scopes[LeaderboardScope_Module] = moduleID
+		modules, err := GetModulesByIDs(q, []int{moduleID})
+		if err != nil {
+			return errors.Wrap(err, "getting module")
+		}
+		if len(modules) == 1 && modules[0].CategoryID > 0 {
+			scopes[LeaderboardScope_Category] = modules[0].CategoryID
+		}
+	}
+
+	day := FromTime(at.UTC())
+	for scope, scopeID := range scopes {
+		for _, p := range leaderboardPeriods {
+			if err := IncrementLeaderboardScore(q, userID, m, scope, scopeID, p, p.Start(day), delta); err != nil {
+				return errors.Wrapf(err, "incrementing %s %s %s score", m, scope, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// quizPassedBefore reports whether any take of a quiz before the given one was
+// passed by the user.
+func quizPassedBefore(q Q, userID int, quiz *Quiz, takeNumber int) (bool, error) {
+	gradings, err := GetQuizGradingsByUserID(q, userID)
+	if err != nil {
+		return false, err
+	}
+
+	takes := map[int][]*QuizGrading{}
+	for _, g := range gradings {
+		if g.QuizID == quiz.ID && g.TakeNumber < takeNumber {
+			takes[g.TakeNumber] = append(takes[g.TakeNumber], g)
+		}
+	}
+	for _, t := range takes {
+		if correct, total := QuizScore(t); QuizPassed(quiz, correct, total) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (e *External) HandleGetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	query := r.URL.Query()
+
+	l := &Leaderboard{
+		Metric: LeaderboardMetric(strings.ToUpper(query.Get("metric"))),
+		Scope:  LeaderboardScope(strings.ToUpper(query.Get("scope"))),
+		Period: LeaderboardPeriod(strings.ToUpper(query.Get("period"))),
+	}
+	if l.Metric == "" {
+		l.Metric = LeaderboardMetric_XP
+	}
+	if l.Scope == "" {
+		l.Scope = LeaderboardScope_Global
+	}
+	if l.Period == "" {
+		l.Period = LeaderboardPeriod_Weekly
+	}
+	if l.Metric.String() == "" || l.Scope.String() == "" || l.Period.String() == "" {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid metric, scope or period"))
+		return
+	}
+
+	if l.Scope != LeaderboardScope_Global {
+		scopeID, err := strconv.Atoi(query.Get("scope_id"))
+		if err != nil || scopeID <= 0 {
+			e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("scope %s needs a scope_id", l.Scope))
+			return
+		}
+		l.ScopeID = scopeID
+	}
+	// team boards are only shown to the members of the team
+	if l.Scope == LeaderboardScope_Team {
+		member, err := IsTeamMember(e.dao.ReadDB, l.ScopeID, userID)
+		if err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "checking team membership"))
+			return
+		}
+		if !member {
+			e.writeError(w, r, http.StatusForbidden, fmt.Errorf("not a member of team %d", l.ScopeID))
+			return
+		}
+	}
+
+	limit, err := queryInt(query.Get("limit"), leaderboardDefaultLimit, leaderboardMaxLimit)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid limit"))
+		return
+	}
+	around, err := queryInt(query.Get("around"), leaderboardDefaultAround, leaderboardMaxAround)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid around"))
+		return
+	}
+
+	l.PeriodStart = l.Period.Start(Today())
+	entries, err := GetLeaderboardEntries(e.dao.ReadDB, l, userID, limit, around)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting leaderboard entries"))
+		return
+	}
+
+	l.Entries = []*LeaderboardEntry{}
+	for _, en := range entries {
+		if en.UserID == userID {
+			l.Self = en
+		}
+	}
+	for _, en := range entries {
+		if en.Position <= limit {
+			l.Entries = append(l.Entries, en)
+		}
+		if l.Self != nil && en.Position >= l.Self.Position-around && en.Position <= l.Self.Position+around {
+			l.AroundSelf = append(l.AroundSelf, en)
+		}
+	}
+	l.Total, err = CountLeaderboardEntries(e.dao.ReadDB, l)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "counting leaderboard entries"))
+		return
+	}
+
+	e.returnJSON(w, l)
+}
+
+func (e *External) HandleUpdateLeaderboardPreferences(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		OptOut bool `json:"opt_out"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid request"))
+		return
+	}
+
+	if err := SetLeaderboardOptOut(e.dao.DB, r.Context().Value("user_id").(int), req.OptOut); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "updating leaderboard opt out"))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
+
+// queryInt parses an optional positive query parameter, capped to max.
+func queryInt(v string, def, max int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("not a positive number: %q", v)
+	}
+	if i > max {
+		return max, nil
+	}
+	return i, nil
+}
@@ -0,0 +1,151 @@
+package external
+
+func IncrementLeaderboardScore(
+	q Q,
+	userID int,
+	m LeaderboardMetric,
+	scope LeaderboardScope,
+	scopeID int,
+	p LeaderboardPeriod,
+	periodStart Date,
+	delta float64,
+) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.leaderboard_scores
+			(
+				metric, scope, scope_id, period, period_start, user_id, score, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, $5, $6, $7, NOW()
+			)
+			ON CONFLICT (metric, scope, scope_id, period, period_start, user_id) DO UPDATE
+			SET
+				score = leaderboard_scores.score + EXCLUDED.score,
+				updated_at = NOW()
+		`,
+		m,
+		scope,
+		scopeID,
+		p,
+		periodStart,
+		userID,
+		delta,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// rankedLeaderboardScores ranks the users on a board, skipping those who
+// opted out. Team boards rank the global scores of the team members.
+const rankedLeaderboardScores = `
+	WITH ranked AS (
+		SELECT
+			s.user_id,
+			s.score,
+			RANK() OVER (ORDER BY s.score DESC) rank,
+			ROW_NUMBER() OVER (ORDER BY s.score DESC, s.user_id) position
+		FROM
+			ggwp.leaderboard_scores s
+		JOIN
+			ggwp.users u
+			ON u.id = s.user_id
+		WHERE
+			s.metric = $1
+			AND s.scope = $2
+			AND s.scope_id = $3
+			AND s.period = $4
+			AND s.period_start = $5
+			AND s.score > 0
+			AND u.is_active
+			AND NOT u.leaderboard_opt_out
+			AND (
+				$6 = 0
+				OR EXISTS (
+					SELECT 1
+					FROM ggwp.team_members tM
+					WHERE tM.user_id = s.user_id
+						AND tM.team_id = $6
+				)
+			)
+	)
+`
+
+func leaderboardArgs(l *Leaderboard) []interface{} {
+	scope, scopeID, teamID := l.Scope, l.ScopeID, 0
+	if l.Scope == LeaderboardScope_Team {
+		scope, scopeID, teamID = LeaderboardScope_Global, 0, l.ScopeID
+	}
+	return []interface{}{l.Metric, scope, scopeID, l.Period, l.PeriodStart, teamID}
+}
+
+// GetLeaderboardEntries gives the top entries of a board along with the ones
+// around the user.
+func GetLeaderboardEntries(q Q, l *Leaderboard, userID, limit, around int) ([]*LeaderboardEntry, error) {
+	var e []*LeaderboardEntry
+	if err := q.Select(
+		&e,
+		rankedLeaderboardScores+`
+			, self AS (
+				SELECT position FROM ranked WHERE user_id = $7
+			)
+			SELECT
+				r.user_id,
+				r.score,
+				r.rank,
+				r.position,
+				TRIM(COALESCE(p.first_name, '') || ' ' || LEFT(COALESCE(p.last_name, ''), 1)) display_name
+			FROM
+				ranked r
+			LEFT JOIN
+				ggwp.players p
+				ON p.user_id = r.user_id
+			WHERE
+				r.position <= $8
+				OR EXISTS (
+					SELECT 1
+					FROM self
+					WHERE r.position BETWEEN self.position - $9 AND self.position + $9
+				)
+			ORDER BY
+				r.position
+		`,
+		append(leaderboardArgs(l), userID, limit, around)...,
+	); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func CountLeaderboardEntries(q Q, l *Leaderboard) (int, error) {
+	var c int
+	if err := q.Get(
+		&c,
+		rankedLeaderboardScores+`SELECT COUNT(*) FROM ranked`,
+		leaderboardArgs(l)...,
+	); err != nil {
+		return 0, err
+	}
+
+	return c, nil
+}
+
+func SetLeaderboardOptOut(q Q, userID int, optOut bool) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.users
+			SET leaderboard_opt_out = $2, updated_at = NOW()
+			WHERE id = $1
+		`,
+		userID,
+		optOut,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package external_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestLeaderboardPeriodStart(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	// a wednesday
+	d := external.MustFromString("2020-03-04")
+	h.ExpectDeepEq(external.LeaderboardPeriod_Weekly.Start(d).String(), "2020-03-02")
+	h.ExpectDeepEq(external.LeaderboardPeriod_Monthly.Start(d).String(), "2020-03-01")
+	h.ExpectDeepEq(external.LeaderboardPeriod_AllTime.Start(d).String(), "1970-01-01")
+
+	// sundays belong to the week started the monday before
+	h.ExpectDeepEq(external.LeaderboardPeriod_Weekly.Start(external.MustFromString("2020-03-08")).String(), "2020-03-02")
+	h.ExpectDeepEq(external.LeaderboardPeriod_Weekly.Start(external.MustFromString("2020-03-09")).String(), "2020-03-09")
+}
+
+// leaderboardRanks gives the user ids, scores and ranks of entries in order.
+func leaderboardRanks(entries []*external.LeaderboardEntry) [][3]float64 {
+	r := [][3]float64{}
+	for _, e := range entries {
+		r = append(r, [3]float64{float64(e.UserID), e.Score, float64(e.Rank)})
+	}
+	return r
+}
+
+func weeklyXPLeaderboard(scope external.LeaderboardScope, scopeID int) *external.Leaderboard {
+	return &external.Leaderboard{
+		Metric:      external.LeaderboardMetric_XP,
+		Scope:       scope,
+		ScopeID:     scopeID,
+		Period:      external.LeaderboardPeriod_Weekly,
+		PeriodStart: external.MustFromString("2020-03-02"),
+	}
+}
+
+func TestIncrementLeaderboardScore(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	userID := f.GetAuthToken("scores@ggwpacademy.com").UserID
+	l := weeklyXPLeaderboard(external.LeaderboardScope_Global, 0)
+	increment := func(l *external.Leaderboard, delta float64) {
+		f.ExpectNoError(external.IncrementLeaderboardScore(
+			f.DAO.DB, userID, l.Metric, l.Scope, l.ScopeID, l.Period, l.PeriodStart, delta,
+		))
+	}
+
+	// scores add up
+	increment(l, 10)
+	increment(l, 5.5)
+	entries, err := external.GetLeaderboardEntries(f.DAO.DB, l, userID, 10, 2)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{{float64(userID), 15.5, 1}})
+	f.ExpectDeepEq(entries[0].DisplayName, "Test U")
+	f.ExpectRowCount("ggwp.leaderboard_scores", 1)
+
+	// every board keeps its own score
+	nextWeek := weeklyXPLeaderboard(external.LeaderboardScope_Global, 0)
+	nextWeek.PeriodStart = external.MustFromString("2020-03-09")
+	increment(nextWeek, 3)
+	module := weeklyXPLeaderboard(external.LeaderboardScope_Module, 1)
+	increment(module, 7)
+	f.ExpectRowCount("ggwp.leaderboard_scores", 3)
+	for board, score := range map[*external.Leaderboard]float64{l: 15.5, nextWeek: 3, module: 7} {
+		entries, err := external.GetLeaderboardEntries(f.DAO.DB, board, userID, 10, 2)
+		f.ExpectNoError(err)
+		f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{{float64(userID), score, 1}})
+	}
+
+	// boards only rank positive scores
+	increment(l, -15.5)
+	entries, err = external.GetLeaderboardEntries(f.DAO.DB, l, userID, 10, 2)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(entries), 0)
+}
+
+func TestGetLeaderboardEntries(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	l := weeklyXPLeaderboard(external.LeaderboardScope_Global, 0)
+	scores := []float64{30, 20, 20, 10, 5, 4, 1}
+	ids := make([]int, len(scores))
+	for i, score := range scores {
+		ids[i] = f.GetAuthToken(fmt.Sprintf("leaderboard-%d@ggwpacademy.com", i)).UserID
+		f.ExpectNoError(external.IncrementLeaderboardScore(
+			f.DAO.DB, ids[i], l.Metric, l.Scope, l.ScopeID, l.Period, l.PeriodStart, score,
+		))
+	}
+	entry := func(i int, rank float64) [3]float64 {
+		return [3]float64{float64(ids[i]), scores[i], rank}
+	}
+
+	// ties share a rank, the next rank skips past them
+	entries, err := external.GetLeaderboardEntries(f.DAO.DB, l, ids[0], 4, 0)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{
+		entry(0, 1), entry(1, 2), entry(2, 2), entry(3, 4),
+	})
+	f.ExpectDeepEq(entries[2].Position, 3)
+
+	// the entries around the caller come along with the top ones
+	entries, err = external.GetLeaderboardEntries(f.DAO.DB, l, ids[5], 1, 1)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{
+		entry(0, 1), entry(4, 5), entry(5, 6), entry(6, 7),
+	})
+	// and overlap with them without repeating
+	entries, err = external.GetLeaderboardEntries(f.DAO.DB, l, ids[1], 2, 1)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{
+		entry(0, 1), entry(1, 2), entry(2, 2),
+	})
+
+	// users who opted out aren't ranked
+	f.ExpectNoError(external.SetLeaderboardOptOut(f.DAO.DB, ids[1], true))
+	entries, err = external.GetLeaderboardEntries(f.DAO.DB, l, ids[1], 3, 1)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{
+		entry(0, 1), entry(2, 2), entry(3, 3),
+	})
+	total, err := external.CountLeaderboardEntries(f.DAO.DB, l)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(total, len(scores)-1)
+
+	// team boards rank the global scores of the members
+	var teamID int
+	f.ExpectNoError(f.DAO.DB.Get(&teamID, `INSERT INTO ggwp.teams (name) VALUES ('Team') RETURNING id`))
+	for _, i := range []int{1, 3, 6} {
+		_, err := f.DAO.DB.Exec(`INSERT INTO ggwp.team_members (team_id, user_id) VALUES ($1, $2)`, teamID, ids[i])
+		f.ExpectNoError(err)
+	}
+	team := weeklyXPLeaderboard(external.LeaderboardScope_Team, teamID)
+	entries, err = external.GetLeaderboardEntries(f.DAO.DB, team, ids[6], 10, 1)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{entry(3, 1), entry(6, 2)})
+	total, err = external.CountLeaderboardEntries(f.DAO.DB, team)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(total, 2)
+
+	// scores of other boards don't count on a team board
+	f.ExpectNoError(external.IncrementLeaderboardScore(
+		f.DAO.DB, ids[6], l.Metric, external.LeaderboardScope_Module, 1, l.Period, l.PeriodStart, 100,
+	))
+	entries, err = external.GetLeaderboardEntries(f.DAO.DB, team, ids[6], 10, 1)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(leaderboardRanks(entries), [][3]float64{entry(3, 1), entry(6, 2)})
+}
+
+func TestHandleGetLeaderboard(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("leaderboard@ggwpacademy.com")
+	other := f.GetAuthToken("leaderboard-other@ggwpacademy.com")
+	l := weeklyXPLeaderboard(external.LeaderboardScope_Global, 0)
+	l.PeriodStart = l.Period.Start(external.Today())
+	for userID, score := range map[int]float64{auth.UserID: 5, other.UserID: 8} {
+		f.ExpectNoError(external.IncrementLeaderboardScore(
+			f.DAO.DB, userID, l.Metric, l.Scope, l.ScopeID, l.Period, l.PeriodStart, score,
+		))
+	}
+
+	rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/leaderboards?limit=1", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	got := &external.Leaderboard{}
+	f.Bind(rr, got)
+	f.ExpectDeepEq(got.Total, 2)
+	f.ExpectDeepEq(leaderboardRanks(got.Entries), [][3]float64{{float64(other.UserID), 8, 1}})
+	f.ExpectDeepEq(got.Self.UserID, auth.UserID)
+	f.ExpectDeepEq(got.Self.Rank, 2)
+	f.ExpectDeepEq(len(got.AroundSelf), 2)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/leaderboards?scope=team", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+
+	// team boards are for the members of the team
+	team := &external.Team{Name: "Team"}
+	f.ExpectNoError(external.CreateTeam(f.DAO.DB, team))
+	f.ExpectNoError(external.AddTeamMember(f.DAO.DB, team.ID, auth.UserID))
+	teamPath := fmt.Sprintf("/api/v0.1/leaderboards?scope=team&scope_id=%d", team.ID)
+	rr = f.AuthedRequest(http.MethodGet, teamPath, "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	got = &external.Leaderboard{}
+	f.Bind(rr, got)
+	f.ExpectDeepEq(leaderboardRanks(got.Entries), [][3]float64{{float64(auth.UserID), 5, 1}})
+	rr = f.AuthedRequest(http.MethodGet, teamPath, "", other.AccessToken)
+	f.ExpectStatus(rr, http.StatusForbidden)
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/leaderboards?metric=steps", "", auth.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+}
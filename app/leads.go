@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -21,6 +22,8 @@ func (e *External) HandleGetExploreModules(w http.ResponseWriter, r *http.Reques
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "adding module details"))
 		return
 	}
+	// leads haven't got a referral code in yet
+	lockModules(mWD, nil, time.Now())
 
 	e.returnJSON(w, mWD)
 }
@@ -37,11 +40,8 @@ func (e *External) HandleRecordLeadModuleProgress(w http.ResponseWriter, r *http
 	}
 
 	if err := RecordModuleProgress(e.dao.DB, p); err != nil {
-		// e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording module progress"))
-		// return
-
-		// just log the error
-		e.log.WithError(err).Error("recording lead module progress")
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording lead module progress"))
+		return
 	}
 
 	e.returnJSON(w, nil)
@@ -56,3 +56,17 @@ func (e *External) HandleGetLeadLearningProgress(w http.ResponseWriter, r *http.
 
 	e.returnJSON(w, l)
 }
+
+func (e *External) HandleRecordLeadProgressEvents(w http.ResponseWriter, r *http.Request) {
+	e.handleRecordProgressEvents(w, r, sql.NullInt64{})
+}
+
+func (e *External) HandleGetLeadModuleFileProgress(w http.ResponseWriter, r *http.Request) {
+	p, err := GetModuleFileProgressesByDeviceUniqueID(e.dao.ReadDB, r.Context().Value("device_unique_id").(string))
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	e.returnJSON(w, p)
+}
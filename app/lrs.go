@@ -0,0 +1,65 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LRS is an external Learning Record Store statements are pushed to.
+type LRS interface {
+	SendStatements(ctx context.Context, statements []*XAPIStatement) error
+}
+
+type LRSClient struct {
+	// statements resource is resolved relative to the endpoint
+	Endpoint   string
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+}
+
+func (c *LRSClient) SendStatements(ctx context.Context, statements []*XAPIStatement) error {
+	body, err := json.Marshal(statements)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		strings.TrimRight(c.Endpoint, "/")+"/statements",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(xapiVersionHeader, XAPIVersion)
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("lrs responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
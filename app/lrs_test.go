@@ -0,0 +1,220 @@
+package external_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+// LRSStandIn is a local HTTP stand-in for an external Learning Record Store.
+type LRSStandIn struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	StatusCode int
+	Requests   []*http.Request
+	Statements []*external.XAPIStatement
+}
+
+func NewLRSStandIn() *LRSStandIn {
+	s := &LRSStandIn{StatusCode: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.Requests = append(s.Requests, r)
+		if r.Method != http.MethodPost || r.URL.Path != "/xapi/statements" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if s.StatusCode != http.StatusOK && s.StatusCode != http.StatusNoContent {
+			w.WriteHeader(s.StatusCode)
+			return
+		}
+
+		var statements []*external.XAPIStatement
+		if err := json.NewDecoder(r.Body).Decode(&statements); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.Statements = append(s.Statements, statements...)
+		ids := []string{}
+		for _, st := range statements {
+			ids = append(ids, st.ID)
+		}
+		w.WriteHeader(s.StatusCode)
+		json.NewEncoder(w).Encode(ids)
+	}))
+
+	return s
+}
+
+func (s *LRSStandIn) Client() *external.LRSClient {
+	return &external.LRSClient{
+		Endpoint: s.URL + "/xapi/",
+		Username: "lrs-key",
+		Password: "lrs-secret",
+	}
+}
+
+func TestLRSClientSendStatements(t *testing.T) {
+	h := &TestHelper{T: t}
+	lrs := NewLRSStandIn()
+	defer lrs.Close()
+
+	statement := external.NewGoalCompletedStatement(&external.UserGoal{
+		ID:          3,
+		UserID:      7,
+		Description: "Watch a module every day",
+	})
+	statement.ID = "8f5d5b5e-3c1a-4d5e-9a3f-0c6b2f1e7d10"
+
+	h.ExpectNoError(lrs.Client().SendStatements(
+		context.Background(),
+		[]*external.XAPIStatement{statement},
+	))
+
+	h.ExpectDeepEq(len(lrs.Requests), 1)
+	r := lrs.Requests[0]
+	h.ExpectDeepEq(r.Header.Get("X-Experience-API-Version"), external.XAPIVersion)
+	username, password, ok := r.BasicAuth()
+	h.ExpectDeepEq(ok, true)
+	h.ExpectDeepEq(username, "lrs-key")
+	h.ExpectDeepEq(password, "lrs-secret")
+
+	h.ExpectDeepEq(len(lrs.Statements), 1)
+	got := lrs.Statements[0]
+	h.ExpectDeepEq(got.ID, statement.ID)
+	h.ExpectDeepEq(got.Verb.ID, external.XAPIVerb_Completed.ID)
+	h.ExpectDeepEq(got.Actor.Account.Name, "7")
+	h.ExpectDeepEq(got.Object.ID, "https://ggwpacademy.com/goals/3")
+}
+
+func TestLRSClientSendStatementsError(t *testing.T) {
+	h := &TestHelper{T: t}
+	lrs := NewLRSStandIn()
+	defer lrs.Close()
+	lrs.StatusCode = http.StatusServiceUnavailable
+
+	err := lrs.Client().SendStatements(
+		context.Background(),
+		[]*external.XAPIStatement{external.NewGoalCompletedStatement(&external.UserGoal{ID: 1, UserID: 1})},
+	)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	h.ExpectErrorContains(err, "503")
+	h.ExpectDeepEq(len(lrs.Statements), 0)
+}
+
+func TestPushXAPIStatementsRetriesThenPushes(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	userID := f.InsertUser(external.NewUser{
+		Email:     "test.user@ggwpacademy.com",
+		FirstName: "Test",
+		LastName:  "User",
+	})
+	statement := external.NewQuizAttemptStatement(userID, 1, &external.Quiz{ID: 2}, []*external.QuizGrading{
+		{Correct: true, TakeNumber: 1},
+		{Correct: true, TakeNumber: 1},
+		{Correct: false, TakeNumber: 1},
+	})
+	statement.ID = "0b7c1d0e-5a57-4c1f-8d2a-3e9f6b4a2c11"
+	statement.Version = external.XAPIVersion
+	f.ExpectNoError(external.CreateXAPIStatement(f.DAO.DB, statement))
+
+	// lrs down, the statement stays in the outbox with a backoff
+	f.LRS.StatusCode = http.StatusInternalServerError
+	_, err := f.Server.PushXAPIStatements(context.Background())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	f.ExpectRowCountWhere(
+		"ggwp.xapi_statements",
+		"lrs_pushed_at IS NULL AND lrs_attempts = 1 AND lrs_next_attempt_at > NOW() AND lrs_last_error IS NOT NULL",
+		1,
+	)
+
+	// not due yet
+	f.LRS.StatusCode = http.StatusOK
+	pushed, err := f.Server.PushXAPIStatements(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(pushed, 0)
+
+	_, err = f.DAO.DB.Exec(`UPDATE ggwp.xapi_statements SET lrs_next_attempt_at = NOW() - INTERVAL '1 second'`)
+	f.ExpectNoError(err)
+	pushed, err = f.Server.PushXAPIStatements(context.Background())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(pushed, 1)
+	f.ExpectRowCountWhere("ggwp.xapi_statements", "lrs_pushed_at IS NOT NULL AND lrs_last_error IS NULL", 1)
+
+	f.ExpectDeepEq(len(f.LRS.Statements), 1)
+	got := f.LRS.Statements[0]
+	f.ExpectDeepEq(got.Verb.ID, external.XAPIVerb_Failed.ID)
+	f.ExpectDeepEq(got.Result.Score.Raw, 2.0)
+	f.ExpectDeepEq(got.Result.Score.Max, 3.0)
+	f.ExpectDeepEq(*got.Result.Success, false)
+}
+
+func TestHandleGetXAPIStatementsSinceUntil(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("test.user@ggwpacademy.com")
+	otherUserID := f.InsertUser(external.NewUser{
+		Email:     "other.user@ggwpacademy.com",
+		FirstName: "Other",
+		LastName:  "User",
+	})
+
+	insert := func(userID int, id string, stored time.Time) {
+		s := external.NewGoalCompletedStatement(&external.UserGoal{ID: 1, UserID: userID})
+		s.ID = id
+		f.ExpectNoError(external.CreateXAPIStatement(f.DAO.DB, s))
+		_, err := f.DAO.DB.Exec(`UPDATE ggwp.xapi_statements SET stored = $2 WHERE id = $1`, id, stored)
+		f.ExpectNoError(err)
+	}
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	insert(auth.UserID, "00000000-0000-4000-8000-000000000001", day)
+	insert(auth.UserID, "00000000-0000-4000-8000-000000000002", day.Add(24*time.Hour))
+	insert(auth.UserID, "00000000-0000-4000-8000-000000000003", day.Add(48*time.Hour))
+	insert(otherUserID, "00000000-0000-4000-8000-000000000004", day.Add(24*time.Hour))
+
+	rr := f.AuthedRequest(
+		http.MethodGet,
+		"/api/v0.1/xapi/statements?since=2020-03-01T00:00:00Z&until=2020-03-03T00:00:00Z",
+		"",
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectDeepEq(rr.Header().Get("X-Experience-API-Version"), external.XAPIVersion)
+
+	var res external.XAPIStatementResult
+	f.ExpectNoError(json.NewDecoder(rr.Body).Decode(&res))
+	ids := []string{}
+	for _, s := range res.Statements {
+		ids = append(ids, s.ID)
+	}
+	// since is exclusive, until inclusive, newest first, only own statements
+	f.ExpectDeepEq(ids, []string{
+		"00000000-0000-4000-8000-000000000003",
+		"00000000-0000-4000-8000-000000000002",
+	})
+	f.ExpectDeepEq(res.More, "")
+
+	rr = f.AuthedRequest(
+		http.MethodGet,
+		"/api/v0.1/xapi/statements?since=yesterday",
+		"",
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+}
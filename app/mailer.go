@@ -3,21 +3,40 @@ package external
 import (
 	"bytes"
 	"context"
+	"embed"
 	"fmt"
-	"html/template"
-	"os"
+	htmltemplate "html/template"
+	"path"
+	"sort"
+	"strconv"
+	texttemplate "text/template"
 	"time"
 
-	"github.com/mailgun/mailgun-go/v3"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// the templates and the images they refer to are part of the binary, so
+// emails go out the same wherever it runs from
+//
+//go:embed templates
+var templateFS embed.FS
+
+// TemplateInfo describes how each type of email is made. Its templates are
+// templates/<name>.txt and templates/<name>.html, which refer to the inlines
+// as cid:<file name>.
 type TemplateInfo struct {
 	name    string
 	subject string
 	inlines []string
-	tag     string
+	// groups the emails in Mailgun's analytics, which go back to when the
+	// tag was the email type
+	tag string
+	// vars which fill every template, to preview them with
+	sample HStoreMap
+	// what people can unsubscribe from, transactional emails are sent because
+	// someone asked for them and go out even after they unsubscribed
+	category EmailCategory
 }
 
 var (
@@ -33,162 +52,289 @@ var (
 				"linkedin_square_grey.png",
 				"twitter_square_grey.png",
 			},
+			category: EmailCategory_Waitlist,
+			tag:      "WAITLIST",
+			sample: HStoreMap{
+				"WaitlistCode":   "WAIT-ABC234X",
+				"Position":       "42",
+				"UnsubscribeURL": sampleUnsubscribeURL,
+			},
+		},
+		EmailType_WaitlistConfirmation: TemplateInfo{
+			name:    "waitlist_confirmation",
+			subject: "Confirm your spot on the waitlist",
+			inlines: []string{
+				"ggwp_logo.png",
+			},
+			category: EmailCategory_Transactional,
+			tag:      "WAITLIST_CONFIRMATION",
+			sample: HStoreMap{
+				"ConfirmURL": "https://www.ggwpacademy.com/api/v0.1/waitlist/confirm?token=sample",
+			},
+		},
+		EmailType_WaitlistInvite: TemplateInfo{
+			name:    "waitlist_invite",
+			subject: "You're off the waitlist",
+			inlines: []string{
+				"ggwp_logo.png",
+			},
+			category: EmailCategory_Transactional,
+			tag:      "WAITLIST_INVITE",
+			sample: HStoreMap{
+				"SignUpURL": "https://www.ggwpacademy.com/signup?invite=sample",
+			},
+		},
+		EmailType_ForgotPassword: TemplateInfo{
+			name:    "forgot_password",
+			subject: "Reset your password",
+			inlines: []string{
+				"ggwp_logo.png",
+			},
+			category: EmailCategory_Transactional,
+			tag:      "FORGOT_PASSWORD",
+			sample: HStoreMap{
+				"Token": "ABCD2345X",
+			},
+		},
+		EmailType_Welcome: TemplateInfo{
+			name:    "welcome",
+			subject: "Welcome to GGWP Academy",
+			inlines: []string{
+				"ggwp_logo.png",
+			},
+			category: EmailCategory_Marketing,
+			tag:      "WELCOME",
+			sample: HStoreMap{
+				"FirstName":      "Alex",
+				"UnsubscribeURL": sampleUnsubscribeURL,
+			},
+		},
+		EmailType_GoalReminder: TemplateInfo{
+			name:    "goal_reminder",
+			subject: "A reminder about your goal",
+			inlines: []string{
+				"ggwp_logo.png",
+			},
+			category: EmailCategory_Reminders,
+			tag:      "GOAL_REMINDER",
+			sample: HStoreMap{
+				"FirstName":       "Alex",
+				"Kind":            GoalReminderKind_Deadline.String(),
+				"GoalDescription": "Finish the budgeting module",
+				"Progress":        "60",
+				"Deadline":        "2020-06-30",
+				"UnsubscribeURL":  sampleUnsubscribeURL,
+			},
+		},
+		EmailType_StreakAtRisk: TemplateInfo{
+			name:    "streak_at_risk",
+			subject: "Your streak is about to end",
+			inlines: []string{
+				"ggwp_logo.png",
+			},
+			category: EmailCategory_Reminders,
+			tag:      "STREAK_AT_RISK",
+			sample: HStoreMap{
+				"FirstName":      "Alex",
+				"CurrentStreak":  "7",
+				"UnsubscribeURL": sampleUnsubscribeURL,
+			},
 		},
 	}
 	sender = "noreply@ggwpacademy.com"
+
+	emailTemplates = mustParseEmailTemplates()
 )
 
 const (
-	templatePath = "./app/mailer/templates"
+	templateDir = "templates"
+
+	sampleUnsubscribeURL = "https://www.ggwpacademy.com/api/v0.1/email/unsubscribe?sample"
+
+	// the variable delivery events find their email with
+	EmailIDVariable = "email_id"
 )
 
-type Mailer struct {
-	mg  *mailgun.MailgunImpl
-	log *logrus.Entry
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
 }
 
-func NewMailer(log *logrus.Entry) *Mailer {
-	return &Mailer{
-		mg: mailgun.NewMailgun(
-			os.Getenv("MAIL_GUN_DOMAIN"),
-			os.Getenv("MAIL_GUN_API_KEY"),
-		),
-		log: log,
+// mustParseEmailTemplates parses the templates of every type of email once,
+// being embedded a broken template can only be a bug.
+func mustParseEmailTemplates() map[EmailType]emailTemplate {
+	templates := make(map[EmailType]emailTemplate, len(templateInfo))
+	for t, info := range templateInfo {
+		templates[t] = emailTemplate{
+			text: texttemplate.Must(
+				texttemplate.New(info.name+".txt").
+					Option("missingkey=zero").
+					ParseFS(templateFS, path.Join(templateDir, info.name+".txt")),
+			),
+			html: htmltemplate.Must(
+				htmltemplate.New(info.name+".html").
+					Option("missingkey=zero").
+					ParseFS(templateFS, path.Join(templateDir, info.name+".html")),
+			),
+		}
 	}
+	return templates
 }
 
-func (m *Mailer) SendForgotPassword(
-	ctx context.Context,
-	recipient,
-	token string,
-) error {
-	subject := "Forgot Password"
-	body := fmt.Sprintf("Your password reset token is: %q", token)
-
-	// The message object allows you to add attachments and Bcc recipients
-	message := m.mg.NewMessage(sender, subject, body, recipient)
-	if err := m.sendEmail(message); err != nil {
-		return errors.Wrapf(err, "sending password reset to %s", recipient)
+// EmailTypes gives every type of email with templates, sorted.
+func EmailTypes() []EmailType {
+	types := make([]EmailType, 0, len(templateInfo))
+	for t := range templateInfo {
+		types = append(types, t)
 	}
-	return nil
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
 }
 
-func (m *Mailer) SendWaitlistEmail(
-	ctx context.Context,
-	recipient,
-	waitlistCode string,
-) error {
-	subject := "Your Waitilst Code"
+// RenderedEmail is an email made from its templates, without a recipient.
+type RenderedEmail struct {
+	Subject string
+	Text    string
+	HTML    string
+	Inlines []EmailInline
+	Tag     string
+}
 
-	text, html, err := GenerateEmail(
-		EmailType_Waitlist,
-		WaitlistEmailVars{
-			WaitlistCode: waitlistCode,
-		},
-	)
-	if err != nil {
-		return errors.Wrapf(err, "getting templates for %s", EmailType_Waitlist)
+// RenderEmail fills in the templates of an email type with vars.
+func RenderEmail(t EmailType, vars interface{}) (*RenderedEmail, error) {
+	info, ok := templateInfo[t]
+	if !ok {
+		return nil, fmt.Errorf("no template info for email type: %s", string(t))
 	}
+	tmpl := emailTemplates[t]
 
-	// The message object allows you to add attachments and Bcc recipients
-	message := m.mg.NewMessage(sender, subject, text, recipient)
-	message.SetHtml(html)
-
-	info, ok := templateInfo[EmailType_Waitlist]
-	if !ok {
-		return fmt.Errorf("no template info for email type: %s", EmailType_Waitlist)
+	textBuf := &bytes.Buffer{}
+	if err := tmpl.text.Execute(textBuf, vars); err != nil {
+		return nil, errors.Wrapf(err, "executing text template %s", t)
 	}
-	for _, i := range info.inlines {
-		message.AddInline(fmt.Sprintf("%s/%s", templatePath, i))
+	htmlBuf := &bytes.Buffer{}
+	if err := tmpl.html.Execute(htmlBuf, vars); err != nil {
+		return nil, errors.Wrapf(err, "executing html template %s", t)
 	}
-	message.AddTag(EmailType_Waitlist.String())
 
-	if err := m.sendEmail(message); err != nil {
-		return errors.Wrapf(err, "sending waitlist email to %s", recipient)
+	inlines := make([]EmailInline, 0, len(info.inlines))
+	for _, name := range info.inlines {
+		content, err := templateFS.ReadFile(path.Join(templateDir, name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading inline %s", name)
+		}
+		inlines = append(inlines, EmailInline{Name: name, Content: content})
 	}
-	return nil
+
+	return &RenderedEmail{
+		Subject: info.subject,
+		Text:    textBuf.String(),
+		HTML:    htmlBuf.String(),
+		Inlines: inlines,
+		Tag:     info.tag,
+	}, nil
 }
 
-func GenerateEmail(t EmailType, vars interface{}) (
-	text,
-	html string,
-	err error,
-) {
+// RenderEmailPreview renders an email type with its sample vars.
+func RenderEmailPreview(t EmailType) (*RenderedEmail, error) {
 	info, ok := templateInfo[t]
 	if !ok {
-		return "", "", fmt.Errorf("no template info for email type: %s", t)
-	}
-	textTemplateName := fmt.Sprintf("%s/%s.txt", templatePath, info.name)
-	htmlTemplateName := fmt.Sprintf("%s/%s.html", templatePath, info.name)
-
-	textTemplate, err := template.ParseFiles(textTemplateName)
-	if err != nil {
-		return "", "", errors.Wrapf(err, "parsing text template: %s", textTemplateName)
-	}
-
-	htmlTemplate, err := template.ParseFiles(htmlTemplateName)
-	if err != nil {
-		return "", "", errors.Wrapf(err, "parsing html template: %s", htmlTemplateName)
+		return nil, fmt.Errorf("no template info for email type: %s", string(t))
 	}
+	return RenderEmail(t, info.sample)
+}
 
-	textBuf := &bytes.Buffer{}
-	if err := textTemplate.Execute(textBuf, vars); err != nil {
-		return "", "", errors.Wrapf(err, "executing text template %s", t)
-	}
-	text = textBuf.String()
+type Mailer struct {
+	sender         EmailSender
+	log            *logrus.Entry
+	unsubscribeKey string
+	baseURL        string
+}
 
-	htmlBuf := &bytes.Buffer{}
-	if err := htmlTemplate.Execute(htmlBuf, vars); err != nil {
-		return "", "", errors.Wrapf(err, "executing html template %s", t)
+func NewMailer(log *logrus.Entry, sender EmailSender, unsubscribeKey, baseURL string) *Mailer {
+	return &Mailer{
+		sender:         sender,
+		log:            log,
+		unsubscribeKey: unsubscribeKey,
+		baseURL:        baseURL,
 	}
-	html = htmlBuf.String()
-	return
 }
 
-func (m *Mailer) sendEmail(message *mailgun.Message) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-
-	// add tracking
-	message.SetTracking(true)
-	message.SetTrackingClicks(true)
-	message.SetTrackingOpens(true)
-
-	_, _, err := m.mg.Send(ctx, message)
-	if err != nil {
-		return errors.Wrap(err, "sending email")
+func (m *Mailer) SendForgotPassword(
+	ctx context.Context,
+	recipient,
+	token string,
+) error {
+	if err := m.sendTemplate(ctx, EmailType_ForgotPassword, recipient, HStoreMap{
+		"Token": token,
+	}, nil); err != nil {
+		return errors.Wrapf(err, "sending password reset to %s", recipient)
 	}
 	return nil
 }
 
+// SendEmail sends a queued email, its delivery events refer back to it with
+// the email_id variable.
 func (m *Mailer) SendEmail(
 	ctx context.Context,
 	e Email,
 ) error {
-	text, html, err := GenerateEmail(
-		e.Type,
-		e.TemplateVars,
-	)
-	if err != nil {
-		return errors.Wrapf(err, "getting templates for %s", EmailType_Waitlist)
+	variables := map[string]string{
+		EmailIDVariable: strconv.Itoa(e.ID),
 	}
-
-	info, ok := templateInfo[EmailType_Waitlist]
-	if !ok {
-		return fmt.Errorf("no template info for email type: %s", EmailType_Waitlist)
+	if err := m.sendTemplate(ctx, e.Type, e.EmailAddress, e.TemplateVars, variables); err != nil {
+		return errors.Wrapf(err, "sending %s email to %s", e.Type, e.EmailAddress)
 	}
+	return nil
+}
 
-	// The message object allows you to add attachments and Bcc recipients
-	message := m.mg.NewMessage(sender, info.subject, text, e.EmailAddress)
-	message.SetHtml(html)
+func (m *Mailer) sendTemplate(
+	ctx context.Context,
+	t EmailType,
+	recipient string,
+	vars HStoreMap,
+	variables map[string]string,
+) error {
+	var headers map[string]string
+	if category := templateInfo[t].category; category != EmailCategory_Transactional {
+		// every email people didn't ask for can be stopped in one click
+		unsubscribeURL := EmailUnsubscribeURL(m.baseURL, m.unsubscribeKey, recipient, category)
+		withURL := make(HStoreMap, len(vars)+1)
+		for k, v := range vars {
+			withURL[k] = v
+		}
+		withURL["UnsubscribeURL"] = unsubscribeURL
+		vars = withURL
+		headers = map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
 
-	for _, i := range info.inlines {
-		message.AddInline(fmt.Sprintf("%s/%s", templatePath, i))
+	rendered, err := RenderEmail(t, vars)
+	if err != nil {
+		return errors.Wrapf(err, "rendering %s email", t)
 	}
-	message.AddTag(EmailType_Waitlist.String())
 
-	if err := m.sendEmail(message); err != nil {
-		return errors.Wrapf(err, "sending waitlist email to %s", e.EmailAddress)
+	return m.send(ctx, &OutgoingEmail{
+		From:      sender,
+		To:        recipient,
+		Subject:   rendered.Subject,
+		Text:      rendered.Text,
+		HTML:      rendered.HTML,
+		Inlines:   rendered.Inlines,
+		Tags:      []string{rendered.Tag},
+		Variables: variables,
+		Headers:   headers,
+	})
+}
+
+func (m *Mailer) send(ctx context.Context, message *OutgoingEmail) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	if err := m.sender.Send(ctx, message); err != nil {
+		return errors.Wrap(err, "sending email")
 	}
 	return nil
 }
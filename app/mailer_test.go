@@ -0,0 +1,70 @@
+package external_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+var cidPattern = regexp.MustCompile(`cid:([^"'\s)]+)`)
+
+func TestRenderEmailPreviews(t *testing.T) {
+	h := TestHelper{t}
+
+	types := external.EmailTypes()
+	h.ExpectDeepEq(len(types) >= 7, true)
+	for _, emailType := range types {
+		m, err := external.RenderEmailPreview(emailType)
+		h.ExpectNoError(err)
+		if m.Subject == "" || strings.TrimSpace(m.Text) == "" || m.HTML == "" {
+			t.Errorf("%s renders empty", emailType)
+		}
+		h.ExpectDeepEq(m.Tag, emailType.String())
+
+		// the html refers to every inline sent along, and only to those
+		referred := map[string]bool{}
+		for _, match := range cidPattern.FindAllStringSubmatch(m.HTML, -1) {
+			referred[match[1]] = true
+		}
+		inlined := map[string]bool{}
+		for _, i := range m.Inlines {
+			if len(i.Content) == 0 {
+				t.Errorf("%s has an empty inline %s", emailType, i.Name)
+			}
+			inlined[i.Name] = true
+		}
+		h.ExpectDeepEq(inlined, referred)
+	}
+}
+
+func TestRenderEmail(t *testing.T) {
+	h := TestHelper{t}
+
+	m, err := external.RenderEmail(external.EmailType_GoalReminder, external.HStoreMap{
+		"FirstName":       "Alex",
+		"Kind":            "PERIOD",
+		"GoalDescription": "Budget <better> & save",
+		"Progress":        "40",
+		"UnsubscribeURL":  "https://api.ggwpacademy.com/unsubscribe?token=abc",
+	})
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(m.Subject, "A reminder about your goal")
+	// the text is sent as is, the html escaped
+	h.ExpectDeepEq(strings.Contains(m.Text, `"Budget <better> & save"`), true)
+	h.ExpectDeepEq(strings.Contains(m.HTML, "Budget &lt;better&gt; &amp; save"), true)
+	h.ExpectDeepEq(strings.Contains(m.Text, "this time around"), true)
+	h.ExpectDeepEq(strings.Contains(m.Text, "<no value>"), false)
+
+	// vars left out render empty
+	m, err = external.RenderEmail(external.EmailType_Waitlist, external.HStoreMap{
+		"WaitlistCode": "WAIT-ABC234X",
+	})
+	h.ExpectNoError(err)
+	h.ExpectDeepEq(strings.Contains(m.Text, "number"), false)
+	h.ExpectDeepEq(strings.Contains(m.Text, "WAIT-ABC234X"), true)
+
+	_, err = external.RenderEmail(external.EmailType("UNKNOWN"), nil)
+	h.ExpectErrorContains(err, "no template info for email type: UNKNOWN")
+}
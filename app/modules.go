@@ -12,6 +12,12 @@ import (
 	"github.com/pkg/errors"
 )
 
+// percentage of correct answers needed to pass a quiz without a passing grade
+var QuizPassPercentage = 80.0
+
+// ErrModuleLocked is returned for quizzes of modules the user can't take yet.
+var ErrModuleLocked = fmt.Errorf("module is locked")
+
 type GradingRequest struct {
 	UserID   int       `json:"user_id,omitempty"`
 	ModuleID int       `json:"module_id,omitempty"`
@@ -31,6 +37,10 @@ func (e *External) HandleGetAllModules(w http.ResponseWriter, r *http.Request) {
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "adding module details"))
 		return
 	}
+	if err := e.lockModulesForUser(r.Context().Value("user_id").(int), mWD); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "locking modules"))
+		return
+	}
 	e.returnJSON(
 		w,
 		mWD,
@@ -47,6 +57,11 @@ func (e *External) HandleSearchModules(w http.ResponseWriter, r *http.Request) {
 	mWD, err := e.injectModuleDetails(m)
 	if err != nil {
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "adding module details"))
+		return
+	}
+	if err := e.lockModulesForUser(r.Context().Value("user_id").(int), mWD); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "locking modules"))
+		return
 	}
 	e.returnJSON(
 		w,
@@ -154,6 +169,33 @@ func (e *External) injectModuleDetails(
 	return finalModules, nil
 }
 
+// lockModulesForUser leaves out the content of modules yet to unlock, unless
+// the user was given access to them early, like with a referral code.
+func (e *External) lockModulesForUser(userID int, modules []*Module) error {
+	unlockedIDs, err := GetUnlockedModuleIDsByUserID(e.dao.ReadDB, userID)
+	if err != nil {
+		return errors.Wrap(err, "getting unlocked modules")
+	}
+	lockModules(modules, unlockedIDs, time.Now())
+	return nil
+}
+
+func lockModules(modules []*Module, unlockedIDs []int, now time.Time) {
+	unlocked := map[int]bool{}
+	for _, id := range unlockedIDs {
+		unlocked[id] = true
+	}
+	for _, m := range modules {
+		if m.UnlocksAt == nil || !m.UnlocksAt.Valid || !m.UnlocksAt.Time.After(now) || unlocked[m.ID] {
+			continue
+		}
+		m.Locked = true
+		m.Quizzes = nil
+		m.Files = nil
+		m.SupportingMaterial = nil
+	}
+}
+
 func (e *External) injectQuizDetails(
 	quizzes []*Quiz,
 ) ([]*Quiz, error) {
@@ -232,11 +274,25 @@ func (e *External) HandleRecordModuleProgress(w http.ResponseWriter, r *http.Req
 		Valid:  true,
 	}
 
-	if err := RecordModuleProgress(e.dao.DB, p); err != nil {
-		// e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording module progress"))
-		// return
-		// just log the error
-		e.log.WithError(err).Error("recording module progress")
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := RecordModuleProgress(tx, p); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording module progress"))
+		return
+	}
+	if err := recordXAPIStatement(tx, NewProgressedStatement(p)); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording progressed statement"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting module progress"))
+		return
 	}
 
 	e.returnJSON(w, nil)
@@ -255,29 +311,56 @@ func (e *External) HandleGradeQuiz(w http.ResponseWriter, r *http.Request) {
 		e.writeError(
 			w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"),
 		)
+		return
 	}
 	defer tx.Rollback()
 
-	quiz, err := GetQuizByID(tx, gR.QuizID)
-	if err != nil {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "getting quiz by id %d", gR.QuizID))
+	gradings, err := e.gradeQuiz(tx, gR, r.Context().Value("device_unique_id").(string))
+	if err == ErrModuleLocked {
+		e.writeError(w, r, http.StatusForbidden, err)
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting grading"))
 		return
 	}
+	e.returnJSON(w, gradings)
+}
+
+// gradeQuiz grades the answers of a quiz take, saves the gradings and bumps
+// the user to the next module if there is one.
+func (e *External) gradeQuiz(
+	q Q,
+	gR *GradingRequest,
+	deviceUniqueID string,
+) ([]*QuizGrading, error) {
+	quiz, err := GetQuizByID(q, gR.QuizID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting quiz by id %d", gR.QuizID)
+	}
+	locked, err := IsModuleLockedForUser(q, gR.UserID, quiz.ModuleID, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "checking module lock")
+	}
+	if locked {
+		return nil, ErrModuleLocked
+	}
 	quizzes, err := e.injectQuizDetails([]*Quiz{quiz})
 	if err != nil {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "adding module quiz details"))
-		return
+		return nil, errors.Wrap(err, "adding module quiz details")
 	}
 	if len(quizzes) != 1 {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "wrong total number of quizzes obtaines after injecting quiz details"))
-		return
+		return nil, fmt.Errorf("wrong total number of quizzes obtaines after injecting quiz details")
 	}
 	quiz = quizzes[0]
 
-	quizTakes, err := GetQuizTakesByUserIDModuleIDAndQuizID(tx, gR.UserID, gR.ModuleID, gR.QuizID)
+	quizTakes, err := GetQuizTakesByUserIDModuleIDAndQuizID(q, gR.UserID, gR.ModuleID, gR.QuizID)
 	if err != nil && err != sql.ErrNoRows {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting quiz takes"))
-		return
+		return nil, errors.Wrap(err, "getting quiz takes")
 	} else if err == sql.ErrNoRows {
 		quizTakes = 0
 	}
@@ -292,11 +375,7 @@ func (e *External) HandleGradeQuiz(w http.ResponseWriter, r *http.Request) {
 	for _, a := range gR.Answers {
 		question, ok := questionIDToQuestion[a.QuestionID]
 		if !ok {
-			e.writeError(
-				w, r, http.StatusInternalServerError,
-				fmt.Errorf("missing question id: %d in map: %#v", a.QuestionID, questionIDToQuestion),
-			)
-			return
+			return nil, fmt.Errorf("missing question id: %d in map: %#v", a.QuestionID, questionIDToQuestion)
 		}
 
 		var correct bool
@@ -335,16 +414,42 @@ func (e *External) HandleGradeQuiz(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// save grading
-	if err := InsertQuizGradings(tx, gradings); err != nil {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "inserting quiz grading"))
-		return
+	if err := InsertQuizGradings(q, gradings); err != nil {
+		return nil, errors.Wrap(err, "inserting quiz grading")
+	}
+	if err := recordXAPIStatement(
+		q, NewQuizAttemptStatement(gR.UserID, gR.ModuleID, quiz, gradings),
+	); err != nil {
+		return nil, errors.Wrap(err, "recording quiz attempt statement")
+	}
+	if correct, total := QuizScore(gradings); QuizPassed(quiz, correct, total) {
+		if takeNumber == 1 {
+			if _, err := RecordXPEvent(
+				q, gR.UserID, XPEventType_QuizPassedFirstTake, fmt.Sprintf("quiz:%d", gR.QuizID), gR.ModuleID,
+			); err != nil {
+				return nil, errors.Wrap(err, "recording quiz xp")
+			}
+		}
+		passedBefore, err := quizPassedBefore(q, gR.UserID, quiz, takeNumber)
+		if err != nil {
+			return nil, errors.Wrap(err, "checking earlier quiz takes")
+		}
+		if !passedBefore {
+			if err := AddLeaderboardScore(
+				q, gR.UserID, LeaderboardMetric_QuizzesPassed, gR.ModuleID, 1, time.Now(),
+			); err != nil {
+				return nil, errors.Wrap(err, "adding passed quiz to leaderboards")
+			}
+		}
+	}
+	if err := RecordLearningActivity(q, gR.UserID, time.Now()); err != nil {
+		return nil, errors.Wrap(err, "recording learning activity")
 	}
 
 	// bump user to next module
-	modules, err := GetModulesByIDs(tx, []int{gR.ModuleID})
+	modules, err := GetModulesByIDs(q, []int{gR.ModuleID})
 	if err != nil {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting current module by id"))
-		return
+		return nil, errors.Wrap(err, "getting current module by id")
 	}
 	var currentModule *Module
 	for _, module := range modules {
@@ -353,19 +458,16 @@ func (e *External) HandleGradeQuiz(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	if currentModule == nil {
+		return nil, fmt.Errorf("unknown module id: %d", gR.ModuleID)
+	}
 	// there is a next module, bump the user
-	nextModuleFile, err := GetFirstFileOfModuleByModuleRanking(e.dao.ReadDB, currentModule.Ranking+1)
+	nextModuleFile, err := GetFirstFileOfModuleByModuleRanking(q, currentModule.Ranking+1)
 	if err != nil && err != sql.ErrNoRows {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting first file of next module by ranking"))
-		return
+		return nil, errors.Wrap(err, "getting first file of next module by ranking")
 	} else if err == sql.ErrNoRows {
-		if err := tx.Commit(); err != nil {
-			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting grading"))
-			return
-		}
 		// no next module, we are done here
-		e.returnJSON(w, gradings)
-		return
+		return gradings, nil
 	}
 
 	// there is a next module, bump the user to it by adding a learning progress
@@ -373,27 +475,46 @@ func (e *External) HandleGradeQuiz(w http.ResponseWriter, r *http.Request) {
 		Int64: int64(gR.UserID),
 		Valid: true,
 	}
-	deviceUniqueID := sql.NullString{
-		String: r.Context().Value("device_unique_id").(string),
-		Valid:  true,
-	}
-	if err := RecordModuleProgress(tx, &LearningProgress{
+	if err := RecordModuleProgress(q, &LearningProgress{
 		UserID:            userID,
 		ModuleID:          nextModuleFile.ModuleID,
 		ModuleFileRanking: 1,
-		DeviceUniqueID:    deviceUniqueID,
+		DeviceUniqueID: sql.NullString{
+			String: deviceUniqueID,
+			Valid:  true,
+		},
 	}); err != nil {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "adding learning progress "))
-		return
+		return nil, errors.Wrap(err, "adding learning progress ")
 	}
 
 	// TODO:
 	// Get the user grade and insert it into the db
 	// Make this the returned value to the frontend as well
 
-	if err := tx.Commit(); err != nil {
-		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting grading"))
-		return
+	return gradings, nil
+}
+
+// QuizScore counts the correct answers of a quiz take.
+func QuizScore(gradings []*QuizGrading) (correct, total int) {
+	for _, g := range gradings {
+		if g.Correct {
+			correct++
+		}
 	}
-	e.returnJSON(w, gradings)
+
+	return correct, len(gradings)
+}
+
+// QuizPassed compares the percentage of correct answers with the passing
+// grade of the quiz.
+func QuizPassed(quiz *Quiz, correct, total int) bool {
+	if total == 0 {
+		return false
+	}
+	passingGrade := QuizPassPercentage
+	if quiz != nil && quiz.PassingGrade.IsPositive() {
+		passingGrade, _ = quiz.PassingGrade.Float64()
+	}
+
+	return float64(correct)*100 >= passingGrade*float64(total)
 }
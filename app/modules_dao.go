@@ -2,6 +2,7 @@ package external
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -19,6 +20,7 @@ func selectFromModulesWhere(where string) string {
 				category_id,
 				free,
 				is_active,
+				unlocks_at,
 				created_at,
 				updated_at
 			FROM
@@ -185,6 +187,7 @@ func GetFirstFileOfModuleByModuleRanking(q Q, ranking int) (*ModuleFile, error)
 				mF.module_id,
 				mF.file_id,
 				mF.ranking,
+				mF.package_prefix,
 				mF.created_at,
 				mF.updated_at
 			FROM
@@ -205,6 +208,42 @@ func GetFirstFileOfModuleByModuleRanking(q Q, ranking int) (*ModuleFile, error)
 	return m, nil
 }
 
+func GetModuleFileByID(q Q, ID int) (*ModuleFile, error) {
+	m := &ModuleFile{}
+	if err := q.Get(
+		m,
+		`
+			SELECT
+				mF.id,
+				mF.module_id,
+				mF.file_id,
+				mF.ranking,
+				mF.package_prefix,
+				mF.created_at,
+				mF.updated_at,
+
+				-- video details
+				v.id as "file.video_details.id",
+				v.file_id as "file.video_details.file_id",
+				v.duration as "file.video_details.duration",
+				v.created_at "file.video_details.created_at",
+				v.updated_at "file.video_details.updated_at"
+			FROM
+				ggwp.module_files mF
+			LEFT JOIN
+				ggwp.video_details v
+				ON v.file_id = mF.file_id
+			WHERE
+				mF.id = $1
+		`,
+		ID,
+	); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
 func GetModulesFilesByModuleIDs(q Q, IDs []int) ([]*ModuleFile, error) {
 	var m []*ModuleFile
 	if err := q.Select(
@@ -216,6 +255,7 @@ func GetModulesFilesByModuleIDs(q Q, IDs []int) ([]*ModuleFile, error) {
 					mF.module_id,
 					mF.file_id,
 					mF.ranking,
+					mF.package_prefix,
 					mF.created_at,
 					mF.updated_at,
 
@@ -371,6 +411,101 @@ func GetModulesCategoryByIDs(q Q, IDs []int) ([]*ModuleCategory, error) {
 	return m, nil
 }
 
+func GetMaxModuleRanking(q Q) (int, error) {
+	var r int
+	if err := q.Get(
+		&r,
+		`
+			SELECT COALESCE(MAX(ranking), 0)
+			FROM ggwp.modules
+		`,
+	); err != nil {
+		return 0, err
+	}
+
+	return r, nil
+}
+
+func CreateModule(q Q, m *Module) error {
+	if err := q.Get(
+		&m.ID,
+		`
+			INSERT INTO ggwp.modules
+			(
+				user_id, name, description, ranking, hashtags, category_id, free,
+				is_active, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW()
+			)
+			RETURNING id
+		`,
+		m.UserID,
+		m.Name,
+		m.Description,
+		m.Ranking,
+		m.Hashtags,
+		m.CategoryID,
+		m.Free,
+		m.IsActive,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CreateModuleFile(q Q, m *ModuleFile) error {
+	if err := q.Get(
+		&m.ID,
+		`
+			INSERT INTO ggwp.module_files
+			(
+				module_id, file_id, ranking, package_prefix, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, NOW(), NOW()
+			)
+			RETURNING id
+		`,
+		m.ModuleID,
+		m.FileID,
+		m.Ranking,
+		m.PackagePrefix,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CreateModuleLearningOutcome(q Q, o *ModuleLearningOutcome) error {
+	if err := q.Get(
+		&o.ID,
+		`
+			INSERT INTO ggwp.module_learning_outcomes
+			(
+				module_id, description, ranking, is_active, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, NOW(), NOW()
+			)
+			RETURNING id
+		`,
+		o.ModuleID,
+		o.Description,
+		o.Ranking,
+		o.IsActive,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func intsToString(s []int) string {
 	l := len(s)
 	if l == 0 {
@@ -388,18 +523,33 @@ func intsToString(s []int) string {
 	return res
 }
 
+// RecordModuleProgress keeps a single row per user (or device) and module file,
+// moving its seek position forward rather than appending new rows.
 func RecordModuleProgress(q Q, p *LearningProgress) error {
+	// the conflict target has to match the partial unique index of the owner
+	conflict := `(user_id, module_id, module_file_ranking) WHERE user_id IS NOT NULL`
+	if !p.UserID.Valid {
+		conflict = `(device_unique_id, module_id, module_file_ranking) WHERE user_id IS NULL`
+	}
 	if _, err := q.NamedExec(
-		`
-			INSERT INTO ggwp.learning_progresses
-			(
-				module_id, user_id, module_file_ranking, seek, created_at, updated_at, device_unique_id
-			)
-			VALUES
-			(
-				:module_id, :user_id, :module_file_ranking, :seek, NOW(), NOW(), :device_unique_id
-			)
-		`,
+		fmt.Sprintf(
+			`
+				INSERT INTO ggwp.learning_progresses
+				(
+					module_id, user_id, module_file_ranking, seek, created_at, updated_at, device_unique_id
+				)
+				VALUES
+				(
+					:module_id, :user_id, :module_file_ranking, :seek, NOW(), NOW(), :device_unique_id
+				)
+				ON CONFLICT %s DO UPDATE
+				SET
+					seek = EXCLUDED.seek,
+					device_unique_id = COALESCE(EXCLUDED.device_unique_id, learning_progresses.device_unique_id),
+					updated_at = NOW()
+			`,
+			conflict,
+		),
 		p,
 	); err != nil {
 		return err
@@ -419,8 +569,8 @@ func GetQuizTakesByUserIDModuleIDAndQuizID(q Q, userID, moduleID, quizID int) (i
 				ggwp.quiz_gradings
 			WHERE
 				user_id = $1
-				AND quiz_id = $2
-				AND module_id = $3
+				AND module_id = $2
+				AND quiz_id = $3
 			ORDER BY
 				take_number DESC
 			LIMIT 1
@@ -573,3 +723,52 @@ func GetCompletedModuleIDsByUserID(q Q, userID int) ([]int, error) {
 
 	return c, nil
 }
+
+// GetUnlockedModuleIDsByUserID gives the modules a user was given access to
+// before they unlock for everyone.
+func GetUnlockedModuleIDsByUserID(q Q, userID int) ([]int, error) {
+	var ids []int
+	if err := q.Select(
+		&ids,
+		`
+			SELECT module_id
+			FROM ggwp.user_module_unlocks
+			WHERE user_id = $1
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// IsModuleLockedForUser tells whether a module is yet to unlock and the user
+// wasn't given access to it early.
+func IsModuleLockedForUser(q Q, userID, moduleID int, now time.Time) (bool, error) {
+	var locked bool
+	if err := q.Get(
+		&locked,
+		`
+			SELECT EXISTS (
+				SELECT 1
+				FROM ggwp.modules m
+				WHERE
+					m.id = $2
+					AND m.unlocks_at > $3
+					AND NOT EXISTS (
+						SELECT 1
+						FROM ggwp.user_module_unlocks u
+						WHERE u.user_id = $1 AND u.module_id = m.id
+					)
+			)
+		`,
+		userID,
+		moduleID,
+		now,
+	); err != nil {
+		return false, err
+	}
+
+	return locked, nil
+}
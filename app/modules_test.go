@@ -1 +1,159 @@
 package external_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+	"github.com/shopspring/decimal"
+)
+
+func TestRecordModuleProgressKeepsOneRow(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("module-progress@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+
+	record := func(userID sql.NullInt64, device string, seek float64) {
+		f.ExpectNoError(external.RecordModuleProgress(f.DAO.DB, &external.LearningProgress{
+			UserID:            userID,
+			DeviceUniqueID:    sql.NullString{String: device, Valid: device != ""},
+			ModuleID:          mf.ModuleID,
+			ModuleFileRanking: mf.Ranking,
+			Seek:              decimal.NewFromFloat(seek),
+		}))
+	}
+	user := sql.NullInt64{Int64: int64(auth.UserID), Valid: true}
+	record(user, "", 10)
+	record(user, "device-a", 20)
+	record(sql.NullInt64{}, "device-b", 5)
+	record(sql.NullInt64{}, "device-b", 15)
+
+	f.ExpectRowCountWhere(
+		"ggwp.learning_progresses",
+		fmt.Sprintf("user_id = %d AND seek = 20 AND device_unique_id = 'device-a'", auth.UserID),
+		1,
+	)
+	f.ExpectRowCountWhere(
+		"ggwp.learning_progresses",
+		"user_id IS NULL AND device_unique_id = 'device-b' AND seek = 15",
+		1,
+	)
+	f.ExpectRowCount("ggwp.learning_progresses", 2)
+}
+
+func TestModulesLockedUntilUnlocked(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("locked-modules@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+	_, err := f.DAO.DB.Exec(
+		`UPDATE ggwp.modules SET unlocks_at = NOW() + INTERVAL '7 days' WHERE id = $1`,
+		mf.ModuleID,
+	)
+	f.ExpectNoError(err)
+
+	getModule := func() *external.Module {
+		rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/modules", "", auth.AccessToken)
+		f.ExpectStatus(rr, http.StatusOK)
+		var modules []*external.Module
+		f.Bind(rr, &modules)
+		for _, m := range modules {
+			if m.ID == mf.ModuleID {
+				return m
+			}
+		}
+		t.Fatalf("module %d not listed", mf.ModuleID)
+		return nil
+	}
+
+	// modules yet to unlock are listed without their content
+	m := getModule()
+	f.ExpectDeepEq(m.Locked, true)
+	f.ExpectDeepEq(len(m.Files), 0)
+	locked, err := external.IsModuleLockedForUser(f.DAO.DB, auth.UserID, mf.ModuleID, time.Now())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(locked, true)
+
+	// unless the user was given access early, like with a referral code
+	f.ExpectNoError(external.UnlockModuleForUser(f.DAO.DB, auth.UserID, mf.ModuleID, "test"))
+	m = getModule()
+	f.ExpectDeepEq(m.Locked, false)
+	f.ExpectDeepEq(len(m.Files), 1)
+	locked, err = external.IsModuleLockedForUser(f.DAO.DB, auth.UserID, mf.ModuleID, time.Now())
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(locked, false)
+
+	// and everyone once it unlocks
+	other := f.GetAuthToken("other-locked-modules@ggwpacademy.com")
+	locked, err = external.IsModuleLockedForUser(f.DAO.DB, other.UserID, mf.ModuleID, time.Now().Add(8*24*time.Hour))
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(locked, false)
+}
+
+func TestGetQuizTakesByUserIDModuleIDAndQuizID(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("quiz-takes@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+
+	// a quiz whose id isn't the id of its module, so mixing them up shows
+	var quizID int
+	for quizID == 0 || quizID == mf.ModuleID {
+		f.ExpectNoError(f.DAO.DB.Get(
+			&quizID,
+			`
+				INSERT INTO ggwp.quizzes
+				(
+					module_id, name, description, passing_grade, is_active, created_at, updated_at
+				)
+				VALUES
+				(
+					$1, 'Quiz', 'Quiz', 80, TRUE, NOW(), NOW()
+				)
+				RETURNING id
+			`,
+			mf.ModuleID,
+		))
+	}
+	var questionID int
+	f.ExpectNoError(f.DAO.DB.Get(
+		&questionID,
+		`
+			INSERT INTO ggwp.quiz_questions
+			(
+				quiz_id, name, description, ranking, answer_option_ranking, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, 'Question', 'Question', 1, 1, NOW(), NOW()
+			)
+			RETURNING id
+		`,
+		quizID,
+	))
+
+	_, err := external.GetQuizTakesByUserIDModuleIDAndQuizID(f.DAO.DB, auth.UserID, mf.ModuleID, quizID)
+	f.ExpectDeepEq(err, sql.ErrNoRows)
+
+	for take := 1; take <= 2; take++ {
+		f.ExpectNoError(external.InsertQuizGradings(f.DAO.DB, []*external.QuizGrading{{
+			ModuleID:   mf.ModuleID,
+			QuizID:     quizID,
+			QuestionID: questionID,
+			UserID:     auth.UserID,
+			TakeNumber: take,
+			CreatedAt:  goalTime(time.Now()),
+			UpdatedAt:  goalTime(time.Now()),
+		}}))
+	}
+	takes, err := external.GetQuizTakesByUserIDModuleIDAndQuizID(f.DAO.DB, auth.UserID, mf.ModuleID, quizID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(takes, 2)
+}
@@ -0,0 +1,606 @@
+package external
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// a module file counts as finished once this much of it has been watched
+	ModuleFileCompletionPercentage = 90.0
+	// reported positions may run ahead of the wall clock by this many seconds
+	progressPositionTolerance = 2.0
+	// never credit more than this much continuous playback between two events
+	progressMaxEventGap = 5 * time.Minute
+	// maximum number of events accepted in a single request
+	progressMaxEventsPerRequest = 500
+)
+
+type WatchedInterval struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// WatchedIntervals is a sorted list of non overlapping [start, end] ranges, in
+// seconds, of a video that have been watched.
+type WatchedIntervals []WatchedInterval
+
+// Add merges the range [start, end] into the intervals.
+func (w WatchedIntervals) Add(start, end float64) WatchedIntervals {
+	if end <= start {
+		return w
+	}
+
+	merged := WatchedIntervals{}
+	added := false
+	for _, i := range w {
+		switch {
+		case i.End < start:
+			merged = append(merged, i)
+		case i.Start > end:
+			if !added {
+				merged = append(merged, WatchedInterval{start, end})
+				added = true
+			}
+			merged = append(merged, i)
+		default:
+			// overlapping or touching, widen the new range
+			start = math.Min(start, i.Start)
+			end = math.Max(end, i.End)
+		}
+	}
+	if !added {
+		merged = append(merged, WatchedInterval{start, end})
+	}
+
+	return merged
+}
+
+// Clip drops anything watched past max, eg. the duration of the video.
+func (w WatchedIntervals) Clip(max float64) WatchedIntervals {
+	clipped := WatchedIntervals{}
+	for _, i := range w {
+		if i.Start >= max {
+			break
+		}
+		clipped = append(clipped, WatchedInterval{i.Start, math.Min(i.End, max)})
+	}
+	return clipped
+}
+
+// Total gives the number of seconds covered by the intervals.
+func (w WatchedIntervals) Total() float64 {
+	var t float64
+	for _, i := range w {
+		t += i.End - i.Start
+	}
+	return t
+}
+
+func (w *WatchedIntervals) Scan(src interface{}) error {
+	var b []byte
+	switch src.(type) {
+	case nil:
+		*w = WatchedIntervals{}
+		return nil
+	case string:
+		b = []byte(src.(string))
+	case []byte:
+		b = src.([]byte)
+	default:
+		return fmt.Errorf("Scan: unable to scan %T into watched intervals", src)
+	}
+	return json.Unmarshal(b, w)
+}
+
+func (w WatchedIntervals) Value() (driver.Value, error) {
+	if w == nil {
+		w = WatchedIntervals{}
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		return nil, err
+	}
+	return driver.Value(string(b)), nil
+}
+
+// FoldProgressEvents applies events to the aggregated progress p. Playback
+// between two consecutive events is credited as watched, bounded by how much
+// wall clock time passed so that unreported seeks do not count. duration is
+// the length of the video in seconds, zero if unknown.
+// It returns true if the module file became complete because of these events.
+func FoldProgressEvents(
+	p *ModuleFileProgress,
+	events []*ProgressEvent,
+	duration float64,
+) bool {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+
+	wasComplete := p.CompletedAt != nil && p.CompletedAt.Valid
+	intervals := p.WatchedIntervals
+	position, _ := p.ResumePosition.Float64()
+	rate, _ := p.PlaybackRate.Float64()
+	if rate <= 0 {
+		rate = 1
+	}
+	playing := p.IsPlaying
+	var lastAt time.Time
+	if p.LastEventAt != nil && p.LastEventAt.Valid {
+		lastAt = p.LastEventAt.Time
+	}
+
+	for _, ev := range events {
+		if !lastAt.IsZero() && ev.OccurredAt.Before(lastAt) {
+			// arrived late, the state has already moved on
+			continue
+		}
+		evPosition, _ := ev.Position.Float64()
+
+		if playing && !lastAt.IsZero() {
+			elapsed := ev.OccurredAt.Sub(lastAt)
+			if elapsed > progressMaxEventGap {
+				elapsed = progressMaxEventGap
+			}
+			expected := position + elapsed.Seconds()*rate
+
+			end := evPosition
+			if ev.Type == ProgressEventType_Seek {
+				// the position is where the player jumped to, not where it was
+				end = expected
+			} else if end > expected+progressPositionTolerance {
+				end = expected
+			}
+			intervals = intervals.Add(position, end)
+		}
+
+		switch ev.Type {
+		case ProgressEventType_Play, ProgressEventType_Heartbeat:
+			playing = true
+		case ProgressEventType_Pause, ProgressEventType_Ended:
+			playing = false
+		}
+		if r, _ := ev.PlaybackRate.Float64(); r > 0 {
+			rate = r
+		}
+		position = evPosition
+		lastAt = ev.OccurredAt
+	}
+
+	if duration > 0 {
+		intervals = intervals.Clip(duration)
+		position = math.Min(position, duration)
+	}
+	watched := intervals.Total()
+
+	p.WatchedIntervals = intervals
+	p.WatchedSeconds = decimal.NewFromFloat(watched).Round(3)
+	p.ResumePosition = decimal.NewFromFloat(position).Round(3)
+	p.PlaybackRate = decimal.NewFromFloat(rate)
+	p.IsPlaying = playing
+	if !lastAt.IsZero() {
+		p.LastEventAt = &NullTime{pq.NullTime{Time: lastAt, Valid: true}}
+	}
+
+	if duration > 0 {
+		completion := math.Min(watched/duration*100, 100)
+		p.CompletionPercentage = decimal.NewFromFloat(completion).Round(2)
+		if !wasComplete && completion >= ModuleFileCompletionPercentage {
+			p.CompletedAt = &NullTime{pq.NullTime{Time: lastAt, Valid: true}}
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *External) HandleRecordProgressEvents(w http.ResponseWriter, r *http.Request) {
+	userID := sql.NullInt64{
+		Int64: int64(r.Context().Value("user_id").(int)),
+		Valid: true,
+	}
+	e.handleRecordProgressEvents(w, r, userID)
+}
+
+func (e *External) HandleGetModuleFileProgress(w http.ResponseWriter, r *http.Request) {
+	p, err := GetModuleFileProgressesByUserIDAndDeviceUniqueID(
+		e.dao.ReadDB,
+		r.Context().Value("user_id").(int),
+		r.Context().Value("device_unique_id").(string),
+	)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting module file progress"))
+		return
+	}
+
+	e.returnJSON(w, p)
+}
+
+func (e *External) handleRecordProgressEvents(
+	w http.ResponseWriter,
+	r *http.Request,
+	userID sql.NullInt64,
+) {
+	req := &ProgressEventsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	if len(req.Events) == 0 {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing events"))
+		return
+	}
+	if len(req.Events) > progressMaxEventsPerRequest {
+		e.writeError(
+			w, r, http.StatusBadRequest,
+			fmt.Errorf("too many events, at most %d per request", progressMaxEventsPerRequest),
+		)
+		return
+	}
+
+	deviceUniqueID := sql.NullString{
+		String: r.Context().Value("device_unique_id").(string),
+		Valid:  r.Context().Value("device_unique_id").(string) != "",
+	}
+	if !userID.Valid && !deviceUniqueID.Valid {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing %s header", deviceIDHeader))
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	progress, err := e.recordProgressEvents(tx, userID, deviceUniqueID, req.Events)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "recording progress events"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting progress events"))
+		return
+	}
+
+	e.returnJSON(w, progress)
+}
+
+// recordProgressEvents stores raw events and folds them into the aggregated
+// progress of every module file they refer to.
+func (e *External) recordProgressEvents(
+	q Q,
+	userID sql.NullInt64,
+	deviceUniqueID sql.NullString,
+	events []*ProgressEvent,
+) ([]*ModuleFileProgress, error) {
+	now := time.Now()
+	moduleFileIDs := []int{}
+	moduleFiles := map[int]*ModuleFile{}
+	for _, ev := range events {
+		if ev.ModuleFileID <= 0 {
+			return nil, fmt.Errorf("missing module_file_id")
+		}
+		if ev.Type.String() == "" {
+			return nil, fmt.Errorf("unknown event type: %q", ev.Type)
+		}
+		if ev.Position.IsNegative() {
+			return nil, fmt.Errorf("negative position for module file %d", ev.ModuleFileID)
+		}
+		if ev.OccurredAt.IsZero() || ev.OccurredAt.After(now) {
+			ev.OccurredAt = now
+		}
+		ev.UserID = userID
+		ev.DeviceUniqueID = deviceUniqueID
+
+		// the module comes from the file, whatever the client sent
+		moduleFile, ok := moduleFiles[ev.ModuleFileID]
+		if !ok {
+			var err error
+			moduleFile, err = GetModuleFileByID(q, ev.ModuleFileID)
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("unknown module file %d", ev.ModuleFileID)
+			} else if err != nil {
+				return nil, errors.Wrapf(err, "getting module file %d", ev.ModuleFileID)
+			}
+			moduleFiles[ev.ModuleFileID] = moduleFile
+			moduleFileIDs = append(moduleFileIDs, ev.ModuleFileID)
+		}
+		ev.ModuleID = moduleFile.ModuleID
+	}
+
+	if err := InsertProgressEvents(q, events); err != nil {
+		return nil, errors.Wrap(err, "inserting progress events")
+	}
+
+	progress := []*ModuleFileProgress{}
+	for _, moduleFileID := range moduleFileIDs {
+		p, err := e.foldModuleFileProgress(q, userID, deviceUniqueID, moduleFileID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "folding progress for module file %d", moduleFileID)
+		}
+		progress = append(progress, p)
+	}
+
+	return progress, nil
+}
+
+// foldModuleFileProgress folds every pending event of a module file into its
+// aggregated progress and keeps the legacy learning progress row in step.
+func (e *External) foldModuleFileProgress(
+	q Q,
+	userID sql.NullInt64,
+	deviceUniqueID sql.NullString,
+	moduleFileID int,
+) (*ModuleFileProgress, error) {
+	moduleFile, err := GetModuleFileByID(q, moduleFileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("unknown module file: %d", moduleFileID)
+		}
+		return nil, errors.Wrap(err, "getting module file")
+	}
+	duration := moduleFileDuration(moduleFile)
+
+	p, err := GetModuleFileProgress(q, userID, deviceUniqueID, moduleFileID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "getting module file progress")
+	} else if err == sql.ErrNoRows {
+		p = &ModuleFileProgress{
+			UserID:           userID,
+			DeviceUniqueID:   deviceUniqueID,
+			ModuleID:         moduleFile.ModuleID,
+			ModuleFileID:     moduleFileID,
+			WatchedIntervals: WatchedIntervals{},
+		}
+	}
+
+	events, err := GetUnfoldedProgressEvents(q, userID, deviceUniqueID, moduleFileID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting unfolded progress events")
+	}
+	if len(events) == 0 {
+		return p, nil
+	}
+
+	watchedBefore := p.WatchedSeconds
+	completed := FoldProgressEvents(p, events, duration)
+
+	if err := SaveModuleFileProgress(q, p); err != nil {
+		return nil, errors.Wrap(err, "saving module file progress")
+	}
+
+	eventIDs := []int{}
+	for _, ev := range events {
+		eventIDs = append(eventIDs, ev.ID)
+	}
+	if err := MarkProgressEventsFolded(q, eventIDs); err != nil {
+		return nil, errors.Wrap(err, "marking progress events folded")
+	}
+
+	if err := RecordModuleProgress(q, &LearningProgress{
+		UserID:            userID,
+		DeviceUniqueID:    deviceUniqueID,
+		ModuleID:          moduleFile.ModuleID,
+		ModuleFileRanking: moduleFile.Ranking,
+		Seek:              p.ResumePosition,
+	}); err != nil {
+		return nil, errors.Wrap(err, "recording module progress")
+	}
+
+	if userID.Valid {
+		watched, _ := p.WatchedSeconds.Sub(watchedBefore).Float64()
+		if err := AddLeaderboardScore(
+			q,
+			int(userID.Int64),
+			LeaderboardMetric_WatchTime,
+			moduleFile.ModuleID,
+			watched,
+			time.Now(),
+		); err != nil {
+			return nil, errors.Wrap(err, "adding watch time to leaderboards")
+		}
+	}
+
+	if completed && userID.Valid {
+		if err := recordXAPIStatement(q, NewModuleFileCompletedStatement(p, moduleFile.Ranking)); err != nil {
+			return nil, errors.Wrap(err, "recording completed statement")
+		}
+		if _, err := RecordXPEvent(
+			q,
+			int(userID.Int64),
+			XPEventType_ModuleFileCompleted,
+			fmt.Sprintf("module_file:%d", moduleFileID),
+			moduleFile.ModuleID,
+		); err != nil {
+			return nil, errors.Wrap(err, "recording module file xp")
+		}
+		if err := RecordLearningActivity(q, int(userID.Int64), time.Now()); err != nil {
+			return nil, errors.Wrap(err, "recording learning activity")
+		}
+	}
+
+	return p, nil
+}
+
+// claimDeviceProgress attaches progress recorded anonymously on a device to a
+// user. When both have progress for the same module file the furthest one
+// wins. Every claim that changes anything is recorded in the audit trail.
+func (e *External) claimDeviceProgress(
+	q Q,
+	userID int,
+	deviceUniqueID string,
+	source ProgressClaimSource,
+) (*ProgressClaim, error) {
+	c := &ProgressClaim{
+		UserID:         userID,
+		DeviceUniqueID: deviceUniqueID,
+		Source:         source,
+	}
+	if deviceUniqueID == "" {
+		return c, nil
+	}
+
+	var err error
+	if c.LearningProgressMerged, err = MergeDeviceLearningProgresses(q, userID, deviceUniqueID); err != nil {
+		return nil, errors.Wrap(err, "merging device learning progresses")
+	}
+	if c.LearningProgressClaimed, err = ClaimDeviceLearningProgresses(q, userID, deviceUniqueID); err != nil {
+		return nil, errors.Wrap(err, "claiming device learning progresses")
+	}
+
+	deviceProgresses, err := GetModuleFileProgressesByDeviceUniqueID(q, deviceUniqueID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting device module file progresses")
+	}
+	owner := sql.NullInt64{Int64: int64(userID), Valid: true}
+	for _, d := range deviceProgresses {
+		u, err := GetModuleFileProgress(q, owner, sql.NullString{}, d.ModuleFileID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, errors.Wrapf(err, "getting user progress for module file %d", d.ModuleFileID)
+		} else if err == sql.ErrNoRows {
+			if err := ClaimModuleFileProgress(q, d.ID, userID); err != nil {
+				return nil, errors.Wrapf(err, "claiming progress for module file %d", d.ModuleFileID)
+			}
+			c.FileProgressClaimed++
+			continue
+		}
+
+		var duration float64
+		moduleFile, err := GetModuleFileByID(q, d.ModuleFileID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, errors.Wrapf(err, "getting module file %d", d.ModuleFileID)
+		} else if err == nil {
+			duration = moduleFileDuration(moduleFile)
+		}
+		if err := SaveModuleFileProgress(q, mergeModuleFileProgress(d, u, duration)); err != nil {
+			return nil, errors.Wrapf(err, "merging progress for module file %d", d.ModuleFileID)
+		}
+		if err := DeleteModuleFileProgress(q, d.ID); err != nil {
+			return nil, errors.Wrapf(err, "deleting device progress for module file %d", d.ModuleFileID)
+		}
+		c.FileProgressMerged++
+	}
+
+	if c.ProgressEventsClaimed, err = ClaimDeviceProgressEvents(q, userID, deviceUniqueID); err != nil {
+		return nil, errors.Wrap(err, "claiming device progress events")
+	}
+
+	if c.LearningProgressMerged+c.LearningProgressClaimed+
+		c.FileProgressClaimed+c.FileProgressMerged+c.ProgressEventsClaimed == 0 {
+		return c, nil
+	}
+	if err := CreateProgressClaim(q, c); err != nil {
+		return nil, errors.Wrap(err, "recording progress claim")
+	}
+
+	return c, nil
+}
+
+// claimDeviceProgressForUser claims device progress in its own transaction.
+// Failing to claim must never stop someone from signing up or logging in, so
+// errors are only logged.
+func (e *External) claimDeviceProgressForUser(
+	ctx context.Context,
+	userID int,
+	deviceUniqueID string,
+	source ProgressClaimSource,
+) {
+	l := e.log.WithFields(logrus.Fields{
+		"user_id":          userID,
+		"device_unique_id": deviceUniqueID,
+		"source":           source,
+	})
+	if deviceUniqueID == "" {
+		return
+	}
+
+	tx, err := e.dao.GetTx(ctx)
+	if err != nil {
+		l.WithError(err).Error("unable to get tx for claiming device progress")
+		return
+	}
+	defer tx.Rollback()
+
+	c, err := e.claimDeviceProgress(tx, userID, deviceUniqueID, source)
+	if err != nil {
+		l.WithError(err).Error("unable to claim device progress")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		l.WithError(err).Error("unable to commit claimed device progress")
+		return
+	}
+	l.WithField("claim", c).Info("claimed device progress")
+}
+
+// mergeModuleFileProgress merges the progress of a device into the progress of
+// a user for the same module file. Playback resumes from whichever got further
+// and everything watched on either counts. Completing by the merge alone is
+// left to the next events folded.
+func mergeModuleFileProgress(device, user *ModuleFileProgress, duration float64) *ModuleFileProgress {
+	merged := *user
+	if isFurtherProgress(device, user) {
+		merged = *device
+		merged.ID = user.ID
+		merged.UserID = user.UserID
+	}
+
+	intervals := user.WatchedIntervals
+	for _, i := range device.WatchedIntervals {
+		intervals = intervals.Add(i.Start, i.End)
+	}
+	watched := intervals.Total()
+	merged.WatchedIntervals = intervals
+	merged.WatchedSeconds = decimal.NewFromFloat(watched).Round(3)
+	if duration > 0 {
+		completion := decimal.NewFromFloat(math.Min(watched/duration*100, 100)).Round(2)
+		if completion.GreaterThan(merged.CompletionPercentage) {
+			merged.CompletionPercentage = completion
+		}
+	}
+
+	merged.CompletedAt = user.CompletedAt
+	if device.CompletedAt != nil && device.CompletedAt.Valid &&
+		(merged.CompletedAt == nil || !merged.CompletedAt.Valid || device.CompletedAt.Time.Before(merged.CompletedAt.Time)) {
+		merged.CompletedAt = device.CompletedAt
+	}
+
+	return &merged
+}
+
+// moduleFileDuration gives the length of the video of a module file in
+// seconds, zero if unknown.
+func moduleFileDuration(moduleFile *ModuleFile) float64 {
+	var duration float64
+	if moduleFile.File != nil &&
+		moduleFile.File.VideoDetails != nil &&
+		moduleFile.File.VideoDetails.Duration != nil &&
+		moduleFile.File.VideoDetails.Duration.Valid {
+		duration, _ = moduleFile.File.VideoDetails.Duration.Decimal.Float64()
+	}
+	return duration
+}
+
+// isFurtherProgress reports whether a has progressed further than b.
+func isFurtherProgress(a, b *ModuleFileProgress) bool {
+	if !a.CompletionPercentage.Equal(b.CompletionPercentage) {
+		return a.CompletionPercentage.GreaterThan(b.CompletionPercentage)
+	}
+	return a.ResumePosition.GreaterThan(b.ResumePosition)
+}
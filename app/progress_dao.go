@@ -0,0 +1,450 @@
+package external
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// progressOwnerWhere matches rows owned by a user when one is given, otherwise
+// rows of the anonymous device.
+func progressOwnerWhere(userArg, deviceArg string) string {
+	return fmt.Sprintf(
+		`
+			(
+				(CAST(%[1]s AS BIGINT) IS NOT NULL AND user_id = %[1]s)
+				OR (
+					CAST(%[1]s AS BIGINT) IS NULL
+					AND user_id IS NULL
+					AND device_unique_id = %[2]s
+				)
+			)
+		`,
+		userArg,
+		deviceArg,
+	)
+}
+
+func selectFromModuleFileProgressesWhere(where string) string {
+	return fmt.Sprintf(
+		`
+			SELECT
+				id,
+				user_id,
+				device_unique_id,
+				module_id,
+				module_file_id,
+				watched_intervals,
+				watched_seconds,
+				completion_percentage,
+				resume_position,
+				playback_rate,
+				is_playing,
+				last_event_at,
+				completed_at,
+				created_at,
+				updated_at
+			FROM
+				ggwp.module_file_progresses
+			%s
+		`,
+		where,
+	)
+}
+
+func InsertProgressEvents(q Q, events []*ProgressEvent) error {
+	for _, ev := range events {
+		if _, err := q.NamedExec(
+			`
+				INSERT INTO ggwp.learning_progress_events
+				(
+					user_id, device_unique_id, module_id, module_file_id, type,
+					position, playback_rate, occurred_at, created_at
+				)
+				VALUES
+				(
+					:user_id, :device_unique_id, :module_id, :module_file_id, :type,
+					:position, :playback_rate, :occurred_at, NOW()
+				)
+			`,
+			ev,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetUnfoldedProgressEvents(
+	q Q,
+	userID sql.NullInt64,
+	deviceUniqueID sql.NullString,
+	moduleFileID int,
+) ([]*ProgressEvent, error) {
+	var events []*ProgressEvent
+	if err := q.Select(
+		&events,
+		fmt.Sprintf(
+			`
+				SELECT
+					id,
+					user_id,
+					device_unique_id,
+					module_id,
+					module_file_id,
+					type,
+					position,
+					COALESCE(playback_rate, 0) playback_rate,
+					occurred_at,
+					folded_at,
+					created_at
+				FROM
+					ggwp.learning_progress_events
+				WHERE
+					module_file_id = $3
+					AND folded_at IS NULL
+					AND %s
+				ORDER BY
+					occurred_at, id
+			`,
+			progressOwnerWhere("$1", "$2"),
+		),
+		userID,
+		deviceUniqueID,
+		moduleFileID,
+	); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func MarkProgressEventsFolded(q Q, ids []int) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.learning_progress_events
+			SET folded_at = NOW()
+			WHERE id = ANY($1)
+		`,
+		pq.Array(ids),
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ProgressEventKey identifies the owner and module file of pending events.
+type ProgressEventKey struct {
+	UserID         sql.NullInt64  `json:"user_id,omitempty"`
+	DeviceUniqueID sql.NullString `json:"device_unique_id,omitempty"`
+	ModuleFileID   int            `json:"module_file_id,omitempty"`
+}
+
+func GetUnfoldedProgressEventKeys(q Q) ([]*ProgressEventKey, error) {
+	var k []*ProgressEventKey
+	if err := q.Select(
+		&k,
+		`
+			SELECT DISTINCT
+				user_id,
+				CASE WHEN user_id IS NULL THEN device_unique_id END device_unique_id,
+				module_file_id
+			FROM
+				ggwp.learning_progress_events
+			WHERE
+				folded_at IS NULL
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func DeleteFoldedProgressEvents(q Q, before time.Time) (int64, error) {
+	res, err := q.Exec(
+		`
+			DELETE FROM ggwp.learning_progress_events
+			WHERE folded_at IS NOT NULL
+				AND folded_at < $1
+		`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func GetModuleFileProgress(
+	q Q,
+	userID sql.NullInt64,
+	deviceUniqueID sql.NullString,
+	moduleFileID int,
+) (*ModuleFileProgress, error) {
+	var p ModuleFileProgress
+	if err := q.Get(
+		&p,
+		selectFromModuleFileProgressesWhere(
+			fmt.Sprintf(
+				`
+					WHERE module_file_id = $3
+						AND %s
+				`,
+				progressOwnerWhere("$1", "$2"),
+			),
+		),
+		userID,
+		deviceUniqueID,
+		moduleFileID,
+	); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func GetModuleFileProgressesByUserIDAndDeviceUniqueID(
+	q Q,
+	userID int,
+	deviceUniqueID string,
+) ([]*ModuleFileProgress, error) {
+	var p []*ModuleFileProgress
+	if err := q.Select(
+		&p,
+		selectFromModuleFileProgressesWhere(
+			`
+				WHERE user_id = $1
+					-- only include device_unique_id info if provided device_unique_id is not empty
+					OR (
+						NULLIF(TRUE, $2 = '')
+						AND
+						device_unique_id = $2
+					)
+				ORDER BY updated_at
+			`,
+		),
+		userID,
+		deviceUniqueID,
+	); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func GetModuleFileProgressesByDeviceUniqueID(q Q, deviceUniqueID string) ([]*ModuleFileProgress, error) {
+	var p []*ModuleFileProgress
+	if err := q.Select(
+		&p,
+		selectFromModuleFileProgressesWhere(
+			`
+				WHERE user_id IS NULL
+					AND device_unique_id = $1
+				ORDER BY updated_at
+			`,
+		),
+		deviceUniqueID,
+	); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func SaveModuleFileProgress(q Q, p *ModuleFileProgress) error {
+	if p.ID == 0 {
+		rows, err := q.NamedQuery(
+			`
+				INSERT INTO ggwp.module_file_progresses
+				(
+					user_id, device_unique_id, module_id, module_file_id, watched_intervals,
+					watched_seconds, completion_percentage, resume_position, playback_rate,
+					is_playing, last_event_at, completed_at, created_at, updated_at
+				)
+				VALUES
+				(
+					:user_id, :device_unique_id, :module_id, :module_file_id, :watched_intervals,
+					:watched_seconds, :completion_percentage, :resume_position, :playback_rate,
+					:is_playing, :last_event_at, :completed_at, NOW(), NOW()
+				)
+				RETURNING id
+			`,
+			p,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if rows.Next() {
+			if err := rows.Scan(&p.ID); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	if _, err := q.NamedExec(
+		`
+			UPDATE ggwp.module_file_progresses
+			SET
+				user_id = :user_id,
+				device_unique_id = :device_unique_id,
+				watched_intervals = :watched_intervals,
+				watched_seconds = :watched_seconds,
+				completion_percentage = :completion_percentage,
+				resume_position = :resume_position,
+				playback_rate = :playback_rate,
+				is_playing = :is_playing,
+				last_event_at = :last_event_at,
+				completed_at = :completed_at,
+				updated_at = NOW()
+			WHERE id = :id
+		`,
+		p,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MergeDeviceLearningProgresses folds device rows into rows the user already
+// has for the same module file, keeping the furthest seek, and drops them.
+func MergeDeviceLearningProgresses(q Q, userID int, deviceUniqueID string) (int64, error) {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.learning_progresses u
+			SET
+				seek = GREATEST(COALESCE(u.seek, 0), COALESCE(d.seek, 0)),
+				updated_at = NOW()
+			FROM
+				ggwp.learning_progresses d
+			WHERE
+				u.user_id = $1
+				AND d.user_id IS NULL
+				AND d.device_unique_id = $2
+				AND d.module_id = u.module_id
+				AND d.module_file_ranking = u.module_file_ranking
+		`,
+		userID,
+		deviceUniqueID,
+	); err != nil {
+		return 0, err
+	}
+
+	res, err := q.Exec(
+		`
+			DELETE FROM ggwp.learning_progresses d
+			USING ggwp.learning_progresses u
+			WHERE
+				u.user_id = $1
+				AND d.user_id IS NULL
+				AND d.device_unique_id = $2
+				AND d.module_id = u.module_id
+				AND d.module_file_ranking = u.module_file_ranking
+		`,
+		userID,
+		deviceUniqueID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func ClaimDeviceLearningProgresses(q Q, userID int, deviceUniqueID string) (int64, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.learning_progresses
+			SET user_id = $1, updated_at = NOW()
+			WHERE user_id IS NULL
+				AND device_unique_id = $2
+		`,
+		userID,
+		deviceUniqueID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func ClaimDeviceProgressEvents(q Q, userID int, deviceUniqueID string) (int64, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.learning_progress_events
+			SET user_id = $1
+			WHERE user_id IS NULL
+				AND device_unique_id = $2
+		`,
+		userID,
+		deviceUniqueID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func ClaimModuleFileProgress(q Q, id, userID int) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.module_file_progresses
+			SET user_id = $2, updated_at = NOW()
+			WHERE id = $1
+		`,
+		id,
+		userID,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteModuleFileProgress(q Q, id int) error {
+	if _, err := q.Exec(
+		`
+			DELETE FROM ggwp.module_file_progresses
+			WHERE id = $1
+		`,
+		id,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func CreateProgressClaim(q Q, c *ProgressClaim) error {
+	if _, err := q.NamedExec(
+		`
+			INSERT INTO ggwp.progress_claims
+			(
+				user_id, device_unique_id, source, learning_progress_claimed,
+				learning_progress_merged, file_progress_claimed, file_progress_merged,
+				progress_events_claimed, created_at
+			)
+			VALUES
+			(
+				:user_id, :device_unique_id, :source, :learning_progress_claimed,
+				:learning_progress_merged, :file_progress_claimed, :file_progress_merged,
+				:progress_events_claimed, NOW()
+			)
+		`,
+		c,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package external_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+	"github.com/shopspring/decimal"
+)
+
+func progressEvent(
+	t external.ProgressEventType,
+	position float64,
+	at time.Time,
+) *external.ProgressEvent {
+	return &external.ProgressEvent{
+		Type:       t,
+		Position:   decimal.NewFromFloat(position),
+		OccurredAt: at,
+	}
+}
+
+func TestWatchedIntervalsAddMerges(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	w := external.WatchedIntervals{}
+	w = w.Add(10, 20)
+	w = w.Add(30, 40)
+	w = w.Add(15, 32)
+	w = w.Add(50, 45)
+
+	h.ExpectDeepEq(w, external.WatchedIntervals{{Start: 10, End: 40}})
+	h.ExpectDeepEq(w.Total(), 30.0)
+	h.ExpectDeepEq(w.Clip(25), external.WatchedIntervals{{Start: 10, End: 25}})
+}
+
+func TestFoldProgressEventsPlayPause(t *testing.T) {
+	h := &TestHelper{T: t}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := &external.ModuleFileProgress{}
+	completed := external.FoldProgressEvents(
+		p,
+		[]*external.ProgressEvent{
+			progressEvent(external.ProgressEventType_Pause, 30, start.Add(30*time.Second)),
+			progressEvent(external.ProgressEventType_Play, 0, start),
+		},
+		100,
+	)
+
+	h.ExpectDeepEq(completed, false)
+	h.ExpectDeepEq(p.WatchedIntervals, external.WatchedIntervals{{Start: 0, End: 30}})
+	h.ExpectDeepEq(p.WatchedSeconds.String(), "30")
+	h.ExpectDeepEq(p.CompletionPercentage.String(), "30")
+	h.ExpectDeepEq(p.ResumePosition.String(), "30")
+	h.ExpectDeepEq(p.IsPlaying, false)
+}
+
+func TestFoldProgressEventsSeekIsNotWatched(t *testing.T) {
+	h := &TestHelper{T: t}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := &external.ModuleFileProgress{}
+	external.FoldProgressEvents(
+		p,
+		[]*external.ProgressEvent{
+			progressEvent(external.ProgressEventType_Play, 0, start),
+			// jumped forward after 10 seconds of playback
+			progressEvent(external.ProgressEventType_Seek, 80, start.Add(10*time.Second)),
+			progressEvent(external.ProgressEventType_Ended, 100, start.Add(30*time.Second)),
+		},
+		100,
+	)
+
+	h.ExpectDeepEq(p.WatchedIntervals, external.WatchedIntervals{
+		{Start: 0, End: 10},
+		{Start: 80, End: 100},
+	})
+	h.ExpectDeepEq(p.CompletionPercentage.String(), "30")
+	h.ExpectDeepEq(p.CompletedAt, (*external.NullTime)(nil))
+}
+
+func TestFoldProgressEventsAcrossBatchesCompletes(t *testing.T) {
+	h := &TestHelper{T: t}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := &external.ModuleFileProgress{}
+	external.FoldProgressEvents(
+		p,
+		[]*external.ProgressEvent{
+			progressEvent(external.ProgressEventType_Play, 0, start),
+			progressEvent(external.ProgressEventType_Heartbeat, 50, start.Add(50*time.Second)),
+		},
+		100,
+	)
+	completed := external.FoldProgressEvents(
+		p,
+		[]*external.ProgressEvent{
+			// reported position is ahead of the clock, only 45 seconds are credited
+			progressEvent(external.ProgressEventType_Pause, 100, start.Add(95*time.Second)),
+		},
+		100,
+	)
+
+	h.ExpectDeepEq(completed, true)
+	h.ExpectDeepEq(p.WatchedIntervals, external.WatchedIntervals{{Start: 0, End: 95}})
+	h.ExpectDeepEq(p.CompletionPercentage.String(), "95")
+	h.ExpectDeepEq(p.CompletedAt.Time, start.Add(95*time.Second))
+}
+
+func TestFoldProgressEventsPlaybackRate(t *testing.T) {
+	h := &TestHelper{T: t}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rateChange := progressEvent(external.ProgressEventType_RateChange, 10, start.Add(10*time.Second))
+	rateChange.PlaybackRate = decimal.NewFromFloat(2)
+
+	p := &external.ModuleFileProgress{}
+	external.FoldProgressEvents(
+		p,
+		[]*external.ProgressEvent{
+			progressEvent(external.ProgressEventType_Play, 0, start),
+			rateChange,
+			progressEvent(external.ProgressEventType_Pause, 50, start.Add(30*time.Second)),
+		},
+		0,
+	)
+
+	h.ExpectDeepEq(p.WatchedIntervals, external.WatchedIntervals{{Start: 0, End: 50}})
+	h.ExpectDeepEq(p.PlaybackRate.String(), "2")
+	// unknown duration, no completion
+	h.ExpectDeepEq(p.CompletionPercentage.String(), "0")
+}
+
+func TestHandleRecordProgressEventsModuleFromFile(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("progress-events@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+	other := f.InsertModuleFile(auth.UserID)
+
+	// the module is taken from the file, left out or not
+	rr := f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/modules/progress_events",
+		fmt.Sprintf(
+			`{"events": [
+				{"module_file_id": %d, "type": "PLAY", "position": "0"},
+				{"module_file_id": %d, "module_id": %d, "type": "PAUSE", "position": "10"}
+			]}`,
+			mf.ID, mf.ID, other.ModuleID,
+		),
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCountWhere(
+		"ggwp.learning_progress_events",
+		fmt.Sprintf("module_file_id = %d AND module_id = %d", mf.ID, mf.ModuleID),
+		2,
+	)
+
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/modules/progress_events",
+		`{"events": [{"module_file_id": 999999, "type": "PLAY", "position": "0"}]}`,
+		auth.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	f.ExpectBodyContains(rr, "unknown module file 999999")
+	f.ExpectRowCount("ggwp.learning_progress_events", 2)
+}
+
+func TestClaimDeviceProgressOnLogin(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	email := "claim-progress@ggwpacademy.com"
+	auth := f.GetAuthToken(email)
+	user := sql.NullInt64{Int64: int64(auth.UserID), Valid: true}
+	device := sql.NullString{String: "claim-device", Valid: true}
+
+	deviceOnly := f.InsertModuleFile(auth.UserID)
+	userOnly := f.InsertModuleFile(auth.UserID)
+	both := f.InsertModuleFile(auth.UserID)
+
+	save := func(
+		userID sql.NullInt64,
+		deviceUniqueID sql.NullString,
+		mf *external.ModuleFile,
+		intervals external.WatchedIntervals,
+		completion float64,
+	) {
+		f.ExpectNoError(external.SaveModuleFileProgress(f.DAO.DB, &external.ModuleFileProgress{
+			UserID:               userID,
+			DeviceUniqueID:       deviceUniqueID,
+			ModuleID:             mf.ModuleID,
+			ModuleFileID:         mf.ID,
+			WatchedIntervals:     intervals,
+			WatchedSeconds:       decimal.NewFromFloat(intervals.Total()),
+			CompletionPercentage: decimal.NewFromFloat(completion),
+			ResumePosition:       decimal.NewFromFloat(intervals[len(intervals)-1].End),
+			PlaybackRate:         decimal.NewFromFloat(1),
+		}))
+		f.ExpectNoError(external.RecordModuleProgress(f.DAO.DB, &external.LearningProgress{
+			UserID:            userID,
+			DeviceUniqueID:    deviceUniqueID,
+			ModuleID:          mf.ModuleID,
+			ModuleFileRanking: mf.Ranking,
+			Seek:              decimal.NewFromFloat(intervals[len(intervals)-1].End),
+		}))
+	}
+	save(sql.NullInt64{}, device, deviceOnly, external.WatchedIntervals{{Start: 0, End: 10}}, 10)
+	save(user, sql.NullString{}, userOnly, external.WatchedIntervals{{Start: 0, End: 20}}, 20)
+	save(user, sql.NullString{}, both, external.WatchedIntervals{{Start: 0, End: 30}}, 30)
+	save(sql.NullInt64{}, device, both, external.WatchedIntervals{{Start: 20, End: 60}}, 40)
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/api/v0.1/user/login",
+		strings.NewReader(fmt.Sprintf(`{"email": "%s", "password": "keto"}`, email)),
+	)
+	req.Header.Set("X-GGWP-Device-Unique-Id", device.String)
+	f.ExpectStatus(f.Serve(req), http.StatusOK)
+
+	// device rows are claimed or merged, user rows stay as they are
+	f.ExpectRowCountWhere("ggwp.module_file_progresses", "user_id IS NULL", 0)
+	f.ExpectRowCountWhere("ggwp.module_file_progresses", fmt.Sprintf("user_id = %d", auth.UserID), 3)
+	f.ExpectRowCountWhere("ggwp.learning_progresses", "user_id IS NULL", 0)
+	f.ExpectRowCountWhere("ggwp.learning_progresses", fmt.Sprintf("user_id = %d", auth.UserID), 3)
+
+	expect := func(mf *external.ModuleFile, intervals external.WatchedIntervals, seek string) {
+		p, err := external.GetModuleFileProgress(f.DAO.DB, user, sql.NullString{}, mf.ID)
+		f.ExpectNoError(err)
+		f.ExpectDeepEq(p.WatchedIntervals, intervals)
+		f.ExpectDeepEq(p.ResumePosition.String(), seek)
+		f.ExpectRowCountWhere(
+			"ggwp.learning_progresses",
+			fmt.Sprintf("user_id = %d AND module_id = %d AND seek = %s", auth.UserID, mf.ModuleID, seek),
+			1,
+		)
+	}
+	expect(deviceOnly, external.WatchedIntervals{{Start: 0, End: 10}}, "10")
+	expect(userOnly, external.WatchedIntervals{{Start: 0, End: 20}}, "20")
+	// the device got further, but what the user watched still counts
+	expect(both, external.WatchedIntervals{{Start: 0, End: 60}}, "60")
+	p, err := external.GetModuleFileProgress(f.DAO.DB, user, sql.NullString{}, both.ID)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(p.WatchedSeconds.String(), "60")
+	f.ExpectDeepEq(p.CompletionPercentage.String(), "40")
+
+	f.ExpectRowCountWhere(
+		"ggwp.progress_claims",
+		fmt.Sprintf(
+			"user_id = %d AND learning_progress_merged = 1 AND learning_progress_claimed = 1"+
+				" AND file_progress_claimed = 1 AND file_progress_merged = 1",
+			auth.UserID,
+		),
+		1,
+	)
+}
@@ -0,0 +1,230 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// campaign and vanity codes are picked by people, unlike generated codes
+var referralCampaignCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9-]{2,31}$`)
+
+func validateReferralCampaign(c *ReferralCampaignRequest) error {
+	c.ReferralCode = NormalizeCode(c.ReferralCode)
+	if !referralCampaignCodePattern.MatchString(c.ReferralCode) {
+		return fmt.Errorf("code has to be 3 to 32 letters, digits or dashes")
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+
+	switch c.Kind {
+	case ReferralCodeKind_Campaign:
+		if c.UserID != nil {
+			return fmt.Errorf("campaign codes have no owner, use a vanity code")
+		}
+	case ReferralCodeKind_Vanity:
+		if c.UserID == nil {
+			return fmt.Errorf("vanity codes need the user_id of their owner")
+		}
+	default:
+		return fmt.Errorf("kind has to be %s or %s", ReferralCodeKind_Campaign, ReferralCodeKind_Vanity)
+	}
+
+	switch c.ReferralType {
+	case ReferralTypeHours, ReferralTypeQuantity:
+		if c.Value <= 0 {
+			return fmt.Errorf("value has to be positive for %s codes", c.ReferralType)
+		}
+	case ReferralTypeUnlimited:
+		c.Value = 0
+	default:
+		return fmt.Errorf(
+			"referral type has to be %s, %s or %s",
+			ReferralTypeHours, ReferralTypeQuantity, ReferralTypeUnlimited,
+		)
+	}
+
+	if c.StartsAt != nil && c.EndsAt != nil && !c.StartsAt.Before(*c.EndsAt) {
+		return fmt.Errorf("ends_at has to be after starts_at")
+	}
+	return nil
+}
+
+// referralCampaignError turns the constraints a campaign code runs into into
+// bad requests.
+func referralCampaignError(err error) (int, error) {
+	if isUniqueViolation(err) {
+		return http.StatusBadRequest, fmt.Errorf("code is already taken")
+	}
+	if pqErr, ok := errors.Cause(err).(*pq.Error); ok && pqErr.Code == "23503" {
+		return http.StatusBadRequest, fmt.Errorf("unknown module or user")
+	}
+	return http.StatusInternalServerError, err
+}
+
+func (e *External) HandleGetReferralCampaigns(w http.ResponseWriter, r *http.Request) {
+	codes, err := GetReferralCampaignCodes(e.dao.ReadDB)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral campaigns"))
+		return
+	}
+	if codes == nil {
+		codes = []*ReferralCode{}
+	}
+
+	e.returnJSON(w, codes)
+}
+
+func (e *External) HandleCreateReferralCampaign(w http.ResponseWriter, r *http.Request) {
+	c := &ReferralCampaignRequest{}
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	if err := validateReferralCampaign(c); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	code, err := CreateReferralCampaignCode(e.dao.DB, c, r.Context().Value("user_id").(int))
+	if err != nil {
+		status, err := referralCampaignError(err)
+		e.writeError(w, r, status, errors.Wrap(err, "creating referral campaign"))
+		return
+	}
+
+	e.returnJSON(w, code)
+}
+
+func (e *External) HandleGetReferralCampaign(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	code, err := GetReferralCampaignCode(e.dao.ReadDB, id)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown referral campaign: %d", id))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral campaign"))
+		return
+	}
+
+	e.returnJSON(w, code)
+}
+
+// HandleUpdateReferralCampaign changes the fields given, apart from the code
+// and its kind.
+func (e *External) HandleUpdateReferralCampaign(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	code, err := GetReferralCampaignCode(tx, id)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown referral campaign: %d", id))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral campaign"))
+		return
+	}
+	c := &ReferralCampaignRequest{
+		ReferralType: code.ReferralType,
+		Value:        code.Value,
+		StartsAt:     code.StartsAt,
+		EndsAt:       code.EndsAt,
+		ModuleID:     code.ModuleID,
+	}
+	if code.Name != nil {
+		c.Name = *code.Name
+	}
+	if code.UserID > 0 {
+		c.UserID = &code.UserID
+	}
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	c.ReferralCode, c.Kind = code.ReferralCode, code.Kind
+	if err := validateReferralCampaign(c); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := UpdateReferralCampaignCode(tx, id, c); err != nil {
+		status, err := referralCampaignError(err)
+		e.writeError(w, r, status, errors.Wrap(err, "updating referral campaign"))
+		return
+	}
+	if code, err = GetReferralCampaignCode(tx, id); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral campaign"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting referral campaign"))
+		return
+	}
+
+	e.returnJSON(w, code)
+}
+
+// HandleDeleteReferralCampaign deactivates a campaign, whoever it brought in
+// stays attributed to it.
+func (e *External) HandleDeleteReferralCampaign(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	found, err := DeactivateReferralCampaignCode(e.dao.DB, id)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "deactivating referral campaign"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown referral campaign: %d", id))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
+
+// HandleGetReferralCampaignFunnel gives how far the people a campaign brought
+// in got, from the waitlist to using the app.
+func (e *External) HandleGetReferralCampaignFunnel(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	code, err := GetReferralCampaignCode(e.dao.ReadDB, id)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown referral campaign: %d", id))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral campaign"))
+		return
+	}
+	f, err := GetReferralCampaignFunnel(e.dao.ReadDB, id)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral campaign funnel"))
+		return
+	}
+	if code.ReferralType == ReferralTypeQuantity {
+		remaining := code.Value - f.Redemptions
+		if remaining < 0 {
+			remaining = 0
+		}
+		f.RemainingUses = &remaining
+	}
+
+	e.returnJSON(w, f)
+}
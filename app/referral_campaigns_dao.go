@@ -0,0 +1,191 @@
+package external
+
+func CreateReferralCampaignCode(q Q, r *ReferralCampaignRequest, createdBy int) (*ReferralCode, error) {
+	var id int
+	if err := q.Get(
+		&id,
+		`
+			INSERT INTO ggwp.referral_codes
+			(
+				user_id, referral_code, referral_type, value, is_active, kind, name,
+				starts_at, ends_at, module_id, created_by, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, TRUE, $5, $6,
+				$7, $8, $9, $10, NOW(), NOW()
+			)
+			RETURNING id
+		`,
+		r.UserID,
+		r.ReferralCode,
+		r.ReferralType,
+		r.Value,
+		r.Kind,
+		r.Name,
+		r.StartsAt,
+		r.EndsAt,
+		r.ModuleID,
+		createdBy,
+	); err != nil {
+		return nil, err
+	}
+
+	return GetReferralCampaignCode(q, id)
+}
+
+// GetReferralCampaignCode gives a campaign or vanity code.
+func GetReferralCampaignCode(q Q, id int) (*ReferralCode, error) {
+	var c ReferralCode
+	if err := q.Get(
+		&c,
+		selectFromReferralCodesWhere(
+			`
+				WHERE id = $1
+					AND kind <> 'USER'
+			`,
+		),
+		id,
+	); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// GetReferralCampaignCodes gives every campaign and vanity code, newest first.
+func GetReferralCampaignCodes(q Q) ([]*ReferralCode, error) {
+	var c []*ReferralCode
+	if err := q.Select(
+		&c,
+		selectFromReferralCodesWhere(
+			`
+				WHERE kind <> 'USER'
+				ORDER BY created_at DESC, id DESC
+			`,
+		),
+	); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// UpdateReferralCampaignCode replaces everything but the code itself and its
+// kind, as codes end up printed.
+func UpdateReferralCampaignCode(q Q, id int, r *ReferralCampaignRequest) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.referral_codes
+			SET
+				user_id = $2,
+				referral_type = $3,
+				value = $4,
+				is_active = COALESCE($5, is_active),
+				name = $6,
+				starts_at = $7,
+				ends_at = $8,
+				module_id = $9,
+				updated_at = NOW()
+			WHERE id = $1
+				AND kind <> 'USER'
+		`,
+		id,
+		r.UserID,
+		r.ReferralType,
+		r.Value,
+		r.IsActive,
+		r.Name,
+		r.StartsAt,
+		r.EndsAt,
+		r.ModuleID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// DeactivateReferralCampaignCode stops a campaign or vanity code from being
+// redeemed, its redemptions are kept for the funnel.
+func DeactivateReferralCampaignCode(q Q, id int) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.referral_codes
+			SET is_active = FALSE, updated_at = NOW()
+			WHERE id = $1
+				AND kind <> 'USER'
+		`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetReferralCampaignFunnel counts the people a referral code brought in,
+// through the waitlist or by signing up with it. Active people went on to
+// complete a module file.
+func GetReferralCampaignFunnel(q Q, id int) (*ReferralCampaignFunnel, error) {
+	var f ReferralCampaignFunnel
+	if err := q.Get(
+		&f,
+		`
+			WITH waitlisted AS (
+				SELECT
+					id,
+					confirmed_at,
+					user_id
+				FROM
+					ggwp.waitlist
+				WHERE
+					original_referral_code_id = $1
+			), signed_up AS (
+				SELECT user_id FROM waitlisted WHERE user_id IS NOT NULL
+				UNION
+				SELECT referral_user_id FROM ggwp.referral_redemptions WHERE referral_code_id = $1
+			)
+			SELECT
+				$1::INTEGER referral_code_id,
+				(SELECT COUNT(*) FROM waitlisted) waitlist_joined,
+				(SELECT COUNT(confirmed_at) FROM waitlisted) waitlist_confirmed,
+				(
+					SELECT COUNT(*)
+					FROM waitlisted w
+					JOIN ggwp.waitlist_invites i ON i.waitlist_id = w.id
+				) waitlist_invited,
+				(SELECT COUNT(*) FROM signed_up) signed_up,
+				(
+					SELECT COUNT(*)
+					FROM ggwp.referral_redemptions
+					WHERE referral_code_id = $1
+				) redemptions,
+				(
+					SELECT COUNT(*)
+					FROM signed_up s
+					WHERE EXISTS (
+						SELECT 1
+						FROM ggwp.xp_ledger l
+						WHERE l.user_id = s.user_id
+							AND l.event_type = 'MODULE_FILE_COMPLETED'
+					)
+				) active
+		`,
+		id,
+	); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
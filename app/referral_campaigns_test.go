@@ -0,0 +1,144 @@
+package external_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestReferralCampaigns(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	admin := f.GetAuthToken("admin.user@ggwpacademy.com")
+	f.MakeAdmin(admin.UserID)
+	influencer := f.GetAuthToken("influencer@ggwpacademy.com")
+
+	rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/referrals/campaigns", "", influencer.AccessToken)
+	f.ExpectStatus(rr, http.StatusForbidden)
+
+	endsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/referrals/campaigns",
+		fmt.Sprintf(
+			`{"referral_code": "camp-summer", "kind": "CAMPAIGN", "name": "Summer", "referral_type": "QUANTITY", "value": 2, "ends_at": %q}`,
+			endsAt.Format(time.RFC3339),
+		),
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	campaign := &external.ReferralCode{}
+	f.Bind(rr, campaign)
+	f.ExpectDeepEq(campaign.ReferralCode, "CAMP-SUMMER")
+	f.ExpectDeepEq(campaign.Kind, external.ReferralCodeKind_Campaign)
+	f.ExpectDeepEq(campaign.EndsAt.Equal(endsAt), true)
+
+	// codes are unique and vanity codes need an owner
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/referrals/campaigns",
+		`{"referral_code": "CAMP-SUMMER", "kind": "CAMPAIGN", "name": "Again", "referral_type": "UNLIMITED"}`,
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	f.ExpectBodyContains(rr, "already taken")
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/referrals/campaigns",
+		`{"referral_code": "NINJA", "kind": "VANITY", "name": "Ninja", "referral_type": "UNLIMITED"}`,
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/referrals/campaigns",
+		`{"referral_code": "CAMP-MODULE", "kind": "CAMPAIGN", "name": "Module", "referral_type": "UNLIMITED", "module_id": 999999}`,
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/referrals/campaigns",
+		fmt.Sprintf(
+			`{"referral_code": "NINJA", "kind": "VANITY", "name": "Ninja", "referral_type": "UNLIMITED", "user_id": %d}`,
+			influencer.UserID,
+		),
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	vanity := &external.ReferralCode{}
+	f.Bind(rr, vanity)
+
+	// people come in through the waitlist and by signing up with the codes
+	rr = f.UnAuthedRequest(
+		http.MethodPost,
+		"/waitlist/user",
+		`{"email_address": "waiting@ggwpacademy.com", "original_referral_code": "CAMP-SUMMER"}`,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	signUp := func(email, code string) int {
+		rr := f.UnAuthedRequest(
+			http.MethodPost,
+			"/user",
+			`{"email": "`+email+`", "password": "test123", "first_name": "Test", "last_name": "User", "referral_code": "`+code+`"}`,
+		)
+		return rr.Code
+	}
+	f.ExpectDeepEq(signUp("first@ggwpacademy.com", "CAMP-SUMMER"), http.StatusOK)
+	f.ExpectDeepEq(signUp("second@ggwpacademy.com", "CAMP-SUMMER"), http.StatusOK)
+	f.ExpectDeepEq(signUp("third@ggwpacademy.com", "CAMP-SUMMER"), http.StatusBadRequest)
+	f.ExpectDeepEq(signUp("fan@ggwpacademy.com", "NINJA"), http.StatusOK)
+
+	// campaign codes have nobody to reward, vanity codes reward their owner
+	f.ExpectRowCountWhere("ggwp.referral_rewards", "recipient = 'REFERRER'", 1)
+	f.ExpectRowCountWhere("ggwp.referral_rewards", fmt.Sprintf("user_id = %d", influencer.UserID), 1)
+
+	rr = f.AuthedRequest(http.MethodGet, fmt.Sprintf("/api/v0.1/referrals/campaigns/%d/funnel", campaign.ID), "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	funnel := &external.ReferralCampaignFunnel{}
+	f.Bind(rr, funnel)
+	remaining := 0
+	f.ExpectDeepEq(funnel, &external.ReferralCampaignFunnel{
+		ReferralCodeID: campaign.ID,
+		WaitlistJoined: 1,
+		SignedUp:       2,
+		Redemptions:    2,
+		RemainingUses:  &remaining,
+	})
+
+	// more uses and the campaign takes sign ups again, until deactivated
+	rr = f.AuthedRequest(
+		http.MethodPut,
+		fmt.Sprintf("/api/v0.1/referrals/campaigns/%d", campaign.ID),
+		`{"value": 3}`,
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, campaign)
+	f.ExpectDeepEq(campaign.Value, 3)
+	f.ExpectDeepEq(*campaign.Name, "Summer")
+	f.ExpectDeepEq(signUp("third@ggwpacademy.com", "CAMP-SUMMER"), http.StatusOK)
+
+	rr = f.AuthedRequest(http.MethodDelete, fmt.Sprintf("/api/v0.1/referrals/campaigns/%d", vanity.ID), "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectDeepEq(signUp("late.fan@ggwpacademy.com", "NINJA"), http.StatusBadRequest)
+	rr = f.UnAuthedRequest(
+		http.MethodPost,
+		"/waitlist/user",
+		`{"email_address": "late.waiting@ggwpacademy.com", "original_referral_code": "NINJA"}`,
+	)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+	f.ExpectBodyContains(rr, `referral code \"NINJA\" is not active`)
+	f.ExpectRowCountWhere("ggwp.waitlist", "email_address = 'late.waiting@ggwpacademy.com'", 0)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/referrals/campaigns", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var campaigns []*external.ReferralCode
+	f.Bind(rr, &campaigns)
+	f.ExpectDeepEq(len(campaigns), 2)
+}
@@ -3,96 +3,220 @@ package external
 import (
 	"database/sql"
 	"fmt"
+	"net/http"
 	"time"
 
-	"github.com/AmirSoleimani/VoucherCodeGenerator/vcgen"
 	"github.com/pkg/errors"
 )
 
 func GenerateReferralCode(q Q, userID int) (*ReferralCode, error) {
-	vcPrefix := vcgen.New(
-		&vcgen.Generator{
-			Count: 1, Pattern: "######", Prefix: "WELC-",
-		},
-	)
-	res, err := vcPrefix.Run()
+	code, err := GenerateUniqueCode(q, ReferralCodeFormat, func(code string) error {
+		return CreateReferralCode(q, userID, code)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to generate ref code")
+		return nil, errors.Wrap(err, "unable to save referral code")
 	}
-	if res == nil {
-		return nil, fmt.Errorf("no ref codes returned from library")
+	referralCode, err := GetReferralCodeByUserID(q, userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get generated referral code ref_code: %s", code)
 	}
 
-	generatedRefCodes := *res
-	if len(generatedRefCodes) != 1 {
-		return nil, fmt.Errorf("invalid number of generated ref codes")
+	return referralCode, nil
+}
+
+// UnredeemableReason tells why a referral code can't be redeemed any more, it
+// is empty while the code can still be redeemed. Hours codes expire that many
+// hours after they were created, quantity codes once redeemed that many times.
+// Campaign codes are also limited to their start and end dates.
+func (c *ReferralCode) UnredeemableReason(redemptions int, now time.Time) string {
+	if !c.IsActive {
+		return fmt.Sprintf("referral code %q is not active", c.ReferralCode)
 	}
-	if err := CreateReferralCode(q, userID, generatedRefCodes[0]); err != nil {
-		return nil, errors.Wrapf(err, "unable to save referral code: %s", generatedRefCodes[0])
+	if c.StartsAt != nil && now.Before(*c.StartsAt) {
+		return fmt.Sprintf("referral code %q has not started yet", c.ReferralCode)
 	}
-	referralCode, err := GetReferralCodeByUserID(q, userID)
+	if c.EndsAt != nil && !now.Before(*c.EndsAt) {
+		return fmt.Sprintf("referral code %q has ended", c.ReferralCode)
+	}
+
+	switch c.ReferralType {
+	case ReferralTypeHours:
+		if c.CreatedAt != nil && now.After(c.CreatedAt.Add(time.Duration(c.Value)*time.Hour)) {
+			return fmt.Sprintf("referral code %q is expired", c.ReferralCode)
+		}
+	case ReferralTypeQuantity:
+		if redemptions >= c.Value {
+			return fmt.Sprintf("referral code %q is at capacity", c.ReferralCode)
+		}
+	}
+
+	return ""
+}
+
+// ValidateReferralCode gives the referral code when it can still be redeemed,
+// otherwise it gives why not. The code is locked until the transaction ends so
+// concurrent sign ups can't go over its capacity.
+func ValidateReferralCode(q Q, referralCode string, now time.Time) (*ReferralCode, string, error) {
+	referralCode = NormalizeCode(referralCode)
+	inviteReferralCode, err := GetReferralCodeByCodeForUpdate(q, referralCode)
+	if err == sql.ErrNoRows {
+		if ReferralCodeFormat.Mistyped(referralCode) {
+			return nil, fmt.Sprintf("referral code %q has a typo", referralCode), nil
+		}
+		return nil, fmt.Sprintf("unknown referral code used: %s", referralCode), nil
+	} else if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to get referral code: %s", referralCode)
+	}
+
+	count, err := GetTotalReferralCodeRedemptions(q, inviteReferralCode.ReferralCode)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to get generated referral code ref_code: %s", generatedRefCodes[0])
+		return nil, "", errors.Wrap(err, "unable to get total redemptions for code")
+	}
+	if reason := inviteReferralCode.UnredeemableReason(count, now); reason != "" {
+		return nil, reason, nil
 	}
 
-	return referralCode, nil
+	return inviteReferralCode, "", nil
 }
 
-func ValidateReferralCode(q Q, referralCode string) (*ReferralCode, bool, error) {
-	inviteReferralCode, err := GetReferralCodeByCode(q, referralCode)
+// RedeemReferralCode records a user redeeming a referral code and grants the
+// active referral rewards to the owner of the code and to the user, along with
+// the module the code unlocks, if any. Campaign codes have no owner to reward.
+func RedeemReferralCode(q Q, referralCode *ReferralCode, userID int) ([]*ReferralReward, error) {
+	redemptionID, err := CreateReferralRedemption(q, userID, referralCode.ID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, false, fmt.Errorf("unknown referral code used: %s", referralCode)
-		} else if err != sql.ErrNoRows {
-			return nil, false, errors.Wrapf(
-				err, "unable to get referral code: %s", referralCode,
-			)
+		return nil, errors.Wrap(err, "creating referral redemption")
+	}
+	rules, err := GetActiveReferralRewardRules(q)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting referral reward rules")
+	}
+
+	rewards := []*ReferralReward{}
+	for _, rule := range rules {
+		reward := &ReferralReward{
+			RedemptionID: redemptionID,
+			RuleID:       &rule.ID,
+			UserID:       referralCode.UserID,
+			Recipient:    rule.Recipient,
+			RewardType:   rule.RewardType,
+			ModuleID:     rule.ModuleID,
+		}
+		if rule.Recipient == ReferralRewardRecipient_Referee {
+			reward.UserID = userID
+		}
+		if reward.UserID <= 0 {
+			continue
+		}
+		rewards = append(rewards, reward)
+	}
+	if referralCode.ModuleID != nil {
+		rewards = append(rewards, &ReferralReward{
+			RedemptionID: redemptionID,
+			UserID:       userID,
+			Recipient:    ReferralRewardRecipient_Referee,
+			RewardType:   ReferralRewardType_ModuleUnlock,
+			ModuleID:     referralCode.ModuleID,
+		})
+	}
+
+	sourceKey := fmt.Sprintf("referral_redemption:%d", userID)
+	for _, reward := range rewards {
+		if err := grantReferralReward(q, reward, sourceKey); err != nil {
+			return nil, err
 		}
 	}
 
-	switch inviteReferralCode.ReferralType {
-	case ReferralTypeHours:
-		if time.Now().After(inviteReferralCode.CreatedAt.Add(time.Duration(inviteReferralCode.Value) * time.Hour)) {
-			// expired
-			return inviteReferralCode, false, fmt.Errorf("referral code %q is expired", referralCode)
+	return rewards, nil
+}
+
+func grantReferralReward(q Q, reward *ReferralReward, sourceKey string) error {
+	switch reward.RewardType {
+	case ReferralRewardType_XP:
+		xpEventType := XPEventType_ReferralRedeemed
+		if reward.Recipient == ReferralRewardRecipient_Referee {
+			xpEventType = XPEventType_ReferralSignUp
 		}
-	case ReferralTypeQuantity:
-		// check the total number of times this has been used
-		count, err := GetTotalReferralCodeRedemptions(q, inviteReferralCode.ReferralCode)
+		entry, err := RecordXPEvent(q, reward.UserID, xpEventType, sourceKey, 0)
 		if err != nil {
-			return nil, false, errors.Wrap(err, "unable to get total redemptions for code")
+			return errors.Wrap(err, "recording referral xp")
 		}
-		if count >= inviteReferralCode.Value {
-			// reached capacity
-			return inviteReferralCode, false, fmt.Errorf("referral code %q is at capacity", referralCode)
+		if entry != nil {
+			reward.XP = entry.XP
 		}
+	case ReferralRewardType_ModuleUnlock:
+		if err := UnlockModuleForUser(q, reward.UserID, *reward.ModuleID, sourceKey); err != nil {
+			return errors.Wrapf(err, "unlocking module %d", *reward.ModuleID)
+		}
+	}
+
+	if err := CreateReferralReward(q, reward); err != nil {
+		return errors.Wrapf(err, "creating %s referral reward", reward.RewardType)
 	}
 
-	return inviteReferralCode, true, nil
+	return nil
 }
 
-func GenerateAndWriteWaitlistCode(q Q, email string) error {
-	vcPrefix := vcgen.New(
-		&vcgen.Generator{
-			Count: 1, Pattern: "######", Prefix: "WAIT-",
-		},
-	)
-	res, err := vcPrefix.Run()
+// applyReferralCode redeems the referral code a user signed up with, if any.
+// It gives why the code was turned down when it can't be redeemed.
+func applyReferralCode(q Q, code string, userID int) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	referralCode, reason, err := ValidateReferralCode(q, code, time.Now())
+	if err != nil || reason != "" {
+		return reason, err
+	}
+	if _, err := RedeemReferralCode(q, referralCode, userID); err != nil {
+		return "", errors.Wrap(err, "redeeming referral code")
+	}
+
+	return "", nil
+}
+
+// HandleGetReferrals gives the referral code of the user, who signed up with
+// it and the rewards the user earned through referrals.
+func (e *External) HandleGetReferrals(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	referralCode, err := GetReferralCodeByUserID(e.dao.ReadDB, userID)
+	if err != nil && err != sql.ErrNoRows {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral code"))
+		return
+	}
+	redemptions, err := GetReferralRedemptionsByReferrer(e.dao.ReadDB, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral redemptions"))
+		return
+	}
+	rewards, err := GetReferralRewardsByUserID(e.dao.ReadDB, userID)
 	if err != nil {
-		return fmt.Errorf("unable to generate ref code")
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral rewards"))
+		return
+	}
+
+	res := &UserReferrals{
+		ReferralCode: referralCode,
+		Redemptions:  redemptions,
+		Rewards:      rewards,
+	}
+	if res.Redemptions == nil {
+		res.Redemptions = []*ReferralRedemption{}
 	}
-	if res == nil {
-		return fmt.Errorf("no ref codes returned from library")
+	if res.Rewards == nil {
+		res.Rewards = []*ReferralReward{}
 	}
-	generatedRefCodes := *res
-	if len(generatedRefCodes) != 1 {
-		return fmt.Errorf("invalid number of generated ref codes")
+	for _, v := range res.Rewards {
+		res.TotalXP += v.XP
 	}
-	ownerWaitlistCode := generatedRefCodes[0]
 
-	if err = UpdateWaitlistCode(
-		q, email, ownerWaitlistCode,
-	); err != nil {
+	e.returnJSON(w, res)
+}
+
+func GenerateAndWriteWaitlistCode(q Q, email string) error {
+	if _, err := GenerateUniqueCode(q, WaitlistCodeFormat, func(code string) error {
+		return UpdateWaitlistCode(q, email, code)
+	}); err != nil {
 		return errors.Wrap(err, "updating waitlist item")
 	}
 	return nil
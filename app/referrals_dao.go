@@ -1,30 +1,70 @@
 package external
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type ReferralType string
 
 const ReferralTypeHours ReferralType = "HOURS"
 const ReferralTypeQuantity ReferralType = "QUANTITY"
+const ReferralTypeUnlimited ReferralType = "UNLIMITED"
 
 type ReferralCode struct {
-	ID           int          `json:"id,omitempty"`
-	UserID       int          `json:"user_id,omitempty"`
-	ReferralCode string       `json:"referral_code,omitempty"`
-	ReferralType ReferralType `json:"referral_type,omitempty"`
-	Value        int          `json:"value,omitempty"`
-	IsActive     bool         `json:"is_active,omitempty"`
-	CreatedAt    *time.Time   `json:"created_at,omitempty"`
-	UpdatedAt    *time.Time   `json:"updated_at,omitempty"`
+	ID           int              `json:"id,omitempty"`
+	UserID       int              `json:"user_id,omitempty"`
+	ReferralCode string           `json:"referral_code,omitempty"`
+	ReferralType ReferralType     `json:"referral_type,omitempty"`
+	Value        int              `json:"value,omitempty"`
+	IsActive     bool             `json:"is_active,omitempty"`
+	Kind         ReferralCodeKind `json:"kind,omitempty"`
+	// campaign and vanity codes only
+	Name      *string    `json:"name,omitempty"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	ModuleID  *int       `json:"module_id,omitempty"`
+	CreatedBy *int       `json:"created_by,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
 
 type ReferralRedemption struct {
-	ID             int        `json:"id,omitempty"`
-	UserID         int        `json:"user_id,omitempty"`
-	ReferralCodeID int        `json:"referral_code_id,omitempty"`
-	ReferralUserID bool       `json:"referral_user_id,omitempty"`
-	CreatedAt      *time.Time `json:"created_at,omitempty"`
-	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+	ID                    int        `json:"id,omitempty"`
+	ReferralCodeID        int        `json:"referral_code_id,omitempty"`
+	ReferralUserID        int        `json:"referral_user_id,omitempty"`
+	ReferralUserFirstName string     `json:"referral_user_first_name,omitempty"`
+	CreatedAt             *time.Time `json:"created_at,omitempty"`
+}
+
+// ReferralRewardRule is a benefit granted on every referral code redemption.
+type ReferralRewardRule struct {
+	ID         int                     `json:"id,omitempty"`
+	Recipient  ReferralRewardRecipient `json:"recipient,omitempty"`
+	RewardType ReferralRewardType      `json:"reward_type,omitempty"`
+	ModuleID   *int                    `json:"module_id,omitempty"`
+	IsActive   bool                    `json:"is_active,omitempty"`
+}
+
+// ReferralReward is a benefit a user was granted for a redemption, the ledger
+// of referral rewards.
+type ReferralReward struct {
+	ID           int                     `json:"id,omitempty"`
+	RedemptionID int                     `json:"redemption_id,omitempty"`
+	RuleID       *int                    `json:"rule_id,omitempty"`
+	UserID       int                     `json:"user_id,omitempty"`
+	Recipient    ReferralRewardRecipient `json:"recipient,omitempty"`
+	RewardType   ReferralRewardType      `json:"reward_type,omitempty"`
+	XP           int                     `json:"xp"`
+	ModuleID     *int                    `json:"module_id,omitempty"`
+	CreatedAt    *time.Time              `json:"created_at,omitempty"`
+}
+
+type UserReferrals struct {
+	ReferralCode *ReferralCode         `json:"referral_code,omitempty"`
+	Redemptions  []*ReferralRedemption `json:"redemptions"`
+	Rewards      []*ReferralReward     `json:"rewards"`
+	TotalXP      int                   `json:"total_xp"`
 }
 
 type Waitlist struct {
@@ -38,23 +78,41 @@ type Waitlist struct {
 	UpdatedAt            *time.Time `json:"updated_at,omitempty"`
 }
 
-func GetReferralCodeByUserID(q Q, userID int) (*ReferralCode, error) {
-	var i ReferralCode
-	if err := q.Get(
-		&i,
+func selectFromReferralCodesWhere(where string) string {
+	return fmt.Sprintf(
 		`
 			SELECT
 				id,
-				user_id,
+				COALESCE(user_id, 0) user_id,
 				referral_code,
 				referral_type,
 				value,
 				is_active,
+				kind,
+				name,
+				starts_at,
+				ends_at,
+				module_id,
+				created_by,
 				created_at,
 				updated_at
 			FROM ggwp.referral_codes
-			WHERE user_id = $1
+			%s
 		`,
+		where,
+	)
+}
+
+func GetReferralCodeByUserID(q Q, userID int) (*ReferralCode, error) {
+	var i ReferralCode
+	if err := q.Get(
+		&i,
+		selectFromReferralCodesWhere(
+			`
+				WHERE user_id = $1
+					AND kind = 'USER'
+			`,
+		),
 		userID,
 	); err != nil {
 		return nil, err
@@ -67,19 +125,31 @@ func GetReferralCodeByCode(q Q, code string) (*ReferralCode, error) {
 	var i ReferralCode
 	if err := q.Get(
 		&i,
-		`
-			SELECT
-				id,
-				user_id,
-				referral_code,
-				referral_type,
-				value,
-				is_active,
-				created_at,
-				updated_at
-			FROM ggwp.referral_codes
-			WHERE referral_code = $1
-		`,
+		selectFromReferralCodesWhere(
+			`
+				WHERE referral_code = $1
+			`,
+		),
+		code,
+	); err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// GetReferralCodeByCodeForUpdate gives a referral code, locked until the end of
+// the transaction.
+func GetReferralCodeByCodeForUpdate(q Q, code string) (*ReferralCode, error) {
+	var i ReferralCode
+	if err := q.Get(
+		&i,
+		selectFromReferralCodesWhere(
+			`
+				WHERE referral_code = $1
+				FOR UPDATE
+			`,
+		),
 		code,
 	); err != nil {
 		return nil, err
@@ -109,8 +179,10 @@ func CreateReferralCode(q Q, userID int, refCode string) error {
 	return nil
 }
 
-func CreateReferralRedemption(q Q, userID, code int) error {
-	if _, err := q.Exec(
+func CreateReferralRedemption(q Q, userID, code int) (int, error) {
+	var id int
+	if err := q.Get(
+		&id,
 		`
 			INSERT INTO ggwp.referral_redemptions
 			(
@@ -120,9 +192,150 @@ func CreateReferralRedemption(q Q, userID, code int) error {
 			(
 				$1, $2
 			)
+			RETURNING id
 		`,
 		code,
 		userID,
+	); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetReferralRedemptionsByReferrer gives who signed up with the referral code
+// of a user, newest first.
+func GetReferralRedemptionsByReferrer(q Q, userID int) ([]*ReferralRedemption, error) {
+	var r []*ReferralRedemption
+	if err := q.Select(
+		&r,
+		`
+			SELECT
+				r.id,
+				r.referral_code_id,
+				r.referral_user_id,
+				COALESCE(p.first_name, '') referral_user_first_name,
+				r.created_at
+			FROM
+				ggwp.referral_redemptions r
+			JOIN
+				ggwp.referral_codes c
+				ON c.id = r.referral_code_id
+			LEFT JOIN
+				ggwp.players p
+				ON p.user_id = r.referral_user_id
+			WHERE
+				c.user_id = $1
+			ORDER BY
+				r.created_at DESC, r.id DESC
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func GetActiveReferralRewardRules(q Q) ([]*ReferralRewardRule, error) {
+	var r []*ReferralRewardRule
+	if err := q.Select(
+		&r,
+		`
+			SELECT
+				id,
+				recipient,
+				reward_type,
+				module_id,
+				is_active
+			FROM
+				ggwp.referral_reward_rules
+			WHERE
+				is_active
+			ORDER BY
+				id
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func CreateReferralReward(q Q, r *ReferralReward) error {
+	return q.Get(
+		r,
+		`
+			INSERT INTO ggwp.referral_rewards
+			(
+				redemption_id, rule_id, user_id, recipient, reward_type, xp, module_id, created_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, $5, $6, $7, NOW()
+			)
+			RETURNING id, redemption_id, rule_id, user_id, recipient, reward_type, xp, module_id, created_at
+		`,
+		r.RedemptionID,
+		r.RuleID,
+		r.UserID,
+		r.Recipient,
+		r.RewardType,
+		r.XP,
+		r.ModuleID,
+	)
+}
+
+// GetReferralRewardsByUserID gives the referral rewards a user earned, newest
+// first.
+func GetReferralRewardsByUserID(q Q, userID int) ([]*ReferralReward, error) {
+	var r []*ReferralReward
+	if err := q.Select(
+		&r,
+		`
+			SELECT
+				id,
+				redemption_id,
+				rule_id,
+				user_id,
+				recipient,
+				reward_type,
+				xp,
+				module_id,
+				created_at
+			FROM
+				ggwp.referral_rewards
+			WHERE
+				user_id = $1
+			ORDER BY
+				created_at DESC, id DESC
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// UnlockModuleForUser gives a user access to a module, unlocking a module
+// twice keeps the first unlock.
+func UnlockModuleForUser(q Q, userID, moduleID int, sourceKey string) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.user_module_unlocks
+			(
+				user_id, module_id, source_key, created_at
+			)
+			VALUES
+			(
+				$1, $2, $3, NOW()
+			)
+			ON CONFLICT (user_id, module_id) DO NOTHING
+		`,
+		userID,
+		moduleID,
+		sourceKey,
 	); err != nil {
 		return err
 	}
@@ -142,6 +355,7 @@ func GetUsersIDsMissingReferralCodes(q Q) ([]int, error) {
       LEFT JOIN
         ggwp.referral_codes r
         ON r.user_id = u.id
+        AND r.kind = 'USER'
       WHERE r.id IS NULL
 		`,
 	); err != nil {
@@ -0,0 +1,132 @@
+package external_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestReferralCodeUnredeemableReason(t *testing.T) {
+	h := TestHelper{t}
+
+	created := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	hours := &external.ReferralCode{
+		ReferralCode: "WELC-000001",
+		ReferralType: external.ReferralTypeHours,
+		Value:        24,
+		IsActive:     true,
+		CreatedAt:    &created,
+	}
+	h.ExpectDeepEq(hours.UnredeemableReason(100, created.Add(23*time.Hour)), "")
+	h.ExpectDeepEq(hours.UnredeemableReason(0, created.Add(25*time.Hour)), `referral code "WELC-000001" is expired`)
+
+	quantity := &external.ReferralCode{
+		ReferralCode: "WELC-000002",
+		ReferralType: external.ReferralTypeQuantity,
+		Value:        2,
+		IsActive:     true,
+		CreatedAt:    &created,
+	}
+	h.ExpectDeepEq(quantity.UnredeemableReason(1, created), "")
+	h.ExpectDeepEq(quantity.UnredeemableReason(2, created), `referral code "WELC-000002" is at capacity`)
+
+	starts, ends := created.AddDate(0, 0, 1), created.AddDate(0, 1, 0)
+	campaign := &external.ReferralCode{
+		ReferralCode: "CAMP-SUMMER",
+		ReferralType: external.ReferralTypeUnlimited,
+		IsActive:     true,
+		StartsAt:     &starts,
+		EndsAt:       &ends,
+	}
+	h.ExpectDeepEq(campaign.UnredeemableReason(0, created), `referral code "CAMP-SUMMER" has not started yet`)
+	h.ExpectDeepEq(campaign.UnredeemableReason(1000, starts), "")
+	h.ExpectDeepEq(campaign.UnredeemableReason(0, ends), `referral code "CAMP-SUMMER" has ended`)
+
+	quantity.IsActive = false
+	h.ExpectDeepEq(quantity.UnredeemableReason(0, created), `referral code "WELC-000002" is not active`)
+}
+
+func TestSignUpWithReferralCode(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	referrer := f.GetAuthToken("referrer@ggwpacademy.com")
+	f.ExpectNoError(external.CreateReferralCode(f.DAO.DB, referrer.UserID, "WELC-123456"))
+
+	signUp := func(email, code string) int {
+		rr := f.UnAuthedRequest(
+			http.MethodPost,
+			"/user",
+			`{"email": "`+email+`", "password": "test123", "first_name": "Test", "last_name": "User", "referral_code": "`+code+`"}`,
+		)
+		return rr.Code
+	}
+
+	f.ExpectDeepEq(signUp("unknown@ggwpacademy.com", "WELC-000000"), http.StatusBadRequest)
+	f.ExpectRowCountWhere("ggwp.users", "email = 'unknown@ggwpacademy.com'", 0)
+
+	f.ExpectDeepEq(signUp("first@ggwpacademy.com", "WELC-123456"), http.StatusOK)
+	// codes are looked up however they were typed
+	f.ExpectDeepEq(signUp("second@ggwpacademy.com", " welc-123456 "), http.StatusOK)
+	f.ExpectRowCount("ggwp.referral_redemptions", 2)
+
+	// the code is good for two sign ups
+	f.ExpectDeepEq(signUp("third@ggwpacademy.com", "WELC-123456"), http.StatusBadRequest)
+	f.ExpectRowCountWhere("ggwp.users", "email = 'third@ggwpacademy.com'", 0)
+
+	// both sides are rewarded
+	f.ExpectRowCountWhere("ggwp.xp_ledger", "event_type = 'REFERRAL_REDEEMED'", 2)
+	f.ExpectRowCountWhere("ggwp.xp_ledger", "event_type = 'REFERRAL_SIGN_UP'", 2)
+	f.ExpectRowCountWhere("ggwp.referral_rewards", "recipient = 'REFEREE'", 2)
+
+	rr := f.AuthedRequest(http.MethodGet, "/api/v0.1/user/self/referrals", "", referrer.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	referrals := &external.UserReferrals{}
+	f.Bind(rr, referrals)
+	f.ExpectDeepEq(referrals.ReferralCode.ReferralCode, "WELC-123456")
+	f.ExpectDeepEq(len(referrals.Redemptions), 2)
+	f.ExpectDeepEq(referrals.Redemptions[0].ReferralUserFirstName, "Test")
+	f.ExpectDeepEq(len(referrals.Rewards), 2)
+	f.ExpectDeepEq(referrals.Rewards[0].Recipient, external.ReferralRewardRecipient_Referrer)
+	f.ExpectDeepEq(referrals.TotalXP, 200)
+}
+
+func TestSocialSignUpWithReferralCode(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	referrer := f.GetAuthToken("social-referrer@ggwpacademy.com")
+	f.ExpectNoError(external.CreateReferralCode(f.DAO.DB, referrer.UserID, "WELC-654321"))
+
+	signUp := func(email, body string) int {
+		f.Twitter.VerifyCredentialsResponse = &external.TwitterUser{
+			FirstName:    "Test",
+			LastName:     "User",
+			EmailAddress: email,
+		}
+		rr := f.UnAuthedRequest(
+			http.MethodPost,
+			"/user/social/signup?access_token=token&access_secret=secret&social_network="+string(external.SocialNetwork_Twitter),
+			body,
+		)
+		return rr.Code
+	}
+
+	// the code comes in the body, like for signing up with a password
+	f.ExpectDeepEq(signUp("unknown-social@ggwpacademy.com", `{"referral_code": "WELC-000000"}`), http.StatusBadRequest)
+	f.ExpectRowCountWhere("ggwp.users", "email = 'unknown-social@ggwpacademy.com'", 0)
+	f.ExpectDeepEq(signUp("social@ggwpacademy.com", `{"referral_code": "WELC-654321"}`), http.StatusOK)
+	f.ExpectRowCountWithJoinWhere(
+		"ggwp.referral_redemptions r",
+		"JOIN ggwp.users u ON u.id = r.referral_user_id",
+		"u.email = 'social@ggwpacademy.com'",
+		1,
+	)
+
+	// and is optional
+	f.ExpectDeepEq(signUp("no-code-social@ggwpacademy.com", ""), http.StatusOK)
+	f.ExpectDeepEq(signUp("bad-body-social@ggwpacademy.com", "not json"), http.StatusBadRequest)
+	f.ExpectRowCount("ggwp.referral_redemptions", 1)
+}
@@ -76,9 +76,27 @@ func Router(
 	userAuthed.
 		HandleFunc("/self/learning_progress", e.HandleGetLearningProgress).
 		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/file_progress", e.HandleGetModuleFileProgress).
+		Methods(http.MethodGet)
 	userAuthed.
 		HandleFunc("/self/quizzes/gradings", e.HandleGetQuizGradings).
 		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/achievements", e.HandleGetAchievements).
+		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/streak", e.HandleGetStreak).
+		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/leaderboards", e.HandleUpdateLeaderboardPreferences).
+		Methods(http.MethodPut)
+	userAuthed.
+		HandleFunc("/self/notifications", e.HandleGetNotificationSettings).
+		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/notifications", e.HandleUpdateNotificationSettings).
+		Methods(http.MethodPut)
 	userAuthed.
 		HandleFunc("/self/password", e.HandlePasswordChange).
 		Methods(http.MethodPut)
@@ -88,15 +106,95 @@ func Router(
 	userAuthed.
 		HandleFunc("/self/goals", e.HandleGetGoals).
 		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/goals/archived", e.HandleGetArchivedGoals).
+		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/goals/reminders", e.HandleGetGoalReminderSettings).
+		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/goals/reminders", e.HandleUpdateGoalReminderSettings).
+		Methods(http.MethodPut)
+	userAuthed.
+		HandleFunc("/self/goals/{id:[0-9]+}", e.HandleGoalUpdate).
+		Methods(http.MethodPut)
+	userAuthed.
+		HandleFunc("/self/goals/{id:[0-9]+}", e.HandleGoalDelete).
+		Methods(http.MethodDelete)
 	userAuthed.
 		HandleFunc("/self/goals/{id:[0-9]+}/complete", e.HandleGoalComplete).
 		Methods(http.MethodPut)
 	userAuthed.
 		HandleFunc("/self/goals/{id:[0-9]+}/incomplete", e.HandleGoalIncomplete).
 		Methods(http.MethodPut)
+	userAuthed.
+		HandleFunc("/self/goals/{id:[0-9]+}/checkins", e.HandleGoalCheckIn).
+		Methods(http.MethodPost)
+	userAuthed.
+		HandleFunc("/self/goals/{id:[0-9]+}/history", e.HandleGetGoalHistory).
+		Methods(http.MethodGet)
 	userAuthed.
 		HandleFunc("/goals/templates", e.HandleGetAllUserGoalTemplates).
 		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/calendar", e.HandleGetCalendarFeed).
+		Methods(http.MethodGet)
+	userAuthed.
+		HandleFunc("/self/calendar/token", e.HandleRegenerateCalendarFeedToken).
+		Methods(http.MethodPost)
+	userAuthed.
+		HandleFunc("/self/referrals", e.HandleGetReferrals).
+		Methods(http.MethodGet)
+
+	// Calendar
+	// Unauthed /calendar, the token in the url is the credential
+	calendarUnAuthed := a.PathPrefix("/calendar").Subrouter()
+	calendarUnAuthed.
+		HandleFunc("/{token:[0-9a-f]+}.ics", e.HandleGetCalendar).
+		Methods(http.MethodGet)
+
+	// Goals
+	// Unauthed /goals
+	goalsUnAuthed := a.PathPrefix("/goals").Subrouter()
+
+	// Admin
+	goalsAdmin := goalsUnAuthed.NewRoute().Subrouter()
+	goalsAdmin.Use(mux.MiddlewareFunc(e.JWTAuthentication), mux.MiddlewareFunc(e.AdminAuthentication))
+	goalsAdmin.
+		HandleFunc("/templates", e.HandleGetGoalTemplates).
+		Methods(http.MethodGet)
+	goalsAdmin.
+		HandleFunc("/templates", e.HandleCreateGoalTemplate).
+		Methods(http.MethodPost)
+	goalsAdmin.
+		HandleFunc("/templates/{id:[0-9]+}", e.HandleUpdateGoalTemplate).
+		Methods(http.MethodPut)
+	goalsAdmin.
+		HandleFunc("/templates/{id:[0-9]+}", e.HandleDeleteGoalTemplate).
+		Methods(http.MethodDelete)
+
+	// Referrals
+	// Admin /referrals
+	referralsAdmin := a.PathPrefix("/referrals").Subrouter()
+	referralsAdmin.Use(mux.MiddlewareFunc(e.JWTAuthentication), mux.MiddlewareFunc(e.AdminAuthentication))
+	referralsAdmin.
+		HandleFunc("/campaigns", e.HandleGetReferralCampaigns).
+		Methods(http.MethodGet)
+	referralsAdmin.
+		HandleFunc("/campaigns", e.HandleCreateReferralCampaign).
+		Methods(http.MethodPost)
+	referralsAdmin.
+		HandleFunc("/campaigns/{id:[0-9]+}", e.HandleGetReferralCampaign).
+		Methods(http.MethodGet)
+	referralsAdmin.
+		HandleFunc("/campaigns/{id:[0-9]+}", e.HandleUpdateReferralCampaign).
+		Methods(http.MethodPut)
+	referralsAdmin.
+		HandleFunc("/campaigns/{id:[0-9]+}", e.HandleDeleteReferralCampaign).
+		Methods(http.MethodDelete)
+	referralsAdmin.
+		HandleFunc("/campaigns/{id:[0-9]+}/funnel", e.HandleGetReferralCampaignFunnel).
+		Methods(http.MethodGet)
 
 	// Files
 	filesUnAuthed := a.PathPrefix("/files").Subrouter()
@@ -125,9 +223,75 @@ func Router(
 	modulesAuthed.
 		HandleFunc("/record_progress", e.HandleRecordModuleProgress).
 		Methods(http.MethodPost)
+	modulesAuthed.
+		HandleFunc("/progress_events", e.HandleRecordProgressEvents).
+		Methods(http.MethodPost)
 	modulesAuthed.
 		HandleFunc("/grade", e.HandleGradeQuiz).
 		Methods(http.MethodPost)
+	// Admin
+	modulesAdmin := modulesAuthed.NewRoute().Subrouter()
+	modulesAdmin.Use(mux.MiddlewareFunc(e.AdminAuthentication))
+	modulesAdmin.
+		HandleFunc("/import", e.HandleImportModulePackage).
+		Methods(http.MethodPost)
+
+	// Sync
+	syncAuthed := a.PathPrefix("/sync").Subrouter()
+	syncAuthed.Use(mux.MiddlewareFunc(e.JWTAuthentication))
+	syncAuthed.
+		HandleFunc("", e.HandleSync).
+		Methods(http.MethodPost)
+
+	// xAPI
+	xapiAuthed := a.PathPrefix("/xapi").Subrouter()
+	xapiAuthed.Use(mux.MiddlewareFunc(e.JWTAuthentication))
+	xapiAuthed.
+		HandleFunc("/statements", e.HandleGetXAPIStatements).
+		Methods(http.MethodGet)
+
+	// Leaderboards
+	leaderboards := a.PathPrefix("/leaderboards").Subrouter()
+	leaderboards.Use(mux.MiddlewareFunc(e.JWTAuthentication))
+	leaderboards.
+		HandleFunc("", e.HandleGetLeaderboard).
+		Methods(http.MethodGet)
+
+	// Teams
+	// Admin /teams
+	teamsAdmin := a.PathPrefix("/teams").Subrouter()
+	teamsAdmin.Use(mux.MiddlewareFunc(e.JWTAuthentication), mux.MiddlewareFunc(e.AdminAuthentication))
+	teamsAdmin.
+		HandleFunc("", e.HandleGetTeams).
+		Methods(http.MethodGet)
+	teamsAdmin.
+		HandleFunc("", e.HandleCreateTeam).
+		Methods(http.MethodPost)
+	teamsAdmin.
+		HandleFunc("/{id:[0-9]+}", e.HandleUpdateTeam).
+		Methods(http.MethodPut)
+	teamsAdmin.
+		HandleFunc("/{id:[0-9]+}", e.HandleDeleteTeam).
+		Methods(http.MethodDelete)
+	teamsAdmin.
+		HandleFunc("/{id:[0-9]+}/members", e.HandleGetTeamMembers).
+		Methods(http.MethodGet)
+	teamsAdmin.
+		HandleFunc("/{id:[0-9]+}/members/{user_id:[0-9]+}", e.HandleAddTeamMember).
+		Methods(http.MethodPut)
+	teamsAdmin.
+		HandleFunc("/{id:[0-9]+}/members/{user_id:[0-9]+}", e.HandleRemoveTeamMember).
+		Methods(http.MethodDelete)
+
+	// Gamification
+	gamificationAdmin := a.PathPrefix("/gamification").Subrouter()
+	gamificationAdmin.Use(mux.MiddlewareFunc(e.JWTAuthentication), mux.MiddlewareFunc(e.AdminAuthentication))
+	gamificationAdmin.
+		HandleFunc("/config", e.HandleGetGamificationConfig).
+		Methods(http.MethodGet)
+	gamificationAdmin.
+		HandleFunc("/config", e.HandleUpdateGamificationConfig).
+		Methods(http.MethodPut)
 
 	// Waitlist
 	// Unauthed /waitlist
@@ -135,18 +299,64 @@ func Router(
 	waitlistUnAuthed.
 		HandleFunc("", e.HandleGetWaitlist).
 		Methods(http.MethodGet)
+	waitlistUnAuthed.
+		HandleFunc("/status", e.HandleGetWaitlistStatus).
+		Methods(http.MethodGet)
+	waitlistUnAuthed.
+		HandleFunc("/confirm", e.HandleConfirmWaitlist).
+		Methods(http.MethodGet)
 	waitlistUnAuthed.
 		HandleFunc("/user", e.HandleWaitlistUserAdd).
 		Methods(http.MethodPost)
 
+	// Admin
+	waitlistAdmin := waitlistUnAuthed.NewRoute().Subrouter()
+	waitlistAdmin.Use(mux.MiddlewareFunc(e.JWTAuthentication), mux.MiddlewareFunc(e.AdminAuthentication))
+	waitlistAdmin.
+		HandleFunc("/invitations", e.HandleGetWaitlistInviteWaves).
+		Methods(http.MethodGet)
+	waitlistAdmin.
+		HandleFunc("/invitations", e.HandleCreateWaitlistInviteWave).
+		Methods(http.MethodPost)
+	waitlistAdmin.
+		HandleFunc("/entries", e.HandleGetWaitlistEntries).
+		Methods(http.MethodGet)
+	waitlistAdmin.
+		HandleFunc("/entries/export", e.HandleExportWaitlistEntries).
+		Methods(http.MethodGet)
+	waitlistAdmin.
+		HandleFunc("/entries/{id:[0-9]+}", e.HandleDeleteWaitlistEntry).
+		Methods(http.MethodDelete)
+	waitlistAdmin.
+		HandleFunc("/entries/{id:[0-9]+}/referrals", e.HandleGetWaitlistReferralTree).
+		Methods(http.MethodGet)
+
 	// Mailer
 	emailsUnAuthed := a.PathPrefix("/email").Subrouter()
+	emailsUnAuthed.
+		HandleFunc("/webhooks/mailgun", e.HandleMailgunWebhook).
+		Methods(http.MethodPost)
+	emailsUnAuthed.
+		HandleFunc("/unsubscribe", e.HandleEmailUnsubscribeConfirm).
+		Methods(http.MethodGet)
+	emailsUnAuthed.
+		HandleFunc("/unsubscribe", e.HandleEmailUnsubscribe).
+		Methods(http.MethodPost)
 	emailsAuthed := emailsUnAuthed.NewRoute().Subrouter()
 	emailsAuthed.Use(mux.MiddlewareFunc(e.JWTAuthentication), mux.MiddlewareFunc(e.AdminAuthentication))
 	emailsAuthed.
 		HandleFunc("/preview", e.HandlePreviewEmail).
 		Methods(http.MethodGet).
 		Queries("type", "{type}")
+	emailsAuthed.
+		HandleFunc("/failed", e.HandleGetFailedEmails).
+		Methods(http.MethodGet)
+	emailsAuthed.
+		HandleFunc("/failed/{id:[0-9]+}/retry", e.HandleRetryFailedEmail).
+		Methods(http.MethodPost)
+	emailsAuthed.
+		HandleFunc("/failed/{id:[0-9]+}", e.HandleDiscardFailedEmail).
+		Methods(http.MethodDelete)
 
 	// Leads
 	leadUnAuthed := a.PathPrefix("/leads").Subrouter()
@@ -160,6 +370,12 @@ func Router(
 	leadUnAuthedModules.
 		HandleFunc("/learning_progress", e.HandleGetLeadLearningProgress).
 		Methods(http.MethodGet)
+	leadUnAuthedModules.
+		HandleFunc("/progress_events", e.HandleRecordLeadProgressEvents).
+		Methods(http.MethodPost)
+	leadUnAuthedModules.
+		HandleFunc("/file_progress", e.HandleGetLeadModuleFileProgress).
+		Methods(http.MethodGet)
 
 	return cors.New(cors.Options{
 		AllowedOrigins: allowedOrigins,
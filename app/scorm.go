@@ -0,0 +1,730 @@
+package external
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type PackageType string
+
+const (
+	PackageType_SCORM12   PackageType = "SCORM_1_2"
+	PackageType_SCORM2004 PackageType = "SCORM_2004"
+	PackageType_CMI5      PackageType = "CMI5"
+)
+
+var (
+	// largest package accepted by the import
+	packageMaxSize int64 = 512 << 20
+	// largest single asset read out of a package
+	packageMaxAssetSize int64 = 256 << 20
+)
+
+// PackageItem is a launchable item of a package, it becomes a module file.
+type PackageItem struct {
+	Identifier string   `json:"identifier,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Ranking    int      `json:"ranking,omitempty"`
+	LaunchPath string   `json:"launch_path,omitempty"`
+	AssetPaths []string `json:"asset_paths,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// PackageImport is what a content package holds and, once imported, the
+// module created from it.
+type PackageImport struct {
+	Type             PackageType    `json:"type,omitempty"`
+	Name             string         `json:"name,omitempty"`
+	Description      string         `json:"description,omitempty"`
+	LearningOutcomes []string       `json:"learning_outcomes,omitempty"`
+	Items            []*PackageItem `json:"items,omitempty"`
+	Errors           []string       `json:"errors,omitempty"`
+	Module           *Module        `json:"module,omitempty"`
+}
+
+// Valid is true when neither the package nor any of its items has errors.
+func (p *PackageImport) Valid() bool {
+	if len(p.Errors) > 0 {
+		return false
+	}
+	for _, i := range p.Items {
+		if len(i.Errors) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// xmlNode keeps a whole document in order, manifests nest items and blocks in
+// ways that are awkward to map onto structs. Names are matched without their
+// namespace.
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",chardata"`
+	Nodes   []*xmlNode `xml:",any"`
+}
+
+func (n *xmlNode) is(name string) bool {
+	return n != nil && strings.EqualFold(n.XMLName.Local, name)
+}
+
+func (n *xmlNode) children(name string) []*xmlNode {
+	if n == nil {
+		return nil
+	}
+	c := []*xmlNode{}
+	for _, child := range n.Nodes {
+		if child.is(name) {
+			c = append(c, child)
+		}
+	}
+	return c
+}
+
+func (n *xmlNode) child(names ...string) *xmlNode {
+	for _, name := range names {
+		c := n.children(name)
+		if len(c) == 0 {
+			return nil
+		}
+		n = c[0]
+	}
+	return n
+}
+
+func (n *xmlNode) attr(name string) string {
+	if n == nil {
+		return ""
+	}
+	for _, a := range n.Attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// text gives the content of a node, for LOM and cmi5 language strings the
+// first non empty translation.
+func (n *xmlNode) text() string {
+	if n == nil {
+		return ""
+	}
+	for _, name := range []string{"langstring", "string"} {
+		for _, c := range n.children(name) {
+			if t := strings.TrimSpace(c.Content); t != "" {
+				return t
+			}
+		}
+	}
+	return strings.TrimSpace(n.Content)
+}
+
+func parseXMLNode(b []byte) (*xmlNode, error) {
+	n := &xmlNode{}
+	if err := xml.Unmarshal(b, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// packageFiles indexes the entries of a zip by their cleaned path.
+type packageFiles map[string]*zip.File
+
+func newPackageFiles(zr *zip.Reader) packageFiles {
+	files := packageFiles{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files[path.Clean(strings.TrimPrefix(f.Name, "/"))] = f
+	}
+	return files
+}
+
+func (p packageFiles) read(name string) ([]byte, error) {
+	f, ok := p[name]
+	if !ok {
+		return nil, fmt.Errorf("%s missing from package", name)
+	}
+	if int64(f.UncompressedSize64) > packageMaxAssetSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, packageMaxAssetSize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(io.LimitReader(rc, packageMaxAssetSize))
+}
+
+// resolve turns a href relative to the package root into a package path, it
+// is empty for hrefs pointing outside of the package.
+func (p packageFiles) resolve(href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.IsAbs() || u.Host != "" {
+		return ""
+	}
+	name := path.Clean(strings.TrimPrefix(u.Path, "/"))
+	if name == "." || strings.HasPrefix(name, "../") {
+		return ""
+	}
+	return name
+}
+
+// joinHref prefixes a relative href with an xml:base.
+func joinHref(base, href string) string {
+	if u, err := url.Parse(href); err == nil && (u.IsAbs() || u.Host != "") {
+		return href
+	}
+	return base + href
+}
+
+// ParsePackage reads a SCORM 1.2, SCORM 2004 or cmi5 zip package. Problems
+// with single items are reported on the items, the error is only set when the
+// package can not be read at all.
+func ParsePackage(zr *zip.Reader) (*PackageImport, error) {
+	files := newPackageFiles(zr)
+	if _, ok := files["imsmanifest.xml"]; ok {
+		return parseSCORMPackage(files)
+	}
+	if _, ok := files["cmi5.xml"]; ok {
+		return parseCMI5Package(files)
+	}
+
+	return nil, fmt.Errorf("package has neither an imsmanifest.xml nor a cmi5.xml at its root")
+}
+
+func parseSCORMPackage(files packageFiles) (*PackageImport, error) {
+	b, err := files.read("imsmanifest.xml")
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := parseXMLNode(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing imsmanifest.xml")
+	}
+	if !manifest.is("manifest") {
+		return nil, fmt.Errorf("imsmanifest.xml root is %q, expected manifest", manifest.XMLName.Local)
+	}
+
+	p := &PackageImport{}
+	metadata := manifest.child("metadata")
+	switch version := metadata.child("schemaversion").text(); {
+	case version == "1.2":
+		p.Type = PackageType_SCORM12
+	case strings.Contains(version, "2004") || strings.Contains(version, "1.3"):
+		p.Type = PackageType_SCORM2004
+	case version == "" && strings.Contains(manifest.XMLName.Space, "imscp_rootv1p1p2"):
+		p.Type = PackageType_SCORM12
+	case version == "" && strings.Contains(manifest.XMLName.Space, "imscp_v1p1"):
+		p.Type = PackageType_SCORM2004
+	default:
+		p.Errors = append(p.Errors, fmt.Sprintf("unsupported scorm schema version: %q", version))
+	}
+
+	// metadata is either inline or in a separate file
+	lom := metadata.child("lom")
+	if location := metadata.child("location").text(); lom == nil && location != "" {
+		if b, err := files.read(files.resolve(location)); err != nil {
+			p.Errors = append(p.Errors, fmt.Sprintf("reading metadata: %v", err))
+		} else if lom, err = parseXMLNode(b); err != nil {
+			p.Errors = append(p.Errors, fmt.Sprintf("parsing metadata %s: %v", location, err))
+		}
+	}
+	p.Description = lom.child("general", "description").text()
+	p.LearningOutcomes = lomLearningOutcomes(lom)
+
+	organizations := manifest.child("organizations")
+	var organization *xmlNode
+	for _, o := range organizations.children("organization") {
+		if organization == nil || o.attr("identifier") == organizations.attr("default") {
+			organization = o
+		}
+	}
+	if organization == nil {
+		p.Errors = append(p.Errors, "manifest has no organization")
+		return p, nil
+	}
+
+	p.Name = organization.child("title").text()
+	if p.Name == "" {
+		p.Name = lom.child("general", "title").text()
+	}
+	if p.Name == "" {
+		p.Errors = append(p.Errors, "package has no title")
+	}
+
+	resources := manifest.child("resources")
+	resourceByID := map[string]*xmlNode{}
+	for _, r := range resources.children("resource") {
+		resourceByID[r.attr("identifier")] = r
+	}
+
+	var walk func(items []*xmlNode)
+	walk = func(items []*xmlNode) {
+		for _, i := range items {
+			children := i.children("item")
+			if len(children) > 0 && i.attr("identifierref") == "" {
+				walk(children)
+				continue
+			}
+			item := &PackageItem{
+				Identifier: i.attr("identifier"),
+				Title:      i.child("title").text(),
+				Ranking:    len(p.Items) + 1,
+			}
+			p.Items = append(p.Items, item)
+			scormItem(item, i, resources, resourceByID, files)
+			walk(children)
+		}
+	}
+	walk(organization.children("item"))
+
+	if len(p.Items) == 0 {
+		p.Errors = append(p.Errors, "organization has no items")
+	}
+
+	return p, nil
+}
+
+func scormItem(
+	item *PackageItem,
+	node *xmlNode,
+	resources *xmlNode,
+	resourceByID map[string]*xmlNode,
+	files packageFiles,
+) {
+	if item.Title == "" {
+		item.Errors = append(item.Errors, "item has no title")
+	}
+
+	ref := node.attr("identifierref")
+	if ref == "" {
+		item.Errors = append(item.Errors, "item has no resource")
+		return
+	}
+	resource, ok := resourceByID[ref]
+	if !ok {
+		item.Errors = append(item.Errors, fmt.Sprintf("unknown resource %q", ref))
+		return
+	}
+
+	base := resources.attr("base") + resource.attr("base")
+	href := resource.attr("href")
+	if href == "" {
+		item.Errors = append(item.Errors, fmt.Sprintf("resource %q has no launch href", ref))
+		return
+	}
+	item.LaunchPath = files.resolve(joinHref(base, href))
+	if item.LaunchPath == "" {
+		item.Errors = append(item.Errors, fmt.Sprintf("launch href %q points outside of the package", href))
+		return
+	}
+	if _, ok := files[item.LaunchPath]; !ok {
+		item.Errors = append(item.Errors, fmt.Sprintf("launch file %q missing from package", item.LaunchPath))
+	}
+
+	// the launch file and everything the resource and its dependencies list
+	seen := map[string]bool{item.LaunchPath: true}
+	seenResources := map[string]bool{}
+	var collect func(r *xmlNode)
+	collect = func(r *xmlNode) {
+		id := r.attr("identifier")
+		if seenResources[id] {
+			return
+		}
+		seenResources[id] = true
+
+		base := resources.attr("base") + r.attr("base")
+		for _, f := range r.children("file") {
+			name := files.resolve(joinHref(base, f.attr("href")))
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if _, ok := files[name]; !ok {
+				item.Errors = append(item.Errors, fmt.Sprintf("file %q listed by resource %q missing from package", name, id))
+				continue
+			}
+			item.AssetPaths = append(item.AssetPaths, name)
+		}
+		for _, d := range r.children("dependency") {
+			dep, ok := resourceByID[d.attr("identifierref")]
+			if !ok {
+				item.Errors = append(item.Errors, fmt.Sprintf("unknown dependency %q of resource %q", d.attr("identifierref"), id))
+				continue
+			}
+			collect(dep)
+		}
+	}
+	collect(resource)
+}
+
+// lomLearningOutcomes takes the educational objectives of the LOM metadata,
+// falling back to its educational description.
+func lomLearningOutcomes(lom *xmlNode) []string {
+	outcomes := []string{}
+	for _, c := range lom.children("classification") {
+		purpose := strings.ToLower(c.child("purpose", "value").text())
+		if purpose != "educational objective" && purpose != "educationalobjective" {
+			continue
+		}
+		if d := c.child("description").text(); d != "" {
+			outcomes = append(outcomes, d)
+		}
+		for _, t := range c.children("taxonpath") {
+			for _, taxon := range t.children("taxon") {
+				if e := taxon.child("entry").text(); e != "" {
+					outcomes = append(outcomes, e)
+				}
+			}
+		}
+	}
+	if len(outcomes) == 0 {
+		if d := lom.child("educational", "description").text(); d != "" {
+			outcomes = append(outcomes, d)
+		}
+	}
+	return outcomes
+}
+
+func parseCMI5Package(files packageFiles) (*PackageImport, error) {
+	b, err := files.read("cmi5.xml")
+	if err != nil {
+		return nil, err
+	}
+	structure, err := parseXMLNode(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing cmi5.xml")
+	}
+	if !structure.is("courseStructure") {
+		return nil, fmt.Errorf("cmi5.xml root is %q, expected courseStructure", structure.XMLName.Local)
+	}
+
+	course := structure.child("course")
+	p := &PackageImport{
+		Type:             PackageType_CMI5,
+		Name:             course.child("title").text(),
+		Description:      course.child("description").text(),
+		LearningOutcomes: []string{},
+	}
+	if p.Name == "" {
+		p.Errors = append(p.Errors, "course has no title")
+	}
+	for _, o := range structure.child("objectives").children("objective") {
+		if t := o.child("title").text(); t != "" {
+			p.LearningOutcomes = append(p.LearningOutcomes, t)
+		} else if d := o.child("description").text(); d != "" {
+			p.LearningOutcomes = append(p.LearningOutcomes, d)
+		}
+	}
+
+	var walk func(n *xmlNode)
+	walk = func(n *xmlNode) {
+		for _, c := range n.Nodes {
+			switch {
+			case c.is("block"):
+				walk(c)
+			case c.is("au"):
+				item := &PackageItem{
+					Identifier: c.attr("id"),
+					Title:      c.child("title").text(),
+					Ranking:    len(p.Items) + 1,
+				}
+				p.Items = append(p.Items, item)
+				cmi5Item(item, c, files)
+			}
+		}
+	}
+	walk(structure)
+
+	if len(p.Items) == 0 {
+		p.Errors = append(p.Errors, "course has no assignable units")
+	}
+
+	return p, nil
+}
+
+func cmi5Item(item *PackageItem, au *xmlNode, files packageFiles) {
+	if item.Title == "" {
+		item.Errors = append(item.Errors, "assignable unit has no title")
+	}
+	href := au.child("url").text()
+	if href == "" {
+		item.Errors = append(item.Errors, "assignable unit has no url")
+		return
+	}
+	item.LaunchPath = files.resolve(href)
+	if item.LaunchPath == "" {
+		item.Errors = append(item.Errors, fmt.Sprintf("externally hosted assignable unit %q can not be imported", href))
+		return
+	}
+	if _, ok := files[item.LaunchPath]; !ok {
+		item.Errors = append(item.Errors, fmt.Sprintf("launch file %q missing from package", item.LaunchPath))
+		return
+	}
+
+	// cmi5 does not list assets, take everything next to the launch file
+	dir := path.Dir(item.LaunchPath)
+	for name := range files {
+		if name == item.LaunchPath || name == "cmi5.xml" {
+			continue
+		}
+		if dir == "." || strings.HasPrefix(name, dir+"/") {
+			item.AssetPaths = append(item.AssetPaths, name)
+		}
+	}
+	sort.Strings(item.AssetPaths)
+}
+
+// FileStore keeps the assets of imported packages.
+type FileStore interface {
+	UploadFileAt(key string, body []byte, contentType string) error
+	DeleteFile(key string) error
+}
+
+// ModulePackagePrefix gives the folder the assets of a module's package are
+// uploaded to, keeping their paths within the package so relative links
+// between them keep working.
+func ModulePackagePrefix(moduleID int) string {
+	return fmt.Sprintf("modules/%d/package", moduleID)
+}
+
+func (e *External) HandleImportModulePackage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, packageMaxSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing form"))
+		return
+	}
+	file, _, err := r.FormFile("package")
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "getting package from form"))
+		return
+	}
+	defer file.Close()
+
+	categoryID, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil || categoryID <= 0 {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid category_id: %q", r.FormValue("category_id")))
+		return
+	}
+	var ranking int
+	if v := r.FormValue("ranking"); v != "" {
+		if ranking, err = strconv.Atoi(v); err != nil || ranking <= 0 {
+			e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid ranking: %q", v))
+			return
+		}
+	}
+
+	// packages can be large, spool them to disk rather than holding them in memory
+	spool, err := ioutil.TempFile("", "package-*.zip")
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating package spool"))
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, file)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "reading package"))
+		return
+	}
+	zr, err := zip.NewReader(spool, size)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid zip"))
+		return
+	}
+
+	p, err := ParsePackage(zr)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !p.Valid() {
+		// report every problem at once rather than the first one
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		e.returnJSON(w, p)
+		return
+	}
+
+	store, err := NewAWS(e.log)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating upload session"))
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	if ranking == 0 {
+		max, err := GetMaxModuleRanking(tx)
+		if err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting max module ranking"))
+			return
+		}
+		ranking = max + 1
+	}
+
+	p.Module = &Module{
+		UserID:      r.Context().Value("user_id").(int),
+		Name:        p.Name,
+		Description: p.Description,
+		CategoryID:  categoryID,
+		Ranking:     ranking,
+		Free:        r.FormValue("free") == "true",
+		// imported modules are reviewed before going live
+		IsActive: r.FormValue("is_active") == "true",
+	}
+	uploaded, err := ImportPackage(tx, store, zr, p)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "importing package"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		if err := deleteFiles(store, uploaded); err != nil {
+			e.log.WithError(err).Error("deleting files of failed package import")
+		}
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting package import"))
+		return
+	}
+
+	e.returnJSON(w, p)
+}
+
+// ImportPackage imports a valid package in a transaction and gives the keys of
+// the files it uploaded, to delete should the transaction not commit. When the
+// import fails the files uploaded so far are deleted already.
+func ImportPackage(q Q, store FileStore, zr *zip.Reader, p *PackageImport) ([]string, error) {
+	uploaded, err := importPackage(q, store, newPackageFiles(zr), p)
+	if err != nil {
+		if deleteErr := deleteFiles(store, uploaded); deleteErr != nil {
+			return nil, errors.Wrapf(err, "leaving uploaded files behind (%s)", deleteErr)
+		}
+		return nil, err
+	}
+
+	return uploaded, nil
+}
+
+// deleteFiles deletes every file it can, giving the first error.
+func deleteFiles(store FileStore, keys []string) error {
+	var firstErr error
+	for _, key := range keys {
+		if err := store.DeleteFile(key); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "deleting %s", key)
+		}
+	}
+	return firstErr
+}
+
+// importPackage creates the module of a valid package, its learning outcomes
+// and a module file per item, uploading every asset once under the package
+// prefix of the module. It gives the keys of the files uploaded, even when
+// failing part way.
+func importPackage(q Q, store FileStore, files packageFiles, p *PackageImport) ([]string, error) {
+	var keys []string
+	m := p.Module
+	if err := CreateModule(q, m); err != nil {
+		return keys, errors.Wrap(err, "creating module")
+	}
+
+	for i, description := range p.LearningOutcomes {
+		o := &ModuleLearningOutcome{
+			ModuleID:    m.ID,
+			Description: description,
+			Ranking:     i + 1,
+			IsActive:    true,
+		}
+		if err := CreateModuleLearningOutcome(q, o); err != nil {
+			return keys, errors.Wrap(err, "creating learning outcome")
+		}
+		m.LearningOutcomes = append(m.LearningOutcomes, o)
+	}
+
+	prefix := ModulePackagePrefix(m.ID)
+	uploaded := map[string]*File{}
+	upload := func(name string) (*File, error) {
+		if f, ok := uploaded[name]; ok {
+			return f, nil
+		}
+		b, err := files.read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		base := path.Base(name)
+		ext := strings.TrimPrefix(path.Ext(base), ".")
+		contentType := mime.TypeByExtension(path.Ext(base))
+		if contentType == "" {
+			contentType = http.DetectContentType(b)
+		}
+		fileType := strings.Split(contentType, "/")[0]
+
+		f, err := CreateFile(q, &File{
+			UserID:      NewNullInt64(int64(m.UserID)),
+			Name:        NewNullString(strings.TrimSuffix(base, path.Ext(base))),
+			Description: NewNullString(name),
+			Extension:   NewNullString(ext),
+			Size:        NewNullInt64(int64(len(b))),
+			Type:        NewNullString(fileType),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating file for %s", name)
+		}
+		key := prefix + "/" + name
+		if err := store.UploadFileAt(key, b, contentType); err != nil {
+			return nil, errors.Wrapf(err, "uploading %s", name)
+		}
+		keys = append(keys, key)
+		uploaded[name] = f
+		return f, nil
+	}
+
+	for _, item := range p.Items {
+		launch, err := upload(item.LaunchPath)
+		if err != nil {
+			return keys, err
+		}
+		for _, a := range item.AssetPaths {
+			if _, err := upload(a); err != nil {
+				return keys, err
+			}
+		}
+
+		mf := &ModuleFile{
+			ModuleID:      m.ID,
+			Ranking:       item.Ranking,
+			FileID:        strconv.FormatInt(launch.ID.Int64, 10),
+			File:          launch,
+			PackagePrefix: NewNullString(prefix),
+		}
+		if err := CreateModuleFile(q, mf); err != nil {
+			return keys, errors.Wrapf(err, "creating module file for item %s", item.Identifier)
+		}
+		m.Files = append(m.Files, mf)
+	}
+
+	return keys, nil
+}
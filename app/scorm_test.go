@@ -0,0 +1,303 @@
+package external_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func packageZip(t *testing.T, files map[string]string) *zip.Reader {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("creating %s: %v", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("closing zip: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("reading zip: %v", err)
+	}
+	return zr
+}
+
+func TestParsePackageSCORM12(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	p, err := external.ParsePackage(packageZip(t, map[string]string{
+		"imsmanifest.xml": `<?xml version="1.0"?>
+<manifest identifier="course" xmlns="http://www.imsproject.org/xsd/imscp_rootv1p1p2"
+	xmlns:adlcp="http://www.adlnet.org/xsd/adlcp_rootv1p2">
+	<metadata>
+		<schema>ADL SCORM</schema>
+		<schemaversion>1.2</schemaversion>
+		<lom xmlns="http://www.imsglobal.org/xsd/imsmd_rootv1p2p1">
+			<general>
+				<title><langstring xml:lang="en">Passing drills</langstring></title>
+				<description><langstring xml:lang="en">Short and long passing</langstring></description>
+			</general>
+			<classification>
+				<purpose><value><langstring>Educational Objective</langstring></value></purpose>
+				<description><langstring>Pass accurately over 30 metres</langstring></description>
+			</classification>
+		</lom>
+	</metadata>
+	<organizations default="org">
+		<organization identifier="org">
+			<title>Passing</title>
+			<item identifier="week1">
+				<title>Week 1</title>
+				<item identifier="intro" identifierref="res_intro"><title>Intro</title></item>
+				<item identifier="drill" identifierref="res_drill"><title>Drill</title></item>
+			</item>
+			<item identifier="quiz" identifierref="res_quiz"><title>Quiz</title></item>
+		</organization>
+	</organizations>
+	<resources>
+		<resource identifier="res_intro" type="webcontent" adlcp:scormtype="sco" href="intro/index.html">
+			<file href="intro/index.html"/>
+			<dependency identifierref="shared"/>
+		</resource>
+		<resource identifier="res_drill" type="webcontent" adlcp:scormtype="asset" href="drill.mp4">
+			<file href="drill.mp4"/>
+		</resource>
+		<resource identifier="res_quiz" type="webcontent" adlcp:scormtype="sco" href="quiz.html?mode=test">
+			<file href="quiz.html"/>
+			<dependency identifierref="shared"/>
+		</resource>
+		<resource identifier="shared" type="webcontent" adlcp:scormtype="asset">
+			<file href="shared/api.js"/>
+		</resource>
+	</resources>
+</manifest>`,
+		"intro/index.html": "<html></html>",
+		"drill.mp4":        "video",
+		"quiz.html":        "<html></html>",
+		"shared/api.js":    "var api",
+	}))
+	h.ExpectNoError(err)
+
+	h.ExpectDeepEq(p.Valid(), true)
+	h.ExpectDeepEq(p.Type, external.PackageType_SCORM12)
+	h.ExpectDeepEq(p.Name, "Passing")
+	h.ExpectDeepEq(p.Description, "Short and long passing")
+	h.ExpectDeepEq(p.LearningOutcomes, []string{"Pass accurately over 30 metres"})
+	h.ExpectDeepEq(p.Items, []*external.PackageItem{
+		{
+			Identifier: "intro",
+			Title:      "Intro",
+			Ranking:    1,
+			LaunchPath: "intro/index.html",
+			AssetPaths: []string{"shared/api.js"},
+		},
+		{
+			Identifier: "drill",
+			Title:      "Drill",
+			Ranking:    2,
+			LaunchPath: "drill.mp4",
+		},
+		{
+			Identifier: "quiz",
+			Title:      "Quiz",
+			Ranking:    3,
+			LaunchPath: "quiz.html",
+			AssetPaths: []string{"shared/api.js"},
+		},
+	})
+}
+
+func TestParsePackageSCORM2004ReportsItemErrors(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	p, err := external.ParsePackage(packageZip(t, map[string]string{
+		"imsmanifest.xml": `<?xml version="1.0"?>
+<manifest identifier="course" xmlns="http://www.imsglobal.org/xsd/imscp_v1p1">
+	<metadata>
+		<schema>ADL SCORM</schema>
+		<schemaversion>2004 4th Edition</schemaversion>
+	</metadata>
+	<organizations default="org">
+		<organization identifier="org">
+			<title>Defending</title>
+			<item identifier="ok" identifierref="res_ok"><title>Positioning</title></item>
+			<item identifier="unknown" identifierref="res_unknown"><title>Tackling</title></item>
+			<item identifier="missing" identifierref="res_missing"><title>Marking</title></item>
+			<item identifier="remote" identifierref="res_remote"><title>Heading</title></item>
+		</organization>
+	</organizations>
+	<resources xml:base="content/">
+		<resource identifier="res_ok" type="webcontent" href="ok.html"><file href="ok.html"/></resource>
+		<resource identifier="res_missing" type="webcontent" href="missing.html"><file href="missing.html"/></resource>
+		<resource identifier="res_remote" type="webcontent" href="https://example.com/heading"/>
+	</resources>
+</manifest>`,
+		"content/ok.html": "<html></html>",
+	}))
+	h.ExpectNoError(err)
+
+	h.ExpectDeepEq(p.Type, external.PackageType_SCORM2004)
+	h.ExpectDeepEq(p.Valid(), false)
+	h.ExpectDeepEq(len(p.Items), 4)
+	h.ExpectDeepEq(p.Items[0].LaunchPath, "content/ok.html")
+	h.ExpectDeepEq(p.Items[0].Errors, []string(nil))
+	h.ExpectDeepEq(p.Items[1].Errors, []string{`unknown resource "res_unknown"`})
+	h.ExpectDeepEq(p.Items[2].Errors, []string{
+		`launch file "content/missing.html" missing from package`,
+	})
+	h.ExpectDeepEq(p.Items[3].Errors, []string{
+		`launch href "https://example.com/heading" points outside of the package`,
+	})
+}
+
+func TestParsePackageCMI5(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	p, err := external.ParsePackage(packageZip(t, map[string]string{
+		"cmi5.xml": `<?xml version="1.0"?>
+<courseStructure xmlns="https://w3id.org/xapi/profiles/cmi5/v1/CourseStructure.xsd">
+	<course id="https://ggwpacademy.com/courses/finishing">
+		<title><langstring lang="en-US">Finishing</langstring></title>
+		<description><langstring lang="en-US">Scoring from anywhere</langstring></description>
+	</course>
+	<objectives>
+		<objective id="placement"><title><langstring lang="en-US">Place the ball in the corners</langstring></title></objective>
+	</objectives>
+	<au id="au1"><title><langstring lang="en-US">Volleys</langstring></title><url>volleys/index.html</url></au>
+	<block id="b1">
+		<title><langstring lang="en-US">Set pieces</langstring></title>
+		<au id="au2"><title><langstring lang="en-US">Penalties</langstring></title><url>penalties.html</url></au>
+	</block>
+	<au id="au3"><title><langstring lang="en-US">Remote</langstring></title><url>https://example.com/au</url></au>
+</courseStructure>`,
+		"volleys/index.html": "<html></html>",
+		"volleys/clip.mp4":   "video",
+		"penalties.html":     "<html></html>",
+	}))
+	h.ExpectNoError(err)
+
+	h.ExpectDeepEq(p.Type, external.PackageType_CMI5)
+	h.ExpectDeepEq(p.Name, "Finishing")
+	h.ExpectDeepEq(p.Description, "Scoring from anywhere")
+	h.ExpectDeepEq(p.LearningOutcomes, []string{"Place the ball in the corners"})
+	h.ExpectDeepEq(len(p.Items), 3)
+	h.ExpectDeepEq(p.Items[0].LaunchPath, "volleys/index.html")
+	h.ExpectDeepEq(p.Items[0].AssetPaths, []string{"volleys/clip.mp4"})
+	h.ExpectDeepEq(p.Items[1].Title, "Penalties")
+	h.ExpectDeepEq(p.Items[1].Ranking, 2)
+	h.ExpectDeepEq(p.Items[2].Errors, []string{
+		`externally hosted assignable unit "https://example.com/au" can not be imported`,
+	})
+	h.ExpectDeepEq(p.Valid(), false)
+}
+
+func TestParsePackageWithoutManifest(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	_, err := external.ParsePackage(packageZip(t, map[string]string{
+		"index.html": "<html></html>",
+	}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	h.ExpectErrorContains(err, "imsmanifest.xml")
+}
+
+type fakeFileStore struct {
+	failOn   int
+	uploaded []string
+	deleted  []string
+}
+
+func (s *fakeFileStore) UploadFileAt(key string, body []byte, contentType string) error {
+	if len(s.uploaded)+1 == s.failOn {
+		return fmt.Errorf("upload failed")
+	}
+	s.uploaded = append(s.uploaded, key)
+	return nil
+}
+
+func (s *fakeFileStore) DeleteFile(key string) error {
+	s.deleted = append(s.deleted, key)
+	return nil
+}
+
+func TestImportPackageDeletesUploadsOnFailure(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("package-import@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+	var categoryID int
+	f.ExpectNoError(f.DAO.DB.Get(&categoryID, `SELECT category_id FROM ggwp.modules WHERE id = $1`, mf.ModuleID))
+
+	zr := packageZip(t, map[string]string{
+		"imsmanifest.xml": `<?xml version="1.0"?>
+<manifest identifier="course" xmlns="http://www.imsproject.org/xsd/imscp_rootv1p1p2">
+	<metadata><schemaversion>1.2</schemaversion></metadata>
+	<organizations default="org">
+		<organization identifier="org">
+			<title>Passing</title>
+			<item identifier="intro" identifierref="res_intro"><title>Intro</title></item>
+			<item identifier="drill" identifierref="res_drill"><title>Drill</title></item>
+		</organization>
+	</organizations>
+	<resources>
+		<resource identifier="res_intro" type="webcontent" href="intro.html"><file href="intro.html"/></resource>
+		<resource identifier="res_drill" type="webcontent" href="media/drill.mp4"><file href="media/drill.mp4"/></resource>
+	</resources>
+</manifest>`,
+		"intro.html":      "<html></html>",
+		"media/drill.mp4": "video",
+	})
+	var p *external.PackageImport
+	importPackage := func(store *fakeFileStore) ([]string, error) {
+		var err error
+		p, err = external.ParsePackage(zr)
+		f.ExpectNoError(err)
+		f.ExpectDeepEq(p.Valid(), true)
+		p.Module = &external.Module{
+			UserID:     auth.UserID,
+			Name:       p.Name,
+			CategoryID: categoryID,
+			Ranking:    2,
+		}
+
+		tx, err := f.DAO.DB.Beginx()
+		f.ExpectNoError(err)
+		defer tx.Rollback()
+		return external.ImportPackage(tx, store, zr, p)
+	}
+
+	// what was uploaded before failing is deleted
+	store := &fakeFileStore{failOn: 2}
+	_, err := importPackage(store)
+	f.ExpectErrorContains(err, "upload failed")
+	f.ExpectDeepEq(len(store.uploaded), 1)
+	f.ExpectDeepEq(store.deleted, store.uploaded)
+
+	// and otherwise left to the caller should it not commit
+	store = &fakeFileStore{}
+	uploaded, err := importPackage(store)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(uploaded), 2)
+	f.ExpectDeepEq(uploaded, store.uploaded)
+
+	// keeping the layout of the package under the folder of the module
+	prefix := external.ModulePackagePrefix(p.Module.ID)
+	f.ExpectDeepEq(uploaded, []string{prefix + "/intro.html", prefix + "/media/drill.mp4"})
+	for _, mf := range p.Module.Files {
+		f.ExpectDeepEq(mf.PackagePrefix.String, prefix)
+	}
+	f.ExpectDeepEq(len(store.deleted), 0)
+}
@@ -0,0 +1,187 @@
+package external
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// a freeze is earned every time the streak reaches a multiple of this
+	StreakFreezeEvery = 7
+	StreakMaxFreezes  = 2
+
+	// local hour after which users are reminded of a streak at risk
+	StreakReminderHour = 19
+)
+
+// UserLocation gives the location of a user's timezone, falling back to UTC
+// for unknown ones.
+func UserLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// RecordActivity applies a day with learning activity to the streak. Days
+// missed since the last activity are covered by freezes when enough are left,
+// otherwise the streak starts over. Days already counted are ignored and
+// false is returned.
+func (s *Streak) RecordActivity(day Date) bool {
+	switch {
+	case s.LastActivityDate == nil:
+		s.CurrentStreak = 1
+	case day <= *s.LastActivityDate:
+		return false
+	default:
+		missed := int(day-*s.LastActivityDate) - 1
+		if missed <= s.FreezesAvailable {
+			s.FreezesAvailable -= missed
+			s.FreezesUsed += missed
+			s.CurrentStreak++
+		} else {
+			s.CurrentStreak = 1
+		}
+	}
+
+	if s.CurrentStreak > s.LongestStreak {
+		s.LongestStreak = s.CurrentStreak
+	}
+	if s.CurrentStreak%StreakFreezeEvery == 0 && s.FreezesAvailable < StreakMaxFreezes {
+		s.FreezesAvailable++
+	}
+	s.LastActivityDate = &day
+
+	return true
+}
+
+// CurrentOn gives the streak as it stands on a day, it is lost once more days
+// were missed than there are freezes left.
+func (s *Streak) CurrentOn(today Date) int {
+	if s.LastActivityDate == nil {
+		return 0
+	}
+	if missed := int(today-*s.LastActivityDate) - 1; missed > s.FreezesAvailable {
+		return 0
+	}
+	return s.CurrentStreak
+}
+
+// AtRiskOn reports whether the streak is lost without any activity on the day.
+func (s *Streak) AtRiskOn(today Date) bool {
+	if s.CurrentOn(today) == 0 || *s.LastActivityDate >= today {
+		return false
+	}
+	return int(today-*s.LastActivityDate) > s.FreezesAvailable
+}
+
+// On sets the streak as it stands on a day.
+func (s *Streak) On(today Date) *Streak {
+	s.ActiveToday = s.LastActivityDate != nil && *s.LastActivityDate == today
+	s.AtRisk = s.AtRiskOn(today)
+	s.CurrentStreak = s.CurrentOn(today)
+	return s
+}
+
+// RecordLearningActivity extends the streak of a user for the day the activity
+// happened on in their timezone.
+func RecordLearningActivity(q Q, userID int, at time.Time) error {
+	if userID <= 0 {
+		return nil
+	}
+
+	timezone, err := GetUserTimezone(q, userID)
+	if err != nil {
+		return errors.Wrap(err, "getting user timezone")
+	}
+	s, err := GetStreakByUserIDForUpdate(q, userID)
+	if err != nil {
+		return errors.Wrap(err, "getting streak")
+	}
+	if !s.RecordActivity(FromTime(at.In(UserLocation(timezone)))) {
+		return nil
+	}
+	if err := UpdateStreak(q, s); err != nil {
+		return errors.Wrap(err, "updating streak")
+	}
+
+	return nil
+}
+
+func (e *External) HandleGetStreak(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	timezone, err := GetUserTimezone(e.dao.ReadDB, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting user timezone"))
+		return
+	}
+	s, err := GetStreakByUserID(e.dao.ReadDB, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting streak"))
+		return
+	}
+
+	e.returnJSON(w, s.On(TodayIn(UserLocation(timezone))))
+}
+
+// QueueStreakReminders queues an email for every user whose streak ends
+// today unless they learn something, once it is late enough in their day.
+func (e *External) QueueStreakReminders(ctx context.Context, now time.Time) (int, error) {
+	tx, err := e.dao.GetTx(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin tx")
+	}
+	defer tx.Rollback()
+
+	reminders, err := GetStreakReminderCandidates(tx)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting streak reminder candidates")
+	}
+
+	var queued int
+	for _, r := range reminders {
+		local := now.In(UserLocation(r.Timezone))
+		today := FromTime(local)
+		if local.Hour() < StreakReminderHour || !r.AtRiskOn(today) {
+			continue
+		}
+		if r.ReminderSentOn != nil && *r.ReminderSentOn >= today {
+			continue
+		}
+
+		userID := r.UserID
+		if err := CreateEmail(
+			tx,
+			&userID,
+			r.Email,
+			templateInfo[EmailType_StreakAtRisk].name,
+			HStoreMap{
+				"FirstName":     r.FirstName,
+				"CurrentStreak": strconv.Itoa(r.CurrentStreak),
+			},
+			EmailType_StreakAtRisk,
+			EmailStatus_Pending,
+		); err != nil {
+			return 0, errors.Wrapf(err, "creating streak email for user %d", r.UserID)
+		}
+		if err := MarkStreakReminderSent(tx, r.UserID, today); err != nil {
+			return 0, errors.Wrapf(err, "marking streak reminder sent for user %d", r.UserID)
+		}
+		queued++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commiting streak reminders")
+	}
+
+	return queued, nil
+}
@@ -0,0 +1,159 @@
+package external
+
+func GetUserTimezone(q Q, userID int) (string, error) {
+	var timezone string
+	if err := q.Get(
+		&timezone,
+		`SELECT timezone FROM ggwp.users WHERE id = $1`,
+		userID,
+	); err != nil {
+		return "", err
+	}
+
+	return timezone, nil
+}
+
+const selectFromStreaks = `
+	SELECT
+		user_id,
+		current_streak,
+		longest_streak,
+		freezes_available,
+		freezes_used,
+		last_activity_date,
+		created_at,
+		updated_at
+	FROM
+		ggwp.user_streaks
+`
+
+// GetStreakByUserID gives the streak of a user, an empty one when they never
+// had any activity.
+func GetStreakByUserID(q Q, userID int) (*Streak, error) {
+	var s []*Streak
+	if err := q.Select(
+		&s,
+		selectFromStreaks+`WHERE user_id = $1`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+	if len(s) == 0 {
+		return &Streak{UserID: userID}, nil
+	}
+
+	return s[0], nil
+}
+
+// GetStreakByUserIDForUpdate creates the streak of a user if needed and locks
+// it until the end of the transaction.
+func GetStreakByUserIDForUpdate(q Q, userID int) (*Streak, error) {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.user_streaks
+			(
+				user_id, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, NOW(), NOW()
+			)
+			ON CONFLICT (user_id) DO NOTHING
+		`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	var s Streak
+	if err := q.Get(
+		&s,
+		selectFromStreaks+`WHERE user_id = $1 FOR UPDATE`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func UpdateStreak(q Q, s *Streak) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.user_streaks
+			SET
+				current_streak = $2,
+				longest_streak = $3,
+				freezes_available = $4,
+				freezes_used = $5,
+				last_activity_date = $6,
+				updated_at = NOW()
+			WHERE
+				user_id = $1
+		`,
+		s.UserID,
+		s.CurrentStreak,
+		s.LongestStreak,
+		s.FreezesAvailable,
+		s.FreezesUsed,
+		s.LastActivityDate,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetStreakReminderCandidates gives the active streaks that could end within
+// a day, whatever the timezone of their user.
+func GetStreakReminderCandidates(q Q) ([]*StreakReminder, error) {
+	var r []*StreakReminder
+	if err := q.Select(
+		&r,
+		`
+			SELECT
+				s.user_id "streak.user_id",
+				s.current_streak "streak.current_streak",
+				s.longest_streak "streak.longest_streak",
+				s.freezes_available "streak.freezes_available",
+				s.freezes_used "streak.freezes_used",
+				s.last_activity_date "streak.last_activity_date",
+				s.reminder_sent_on,
+				u.email,
+				u.timezone,
+				COALESCE(p.first_name, '') first_name
+			FROM
+				ggwp.user_streaks s
+			JOIN
+				ggwp.users u
+				ON u.id = s.user_id
+			LEFT JOIN
+				ggwp.players p
+				ON p.user_id = u.id
+			WHERE
+				s.current_streak > 0
+				AND u.is_active
+				AND s.last_activity_date >= CURRENT_DATE - s.freezes_available - 2
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func MarkStreakReminderSent(q Q, userID int, day Date) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.user_streaks
+			SET reminder_sent_on = $2
+			WHERE user_id = $1
+		`,
+		userID,
+		day,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
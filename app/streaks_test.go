@@ -0,0 +1,67 @@
+package external_test
+
+import (
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestStreakRecordActivity(t *testing.T) {
+	h := &TestHelper{T: t}
+	day := external.MustFromString("2020-03-01")
+
+	s := &external.Streak{}
+	h.ExpectDeepEq(s.RecordActivity(day), true)
+	h.ExpectDeepEq(s.RecordActivity(day), false)
+	h.ExpectDeepEq(s.CurrentStreak, 1)
+
+	for i := 1; i < external.StreakFreezeEvery; i++ {
+		s.RecordActivity(day + external.Date(i))
+	}
+	h.ExpectDeepEq(s.CurrentStreak, external.StreakFreezeEvery)
+	h.ExpectDeepEq(s.FreezesAvailable, 1)
+
+	// one missed day is covered by the freeze
+	last := *s.LastActivityDate
+	s.RecordActivity(last + 2)
+	h.ExpectDeepEq(s.CurrentStreak, external.StreakFreezeEvery+1)
+	h.ExpectDeepEq(s.FreezesAvailable, 0)
+	h.ExpectDeepEq(s.FreezesUsed, 1)
+
+	// the next one is not
+	last = *s.LastActivityDate
+	s.RecordActivity(last + 2)
+	h.ExpectDeepEq(s.CurrentStreak, 1)
+	h.ExpectDeepEq(s.LongestStreak, external.StreakFreezeEvery+1)
+}
+
+func TestStreakOn(t *testing.T) {
+	h := &TestHelper{T: t}
+	day := external.MustFromString("2020-03-01")
+
+	s := &external.Streak{CurrentStreak: 3, LastActivityDate: &day}
+	h.ExpectDeepEq(s.CurrentOn(day+1), 3)
+	h.ExpectDeepEq(s.AtRiskOn(day), false)
+	h.ExpectDeepEq(s.AtRiskOn(day+1), true)
+	h.ExpectDeepEq(s.CurrentOn(day+2), 0)
+	h.ExpectDeepEq(s.AtRiskOn(day+2), false)
+
+	s.FreezesAvailable = 1
+	h.ExpectDeepEq(s.AtRiskOn(day+1), false)
+	h.ExpectDeepEq(s.AtRiskOn(day+2), true)
+	h.ExpectDeepEq(s.CurrentOn(day+2), 3)
+
+	on := (&external.Streak{CurrentStreak: 3, LastActivityDate: &day}).On(day)
+	h.ExpectDeepEq(on.ActiveToday, true)
+	h.ExpectDeepEq(on.AtRisk, false)
+}
+
+func TestStreakDayInUserTimezone(t *testing.T) {
+	h := &TestHelper{T: t}
+
+	// 9pm UTC is already the next morning in Sydney
+	at := time.Date(2020, 3, 1, 21, 0, 0, 0, time.UTC)
+	h.ExpectDeepEq(external.FromTime(at.In(external.UserLocation("Australia/Sydney"))).String(), "2020-03-02")
+	h.ExpectDeepEq(external.FromTime(at.In(external.UserLocation("Not/AZone"))).String(), "2020-03-01")
+}
@@ -0,0 +1,266 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maximum number of events accepted in a single sync
+var syncMaxEventsPerRequest = 200
+
+func (e *External) HandleSync(w http.ResponseWriter, r *http.Request) {
+	req := &SyncRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	if len(req.Events) > syncMaxEventsPerRequest {
+		e.writeError(
+			w, r, http.StatusBadRequest,
+			fmt.Errorf("too many events, at most %d per sync", syncMaxEventsPerRequest),
+		)
+		return
+	}
+
+	since, err := parseSyncToken(req.SyncToken)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid sync token"))
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+	deviceUniqueID := r.Context().Value("device_unique_id").(string)
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	serverTime, err := GetServerTime(tx)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting server time"))
+		return
+	}
+	// taken before reading the changes, what commits in between is sent again
+	// next time rather than missed
+	cursor, err := GetSyncCursor(tx)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting sync cursor"))
+		return
+	}
+
+	results := []*SyncEventResult{}
+	for _, ev := range req.Events {
+		res, err := e.applySyncEvent(tx, userID, deviceUniqueID, ev)
+		if err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "applying sync event %q", ev.IdempotencyKey))
+			return
+		}
+		results = append(results, res)
+	}
+
+	changes, err := getSyncChanges(tx, userID, since)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting changes since last sync"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting sync"))
+		return
+	}
+
+	e.returnJSON(w, &SyncResponse{
+		Results:    results,
+		SyncToken:  newSyncToken(cursor),
+		ServerTime: serverTime,
+		Changes:    changes,
+	})
+}
+
+// applySyncEvent applies a single event inside its own savepoint so a bad
+// event is reported back without undoing the rest of the batch. The returned
+// error is only set when the transaction itself can no longer be used.
+func (e *External) applySyncEvent(
+	q Q,
+	userID int,
+	deviceUniqueID string,
+	ev *SyncEvent,
+) (*SyncEventResult, error) {
+	res := &SyncEventResult{
+		IdempotencyKey: ev.IdempotencyKey,
+		Type:           ev.Type,
+	}
+	if ev.IdempotencyKey == "" {
+		res.Status = SyncEventStatus_Failed
+		res.Error = "missing idempotency_key"
+		return res, nil
+	}
+
+	existing, err := GetSyncEvent(q, userID, ev.IdempotencyKey)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "getting existing sync event")
+	} else if err == nil {
+		res.Status = SyncEventStatus_Duplicate
+		res.Result = existing.Result
+		res.ServerTimestamp = existing.CreatedAt
+		return res, nil
+	}
+
+	if _, err := q.Exec(`SAVEPOINT sync_event`); err != nil {
+		return nil, errors.Wrap(err, "creating savepoint")
+	}
+
+	result, applyErr := e.applySyncEventPayload(q, userID, deviceUniqueID, ev)
+	if applyErr == nil {
+		res.Result, applyErr = json.Marshal(result)
+	}
+	var concurrent bool
+	if applyErr == nil {
+		res.ServerTimestamp, applyErr = CreateSyncEvent(q, userID, ev, res.Result)
+		// another sync with the same key committed first
+		concurrent = isUniqueViolation(applyErr)
+	}
+	if applyErr != nil {
+		if _, err := q.Exec(`ROLLBACK TO SAVEPOINT sync_event`); err != nil {
+			return nil, errors.Wrap(err, "rolling back to savepoint")
+		}
+		if concurrent {
+			existing, err := GetSyncEvent(q, userID, ev.IdempotencyKey)
+			if err != nil {
+				return nil, errors.Wrap(err, "getting concurrent sync event")
+			}
+			res.Status = SyncEventStatus_Duplicate
+			res.Result = existing.Result
+			res.ServerTimestamp = existing.CreatedAt
+			return res, nil
+		}
+		res.Status = SyncEventStatus_Failed
+		res.Error = applyErr.Error()
+		res.Result = nil
+		return res, nil
+	}
+
+	if _, err := q.Exec(`RELEASE SAVEPOINT sync_event`); err != nil {
+		return nil, errors.Wrap(err, "releasing savepoint")
+	}
+	res.Status = SyncEventStatus_Applied
+
+	return res, nil
+}
+
+func (e *External) applySyncEventPayload(
+	q Q,
+	userID int,
+	deviceUniqueID string,
+	ev *SyncEvent,
+) (interface{}, error) {
+	switch ev.Type {
+	case SyncEventType_ProgressUpdate:
+		p := &LearningProgress{}
+		if err := json.Unmarshal(ev.Payload, p); err != nil {
+			return nil, fmt.Errorf("invalid progress update payload")
+		}
+		if p.ModuleID <= 0 {
+			return nil, fmt.Errorf("missing module_id")
+		}
+		p.UserID = sql.NullInt64{Int64: int64(userID), Valid: true}
+		p.DeviceUniqueID = sql.NullString{String: deviceUniqueID, Valid: true}
+		if err := RecordModuleProgress(q, p); err != nil {
+			return nil, errors.Wrap(err, "recording module progress")
+		}
+		if err := recordXAPIStatement(q, NewProgressedStatement(p)); err != nil {
+			return nil, errors.Wrap(err, "recording progressed statement")
+		}
+		return p, nil
+
+	case SyncEventType_QuizSubmission:
+		gR := &GradingRequest{}
+		if err := json.Unmarshal(ev.Payload, gR); err != nil {
+			return nil, fmt.Errorf("invalid quiz submission payload")
+		}
+		gR.UserID = userID
+		return e.gradeQuiz(q, gR, deviceUniqueID)
+
+	case SyncEventType_GoalCompletion:
+		p := &GoalCompletionPayload{}
+		if err := json.Unmarshal(ev.Payload, p); err != nil {
+			return nil, fmt.Errorf("invalid goal completion payload")
+		}
+		if p.GoalID <= 0 {
+			return nil, fmt.Errorf("missing goal_id")
+		}
+		found, err := CompleteGoal(q, p.GoalID, userID)
+		if err != nil {
+			return nil, errors.Wrap(err, "completing goal")
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown goal: %d", p.GoalID)
+		}
+		if err := recordGoalCompletion(q, p.GoalID, userID); err != nil {
+			return nil, errors.Wrap(err, "recording goal completion")
+		}
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("unknown sync event type: %q", ev.Type)
+}
+
+func getSyncChanges(q Q, userID int, since int64) (*SyncChanges, error) {
+	var err error
+	c := &SyncChanges{}
+	if c.LearningProgress, err = GetLearningProgressesByUserIDChangedSince(q, userID, since); err != nil {
+		return nil, errors.Wrap(err, "getting learning progress")
+	}
+	if c.FileProgress, err = GetModuleFileProgressesByUserIDChangedSince(q, userID, since); err != nil {
+		return nil, errors.Wrap(err, "getting file progress")
+	}
+	if c.QuizGradings, err = GetQuizGradingsByUserIDChangedSince(q, userID, since); err != nil {
+		return nil, errors.Wrap(err, "getting quiz gradings")
+	}
+	if c.UserGoals, err = GetGoalsByUserIDChangedSince(q, userID, since); err != nil {
+		return nil, errors.Wrap(err, "getting goals")
+	}
+
+	return c, nil
+}
+
+// syncTokenPrefix tells cursors apart from the timestamps older tokens held.
+const syncTokenPrefix = "tx:"
+
+// newSyncToken encodes the cursor of a sync. Clients treat it as opaque and
+// hand it back on their next sync.
+func newSyncToken(cursor int64) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(syncTokenPrefix + strconv.FormatInt(cursor, 10)),
+	)
+}
+
+// parseSyncToken gives the cursor a sync token was issued at. Empty tokens and
+// the timestamps older clients hold give 0, so the sync returns everything.
+func parseSyncToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(string(b), syncTokenPrefix) {
+		if _, err := strconv.ParseInt(string(b), 10, 64); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	return strconv.ParseInt(strings.TrimPrefix(string(b), syncTokenPrefix), 10, 64)
+}
@@ -0,0 +1,192 @@
+package external
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type StoredSyncEvent struct {
+	ID             int             `json:"id,omitempty"`
+	UserID         int             `json:"user_id,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	Type           SyncEventType   `json:"type,omitempty"`
+	Result         json.RawMessage `json:"result,omitempty"`
+	CreatedAt      time.Time       `json:"created_at,omitempty"`
+}
+
+func GetSyncEvent(q Q, userID int, idempotencyKey string) (*StoredSyncEvent, error) {
+	var e StoredSyncEvent
+	if err := q.Get(
+		&e,
+		`
+			SELECT
+				id,
+				user_id,
+				idempotency_key,
+				type,
+				COALESCE(result, 'null') result,
+				created_at
+			FROM
+				ggwp.sync_events
+			WHERE
+				user_id = $1
+				AND idempotency_key = $2
+		`,
+		userID,
+		idempotencyKey,
+	); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func CreateSyncEvent(
+	q Q,
+	userID int,
+	event *SyncEvent,
+	result json.RawMessage,
+) (time.Time, error) {
+	var createdAt time.Time
+	if err := q.Get(
+		&createdAt,
+		`
+			INSERT INTO ggwp.sync_events
+			(
+				user_id, idempotency_key, type, payload, result, client_created_at, created_at
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, $5, $6, NOW()
+			)
+			RETURNING created_at
+		`,
+		userID,
+		event.IdempotencyKey,
+		event.Type,
+		string(event.Payload),
+		string(result),
+		event.ClientCreatedAt,
+	); err != nil {
+		return time.Time{}, err
+	}
+
+	return createdAt, nil
+}
+
+func GetServerTime(q Q) (time.Time, error) {
+	var t time.Time
+	if err := q.Get(&t, `SELECT NOW()`); err != nil {
+		return time.Time{}, err
+	}
+
+	return t, nil
+}
+
+// GetSyncCursor gives the oldest transaction still running. Rows carry the
+// transaction that last wrote them, so the ones written by transactions which
+// hadn't committed yet are at or past the cursor whatever order they commit in.
+func GetSyncCursor(q Q) (int64, error) {
+	var c int64
+	if err := q.Get(&c, `SELECT txid_snapshot_xmin(txid_current_snapshot())`); err != nil {
+		return 0, err
+	}
+
+	return c, nil
+}
+
+func GetLearningProgressesByUserIDChangedSince(q Q, userID int, since int64) ([]*LearningProgress, error) {
+	var l []*LearningProgress
+	if err := q.Select(
+		&l,
+		`
+			SELECT
+				id,
+				module_id,
+				user_id,
+				module_file_ranking,
+				COALESCE(seek, 0) seek,
+				device_unique_id,
+				created_at,
+				updated_at
+			FROM ggwp.learning_progresses
+			WHERE user_id = $1
+				AND sync_txid >= $2
+			ORDER BY sync_txid, updated_at
+		`,
+		userID,
+		since,
+	); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func GetModuleFileProgressesByUserIDChangedSince(q Q, userID int, since int64) ([]*ModuleFileProgress, error) {
+	var p []*ModuleFileProgress
+	if err := q.Select(
+		&p,
+		selectFromModuleFileProgressesWhere(
+			`
+				WHERE user_id = $1
+					AND sync_txid >= $2
+				ORDER BY sync_txid, updated_at
+			`,
+		),
+		userID,
+		since,
+	); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func GetQuizGradingsByUserIDChangedSince(q Q, userID int, since int64) ([]*QuizGrading, error) {
+	var g []*QuizGrading
+	if err := q.Select(
+		&g,
+		selectFromQuizGradingsWhere(`
+			WHERE user_id = $1
+				AND sync_txid >= $2
+			ORDER BY sync_txid, updated_at
+		`),
+		userID,
+		since,
+	); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func GetGoalsByUserIDChangedSince(q Q, userID int, since int64) ([]*UserGoal, error) {
+	var g []*UserGoal
+	if err := q.Select(
+		&g,
+		`
+			SELECT
+				id,
+				user_id,
+				description,
+				value,
+				rate,
+				deadline,
+				completed_at,
+				is_active,
+				created_at,
+				updated_at
+			FROM ggwp.user_goals
+			WHERE user_id = $1
+				AND sync_txid >= $2
+			ORDER BY sync_txid, updated_at
+		`,
+		userID,
+		since,
+	); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
@@ -0,0 +1,149 @@
+package external_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func syncRequest(f *Fixture, token string, events ...string) *external.SyncResponse {
+	body := `{"events": [`
+	for i, ev := range events {
+		if i > 0 {
+			body += ","
+		}
+		body += ev
+	}
+	body += `]}`
+
+	rr := f.AuthedRequest(http.MethodPost, "/api/v0.1/sync", body, token)
+	f.ExpectStatus(rr, http.StatusOK)
+	res := &external.SyncResponse{}
+	f.Bind(rr, res)
+	return res
+}
+
+func progressUpdate(key string, moduleID int, seek string) string {
+	return fmt.Sprintf(
+		`{"idempotency_key": "%s", "type": "PROGRESS_UPDATE", "payload": {"module_id": %d, "module_file_ranking": 1, "seek": "%s"}}`,
+		key, moduleID, seek,
+	)
+}
+
+func TestHandleSyncIdempotency(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("sync@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+
+	res := syncRequest(f, auth.AccessToken,
+		progressUpdate("progress-1", mf.ModuleID, "10"),
+		// the same key again in the batch is a duplicate of the first
+		progressUpdate("progress-1", mf.ModuleID, "99"),
+	)
+	f.ExpectDeepEq(len(res.Results), 2)
+	f.ExpectDeepEq(res.Results[0].Status, external.SyncEventStatus_Applied)
+	f.ExpectDeepEq(res.Results[1].Status, external.SyncEventStatus_Duplicate)
+	f.ExpectDeepEq(string(res.Results[1].Result), string(res.Results[0].Result))
+	f.ExpectRowCountWhere(
+		"ggwp.learning_progresses",
+		fmt.Sprintf("user_id = %d AND seek = 10", auth.UserID),
+		1,
+	)
+
+	// replaying a batch applies nothing twice
+	replay := syncRequest(f, auth.AccessToken, progressUpdate("progress-1", mf.ModuleID, "10"))
+	f.ExpectDeepEq(replay.Results[0].Status, external.SyncEventStatus_Duplicate)
+	f.ExpectDeepEq(string(replay.Results[0].Result), string(res.Results[0].Result))
+	f.ExpectDeepEq(replay.Results[0].ServerTimestamp.Equal(res.Results[0].ServerTimestamp), true)
+	f.ExpectRowCount("ggwp.sync_events", 1)
+
+	// keys are per user
+	other := f.GetAuthToken("other-sync@ggwpacademy.com")
+	res = syncRequest(f, other.AccessToken, progressUpdate("progress-1", mf.ModuleID, "20"))
+	f.ExpectDeepEq(res.Results[0].Status, external.SyncEventStatus_Applied)
+	f.ExpectRowCount("ggwp.sync_events", 2)
+}
+
+func TestHandleSyncPartialFailure(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("sync-failures@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+
+	res := syncRequest(f, auth.AccessToken,
+		progressUpdate("progress-1", mf.ModuleID, "10"),
+		`{"type": "PROGRESS_UPDATE", "payload": {"module_id": 1}}`,
+		`{"idempotency_key": "goal-1", "type": "GOAL_COMPLETION", "payload": {"goal_id": 999999}}`,
+		`{"idempotency_key": "unknown-1", "type": "UNKNOWN", "payload": {}}`,
+		progressUpdate("progress-2", mf.ModuleID, "20"),
+	)
+	f.ExpectDeepEq(len(res.Results), 5)
+	statuses := []external.SyncEventStatus{}
+	for _, r := range res.Results {
+		statuses = append(statuses, r.Status)
+	}
+	// failed events don't undo the rest of the batch
+	f.ExpectDeepEq(statuses, []external.SyncEventStatus{
+		external.SyncEventStatus_Applied,
+		external.SyncEventStatus_Failed,
+		external.SyncEventStatus_Failed,
+		external.SyncEventStatus_Failed,
+		external.SyncEventStatus_Applied,
+	})
+	f.ExpectDeepEq(res.Results[1].Error, "missing idempotency_key")
+	f.ExpectDeepEq(res.Results[2].Error, "unknown goal: 999999")
+	f.ExpectRowCount("ggwp.sync_events", 2)
+	f.ExpectRowCountWhere(
+		"ggwp.learning_progresses",
+		fmt.Sprintf("user_id = %d AND seek = 20", auth.UserID),
+		1,
+	)
+
+	// and failed events can be retried with the same key
+	res = syncRequest(f, auth.AccessToken, `{"idempotency_key": "goal-1", "type": "GOAL_COMPLETION", "payload": {"goal_id": 999999}}`)
+	f.ExpectDeepEq(res.Results[0].Status, external.SyncEventStatus_Failed)
+}
+
+func TestHandleSyncToken(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	auth := f.GetAuthToken("sync-token@ggwpacademy.com")
+	mf := f.InsertModuleFile(auth.UserID)
+
+	res := syncRequest(f, auth.AccessToken, progressUpdate("progress-1", mf.ModuleID, "10"))
+	f.ExpectDeepEq(len(res.Changes.LearningProgress), 1)
+
+	sync := func(syncToken string) *httptest.ResponseRecorder {
+		return f.AuthedRequest(
+			http.MethodPost, "/api/v0.1/sync",
+			fmt.Sprintf(`{"sync_token": %q}`, syncToken),
+			auth.AccessToken,
+		)
+	}
+
+	// the test runs in a single transaction, still running when the token was
+	// issued, so its writes are sent again rather than skipped
+	rr := sync(res.SyncToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	next := &external.SyncResponse{}
+	f.Bind(rr, next)
+	f.ExpectDeepEq(len(next.Changes.LearningProgress), 1)
+
+	// tokens holding a timestamp sync everything again
+	rr = sync(base64.RawURLEncoding.EncodeToString([]byte("1600000000000000000")))
+	f.ExpectStatus(rr, http.StatusOK)
+	next = &external.SyncResponse{}
+	f.Bind(rr, next)
+	f.ExpectDeepEq(len(next.Changes.LearningProgress), 1)
+
+	rr = sync("not a token")
+	f.ExpectStatus(rr, http.StatusBadRequest)
+}
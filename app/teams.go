@@ -0,0 +1,191 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// teamRequest gives the team named in the body of a request.
+func teamRequest(r *http.Request) (*Team, error) {
+	t := &Team{}
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		return nil, fmt.Errorf("invalid request")
+	}
+	if t.Name = strings.TrimSpace(t.Name); t.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	return t, nil
+}
+
+func (e *External) HandleGetTeams(w http.ResponseWriter, r *http.Request) {
+	teams, err := GetTeams(e.dao.ReadDB)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting teams"))
+		return
+	}
+	if teams == nil {
+		teams = []*Team{}
+	}
+
+	e.returnJSON(w, teams)
+}
+
+func (e *External) HandleCreateTeam(w http.ResponseWriter, r *http.Request) {
+	t, err := teamRequest(r)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := CreateTeam(e.dao.DB, t); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating team"))
+		return
+	}
+
+	e.returnJSON(w, t)
+}
+
+func (e *External) HandleUpdateTeam(w http.ResponseWriter, r *http.Request) {
+	teamID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing team id"))
+		return
+	}
+	t, err := teamRequest(r)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	t.ID = teamID
+
+	found, err := UpdateTeam(e.dao.DB, t)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "updating team"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown team: %d", teamID))
+		return
+	}
+
+	e.returnJSON(w, t)
+}
+
+// HandleDeleteTeam deletes a team and its memberships, the scores of its
+// members are kept on the global boards.
+func (e *External) HandleDeleteTeam(w http.ResponseWriter, r *http.Request) {
+	teamID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing team id"))
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	found, err := DeleteTeam(tx, teamID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "deleting team"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown team: %d", teamID))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting team deletion"))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
+
+func (e *External) HandleGetTeamMembers(w http.ResponseWriter, r *http.Request) {
+	teamID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing team id"))
+		return
+	}
+
+	if _, err := GetTeamByID(e.dao.ReadDB, teamID); err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown team: %d", teamID))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting team"))
+		return
+	}
+	members, err := GetTeamMembers(e.dao.ReadDB, teamID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting team members"))
+		return
+	}
+	if members == nil {
+		members = []*TeamMember{}
+	}
+
+	e.returnJSON(w, members)
+}
+
+func (e *External) HandleAddTeamMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	teamID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing team id"))
+		return
+	}
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing user id"))
+		return
+	}
+
+	if err := AddTeamMember(e.dao.DB, teamID, userID); err != nil {
+		if pqErr, ok := errors.Cause(err).(*pq.Error); ok && pqErr.Code == "23503" {
+			e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown team or user"))
+			return
+		}
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "adding team member"))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
+
+func (e *External) HandleRemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	teamID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing team id"))
+		return
+	}
+	userID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing user id"))
+		return
+	}
+
+	found, err := RemoveTeamMember(e.dao.DB, teamID, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "removing team member"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("user %d is not in team %d", userID, teamID))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
@@ -0,0 +1,194 @@
+package external
+
+func selectFromTeamsWhere(where string) string {
+	return `
+		SELECT
+			id,
+			name,
+			created_at,
+			updated_at
+		FROM ggwp.teams
+	` + where
+}
+
+func GetTeams(q Q) ([]*Team, error) {
+	var t []*Team
+	if err := q.Select(
+		&t,
+		selectFromTeamsWhere(`
+			ORDER BY id
+		`),
+	); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func GetTeamByID(q Q, ID int) (*Team, error) {
+	var t Team
+	if err := q.Get(
+		&t,
+		selectFromTeamsWhere(`
+			WHERE id = $1
+		`),
+		ID,
+	); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func CreateTeam(q Q, t *Team) error {
+	if err := q.Get(
+		&t.ID,
+		`
+			INSERT INTO ggwp.teams
+			(
+				name, created_at, updated_at
+			)
+			VALUES
+			(
+				$1, NOW(), NOW()
+			)
+			RETURNING id
+		`,
+		t.Name,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateTeam reports whether the team exists.
+func UpdateTeam(q Q, t *Team) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.teams
+			SET
+				name = $2,
+				updated_at = NOW()
+			WHERE
+				id = $1
+		`,
+		t.ID,
+		t.Name,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// DeleteTeam deletes a team along with its memberships, it reports whether the
+// team existed.
+func DeleteTeam(q Q, ID int) (bool, error) {
+	if _, err := q.Exec(`DELETE FROM ggwp.team_members WHERE team_id = $1`, ID); err != nil {
+		return false, err
+	}
+	res, err := q.Exec(`DELETE FROM ggwp.teams WHERE id = $1`, ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func GetTeamMembers(q Q, teamID int) ([]*TeamMember, error) {
+	var m []*TeamMember
+	if err := q.Select(
+		&m,
+		`
+			SELECT
+				tM.team_id,
+				tM.user_id,
+				u.email,
+				tM.created_at
+			FROM
+				ggwp.team_members tM
+			JOIN
+				ggwp.users u
+				ON u.id = tM.user_id
+			WHERE
+				tM.team_id = $1
+			ORDER BY tM.created_at, tM.user_id
+		`,
+		teamID,
+	); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// AddTeamMember is a no-op for users already in the team.
+func AddTeamMember(q Q, teamID, userID int) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.team_members
+			(
+				team_id, user_id, created_at
+			)
+			VALUES
+			(
+				$1, $2, NOW()
+			)
+			ON CONFLICT (team_id, user_id) DO NOTHING
+		`,
+		teamID,
+		userID,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveTeamMember reports whether the user was in the team.
+func RemoveTeamMember(q Q, teamID, userID int) (bool, error) {
+	res, err := q.Exec(
+		`DELETE FROM ggwp.team_members WHERE team_id = $1 AND user_id = $2`,
+		teamID,
+		userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func IsTeamMember(q Q, teamID, userID int) (bool, error) {
+	var member bool
+	if err := q.Get(
+		&member,
+		`
+			SELECT EXISTS (
+				SELECT 1
+				FROM ggwp.team_members
+				WHERE team_id = $1 AND user_id = $2
+			)
+		`,
+		teamID,
+		userID,
+	); err != nil {
+		return false, err
+	}
+
+	return member, nil
+}
@@ -0,0 +1,67 @@
+package external_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestTeamsAdmin(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	admin := f.GetAuthToken("teams-admin@ggwpacademy.com")
+	f.MakeAdmin(admin.UserID)
+	member := f.GetAuthToken("teams-member@ggwpacademy.com")
+
+	rr := f.AuthedRequest(http.MethodPost, "/api/v0.1/teams", `{"name": "Rebels"}`, member.AccessToken)
+	f.ExpectStatus(rr, http.StatusForbidden)
+	rr = f.AuthedRequest(http.MethodPost, "/api/v0.1/teams", `{"name": " "}`, admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+
+	rr = f.AuthedRequest(http.MethodPost, "/api/v0.1/teams", `{"name": "Rebels"}`, admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	team := &external.Team{}
+	f.Bind(rr, team)
+	teamPath := fmt.Sprintf("/api/v0.1/teams/%d", team.ID)
+
+	rr = f.AuthedRequest(http.MethodPut, teamPath, `{"name": "Rebels U16"}`, admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/teams", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var teams []*external.Team
+	f.Bind(rr, &teams)
+	f.ExpectDeepEq(len(teams), 1)
+	f.ExpectDeepEq(teams[0].Name, "Rebels U16")
+
+	// members are added once
+	memberPath := fmt.Sprintf("%s/members/%d", teamPath, member.UserID)
+	for i := 0; i < 2; i++ {
+		rr = f.AuthedRequest(http.MethodPut, memberPath, "", admin.AccessToken)
+		f.ExpectStatus(rr, http.StatusOK)
+	}
+	rr = f.AuthedRequest(http.MethodPut, fmt.Sprintf("%s/members/%d", teamPath, 999999), "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+	rr = f.AuthedRequest(http.MethodGet, teamPath+"/members", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var members []*external.TeamMember
+	f.Bind(rr, &members)
+	f.ExpectDeepEq(len(members), 1)
+	f.ExpectDeepEq(members[0].UserID, member.UserID)
+
+	rr = f.AuthedRequest(http.MethodDelete, memberPath, "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.AuthedRequest(http.MethodDelete, memberPath, "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+
+	rr = f.AuthedRequest(http.MethodPut, memberPath, "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.AuthedRequest(http.MethodDelete, teamPath, "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCount("ggwp.teams", 0)
+	f.ExpectRowCount("ggwp.team_members", 0)
+	rr = f.AuthedRequest(http.MethodDelete, teamPath, "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+}
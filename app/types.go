@@ -3,23 +3,52 @@ package external
 import (
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"time"
 
-	"github.com/lib/pq"
 	"github.com/lib/pq/hstore"
 	"github.com/shopspring/decimal"
 )
 
 type WaitlistEmailVars struct {
 	WaitlistCode string
+	Position     int
+}
+
+type WaitlistConfirmationEmailVars struct {
+	ConfirmURL string
+}
+
+type WaitlistInviteEmailVars struct {
+	SignUpURL string
+}
+
+type GoalReminderEmailVars struct {
+	FirstName       string
+	Kind            string
+	GoalDescription string
+	Deadline        string
+	Progress        string
+	// filled in when sending, like for every email people can unsubscribe from
+	UnsubscribeURL string
+}
+
+type StreakAtRiskEmailVars struct {
+	FirstName     string
+	CurrentStreak string
 }
 
 type EmailType string
 
 const (
-	EmailType_Waitlist       EmailType = "WAITLIST"
-	EmailType_ForgotPassword EmailType = "FORGOT_PASSWORD"
+	EmailType_Waitlist             EmailType = "WAITLIST"
+	EmailType_ForgotPassword       EmailType = "FORGOT_PASSWORD"
+	EmailType_StreakAtRisk         EmailType = "STREAK_AT_RISK"
+	EmailType_GoalReminder         EmailType = "GOAL_REMINDER"
+	EmailType_WaitlistInvite       EmailType = "WAITLIST_INVITE"
+	EmailType_WaitlistConfirmation EmailType = "WAITLIST_CONFIRMATION"
+	EmailType_Welcome              EmailType = "WELCOME"
 )
 
 func (w EmailType) String() string {
@@ -28,6 +57,16 @@ func (w EmailType) String() string {
 		return "WAITLIST"
 	case EmailType_ForgotPassword:
 		return "FORGOT_PASSWORD"
+	case EmailType_StreakAtRisk:
+		return "STREAK_AT_RISK"
+	case EmailType_GoalReminder:
+		return "GOAL_REMINDER"
+	case EmailType_WaitlistInvite:
+		return "WAITLIST_INVITE"
+	case EmailType_WaitlistConfirmation:
+		return "WAITLIST_CONFIRMATION"
+	case EmailType_Welcome:
+		return "WELCOME"
 	}
 	return ""
 }
@@ -51,6 +90,21 @@ func (e *EmailType) Scan(src interface{}) error {
 	case "FORGOT_PASSWORD":
 		*e = EmailType_ForgotPassword
 
+	case "STREAK_AT_RISK":
+		*e = EmailType_StreakAtRisk
+
+	case "GOAL_REMINDER":
+		*e = EmailType_GoalReminder
+
+	case "WAITLIST_INVITE":
+		*e = EmailType_WaitlistInvite
+
+	case "WAITLIST_CONFIRMATION":
+		*e = EmailType_WaitlistConfirmation
+
+	case "WELCOME":
+		*e = EmailType_Welcome
+
 	default:
 		return fmt.Errorf("Scan: no enum str for %v", src)
 	}
@@ -66,6 +120,21 @@ func (e EmailType) Value() (driver.Value, error) {
 	case EmailType_ForgotPassword:
 		return driver.Value("FORGOT_PASSWORD"), nil
 
+	case EmailType_StreakAtRisk:
+		return driver.Value("STREAK_AT_RISK"), nil
+
+	case EmailType_GoalReminder:
+		return driver.Value("GOAL_REMINDER"), nil
+
+	case EmailType_WaitlistInvite:
+		return driver.Value("WAITLIST_INVITE"), nil
+
+	case EmailType_WaitlistConfirmation:
+		return driver.Value("WAITLIST_CONFIRMATION"), nil
+
+	case EmailType_Welcome:
+		return driver.Value("WELCOME"), nil
+
 	default:
 		return driver.Value(""), fmt.Errorf("Value: no val for %v", e)
 	}
@@ -77,6 +146,9 @@ const (
 	EmailStatus_Pending    EmailStatus = "PENDING"
 	EmailStatus_Processing EmailStatus = "PROCESSING"
 	EmailStatus_Sent       EmailStatus = "SENT"
+	EmailStatus_Failed     EmailStatus = "FAILED"
+	EmailStatus_Discarded  EmailStatus = "DISCARDED"
+	EmailStatus_Suppressed EmailStatus = "SUPPRESSED"
 )
 
 func (w EmailStatus) String() string {
@@ -87,6 +159,12 @@ func (w EmailStatus) String() string {
 		return "PROCESSING"
 	case EmailStatus_Sent:
 		return "SENT"
+	case EmailStatus_Failed:
+		return "FAILED"
+	case EmailStatus_Discarded:
+		return "DISCARDED"
+	case EmailStatus_Suppressed:
+		return "SUPPRESSED"
 	}
 	return ""
 }
@@ -113,6 +191,15 @@ func (e *EmailStatus) Scan(src interface{}) error {
 	case "SENT":
 		*e = EmailStatus_Sent
 
+	case "FAILED":
+		*e = EmailStatus_Failed
+
+	case "DISCARDED":
+		*e = EmailStatus_Discarded
+
+	case "SUPPRESSED":
+		*e = EmailStatus_Suppressed
+
 	default:
 		return fmt.Errorf("Scan: no enum str for %v", src)
 	}
@@ -131,6 +218,15 @@ func (e EmailStatus) Value() (driver.Value, error) {
 	case EmailStatus_Sent:
 		return driver.Value("SENT"), nil
 
+	case EmailStatus_Failed:
+		return driver.Value("FAILED"), nil
+
+	case EmailStatus_Discarded:
+		return driver.Value("DISCARDED"), nil
+
+	case EmailStatus_Suppressed:
+		return driver.Value("SUPPRESSED"), nil
+
 	default:
 		return driver.Value(""), fmt.Errorf("Value: no val for %v", e)
 	}
@@ -240,17 +336,121 @@ func (e SocialNetwork) Value() (driver.Value, error) {
 	}
 }
 
+type ProgressEventType string
+
+const (
+	ProgressEventType_Play       ProgressEventType = "PLAY"
+	ProgressEventType_Pause      ProgressEventType = "PAUSE"
+	ProgressEventType_Seek       ProgressEventType = "SEEK"
+	ProgressEventType_Ended      ProgressEventType = "ENDED"
+	ProgressEventType_RateChange ProgressEventType = "RATE_CHANGE"
+	ProgressEventType_Heartbeat  ProgressEventType = "HEARTBEAT"
+)
+
+func (w ProgressEventType) String() string {
+	switch w {
+	case ProgressEventType_Play:
+		return "PLAY"
+	case ProgressEventType_Pause:
+		return "PAUSE"
+	case ProgressEventType_Seek:
+		return "SEEK"
+	case ProgressEventType_Ended:
+		return "ENDED"
+	case ProgressEventType_RateChange:
+		return "RATE_CHANGE"
+	case ProgressEventType_Heartbeat:
+		return "HEARTBEAT"
+	}
+	return ""
+}
+
+func (e *ProgressEventType) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "PLAY":
+		*e = ProgressEventType_Play
+
+	case "PAUSE":
+		*e = ProgressEventType_Pause
+
+	case "SEEK":
+		*e = ProgressEventType_Seek
+
+	case "ENDED":
+		*e = ProgressEventType_Ended
+
+	case "RATE_CHANGE":
+		*e = ProgressEventType_RateChange
+
+	case "HEARTBEAT":
+		*e = ProgressEventType_Heartbeat
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (e ProgressEventType) Value() (driver.Value, error) {
+	switch e {
+
+	case ProgressEventType_Play:
+		return driver.Value("PLAY"), nil
+
+	case ProgressEventType_Pause:
+		return driver.Value("PAUSE"), nil
+
+	case ProgressEventType_Seek:
+		return driver.Value("SEEK"), nil
+
+	case ProgressEventType_Ended:
+		return driver.Value("ENDED"), nil
+
+	case ProgressEventType_RateChange:
+		return driver.Value("RATE_CHANGE"), nil
+
+	case ProgressEventType_Heartbeat:
+		return driver.Value("HEARTBEAT"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", e)
+	}
+}
+
 type Email struct {
-	ID           int           `json:"id,omitempty"`
-	UserID       sql.NullInt64 `json:"user_id,omitempty"`
-	EmailAddress string        `json:"email_address,omitempty"`
-	TemplateName string        `json:"template_name,omitempty"`
-	TemplateVars HStoreMap     `json:"template_vars,omitempty"`
-	Type         EmailType     `json:"type,omitempty"`
-	Status       EmailStatus   `json:"status,omitempty"`
-	CreatedAt    pq.NullTime   `json:"created_at,omitempty"`
-	UpdatedAt    pq.NullTime   `json:"updated_at,omitempty"`
-	SentAt       pq.NullTime   `json:"sent_at,omitempty"`
+	ID            int         `json:"id,omitempty"`
+	UserID        *int        `json:"user_id,omitempty"`
+	EmailAddress  string      `json:"email_address,omitempty"`
+	TemplateName  string      `json:"template_name,omitempty"`
+	TemplateVars  HStoreMap   `json:"template_vars,omitempty"`
+	Type          EmailType   `json:"type,omitempty"`
+	Status        EmailStatus `json:"status,omitempty"`
+	Attempts      int         `json:"attempts"`
+	NextAttemptAt time.Time   `json:"next_attempt_at"`
+	LastError     *string     `json:"last_error,omitempty"`
+	// rolled up from the delivery events
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	OpenedAt       *time.Time `json:"opened_at,omitempty"`
+	OpenCount      int        `json:"open_count"`
+	ClickedAt      *time.Time `json:"clicked_at,omitempty"`
+	ClickCount     int        `json:"click_count"`
+	BouncedAt      *time.Time `json:"bounced_at,omitempty"`
+	ComplainedAt   *time.Time `json:"complained_at,omitempty"`
+	UnsubscribedAt *time.Time `json:"unsubscribed_at,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
 }
 
 type VideoDetails struct {
@@ -285,13 +485,16 @@ type ModuleBanner struct {
 }
 
 type ModuleFile struct {
-	ID        int       `json:"id,omitempty"`
-	ModuleID  int       `json:"module_id,omitempty"`
-	Ranking   int       `json:"ranking,omitempty"`
-	FileID    string    `json:"file_id,omitempty"`
-	File      *File     `json:"file,omitempty"`
-	CreatedAt *NullTime `json:"created_at,omitempty"`
-	UpdatedAt *NullTime `json:"updated_at,omitempty"`
+	ID       int    `json:"id,omitempty"`
+	ModuleID int    `json:"module_id,omitempty"`
+	Ranking  int    `json:"ranking,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+	File     *File  `json:"file,omitempty"`
+	// folder the assets of an imported package are kept in, by their path
+	// within the package
+	PackagePrefix *NullString `json:"package_prefix,omitempty"`
+	CreatedAt     *NullTime   `json:"created_at,omitempty"`
+	UpdatedAt     *NullTime   `json:"updated_at,omitempty"`
 }
 
 type ModuleCategory struct {
@@ -374,6 +577,8 @@ type Module struct {
 	Ranking            int                         `json:"ranking,omitempty"`
 	Free               bool                        `json:"free,omitempty"`
 	IsActive           bool                        `json:"is_active,omitempty"`
+	UnlocksAt          *NullTime                   `json:"unlocks_at,omitempty"`
+	Locked             bool                        `json:"locked,omitempty"`
 	CreatedAt          *NullTime                   `json:"created_at,omitempty"`
 	UpdatedAt          *NullTime                   `json:"updated_at,omitempty"`
 	Banner             *ModuleBanner               `json:"module_banner,omitempty"`
@@ -390,6 +595,7 @@ type WaitlistItem struct {
 	OwnerWaitlistCode      string     `json:"owner_waitlist_code,omitempty"`
 	OriginalReferralCodeID *int       `json:"original_referral_code_id,omitempty"`
 	OriginalWaitlistCodeID *int       `json:"original_waitlist_code_id,omitempty"`
+	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
 	CreatedAt              *time.Time `json:"created_at,omitempty"`
 	UpdatedAt              *time.Time `json:"updated_at,omitempty"`
 }
@@ -430,17 +636,32 @@ type UserGoal struct {
 	Deadline    *NullTime       `json:"deadline,omitempty"`
 	CompletedAt *NullTime       `json:"completed_at,omitempty"`
 	IsActive    bool            `json:"is_active,omitempty"`
+	TemplateID  *int            `json:"template_id,omitempty"`
 	CreatedAt   *NullTime       `json:"created_at,omitempty"`
 	UpdatedAt   *NullTime       `json:"updated_at,omitempty"`
 }
 
 type GoalTemplate struct {
-	ID           int       `json:"id,omitempty"`
-	Name         string    `json:"name,omitempty"`
-	Quantitative bool      `json:"quantitative,omitempty"`
-	IsActive     bool      `json:"is_active,omitempty"`
-	CreatedAt    *NullTime `json:"created_at,omitempty"`
-	UpdatedAt    *NullTime `json:"updated_at,omitempty"`
+	ID                    int                `json:"id,omitempty"`
+	Name                  string             `json:"name,omitempty"`
+	Description           string             `json:"description,omitempty"`
+	Quantitative          bool               `json:"quantitative,omitempty"`
+	DefaultValue          decimal.Decimal    `json:"default_value,omitempty"`
+	DefaultRate           string             `json:"default_rate,omitempty"`
+	Unit                  string             `json:"unit,omitempty"`
+	SuggestedDurationDays int                `json:"suggested_duration_days,omitempty"`
+	IsActive              bool               `json:"is_active,omitempty"`
+	CreatedAt             *NullTime          `json:"created_at,omitempty"`
+	UpdatedAt             *NullTime          `json:"updated_at,omitempty"`
+	Stats                 *GoalTemplateStats `json:"stats,omitempty"`
+}
+
+type GoalTemplateStats struct {
+	TemplateID     int             `json:"template_id"`
+	Adoptions      int             `json:"adoptions"`
+	Users          int             `json:"users"`
+	Completed      int             `json:"completed"`
+	CompletionRate decimal.Decimal `json:"completion_rate"`
 }
 
 type ModuleAccessAuthorizations struct {
@@ -464,6 +685,70 @@ type QuizGrading struct {
 	UpdatedAt         *NullTime `json:"updated_at,omitempty"`
 }
 
+// ProgressEvent is a single playback event reported by a video player. Events
+// are folded into a ModuleFileProgress and later compacted away.
+type ProgressEvent struct {
+	ID             int               `json:"id,omitempty"`
+	UserID         sql.NullInt64     `json:"user_id,omitempty"`
+	DeviceUniqueID sql.NullString    `json:"device_unique_id,omitempty"`
+	ModuleID       int               `json:"module_id,omitempty"`
+	ModuleFileID   int               `json:"module_file_id,omitempty"`
+	Type           ProgressEventType `json:"type,omitempty"`
+	Position       decimal.Decimal   `json:"position,omitempty"`
+	PlaybackRate   decimal.Decimal   `json:"playback_rate,omitempty"`
+	OccurredAt     time.Time         `json:"occurred_at,omitempty"`
+	FoldedAt       *NullTime         `json:"folded_at,omitempty"`
+	CreatedAt      *NullTime         `json:"created_at,omitempty"`
+}
+
+type ProgressEventsRequest struct {
+	Events []*ProgressEvent `json:"events,omitempty"`
+}
+
+// ModuleFileProgress is the aggregated watch state of a single module file for
+// a user (or, for leads, a device).
+type ModuleFileProgress struct {
+	ID                   int              `json:"id,omitempty"`
+	UserID               sql.NullInt64    `json:"user_id,omitempty"`
+	DeviceUniqueID       sql.NullString   `json:"device_unique_id,omitempty"`
+	ModuleID             int              `json:"module_id,omitempty"`
+	ModuleFileID         int              `json:"module_file_id,omitempty"`
+	WatchedIntervals     WatchedIntervals `json:"watched_intervals,omitempty"`
+	WatchedSeconds       decimal.Decimal  `json:"watched_seconds,omitempty"`
+	CompletionPercentage decimal.Decimal  `json:"completion_percentage,omitempty"`
+	ResumePosition       decimal.Decimal  `json:"resume_position,omitempty"`
+	PlaybackRate         decimal.Decimal  `json:"playback_rate,omitempty"`
+	IsPlaying            bool             `json:"is_playing,omitempty"`
+	LastEventAt          *NullTime        `json:"last_event_at,omitempty"`
+	CompletedAt          *NullTime        `json:"completed_at,omitempty"`
+	CreatedAt            *NullTime        `json:"created_at,omitempty"`
+	UpdatedAt            *NullTime        `json:"updated_at,omitempty"`
+}
+
+type ProgressClaimSource string
+
+const (
+	ProgressClaimSource_SignUp       ProgressClaimSource = "SIGN_UP"
+	ProgressClaimSource_SocialSignUp ProgressClaimSource = "SOCIAL_SIGN_UP"
+	ProgressClaimSource_Login        ProgressClaimSource = "LOGIN"
+	ProgressClaimSource_SocialLogin  ProgressClaimSource = "SOCIAL_LOGIN"
+)
+
+// ProgressClaim is the audit record of anonymous device progress being
+// attached to a user.
+type ProgressClaim struct {
+	ID                      int                 `json:"id,omitempty"`
+	UserID                  int                 `json:"user_id,omitempty"`
+	DeviceUniqueID          string              `json:"device_unique_id,omitempty"`
+	Source                  ProgressClaimSource `json:"source,omitempty"`
+	LearningProgressClaimed int64               `json:"learning_progress_claimed"`
+	LearningProgressMerged  int64               `json:"learning_progress_merged"`
+	FileProgressClaimed     int64               `json:"file_progress_claimed"`
+	FileProgressMerged      int64               `json:"file_progress_merged"`
+	ProgressEventsClaimed   int64               `json:"progress_events_claimed"`
+	CreatedAt               *NullTime           `json:"created_at,omitempty"`
+}
+
 type LearningProgress struct {
 	ID                int             `json:"id,omitempty"`
 	UserID            sql.NullInt64   `json:"user_id,omitempty"`
@@ -476,6 +761,277 @@ type LearningProgress struct {
 	UpdatedAt         *NullTime       `json:"updated_at,omitempty"`
 }
 
+type SyncEventType string
+
+const (
+	SyncEventType_ProgressUpdate SyncEventType = "PROGRESS_UPDATE"
+	SyncEventType_QuizSubmission SyncEventType = "QUIZ_SUBMISSION"
+	SyncEventType_GoalCompletion SyncEventType = "GOAL_COMPLETION"
+)
+
+type SyncEventStatus string
+
+const (
+	SyncEventStatus_Applied   SyncEventStatus = "APPLIED"
+	SyncEventStatus_Duplicate SyncEventStatus = "DUPLICATE"
+	SyncEventStatus_Failed    SyncEventStatus = "FAILED"
+)
+
+// SyncEvent is something a client did while offline. IdempotencyKey is
+// generated by the client so that replaying a batch never applies it twice.
+type SyncEvent struct {
+	IdempotencyKey  string          `json:"idempotency_key,omitempty"`
+	Type            SyncEventType   `json:"type,omitempty"`
+	ClientCreatedAt *NullTime       `json:"client_created_at,omitempty"`
+	Payload         json.RawMessage `json:"payload,omitempty"`
+}
+
+type SyncRequest struct {
+	SyncToken string       `json:"sync_token,omitempty"`
+	Events    []*SyncEvent `json:"events,omitempty"`
+}
+
+type SyncEventResult struct {
+	IdempotencyKey  string          `json:"idempotency_key,omitempty"`
+	Type            SyncEventType   `json:"type,omitempty"`
+	Status          SyncEventStatus `json:"status,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	ServerTimestamp time.Time       `json:"server_timestamp,omitempty"`
+}
+
+// SyncChanges holds everything changed server side since a sync token.
+type SyncChanges struct {
+	LearningProgress []*LearningProgress   `json:"learning_progress,omitempty"`
+	FileProgress     []*ModuleFileProgress `json:"file_progress,omitempty"`
+	QuizGradings     []*QuizGrading        `json:"quiz_gradings,omitempty"`
+	UserGoals        []*UserGoal           `json:"user_goals,omitempty"`
+}
+
+type SyncResponse struct {
+	Results    []*SyncEventResult `json:"results,omitempty"`
+	SyncToken  string             `json:"sync_token,omitempty"`
+	ServerTime time.Time          `json:"server_time,omitempty"`
+	Changes    *SyncChanges       `json:"changes,omitempty"`
+}
+
+type GoalCompletionPayload struct {
+	GoalID int `json:"goal_id,omitempty"`
+}
+
+type XPEventType string
+
+const (
+	XPEventType_ModuleFileCompleted XPEventType = "MODULE_FILE_COMPLETED"
+	XPEventType_QuizPassedFirstTake XPEventType = "QUIZ_PASSED_FIRST_TAKE"
+	XPEventType_GoalCompleted       XPEventType = "GOAL_COMPLETED"
+	XPEventType_ReferralRedeemed    XPEventType = "REFERRAL_REDEEMED"
+	XPEventType_ReferralSignUp      XPEventType = "REFERRAL_SIGN_UP"
+)
+
+func (w XPEventType) String() string {
+	switch w {
+	case XPEventType_ModuleFileCompleted:
+		return "MODULE_FILE_COMPLETED"
+	case XPEventType_QuizPassedFirstTake:
+		return "QUIZ_PASSED_FIRST_TAKE"
+	case XPEventType_GoalCompleted:
+		return "GOAL_COMPLETED"
+	case XPEventType_ReferralRedeemed:
+		return "REFERRAL_REDEEMED"
+	case XPEventType_ReferralSignUp:
+		return "REFERRAL_SIGN_UP"
+	}
+	return ""
+}
+
+func (e *XPEventType) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "MODULE_FILE_COMPLETED":
+		*e = XPEventType_ModuleFileCompleted
+
+	case "QUIZ_PASSED_FIRST_TAKE":
+		*e = XPEventType_QuizPassedFirstTake
+
+	case "GOAL_COMPLETED":
+		*e = XPEventType_GoalCompleted
+
+	case "REFERRAL_REDEEMED":
+		*e = XPEventType_ReferralRedeemed
+
+	case "REFERRAL_SIGN_UP":
+		*e = XPEventType_ReferralSignUp
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (e XPEventType) Value() (driver.Value, error) {
+	switch e {
+
+	case XPEventType_ModuleFileCompleted:
+		return driver.Value("MODULE_FILE_COMPLETED"), nil
+
+	case XPEventType_QuizPassedFirstTake:
+		return driver.Value("QUIZ_PASSED_FIRST_TAKE"), nil
+
+	case XPEventType_GoalCompleted:
+		return driver.Value("GOAL_COMPLETED"), nil
+
+	case XPEventType_ReferralRedeemed:
+		return driver.Value("REFERRAL_REDEEMED"), nil
+
+	case XPEventType_ReferralSignUp:
+		return driver.Value("REFERRAL_SIGN_UP"), nil
+
+	}
+	return nil, fmt.Errorf("Value: no enum str for %v", e)
+}
+
+type BadgeConditionType string
+
+const (
+	// the user has recorded at least threshold events of the badge event type
+	BadgeConditionType_EventCount BadgeConditionType = "EVENT_COUNT"
+	// the user has earned at least threshold xp
+	BadgeConditionType_TotalXP BadgeConditionType = "TOTAL_XP"
+	// the user has reached at least level threshold
+	BadgeConditionType_Level BadgeConditionType = "LEVEL"
+)
+
+func (w BadgeConditionType) String() string {
+	switch w {
+	case BadgeConditionType_EventCount:
+		return "EVENT_COUNT"
+	case BadgeConditionType_TotalXP:
+		return "TOTAL_XP"
+	case BadgeConditionType_Level:
+		return "LEVEL"
+	}
+	return ""
+}
+
+func (e *BadgeConditionType) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "EVENT_COUNT":
+		*e = BadgeConditionType_EventCount
+
+	case "TOTAL_XP":
+		*e = BadgeConditionType_TotalXP
+
+	case "LEVEL":
+		*e = BadgeConditionType_Level
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (e BadgeConditionType) Value() (driver.Value, error) {
+	switch e {
+
+	case BadgeConditionType_EventCount:
+		return driver.Value("EVENT_COUNT"), nil
+
+	case BadgeConditionType_TotalXP:
+		return driver.Value("TOTAL_XP"), nil
+
+	case BadgeConditionType_Level:
+		return driver.Value("LEVEL"), nil
+
+	}
+	return nil, fmt.Errorf("Value: no enum str for %v", e)
+}
+
+// XPRule is how much xp an event type is worth.
+type XPRule struct {
+	EventType XPEventType `json:"event_type,omitempty"`
+	XP        int         `json:"xp"`
+	IsActive  bool        `json:"is_active"`
+	CreatedAt *NullTime   `json:"created_at,omitempty"`
+	UpdatedAt *NullTime   `json:"updated_at,omitempty"`
+}
+
+// XPEntry is a line of the xp ledger, a source is only ever rewarded once.
+type XPEntry struct {
+	ID        int         `json:"id,omitempty"`
+	UserID    int         `json:"user_id,omitempty"`
+	EventType XPEventType `json:"event_type,omitempty"`
+	SourceKey string      `json:"source_key,omitempty"`
+	XP        int         `json:"xp"`
+	CreatedAt *NullTime   `json:"created_at,omitempty"`
+}
+
+type Level struct {
+	Level      int    `json:"level,omitempty"`
+	Name       string `json:"name,omitempty"`
+	XPRequired int    `json:"xp_required"`
+}
+
+type Badge struct {
+	ID            int                `json:"id,omitempty"`
+	Code          string             `json:"code,omitempty"`
+	Name          string             `json:"name,omitempty"`
+	Description   string             `json:"description,omitempty"`
+	ConditionType BadgeConditionType `json:"condition_type,omitempty"`
+	EventType     *XPEventType       `json:"event_type,omitempty"`
+	Threshold     int                `json:"threshold,omitempty"`
+	IsActive      bool               `json:"is_active,omitempty"`
+	CreatedAt     *NullTime          `json:"created_at,omitempty"`
+	UpdatedAt     *NullTime          `json:"updated_at,omitempty"`
+}
+
+type UserBadge struct {
+	*Badge   `json:"badge,omitempty"`
+	EarnedAt *NullTime `json:"earned_at,omitempty"`
+}
+
+// XPTotals sums up the xp ledger of a user.
+type XPTotals struct {
+	TotalXP     int                 `json:"total_xp"`
+	EventCounts map[XPEventType]int `json:"event_counts,omitempty"`
+}
+
+type Achievements struct {
+	TotalXP       int          `json:"total_xp"`
+	Level         *Level       `json:"level,omitempty"`
+	NextLevel     *Level       `json:"next_level,omitempty"`
+	XPToNextLevel int          `json:"xp_to_next_level"`
+	Badges        []*UserBadge `json:"badges"`
+	RecentXP      []*XPEntry   `json:"recent_xp"`
+}
+
+type GamificationConfig struct {
+	Rules  []*XPRule `json:"rules"`
+	Levels []*Level  `json:"levels"`
+	Badges []*Badge  `json:"badges"`
+}
+
 type ProfileImage struct {
 	ID        int       `json:"id,omitempty"`
 	UserID    int       `json:"user_id,omitempty"`
@@ -510,6 +1066,7 @@ type User struct {
 	Sports         string        `json:"sports,omitempty"`
 	UserType       string        `json:"user_type,omitempty"`
 	Hashtags       string        `json:"hashtags,omitempty"`
+	Timezone       string        `json:"timezone,omitempty"`
 	IsActive       bool          `json:"is_active,omitempty"`
 	LastOnline     *NullTime     `json:"last_online,omitempty"`
 	UserAdminLevel string        `json:"user_admin_level,omitempty"`
@@ -525,6 +1082,8 @@ type User struct {
 	UserGoals                  []*UserGoal                   `json:"user_goals,omitempty"`
 	CompletedModuleIDs         []int                         `json:"completed_module_ids,omitempty"`
 	ReferralCode               *ReferralCode                 `json:"referral_code,omitempty"`
+	Achievements               *Achievements                 `json:"achievements,omitempty"`
+	LeaderboardOptOut          bool                          `json:"leaderboard_opt_out,omitempty"`
 }
 
 type NewUser struct {
@@ -533,6 +1092,7 @@ type NewUser struct {
 	FirstName    string `json:"first_name,omitempty"`
 	LastName     string `json:"last_name,omitempty"`
 	ReferralCode string `json:"referral_code,omitempty"`
+	InviteToken  string `json:"invite_token,omitempty"`
 }
 
 func (n *NewUser) IsValid() (bool, error) {
@@ -551,3 +1111,1046 @@ func (n *NewUser) IsValid() (bool, error) {
 
 	return true, nil
 }
+
+type Streak struct {
+	UserID           int        `json:"user_id,omitempty"`
+	CurrentStreak    int        `json:"current_streak"`
+	LongestStreak    int        `json:"longest_streak"`
+	FreezesAvailable int        `json:"freezes_available"`
+	FreezesUsed      int        `json:"freezes_used"`
+	LastActivityDate *Date      `json:"last_activity_date,omitempty"`
+	ActiveToday      bool       `json:"active_today"`
+	AtRisk           bool       `json:"at_risk"`
+	CreatedAt        *time.Time `json:"created_at,omitempty"`
+	UpdatedAt        *time.Time `json:"updated_at,omitempty"`
+}
+
+type StreakReminder struct {
+	*Streak        `json:"streak"`
+	Email          string `json:"email"`
+	FirstName      string `json:"first_name"`
+	Timezone       string `json:"timezone"`
+	ReminderSentOn *Date  `json:"reminder_sent_on"`
+}
+
+type LeaderboardMetric string
+
+const (
+	LeaderboardMetric_XP            LeaderboardMetric = "XP"
+	LeaderboardMetric_QuizzesPassed LeaderboardMetric = "QUIZZES_PASSED"
+	LeaderboardMetric_WatchTime     LeaderboardMetric = "WATCH_TIME"
+)
+
+func (l LeaderboardMetric) String() string {
+	switch l {
+	case LeaderboardMetric_XP:
+		return "XP"
+	case LeaderboardMetric_QuizzesPassed:
+		return "QUIZZES_PASSED"
+	case LeaderboardMetric_WatchTime:
+		return "WATCH_TIME"
+	}
+	return ""
+}
+
+func (l *LeaderboardMetric) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "XP":
+		*l = LeaderboardMetric_XP
+
+	case "QUIZZES_PASSED":
+		*l = LeaderboardMetric_QuizzesPassed
+
+	case "WATCH_TIME":
+		*l = LeaderboardMetric_WatchTime
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (l LeaderboardMetric) Value() (driver.Value, error) {
+	switch l {
+
+	case LeaderboardMetric_XP:
+		return driver.Value("XP"), nil
+
+	case LeaderboardMetric_QuizzesPassed:
+		return driver.Value("QUIZZES_PASSED"), nil
+
+	case LeaderboardMetric_WatchTime:
+		return driver.Value("WATCH_TIME"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", l)
+	}
+}
+
+type LeaderboardScope string
+
+const (
+	LeaderboardScope_Global   LeaderboardScope = "GLOBAL"
+	LeaderboardScope_Module   LeaderboardScope = "MODULE"
+	LeaderboardScope_Category LeaderboardScope = "CATEGORY"
+	LeaderboardScope_Team     LeaderboardScope = "TEAM"
+)
+
+func (l LeaderboardScope) String() string {
+	switch l {
+	case LeaderboardScope_Global:
+		return "GLOBAL"
+	case LeaderboardScope_Module:
+		return "MODULE"
+	case LeaderboardScope_Category:
+		return "CATEGORY"
+	case LeaderboardScope_Team:
+		return "TEAM"
+	}
+	return ""
+}
+
+func (l *LeaderboardScope) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "GLOBAL":
+		*l = LeaderboardScope_Global
+
+	case "MODULE":
+		*l = LeaderboardScope_Module
+
+	case "CATEGORY":
+		*l = LeaderboardScope_Category
+
+	case "TEAM":
+		*l = LeaderboardScope_Team
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (l LeaderboardScope) Value() (driver.Value, error) {
+	switch l {
+
+	case LeaderboardScope_Global:
+		return driver.Value("GLOBAL"), nil
+
+	case LeaderboardScope_Module:
+		return driver.Value("MODULE"), nil
+
+	case LeaderboardScope_Category:
+		return driver.Value("CATEGORY"), nil
+
+	case LeaderboardScope_Team:
+		return driver.Value("TEAM"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", l)
+	}
+}
+
+type LeaderboardPeriod string
+
+const (
+	LeaderboardPeriod_Weekly  LeaderboardPeriod = "WEEKLY"
+	LeaderboardPeriod_Monthly LeaderboardPeriod = "MONTHLY"
+	LeaderboardPeriod_AllTime LeaderboardPeriod = "ALL_TIME"
+)
+
+func (l LeaderboardPeriod) String() string {
+	switch l {
+	case LeaderboardPeriod_Weekly:
+		return "WEEKLY"
+	case LeaderboardPeriod_Monthly:
+		return "MONTHLY"
+	case LeaderboardPeriod_AllTime:
+		return "ALL_TIME"
+	}
+	return ""
+}
+
+func (l *LeaderboardPeriod) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "WEEKLY":
+		*l = LeaderboardPeriod_Weekly
+
+	case "MONTHLY":
+		*l = LeaderboardPeriod_Monthly
+
+	case "ALL_TIME":
+		*l = LeaderboardPeriod_AllTime
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (l LeaderboardPeriod) Value() (driver.Value, error) {
+	switch l {
+
+	case LeaderboardPeriod_Weekly:
+		return driver.Value("WEEKLY"), nil
+
+	case LeaderboardPeriod_Monthly:
+		return driver.Value("MONTHLY"), nil
+
+	case LeaderboardPeriod_AllTime:
+		return driver.Value("ALL_TIME"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", l)
+	}
+}
+
+type Leaderboard struct {
+	Metric      LeaderboardMetric   `json:"metric"`
+	Scope       LeaderboardScope    `json:"scope"`
+	ScopeID     int                 `json:"scope_id,omitempty"`
+	Period      LeaderboardPeriod   `json:"period"`
+	PeriodStart Date                `json:"period_start"`
+	Total       int                 `json:"total"`
+	Entries     []*LeaderboardEntry `json:"entries"`
+	Self        *LeaderboardEntry   `json:"self,omitempty"`
+	AroundSelf  []*LeaderboardEntry `json:"around_self,omitempty"`
+}
+
+type Team struct {
+	ID        int       `json:"id,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt *NullTime `json:"created_at,omitempty"`
+	UpdatedAt *NullTime `json:"updated_at,omitempty"`
+}
+
+type TeamMember struct {
+	TeamID    int       `json:"team_id"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt *NullTime `json:"created_at,omitempty"`
+}
+
+type LeaderboardEntry struct {
+	UserID      int     `json:"user_id"`
+	DisplayName string  `json:"display_name"`
+	Score       float64 `json:"score"`
+	Rank        int     `json:"rank"`
+	Position    int     `json:"position"`
+}
+
+type GoalPeriodStatus string
+
+const (
+	GoalPeriodStatus_InProgress GoalPeriodStatus = "IN_PROGRESS"
+	GoalPeriodStatus_Completed  GoalPeriodStatus = "COMPLETED"
+	GoalPeriodStatus_Missed     GoalPeriodStatus = "MISSED"
+)
+
+func (g GoalPeriodStatus) String() string {
+	switch g {
+	case GoalPeriodStatus_InProgress:
+		return "IN_PROGRESS"
+	case GoalPeriodStatus_Completed:
+		return "COMPLETED"
+	case GoalPeriodStatus_Missed:
+		return "MISSED"
+	}
+	return ""
+}
+
+func (g *GoalPeriodStatus) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "IN_PROGRESS":
+		*g = GoalPeriodStatus_InProgress
+
+	case "COMPLETED":
+		*g = GoalPeriodStatus_Completed
+
+	case "MISSED":
+		*g = GoalPeriodStatus_Missed
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (g GoalPeriodStatus) Value() (driver.Value, error) {
+	switch g {
+
+	case GoalPeriodStatus_InProgress:
+		return driver.Value("IN_PROGRESS"), nil
+
+	case GoalPeriodStatus_Completed:
+		return driver.Value("COMPLETED"), nil
+
+	case GoalPeriodStatus_Missed:
+		return driver.Value("MISSED"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", g)
+	}
+}
+
+type GoalCheckIn struct {
+	ID          int             `json:"id,omitempty"`
+	GoalID      int             `json:"goal_id,omitempty"`
+	UserID      int             `json:"user_id,omitempty"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	Note        string          `json:"note,omitempty"`
+	PeriodStart Date            `json:"period_start"`
+	CheckedInAt *time.Time      `json:"checked_in_at,omitempty"`
+	CreatedAt   *time.Time      `json:"created_at,omitempty"`
+}
+
+type GoalPeriod struct {
+	GoalID      int              `json:"goal_id,omitempty"`
+	PeriodStart Date             `json:"period_start"`
+	PeriodEnd   *Date            `json:"period_end,omitempty"`
+	Total       decimal.Decimal  `json:"total"`
+	Target      decimal.Decimal  `json:"target"`
+	Progress    decimal.Decimal  `json:"progress"`
+	Status      GoalPeriodStatus `json:"status"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	UpdatedAt   *time.Time       `json:"updated_at,omitempty"`
+}
+
+type GoalHistory struct {
+	Goal    *UserGoal     `json:"goal"`
+	Periods []*GoalPeriod `json:"periods"`
+}
+
+type RecurringGoal struct {
+	*UserGoal `json:"goal"`
+	Timezone  string `json:"timezone"`
+}
+
+type GoalReminderKind string
+
+const (
+	GoalReminderKind_Deadline      GoalReminderKind = "DEADLINE"
+	GoalReminderKind_PeriodClosing GoalReminderKind = "PERIOD_CLOSING"
+)
+
+func (g GoalReminderKind) String() string {
+	switch g {
+	case GoalReminderKind_Deadline:
+		return "DEADLINE"
+	case GoalReminderKind_PeriodClosing:
+		return "PERIOD_CLOSING"
+	}
+	return ""
+}
+
+func (g *GoalReminderKind) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "DEADLINE":
+		*g = GoalReminderKind_Deadline
+
+	case "PERIOD_CLOSING":
+		*g = GoalReminderKind_PeriodClosing
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (g GoalReminderKind) Value() (driver.Value, error) {
+	switch g {
+
+	case GoalReminderKind_Deadline:
+		return driver.Value("DEADLINE"), nil
+
+	case GoalReminderKind_PeriodClosing:
+		return driver.Value("PERIOD_CLOSING"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", g)
+	}
+}
+
+type GoalReminderSettings struct {
+	UserID          int        `json:"user_id,omitempty"`
+	ReminderTime    ClockTime  `json:"reminder_time"`
+	QuietHoursStart *ClockTime `json:"quiet_hours_start,omitempty"`
+	QuietHoursEnd   *ClockTime `json:"quiet_hours_end,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+type GoalReminderCandidate struct {
+	*UserGoal             `json:"goal"`
+	*GoalReminderSettings `json:"settings"`
+	Email                 string `json:"email"`
+	FirstName             string `json:"first_name"`
+	Timezone              string `json:"timezone"`
+}
+
+type CalendarFeed struct {
+	UserID    int        `json:"user_id,omitempty"`
+	Token     string     `json:"token"`
+	URL       string     `json:"url"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type ModuleUnlock struct {
+	ModuleID  int       `json:"module_id"`
+	Name      string    `json:"name"`
+	UnlocksAt time.Time `json:"unlocks_at"`
+}
+
+type WaitlistStatus struct {
+	WaitlistCode  string `json:"owner_waitlist_code"`
+	Position      int    `json:"position"`
+	ReferralCount int    `json:"referral_count"`
+	PeopleBehind  int    `json:"people_behind"`
+	Total         int    `json:"total"`
+}
+
+type WaitlistInviteWaveRequest struct {
+	Name   string   `json:"name"`
+	Top    int      `json:"top,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+}
+
+type WaitlistInviteWave struct {
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	CreatedBy      *int            `json:"created_by,omitempty"`
+	Invited        int             `json:"invited"`
+	SignedUp       int             `json:"signed_up"`
+	ConversionRate decimal.Decimal `json:"conversion_rate"`
+	CreatedAt      *time.Time      `json:"created_at,omitempty"`
+}
+
+type WaitlistInvite struct {
+	ID           int        `json:"id"`
+	WaveID       int        `json:"wave_id"`
+	WaitlistID   int        `json:"waitlist_id"`
+	EmailAddress string     `json:"email_address"`
+	Token        string     `json:"token"`
+	UserID       *int       `json:"user_id,omitempty"`
+	RedeemedAt   *time.Time `json:"redeemed_at,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+}
+
+type WaitlistSource string
+
+const (
+	WaitlistSource_WaitlistCode WaitlistSource = "WAITLIST_CODE"
+	WaitlistSource_ReferralCode WaitlistSource = "REFERRAL_CODE"
+	WaitlistSource_Direct       WaitlistSource = "DIRECT"
+)
+
+func (w WaitlistSource) String() string {
+	switch w {
+	case WaitlistSource_WaitlistCode:
+		return "WAITLIST_CODE"
+	case WaitlistSource_ReferralCode:
+		return "REFERRAL_CODE"
+	case WaitlistSource_Direct:
+		return "DIRECT"
+	}
+	return ""
+}
+
+func (w *WaitlistSource) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "WAITLIST_CODE":
+		*w = WaitlistSource_WaitlistCode
+
+	case "REFERRAL_CODE":
+		*w = WaitlistSource_ReferralCode
+
+	case "DIRECT":
+		*w = WaitlistSource_Direct
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (w WaitlistSource) Value() (driver.Value, error) {
+	switch w {
+
+	case WaitlistSource_WaitlistCode:
+		return driver.Value("WAITLIST_CODE"), nil
+
+	case WaitlistSource_ReferralCode:
+		return driver.Value("REFERRAL_CODE"), nil
+
+	case WaitlistSource_Direct:
+		return driver.Value("DIRECT"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", w)
+	}
+}
+
+type WaitlistEntry struct {
+	*WaitlistItem
+	UserID         *int           `json:"user_id,omitempty"`
+	Source         WaitlistSource `json:"source"`
+	ReferredByCode *string        `json:"referred_by_code,omitempty"`
+	ReferralCount  int            `json:"referral_count"`
+}
+
+type WaitlistFilter struct {
+	Search     string
+	From       *time.Time
+	Until      *time.Time
+	Source     WaitlistSource
+	ReferredBy string
+	Limit      *int
+	Offset     int
+}
+
+type WaitlistReferralNode struct {
+	Entry     *WaitlistEntry          `json:"entry"`
+	Referrals []*WaitlistReferralNode `json:"referrals"`
+}
+
+type WaitlistSummary struct {
+	Total          int `json:"total"`
+	Referred       int `json:"referred"`
+	JoinedLastWeek int `json:"joined_last_week"`
+}
+
+type ReferralRewardRecipient string
+
+const (
+	ReferralRewardRecipient_Referrer ReferralRewardRecipient = "REFERRER"
+	ReferralRewardRecipient_Referee  ReferralRewardRecipient = "REFEREE"
+)
+
+func (r ReferralRewardRecipient) String() string {
+	switch r {
+	case ReferralRewardRecipient_Referrer:
+		return "REFERRER"
+	case ReferralRewardRecipient_Referee:
+		return "REFEREE"
+	}
+	return ""
+}
+
+func (r *ReferralRewardRecipient) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "REFERRER":
+		*r = ReferralRewardRecipient_Referrer
+
+	case "REFEREE":
+		*r = ReferralRewardRecipient_Referee
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (r ReferralRewardRecipient) Value() (driver.Value, error) {
+	switch r {
+
+	case ReferralRewardRecipient_Referrer:
+		return driver.Value("REFERRER"), nil
+
+	case ReferralRewardRecipient_Referee:
+		return driver.Value("REFEREE"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", r)
+	}
+}
+
+type ReferralRewardType string
+
+const (
+	ReferralRewardType_XP           ReferralRewardType = "XP"
+	ReferralRewardType_ModuleUnlock ReferralRewardType = "MODULE_UNLOCK"
+)
+
+func (r ReferralRewardType) String() string {
+	switch r {
+	case ReferralRewardType_XP:
+		return "XP"
+	case ReferralRewardType_ModuleUnlock:
+		return "MODULE_UNLOCK"
+	}
+	return ""
+}
+
+func (r *ReferralRewardType) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "XP":
+		*r = ReferralRewardType_XP
+
+	case "MODULE_UNLOCK":
+		*r = ReferralRewardType_ModuleUnlock
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (r ReferralRewardType) Value() (driver.Value, error) {
+	switch r {
+
+	case ReferralRewardType_XP:
+		return driver.Value("XP"), nil
+
+	case ReferralRewardType_ModuleUnlock:
+		return driver.Value("MODULE_UNLOCK"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", r)
+	}
+}
+
+type ReferralCodeKind string
+
+const (
+	ReferralCodeKind_User     ReferralCodeKind = "USER"
+	ReferralCodeKind_Campaign ReferralCodeKind = "CAMPAIGN"
+	ReferralCodeKind_Vanity   ReferralCodeKind = "VANITY"
+)
+
+func (k ReferralCodeKind) String() string {
+	switch k {
+	case ReferralCodeKind_User:
+		return "USER"
+	case ReferralCodeKind_Campaign:
+		return "CAMPAIGN"
+	case ReferralCodeKind_Vanity:
+		return "VANITY"
+	}
+	return ""
+}
+
+func (k *ReferralCodeKind) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "USER":
+		*k = ReferralCodeKind_User
+
+	case "CAMPAIGN":
+		*k = ReferralCodeKind_Campaign
+
+	case "VANITY":
+		*k = ReferralCodeKind_Vanity
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (k ReferralCodeKind) Value() (driver.Value, error) {
+	switch k {
+
+	case ReferralCodeKind_User:
+		return driver.Value("USER"), nil
+
+	case ReferralCodeKind_Campaign:
+		return driver.Value("CAMPAIGN"), nil
+
+	case ReferralCodeKind_Vanity:
+		return driver.Value("VANITY"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", k)
+	}
+}
+
+type ReferralCampaignRequest struct {
+	ReferralCode string           `json:"referral_code,omitempty"`
+	Kind         ReferralCodeKind `json:"kind,omitempty"`
+	Name         string           `json:"name,omitempty"`
+	ReferralType ReferralType     `json:"referral_type,omitempty"`
+	Value        int              `json:"value,omitempty"`
+	StartsAt     *time.Time       `json:"starts_at,omitempty"`
+	EndsAt       *time.Time       `json:"ends_at,omitempty"`
+	ModuleID     *int             `json:"module_id,omitempty"`
+	// the influencer owning a vanity code, rewarded as its referrer
+	UserID   *int  `json:"user_id,omitempty"`
+	IsActive *bool `json:"is_active,omitempty"`
+}
+
+// ReferralCampaignFunnel follows the people a referral code brought in, from
+// joining the waitlist with it to signing up and getting started.
+type ReferralCampaignFunnel struct {
+	ReferralCodeID    int  `json:"referral_code_id"`
+	WaitlistJoined    int  `json:"waitlist_joined"`
+	WaitlistConfirmed int  `json:"waitlist_confirmed"`
+	WaitlistInvited   int  `json:"waitlist_invited"`
+	SignedUp          int  `json:"signed_up"`
+	Redemptions       int  `json:"redemptions"`
+	Active            int  `json:"active"`
+	RemainingUses     *int `json:"remaining_uses,omitempty"`
+}
+
+type EmailEventType string
+
+const (
+	EmailEventType_Delivered    EmailEventType = "DELIVERED"
+	EmailEventType_Failed       EmailEventType = "FAILED"
+	EmailEventType_Bounced      EmailEventType = "BOUNCED"
+	EmailEventType_Complained   EmailEventType = "COMPLAINED"
+	EmailEventType_Opened       EmailEventType = "OPENED"
+	EmailEventType_Clicked      EmailEventType = "CLICKED"
+	EmailEventType_Unsubscribed EmailEventType = "UNSUBSCRIBED"
+)
+
+func (e EmailEventType) String() string {
+	switch e {
+	case EmailEventType_Delivered:
+		return "DELIVERED"
+	case EmailEventType_Failed:
+		return "FAILED"
+	case EmailEventType_Bounced:
+		return "BOUNCED"
+	case EmailEventType_Complained:
+		return "COMPLAINED"
+	case EmailEventType_Opened:
+		return "OPENED"
+	case EmailEventType_Clicked:
+		return "CLICKED"
+	case EmailEventType_Unsubscribed:
+		return "UNSUBSCRIBED"
+	}
+	return ""
+}
+
+func (e *EmailEventType) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "DELIVERED":
+		*e = EmailEventType_Delivered
+
+	case "FAILED":
+		*e = EmailEventType_Failed
+
+	case "BOUNCED":
+		*e = EmailEventType_Bounced
+
+	case "COMPLAINED":
+		*e = EmailEventType_Complained
+
+	case "OPENED":
+		*e = EmailEventType_Opened
+
+	case "CLICKED":
+		*e = EmailEventType_Clicked
+
+	case "UNSUBSCRIBED":
+		*e = EmailEventType_Unsubscribed
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (e EmailEventType) Value() (driver.Value, error) {
+	switch e {
+
+	case EmailEventType_Delivered:
+		return driver.Value("DELIVERED"), nil
+
+	case EmailEventType_Failed:
+		return driver.Value("FAILED"), nil
+
+	case EmailEventType_Bounced:
+		return driver.Value("BOUNCED"), nil
+
+	case EmailEventType_Complained:
+		return driver.Value("COMPLAINED"), nil
+
+	case EmailEventType_Opened:
+		return driver.Value("OPENED"), nil
+
+	case EmailEventType_Clicked:
+		return driver.Value("CLICKED"), nil
+
+	case EmailEventType_Unsubscribed:
+		return driver.Value("UNSUBSCRIBED"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", e)
+	}
+}
+
+type EmailSuppressionReason string
+
+const (
+	EmailSuppressionReason_Bounce      EmailSuppressionReason = "BOUNCE"
+	EmailSuppressionReason_Complaint   EmailSuppressionReason = "COMPLAINT"
+	EmailSuppressionReason_Unsubscribe EmailSuppressionReason = "UNSUBSCRIBE"
+)
+
+func (r EmailSuppressionReason) String() string {
+	switch r {
+	case EmailSuppressionReason_Bounce:
+		return "BOUNCE"
+	case EmailSuppressionReason_Complaint:
+		return "COMPLAINT"
+	case EmailSuppressionReason_Unsubscribe:
+		return "UNSUBSCRIBE"
+	}
+	return ""
+}
+
+func (r *EmailSuppressionReason) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "BOUNCE":
+		*r = EmailSuppressionReason_Bounce
+
+	case "COMPLAINT":
+		*r = EmailSuppressionReason_Complaint
+
+	case "UNSUBSCRIBE":
+		*r = EmailSuppressionReason_Unsubscribe
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (r EmailSuppressionReason) Value() (driver.Value, error) {
+	switch r {
+
+	case EmailSuppressionReason_Bounce:
+		return driver.Value("BOUNCE"), nil
+
+	case EmailSuppressionReason_Complaint:
+		return driver.Value("COMPLAINT"), nil
+
+	case EmailSuppressionReason_Unsubscribe:
+		return driver.Value("UNSUBSCRIBE"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", r)
+	}
+}
+
+// EmailEvent is something that happened to an email after it was sent, as
+// reported by the email provider.
+type EmailEvent struct {
+	ID              int            `json:"id"`
+	ProviderEventID string         `json:"provider_event_id"`
+	EmailID         *int           `json:"email_id,omitempty"`
+	EmailAddress    string         `json:"email_address"`
+	Event           EmailEventType `json:"event"`
+	Severity        *string        `json:"severity,omitempty"`
+	Reason          *string        `json:"reason,omitempty"`
+	URL             *string        `json:"url,omitempty"`
+	OccurredAt      time.Time      `json:"occurred_at"`
+}
+
+// EmailSuppression is an address emails aren't sent to anymore.
+type EmailSuppression struct {
+	EmailAddress string                 `json:"email_address"`
+	Reason       EmailSuppressionReason `json:"reason"`
+	EmailEventID *int                   `json:"email_event_id,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+type EmailCategory string
+
+const (
+	EmailCategory_Transactional EmailCategory = "TRANSACTIONAL"
+	EmailCategory_Waitlist      EmailCategory = "WAITLIST"
+	EmailCategory_Reminders     EmailCategory = "REMINDERS"
+	EmailCategory_Marketing     EmailCategory = "MARKETING"
+)
+
+func (c EmailCategory) String() string {
+	switch c {
+	case EmailCategory_Transactional:
+		return "TRANSACTIONAL"
+	case EmailCategory_Waitlist:
+		return "WAITLIST"
+	case EmailCategory_Reminders:
+		return "REMINDERS"
+	case EmailCategory_Marketing:
+		return "MARKETING"
+	}
+	return ""
+}
+
+func (c *EmailCategory) Scan(src interface{}) error {
+	var srcStr string
+	switch src.(type) {
+	case string:
+		srcStr = src.(string)
+	case []byte:
+		srcStr = string(src.([]byte))
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+
+	switch srcStr {
+
+	case "TRANSACTIONAL":
+		*c = EmailCategory_Transactional
+
+	case "WAITLIST":
+		*c = EmailCategory_Waitlist
+
+	case "REMINDERS":
+		*c = EmailCategory_Reminders
+
+	case "MARKETING":
+		*c = EmailCategory_Marketing
+
+	default:
+		return fmt.Errorf("Scan: no enum str for %v", src)
+	}
+	return nil
+}
+
+func (c EmailCategory) Value() (driver.Value, error) {
+	switch c {
+
+	case EmailCategory_Transactional:
+		return driver.Value("TRANSACTIONAL"), nil
+
+	case EmailCategory_Waitlist:
+		return driver.Value("WAITLIST"), nil
+
+	case EmailCategory_Reminders:
+		return driver.Value("REMINDERS"), nil
+
+	case EmailCategory_Marketing:
+		return driver.Value("MARKETING"), nil
+
+	default:
+		return driver.Value(""), fmt.Errorf("Value: no val for %v", c)
+	}
+}
+
+// NotificationSettings are what a user wants to hear about, every email
+// category but transactional ones can be turned off.
+type NotificationSettings struct {
+	Email map[EmailCategory]bool `json:"email"`
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
@@ -158,6 +159,12 @@ func (e *External) HandleUserUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user.ID = r.Context().Value("user_id").(int)
+	if user.Timezone != "" {
+		if _, err := time.LoadLocation(user.Timezone); err != nil {
+			e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid timezone: %q", user.Timezone))
+			return
+		}
+	}
 	e.log.WithFields(logrus.Fields{
 		"user": user,
 		// "struser": string(r.Body),
@@ -241,6 +248,12 @@ func (e *External) GetUserByID(userID int, deviceUniqueID string) (*User, error)
 	}
 	user.ReferralCode = referralCode
 
+	achievements, err := GetAchievements(e.dao.ReadDB, userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting achievements by user id: %d", userID)
+	}
+	user.Achievements = achievements
+
 	return user, nil
 }
 
@@ -252,6 +265,24 @@ func (e *External) HandleGoalCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	nG.UserID = r.Context().Value("user_id").(int)
+	rate, err := validGoalRate(nG.Rate)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	nG.Rate = rate
+
+	if nG.TemplateID != nil {
+		t, err := GetGoalTemplateByID(e.dao.ReadDB, *nG.TemplateID)
+		if err == sql.ErrNoRows || (err == nil && !t.IsActive) {
+			e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("unknown goal template: %d", *nG.TemplateID))
+			return
+		} else if err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal template"))
+			return
+		}
+		GoalFromTemplate(nG, t, time.Now())
+	}
 
 	if err := CreateGoal(e.dao.DB, nG); err != nil {
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating goal"))
@@ -268,15 +299,59 @@ func (e *External) HandleGoalComplete(w http.ResponseWriter, r *http.Request) {
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "parsing goal id"))
 		return
 	}
-	// userID := ctx.Value("user_id").(int)
-	if err := CompleteGoal(e.dao.DB, goalID); err != nil {
+	userID := r.Context().Value("user_id").(int)
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	found, err := CompleteGoal(tx, goalID, userID)
+	if err != nil {
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "completing goal"))
 		return
 	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal: %d", goalID))
+		return
+	}
+	if err := recordGoalCompletion(tx, goalID, userID); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "recording goal completion"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting goal completion"))
+		return
+	}
 
 	e.returnJSON(w, nil)
 }
 
+// recordGoalCompletion records the completion of a goal, once it has been
+// marked as completed, as a statement and rewards it with xp.
+func recordGoalCompletion(q Q, goalID, userID int) error {
+	g, err := GetGoalByID(q, goalID, userID)
+	if err != nil {
+		return errors.Wrap(err, "getting goal")
+	}
+	if err := recordXAPIStatement(q, NewGoalCompletedStatement(g)); err != nil {
+		return errors.Wrap(err, "recording goal completed statement")
+	}
+	if _, err := RecordXPEvent(
+		q, g.UserID, XPEventType_GoalCompleted, fmt.Sprintf("goal:%d", g.ID), 0,
+	); err != nil {
+		return errors.Wrap(err, "recording goal xp")
+	}
+	if err := RecordLearningActivity(q, g.UserID, time.Now()); err != nil {
+		return errors.Wrap(err, "recording learning activity")
+	}
+
+	return nil
+}
+
 func (e *External) HandleGoalIncomplete(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	goalID, err := strconv.Atoi(params["id"])
@@ -285,11 +360,103 @@ func (e *External) HandleGoalIncomplete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// userID := ctx.Value("user_id").(int)
-	if err := IncompleteGoal(e.dao.DB, goalID); err != nil {
+	found, err := IncompleteGoal(e.dao.DB, goalID, r.Context().Value("user_id").(int))
+	if err != nil {
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "incompleting goal"))
 		return
 	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal: %d", goalID))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
+
+// HandleGoalUpdate edits an active goal, fields missing from the request keep
+// their current value.
+func (e *External) HandleGoalUpdate(w http.ResponseWriter, r *http.Request) {
+	goalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing goal id"))
+		return
+	}
+	userID := r.Context().Value("user_id").(int)
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	g, err := GetGoalByID(tx, goalID, userID)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal: %d", goalID))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal"))
+		return
+	}
+	if !g.IsActive {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("goal %d is archived", goalID))
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(g); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	g.ID = goalID
+	g.UserID = userID
+	if strings.TrimSpace(g.Description) == "" {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing description"))
+		return
+	}
+	if g.Value.IsNegative() {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("value can't be negative"))
+		return
+	}
+	if g.Rate, err = validGoalRate(g.Rate); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := UpdateGoal(tx, g); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "updating goal"))
+		return
+	}
+	if g, err = GetGoalByID(tx, goalID, userID); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting goal"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting goal"))
+		return
+	}
+
+	e.returnJSON(w, g)
+}
+
+// HandleGoalDelete archives a goal, it is then only listed with the archived
+// goals.
+func (e *External) HandleGoalDelete(w http.ResponseWriter, r *http.Request) {
+	goalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "parsing goal id"))
+		return
+	}
+
+	found, err := ArchiveGoal(e.dao.DB, goalID, r.Context().Value("user_id").(int))
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "archiving goal"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown goal: %d", goalID))
+		return
+	}
 
 	e.returnJSON(w, nil)
 }
@@ -304,6 +471,16 @@ func (e *External) HandleGetGoals(w http.ResponseWriter, r *http.Request) {
 	e.returnJSON(w, l)
 }
 
+func (e *External) HandleGetArchivedGoals(w http.ResponseWriter, r *http.Request) {
+	l, err := GetArchivedGoalsByUserID(e.dao.ReadDB, r.Context().Value("user_id").(int))
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	e.returnJSON(w, l)
+}
+
 func (e *External) HandleGetAllUserGoalTemplates(w http.ResponseWriter, r *http.Request) {
 	t, err := GetAllGoalTemplates(e.dao.ReadDB)
 	if err != nil {
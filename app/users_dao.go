@@ -17,6 +17,8 @@ func selectFromUsersWhere(w string) string {
 				COALESCE(u.sports, '') sports,
 				COALESCE(u.user_type, '') user_type,
 				COALESCE(u.hashtags, '') hashtags,
+				u.timezone,
+				u.leaderboard_opt_out,
 				u.is_active,
 				u.last_online last_online,
 				u.user_admin_level,
@@ -108,7 +110,7 @@ func GetLearningProgressesByUserIDAndDeviceUniqueID(
 					AND
 					device_unique_id = $2
 				)
-			ORDER BY created_at
+			ORDER BY updated_at
 		`,
 		userID,
 		deviceUniqueID,
@@ -135,7 +137,7 @@ func GetLearningProgressesByDeviceUniqueID(q Q, uniqueID string) ([]*LearningPro
 				updated_at
 			FROM ggwp.learning_progresses
 			WHERE device_unique_id = $1
-			ORDER BY created_at
+			ORDER BY updated_at
 		`,
 		uniqueID,
 	); err != nil {
@@ -145,16 +147,30 @@ func GetLearningProgressesByDeviceUniqueID(q Q, uniqueID string) ([]*LearningPro
 	return l, nil
 }
 
+func selectFromQuizGradingsWhere(where string) string {
+	return `
+		SELECT
+			id,
+			module_id,
+			quiz_id,
+			question_id,
+			user_id,
+			user_answer_ranking,
+			correct,
+			take_number,
+			created_at,
+			updated_at
+		FROM ggwp.quiz_gradings
+	` + where
+}
+
 func GetQuizGradingsByUserID(q Q, userID int) ([]*QuizGrading, error) {
 	var g []*QuizGrading
 	if err := q.Select(
 		&g,
-		`
-			SELECT
-				*
-			FROM ggwp.quiz_gradings
+		selectFromQuizGradingsWhere(`
 			WHERE user_id = $1
-		`,
+		`),
 		userID,
 	); err != nil {
 		return nil, err
@@ -163,25 +179,37 @@ func GetQuizGradingsByUserID(q Q, userID int) ([]*QuizGrading, error) {
 	return g, nil
 }
 
+func selectFromUserGoalsWhere(where string) string {
+	return `
+		SELECT
+			id,
+			user_id,
+			description,
+			value,
+			rate,
+			deadline,
+			completed_at,
+			is_active,
+			template_id,
+			created_at,
+			updated_at
+		FROM ggwp.user_goals
+	` + where
+}
+
+// GetGoalsByUserID gives the active goals of a user, archived goals are listed
+// by GetArchivedGoalsByUserID.
 func GetGoalsByUserID(q Q, userID int) ([]*UserGoal, error) {
 	var g []*UserGoal
 	if err := q.Select(
 		&g,
-		`
-			SELECT
-				id,
-				user_id,
-				description,
-				value,
-				rate,
-				deadline,
-				completed_at,
-				is_active,
-				created_at,
-				updated_at
-			FROM ggwp.user_goals
-			WHERE user_id = $1
-		`,
+		selectFromUserGoalsWhere(
+			`
+				WHERE user_id = $1
+					AND is_active
+				ORDER BY created_at, id
+			`,
+		),
 		userID,
 	); err != nil {
 		return nil, err
@@ -190,16 +218,56 @@ func GetGoalsByUserID(q Q, userID int) ([]*UserGoal, error) {
 	return g, nil
 }
 
+func GetArchivedGoalsByUserID(q Q, userID int) ([]*UserGoal, error) {
+	var g []*UserGoal
+	if err := q.Select(
+		&g,
+		selectFromUserGoalsWhere(
+			`
+				WHERE user_id = $1
+					AND NOT is_active
+				ORDER BY updated_at DESC, id DESC
+			`,
+		),
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// GetGoalByID gives a goal of the user, active or archived. Goals of other
+// users are sql.ErrNoRows.
+func GetGoalByID(q Q, goalID, userID int) (*UserGoal, error) {
+	var g UserGoal
+	if err := q.Get(
+		&g,
+		selectFromUserGoalsWhere(
+			`
+				WHERE id = $1
+					AND user_id = $2
+			`,
+		),
+		goalID,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
 func CreateGoal(q Q, g *UserGoal) error {
 	if _, err := q.NamedExec(
 		`
 			INSERT INTO ggwp.user_goals
 			(
-				user_id, description, value, rate, deadline
+				user_id, description, value, rate, deadline, template_id, is_active
 			)
 			VALUES
 			(
-				:user_id, :description, :value, :rate, :deadline
+				:user_id, :description, :value, :rate, :deadline, :template_id, TRUE
 			)
 		`,
 		g,
@@ -210,34 +278,90 @@ func CreateGoal(q Q, g *UserGoal) error {
 	return nil
 }
 
-func CompleteGoal(q Q, goalID int) error {
-	if _, err := q.Exec(
+// UpdateGoal updates an active goal of the user, it returns false when there
+// is no such goal.
+func UpdateGoal(q Q, g *UserGoal) (bool, error) {
+	return execGoalUpdate(
+		q,
+		`
+			UPDATE ggwp.user_goals
+			SET
+				description = $3,
+				value = $4,
+				rate = $5,
+				deadline = $6,
+				updated_at = NOW()
+			WHERE id = $1
+				AND user_id = $2
+				AND is_active
+		`,
+		g.ID,
+		g.UserID,
+		g.Description,
+		g.Value,
+		g.Rate,
+		g.Deadline,
+	)
+}
+
+func CompleteGoal(q Q, goalID, userID int) (bool, error) {
+	return execGoalUpdate(
+		q,
 		`
 			UPDATE ggwp.user_goals
 			SET completed_at = NOW(), updated_at = NOW()
 			WHERE id = $1
+				AND user_id = $2
+				AND is_active
 		`,
 		goalID,
-	); err != nil {
-		return err
-	}
-
-	return nil
+		userID,
+	)
 }
 
-func IncompleteGoal(q Q, goalID int) error {
-	if _, err := q.Exec(
+func IncompleteGoal(q Q, goalID, userID int) (bool, error) {
+	return execGoalUpdate(
+		q,
 		`
 			UPDATE ggwp.user_goals
 			SET completed_at = NULL, updated_at = NOW()
 			WHERE id = $1
+				AND user_id = $2
+				AND is_active
 		`,
 		goalID,
-	); err != nil {
-		return err
+		userID,
+	)
+}
+
+// ArchiveGoal soft deletes an active goal of the user, its check ins and
+// history are kept.
+func ArchiveGoal(q Q, goalID, userID int) (bool, error) {
+	return execGoalUpdate(
+		q,
+		`
+			UPDATE ggwp.user_goals
+			SET is_active = FALSE, updated_at = NOW()
+			WHERE id = $1
+				AND user_id = $2
+				AND is_active
+		`,
+		goalID,
+		userID,
+	)
+}
+
+func execGoalUpdate(q Q, query string, args ...interface{}) (bool, error) {
+	res, err := q.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	return n > 0, nil
 }
 
 func GetUserByEmail(q Q, email string) (*User, error) {
@@ -358,6 +482,7 @@ func UpdateUser(q Q, u *User) error {
 				location = COALESCE(NULLIF(:location, ''), existing.location),
 				sports = COALESCE(NULLIF(:sports, ''), existing.sports),
 				hashtags = COALESCE(NULLIF(:hashtags, ''), existing.hashtags),
+				timezone = COALESCE(NULLIF(:timezone, ''), existing.timezone),
 				updated_at = NOW()
 			FROM (
 				SELECT * FROM ggwp.users WHERE id = :id
@@ -429,7 +554,7 @@ func CreateFile(q Q, f *File) (*File, error) {
 				updated_at
 			FROM ggwp.files
 			WHERE user_id = $1
-			ORDER BY created_at DESC
+			ORDER BY created_at DESC, id DESC
 			LIMIT 1
 		`,
 		f.UserID,
@@ -471,26 +596,6 @@ func AddProfileImage(q Q, p *ProfileImage) error {
 	return nil
 }
 
-func GetAllGoalTemplates(q Q) ([]*GoalTemplate, error) {
-	var t []*GoalTemplate
-	if err := q.Select(
-		&t,
-		`
-			SELECT
-				id,
-				name,
-				quantitative,
-				created_at,
-				updated_at
-			FROM ggwp.user_goal_templates
-		`,
-	); err != nil {
-		return nil, err
-	}
-
-	return t, nil
-}
-
 func CreatePasswordReset(q Q, userID int, token string) error {
 	if _, err := q.Exec(
 		`
@@ -1,17 +1,35 @@
 package external
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
 
-	"github.com/AmirSoleimani/VoucherCodeGenerator/vcgen"
 	"github.com/badoux/checkmail"
-	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
+// DefaultWaitlistReferralJump is how many places each referral moves someone
+// up the waitlist, unless WAITLIST_REFERRAL_JUMP says otherwise.
+const DefaultWaitlistReferralJump = 10
+
+// WaitlistConfirmationResendInterval is how long after a confirmation email
+// signing up to the waitlist again sends another.
+const WaitlistConfirmationResendInterval = 15 * time.Minute
+
+func waitlistReferralJump() int {
+	if jump, err := strconv.Atoi(os.Getenv("WAITLIST_REFERRAL_JUMP")); err == nil && jump >= 0 {
+		return jump
+	}
+	return DefaultWaitlistReferralJump
+}
+
 func (e *External) HandleWaitlistUserAdd(w http.ResponseWriter, r *http.Request) {
 	wR := &WaitlistRequest{}
 	// decode the request body into struct and failed if any error occur
@@ -25,17 +43,13 @@ func (e *External) HandleWaitlistUserAdd(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// the address itself is verified by the confirmation email, entries are
+	// only queued once confirmed
 	if err := checkmail.ValidateFormat(wR.EmailAddress); err != nil {
 		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "validating email address format"))
 		return
 	}
 
-	// TODO: Bring this back and attempt to send user an email for manual verification
-	// if err := checkmail.ValidateHost(wR.EmailAddress); err != nil {
-	// e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "validating email address host"))
-	// return
-	// }
-
 	tx, err := e.dao.GetTx(r.Context())
 	if err != nil {
 		e.writeError(
@@ -61,35 +75,56 @@ func (e *External) HandleWaitlistUserAdd(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Get waitlist code
-	vcPrefix := vcgen.New(
-		&vcgen.Generator{
-			Count: 1, Pattern: "######", Prefix: "WAIT-",
-		},
-	)
-	res, err := vcPrefix.Run()
-	if err != nil {
-		e.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("unable to generate ref code"))
-		return
-	}
-	if res == nil {
-		e.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("no ref codes returned from library"))
+	// an unconfirmed entry gets its confirmation email again, unless one is on
+	// its way
+	confirmationToken, err := GetWaitlistConfirmationToken(tx, wR.EmailAddress)
+	if err != nil && err != sql.ErrNoRows {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting waitlist confirmation token"))
 		return
-	}
-	generatedRefCodes := *res
-	if len(generatedRefCodes) != 1 {
-		e.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("invalid number of generated ref codes"))
+	} else if err == nil {
+		item, err := GetWaitlistItemByEmail(tx, wR.EmailAddress)
+		if err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting waitlist item"))
+			return
+		}
+		recent, err := HasRecentEmail(
+			tx, item.EmailAddress, EmailType_WaitlistConfirmation,
+			time.Now().Add(-WaitlistConfirmationResendInterval),
+		)
+		if err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "checking recent confirmation emails"))
+			return
+		}
+		if !recent {
+			if err := e.queueWaitlistConfirmationEmail(tx, item.EmailAddress, confirmationToken); err != nil {
+				e.writeError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "comitting tx"))
+			return
+		}
+		e.returnJSON(w, item)
 		return
 	}
-	ownerWaitlistCode := generatedRefCodes[0]
 
 	// check if any of the original codes have been provided
 	// if so, check validity
+	wR.OriginalWaitlistCode = NormalizeCode(wR.OriginalWaitlistCode)
+	wR.OriginalReferralCode = NormalizeCode(wR.OriginalReferralCode)
 	var waitlistCodeID *int
 	if wR.OriginalWaitlistCode != "" {
 		waitlistItem, err := GetWaitlistItemByCode(tx, wR.OriginalWaitlistCode)
 		if err != nil {
 			if err == sql.ErrNoRows {
+				if WaitlistCodeFormat.Mistyped(wR.OriginalWaitlistCode) {
+					e.writeError(
+						w, r, http.StatusBadRequest,
+						fmt.Errorf("waitlist code provided has a typo"),
+					)
+					return
+				}
 				e.writeError(
 					w, r, http.StatusBadRequest,
 					fmt.Errorf("waitlist code provided does not exist"),
@@ -122,25 +157,46 @@ func (e *External) HandleWaitlistUserAdd(w http.ResponseWriter, r *http.Request)
 			)
 			return
 		}
+		// codes that can't be redeemed any more don't take people either
+		count, err := GetTotalReferralCodeRedemptions(tx, referralCode.ReferralCode)
+		if err != nil {
+			e.writeError(
+				w, r, http.StatusInternalServerError,
+				errors.Wrapf(err, "getting total redemptions for code: %s", referralCode.ReferralCode),
+			)
+			return
+		}
+		if reason := referralCode.UnredeemableReason(count, time.Now()); reason != "" {
+			e.writeError(w, r, http.StatusBadRequest, errors.New(reason))
+			return
+		}
 		referralCodeID = &referralCode.ID
 	}
 
+	confirmationToken, err = newSecretToken()
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "generating confirmation token"))
+		return
+	}
 	createdItem, err := CreateWaitlistItem(
 		tx,
 		wR.EmailAddress,
-		ownerWaitlistCode,
+		confirmationToken,
 		referralCodeID,
 		waitlistCodeID,
 	)
 	if err != nil {
-		pqErr, ok := err.(*pq.Error)
-		if ok && pqErr.Code == "23505" {
+		if isUniqueViolation(err) {
 			e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("email already in waitlist"))
 			return
 		}
 		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating waitlist item"))
 		return
 	}
+	if err := e.queueWaitlistConfirmationEmail(tx, createdItem.EmailAddress, confirmationToken); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
 
 	if err := tx.Commit(); err != nil {
 		e.writeError(
@@ -153,8 +209,10 @@ func (e *External) HandleWaitlistUserAdd(w http.ResponseWriter, r *http.Request)
 	e.returnJSON(w, createdItem)
 }
 
+// HandleGetWaitlist gives how many people are on the waitlist, the entries
+// themselves are only given to admins.
 func (e *External) HandleGetWaitlist(w http.ResponseWriter, r *http.Request) {
-	l, err := GetWaitlist(e.dao.ReadDB)
+	l, err := GetWaitlistSummary(e.dao.ReadDB)
 	if err != nil {
 		e.writeError(
 			w, r, http.StatusInternalServerError,
@@ -165,3 +223,142 @@ func (e *External) HandleGetWaitlist(w http.ResponseWriter, r *http.Request) {
 
 	e.returnJSON(w, l)
 }
+
+// HandleGetWaitlistStatus gives the live place in the queue of the owner of a
+// waitlist code, along with how many people they referred.
+func (e *External) HandleGetWaitlistStatus(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing code"))
+		return
+	}
+
+	s, err := GetWaitlistStatus(e.dao.ReadDB, code, waitlistReferralJump())
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown waitlist code"))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting waitlist status"))
+		return
+	}
+
+	e.returnJSON(w, s)
+}
+
+func (e *External) waitlistConfirmationURL(token string) string {
+	return e.apiURL("/waitlist/confirm?token=" + url.QueryEscape(token))
+}
+
+func (e *External) queueWaitlistConfirmationEmail(q Q, emailAddress, token string) error {
+	if err := CreateEmail(
+		q,
+		nil,
+		emailAddress,
+		templateInfo[EmailType_WaitlistConfirmation].name,
+		HStoreMap{
+			"ConfirmURL": e.waitlistConfirmationURL(token),
+		},
+		EmailType_WaitlistConfirmation,
+		EmailStatus_Pending,
+	); err != nil {
+		return errors.Wrap(err, "queueing waitlist confirmation email")
+	}
+
+	return nil
+}
+
+// HandleConfirmWaitlist is linked from the confirmation email, confirmed
+// entries get their waitlist code and a place in the queue.
+func (e *External) HandleConfirmWaitlist(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing token"))
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	emailAddress, err := ConfirmWaitlistItem(tx, token)
+	if err == sql.ErrNoRows {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown token"))
+		return
+	} else if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "confirming waitlist item"))
+		return
+	}
+	item, err := GetWaitlistItemByEmail(tx, emailAddress)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting waitlist item"))
+		return
+	}
+	if item.OwnerWaitlistCode == "" {
+		if err := GenerateAndWriteWaitlistCode(tx, emailAddress); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "generating waitlist code"))
+			return
+		}
+		if item, err = GetWaitlistItemByEmail(tx, emailAddress); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting waitlist item"))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "comitting tx"))
+		return
+	}
+
+	e.returnJSON(w, item)
+}
+
+// PurgeUnconfirmedWaitlist deletes the waitlist entries left unconfirmed for
+// longer than UNCONFIRMED_WAITLIST_RETENTION.
+func (e *External) PurgeUnconfirmedWaitlist(now time.Time) (int64, error) {
+	purged, err := PurgeUnconfirmedWaitlistItems(e.dao.DB, now.Add(-UNCONFIRMED_WAITLIST_RETENTION))
+	if err != nil {
+		return 0, errors.Wrap(err, "purging unconfirmed waitlist items")
+	}
+
+	return purged, nil
+}
+
+// QueueWaitlistEmails queues the email with their code for confirmed waitlist
+// entries which haven't had one yet. It gives how many it queued.
+func (e *External) QueueWaitlistEmails(ctx context.Context) (int, error) {
+	tx, err := e.dao.GetTx(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin tx")
+	}
+	defer tx.Rollback()
+
+	items, err := GetWaitlistItemsWithoutAQueuedEmail(tx)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting waitlist items without a queued email")
+	}
+
+	for _, i := range items {
+		if err := CreateEmail(
+			tx,
+			nil,
+			i.EmailAddress,
+			templateInfo[EmailType_Waitlist].name,
+			HStoreMap{
+				"WaitlistCode": i.OwnerWaitlistCode,
+			},
+			EmailType_Waitlist,
+			EmailStatus_Pending,
+		); err != nil {
+			return 0, errors.Wrapf(err, "creating waitlist email for %s", i.EmailAddress)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commiting waitlist emails")
+	}
+
+	return len(items), nil
+}
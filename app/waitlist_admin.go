@@ -0,0 +1,227 @@
+package external
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	waitlistEntriesDefaultLimit = 100
+	waitlistEntriesMaxLimit     = 1000
+)
+
+// BuildWaitlistReferralTree nests the entries of a referral tree under whoever
+// referred them, starting from the root entry. Entries not connected to the
+// root are left out.
+func BuildWaitlistReferralTree(rootID int, entries []*WaitlistEntry) *WaitlistReferralNode {
+	nodes := make(map[int]*WaitlistReferralNode, len(entries))
+	for _, v := range entries {
+		nodes[v.ID] = &WaitlistReferralNode{Entry: v, Referrals: []*WaitlistReferralNode{}}
+	}
+	for _, v := range entries {
+		if v.ID == rootID || v.OriginalWaitlistCodeID == nil {
+			continue
+		}
+		if parent, ok := nodes[*v.OriginalWaitlistCodeID]; ok {
+			parent.Referrals = append(parent.Referrals, nodes[v.ID])
+		}
+	}
+
+	return nodes[rootID]
+}
+
+// waitlistFilterFromQuery reads the search and filters of the admin waitlist
+// endpoints. Dates are days, until includes the whole day.
+func waitlistFilterFromQuery(r *http.Request) (*WaitlistFilter, error) {
+	query := r.URL.Query()
+	f := &WaitlistFilter{
+		Search:     strings.TrimSpace(query.Get("q")),
+		ReferredBy: query.Get("referred_by"),
+	}
+
+	if v := query.Get("from"); v != "" {
+		d, err := FromString(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid from")
+		}
+		from := d.Time()
+		f.From = &from
+	}
+	if v := query.Get("until"); v != "" {
+		d, err := FromString(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid until")
+		}
+		until := d.Time().AddDate(0, 0, 1)
+		f.Until = &until
+	}
+	if v := query.Get("source"); v != "" {
+		switch s := WaitlistSource(strings.ToUpper(v)); s {
+		case WaitlistSource_WaitlistCode, WaitlistSource_ReferralCode, WaitlistSource_Direct:
+			f.Source = s
+		default:
+			return nil, fmt.Errorf("invalid source: %q", v)
+		}
+	}
+
+	return f, nil
+}
+
+// HandleGetWaitlistEntries searches the waitlist, a page at a time.
+func (e *External) HandleGetWaitlistEntries(w http.ResponseWriter, r *http.Request) {
+	f, err := waitlistFilterFromQuery(r)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := queryInt(r.URL.Query().Get("limit"), waitlistEntriesDefaultLimit, waitlistEntriesMaxLimit)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid limit"))
+		return
+	}
+	offset, err := queryInt(r.URL.Query().Get("offset"), 0, math.MaxInt32)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, errors.Wrap(err, "invalid offset"))
+		return
+	}
+	f.Limit = &limit
+	f.Offset = offset
+
+	entries, err := GetWaitlistEntries(e.dao.ReadDB, f)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting waitlist entries"))
+		return
+	}
+	if entries == nil {
+		entries = []*WaitlistEntry{}
+	}
+
+	e.returnJSON(w, entries)
+}
+
+// HandleExportWaitlistEntries gives every waitlist entry matching the filters
+// as a csv file.
+func (e *External) HandleExportWaitlistEntries(w http.ResponseWriter, r *http.Request) {
+	f, err := waitlistFilterFromQuery(r)
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := GetWaitlistEntries(e.dao.ReadDB, f)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting waitlist entries"))
+		return
+	}
+
+	// written out once complete, so a failure can still be reported
+	buf := &bytes.Buffer{}
+	cw := csv.NewWriter(buf)
+	if err := cw.Write([]string{
+		"id", "email_address", "waitlist_code", "source", "referred_by_code",
+		"referral_count", "confirmed_at", "user_id", "created_at",
+	}); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "writing csv header"))
+		return
+	}
+	for _, v := range entries {
+		var referredBy, confirmedAt, userID, createdAt string
+		if v.ReferredByCode != nil {
+			referredBy = *v.ReferredByCode
+		}
+		if v.ConfirmedAt != nil {
+			confirmedAt = v.ConfirmedAt.UTC().Format(time.RFC3339)
+		}
+		if v.UserID != nil {
+			userID = strconv.Itoa(*v.UserID)
+		}
+		if v.CreatedAt != nil {
+			createdAt = v.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		if err := cw.Write([]string{
+			strconv.Itoa(v.ID), EscapeCSVFormula(v.EmailAddress), EscapeCSVFormula(v.OwnerWaitlistCode),
+			v.Source.String(), EscapeCSVFormula(referredBy),
+			strconv.Itoa(v.ReferralCount), confirmedAt, userID, createdAt,
+		}); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "writing csv row for entry %d", v.ID))
+			return
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "writing csv"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="waitlist.csv"`)
+	if _, err := buf.WriteTo(w); err != nil {
+		e.log.WithError(err).Error("writing waitlist export")
+	}
+}
+
+// EscapeCSVFormula keeps spreadsheets from running a cell as a formula, which
+// anyone joining the waitlist could otherwise put in their email address.
+func EscapeCSVFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// HandleGetWaitlistReferralTree gives everyone who joined the waitlist through
+// an entry's code, nested under whoever referred them.
+func (e *External) HandleGetWaitlistReferralTree(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	entries, err := GetWaitlistReferralTree(e.dao.ReadDB, id)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting referral tree"))
+		return
+	}
+	tree := BuildWaitlistReferralTree(id, entries)
+	if tree == nil {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown waitlist entry"))
+		return
+	}
+
+	e.returnJSON(w, tree)
+}
+
+// HandleDeleteWaitlistEntry removes someone from the waitlist.
+func (e *External) HandleDeleteWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	found, err := DeleteWaitlistItem(tx, id)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "deleting waitlist entry"))
+		return
+	}
+	if !found {
+		e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown waitlist entry"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "comitting tx"))
+		return
+	}
+
+	e.returnJSON(w, nil)
+}
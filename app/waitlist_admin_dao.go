@@ -0,0 +1,168 @@
+package external
+
+import (
+	"fmt"
+)
+
+// WaitlistReferralTreeMaxDepth bounds how deep referral trees are followed.
+var WaitlistReferralTreeMaxDepth = 20
+
+func selectFromWaitlistEntriesWhere(where string) string {
+	return fmt.Sprintf(
+		`
+			SELECT
+				w.id,
+				w.email_address,
+				COALESCE(w.owner_waitlist_code, '') owner_waitlist_code,
+				w.original_referral_code_id,
+				w.original_waitlist_code_id,
+				w.confirmed_at,
+				w.created_at,
+				w.updated_at,
+				w.user_id,
+				w.source,
+				p.owner_waitlist_code referred_by_code,
+				(
+					SELECT COUNT(*)
+					FROM ggwp.waitlist c
+					WHERE c.original_waitlist_code_id = w.id
+				) referral_count
+			FROM
+				(
+					SELECT
+						*,
+						CASE
+							WHEN original_waitlist_code_id IS NOT NULL THEN 'WAITLIST_CODE'
+							WHEN original_referral_code_id IS NOT NULL THEN 'REFERRAL_CODE'
+							ELSE 'DIRECT'
+						END source
+					FROM ggwp.waitlist
+				) w
+			LEFT JOIN
+				ggwp.waitlist p
+				ON p.id = w.original_waitlist_code_id
+			%s
+		`,
+		where,
+	)
+}
+
+// GetWaitlistEntries gives the waitlist entries matching a filter, oldest
+// first. Empty filter fields match everything.
+func GetWaitlistEntries(q Q, f *WaitlistFilter) ([]*WaitlistEntry, error) {
+	var search string
+	if f.Search != "" {
+		search = "%" + f.Search + "%"
+	}
+
+	var e []*WaitlistEntry
+	if err := q.Select(
+		&e,
+		selectFromWaitlistEntriesWhere(
+			`
+				WHERE
+					($1 = '' OR w.email_address ILIKE $1 OR w.owner_waitlist_code ILIKE $1)
+					AND ($2::TIMESTAMPTZ IS NULL OR w.created_at >= $2)
+					AND ($3::TIMESTAMPTZ IS NULL OR w.created_at < $3)
+					AND ($4 = '' OR w.source = $4)
+					AND ($5 = '' OR p.owner_waitlist_code = $5)
+				ORDER BY
+					w.created_at, w.id
+				LIMIT $6
+				OFFSET $7
+			`,
+		),
+		search,
+		f.From,
+		f.Until,
+		string(f.Source),
+		f.ReferredBy,
+		f.Limit,
+		f.Offset,
+	); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// GetWaitlistReferralTree gives an entry along with everyone who joined
+// through its code, and through theirs, ordered by depth.
+func GetWaitlistReferralTree(q Q, id int) ([]*WaitlistEntry, error) {
+	var e []*WaitlistEntry
+	if err := q.Select(
+		&e,
+		`
+			WITH RECURSIVE tree AS (
+				SELECT
+					id,
+					0 depth
+				FROM
+					ggwp.waitlist
+				WHERE
+					id = $1
+				UNION
+				SELECT
+					c.id,
+					t.depth + 1
+				FROM
+					ggwp.waitlist c
+				JOIN
+					tree t
+					ON c.original_waitlist_code_id = t.id
+				WHERE
+					t.depth < $2
+			)
+		`+selectFromWaitlistEntriesWhere(
+			`
+				JOIN
+					tree t
+					ON t.id = w.id
+				ORDER BY
+					t.depth, w.created_at, w.id
+			`,
+		),
+		id,
+		WaitlistReferralTreeMaxDepth,
+	); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// DeleteWaitlistItem removes someone from the waitlist along with their
+// invites. People who joined with their code stay on the waitlist, without a
+// referrer.
+func DeleteWaitlistItem(q Q, id int) (bool, error) {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.waitlist
+			SET original_waitlist_code_id = NULL, updated_at = NOW()
+			WHERE original_waitlist_code_id = $1
+		`,
+		id,
+	); err != nil {
+		return false, err
+	}
+	if _, err := q.Exec(
+		`DELETE FROM ggwp.waitlist_invites WHERE waitlist_id = $1`,
+		id,
+	); err != nil {
+		return false, err
+	}
+
+	res, err := q.Exec(
+		`DELETE FROM ggwp.waitlist WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
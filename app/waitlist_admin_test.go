@@ -0,0 +1,175 @@
+package external_test
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestEscapeCSVFormula(t *testing.T) {
+	h := TestHelper{t}
+
+	for cell, expected := range map[string]string{
+		"":                         "",
+		"a@ggwpacademy.com":        "a@ggwpacademy.com",
+		"WAIT-123456":              "WAIT-123456",
+		"=HYPERLINK(\"http://x\")": "'=HYPERLINK(\"http://x\")",
+		"+1+2@ggwpacademy.com":     "'+1+2@ggwpacademy.com",
+		"-2+3@ggwpacademy.com":     "'-2+3@ggwpacademy.com",
+		"@SUM(A1)":                 "'@SUM(A1)",
+		"\t=1":                     "'\t=1",
+	} {
+		h.ExpectDeepEq(external.EscapeCSVFormula(cell), expected)
+	}
+}
+
+func TestBuildWaitlistReferralTree(t *testing.T) {
+	h := TestHelper{t}
+
+	entry := func(id int, referredBy *int) *external.WaitlistEntry {
+		return &external.WaitlistEntry{
+			WaitlistItem: &external.WaitlistItem{ID: id, OriginalWaitlistCodeID: referredBy},
+		}
+	}
+	root, child := 1, 2
+	entries := []*external.WaitlistEntry{
+		entry(root, nil),
+		entry(2, &root),
+		entry(3, &root),
+		entry(4, &child),
+	}
+
+	tree := external.BuildWaitlistReferralTree(root, entries)
+	h.ExpectDeepEq(tree.Entry.ID, 1)
+	h.ExpectDeepEq(len(tree.Referrals), 2)
+	h.ExpectDeepEq(tree.Referrals[0].Entry.ID, 2)
+	h.ExpectDeepEq(tree.Referrals[1].Entry.ID, 3)
+	h.ExpectDeepEq(len(tree.Referrals[0].Referrals), 1)
+	h.ExpectDeepEq(tree.Referrals[0].Referrals[0].Entry.ID, 4)
+	h.ExpectDeepEq(len(tree.Referrals[1].Referrals), 0)
+
+	h.ExpectDeepEq(external.BuildWaitlistReferralTree(5, entries), (*external.WaitlistReferralNode)(nil))
+}
+
+func TestWaitlistAdmin(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	admin := f.GetAuthToken("admin.user@ggwpacademy.com")
+	f.MakeAdmin(admin.UserID)
+	user := f.GetAuthToken("normal.user@ggwpacademy.com")
+
+	joined := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	join := func(email, code string, referredBy *int) int {
+		var id int
+		f.ExpectNoError(f.DAO.DB.Get(
+			&id,
+			`
+				INSERT INTO ggwp.waitlist
+				(
+					email_address, owner_waitlist_code, original_waitlist_code_id, confirmed_at, created_at, updated_at
+				)
+				VALUES
+				(
+					$1, $2, $3, $4, $4, $4
+				)
+				RETURNING id
+			`,
+			email, code, referredBy, joined,
+		))
+		joined = joined.AddDate(0, 0, 1)
+		return id
+	}
+	a := join("a@ggwpacademy.com", "WAIT-000001", nil)
+	b := join("b@ggwpacademy.com", "WAIT-000002", &a)
+	join("c@ggwpacademy.com", "WAIT-000003", &a)
+	join("d@ggwpacademy.com", "WAIT-000004", &b)
+
+	// the public endpoint only gives counts
+	rr := f.UnAuthedRequest(http.MethodGet, "/waitlist", "")
+	f.ExpectStatus(rr, http.StatusOK)
+	s := &external.WaitlistSummary{}
+	f.Bind(rr, s)
+	f.ExpectDeepEq(s.Total, 4)
+	f.ExpectDeepEq(s.Referred, 3)
+	if strings.Contains(rr.Body.String(), "@") {
+		t.Fatalf("public waitlist leaks email addresses: %s", rr.Body.String())
+	}
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries", "", user.AccessToken)
+	f.ExpectStatus(rr, http.StatusForbidden)
+
+	// search and filters
+	var entries []*external.WaitlistEntry
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries?q=B@GGWP", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, &entries)
+	f.ExpectDeepEq(len(entries), 1)
+	f.ExpectDeepEq(entries[0].EmailAddress, "b@ggwpacademy.com")
+	f.ExpectDeepEq(entries[0].Source, external.WaitlistSource_WaitlistCode)
+	f.ExpectDeepEq(*entries[0].ReferredByCode, "WAIT-000001")
+	f.ExpectDeepEq(entries[0].ReferralCount, 1)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries?referred_by=WAIT-000001", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, &entries)
+	f.ExpectDeepEq(len(entries), 2)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries?source=direct", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, &entries)
+	f.ExpectDeepEq(len(entries), 1)
+	f.ExpectDeepEq(entries[0].ID, a)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries?from=2020-03-02&until=2020-03-03", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, &entries)
+	f.ExpectDeepEq(len(entries), 2)
+	f.ExpectDeepEq(entries[0].ID, b)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries?limit=1&offset=1", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, &entries)
+	f.ExpectDeepEq(len(entries), 1)
+	f.ExpectDeepEq(entries[0].ID, b)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries?source=nope", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusBadRequest)
+
+	// csv export
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries/export", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectDeepEq(rr.Header().Get("Content-Type"), "text/csv; charset=utf-8")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(len(records), 5)
+	f.ExpectDeepEq(records[0][1], "email_address")
+	f.ExpectDeepEq(records[1][1], "a@ggwpacademy.com")
+
+	// referral tree
+	rr = f.AuthedRequest(http.MethodGet, fmt.Sprintf("/api/v0.1/waitlist/entries/%d/referrals", a), "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	tree := &external.WaitlistReferralNode{}
+	f.Bind(rr, tree)
+	f.ExpectDeepEq(tree.Entry.ID, a)
+	f.ExpectDeepEq(len(tree.Referrals), 2)
+	f.ExpectDeepEq(tree.Referrals[0].Entry.ID, b)
+	f.ExpectDeepEq(len(tree.Referrals[0].Referrals), 1)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/entries/999999/referrals", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+
+	// removing someone keeps the people they referred
+	rr = f.AuthedRequest(http.MethodDelete, fmt.Sprintf("/api/v0.1/waitlist/entries/%d", b), "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCount("ggwp.waitlist", 3)
+	f.ExpectRowCountWhere("ggwp.waitlist", "email_address = 'd@ggwpacademy.com' AND original_waitlist_code_id IS NULL", 1)
+
+	rr = f.AuthedRequest(http.MethodDelete, fmt.Sprintf("/api/v0.1/waitlist/entries/%d", b), "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusNotFound)
+}
@@ -2,12 +2,15 @@ package external
 
 import (
 	"strings"
+	"time"
 )
 
+// CreateWaitlistItem adds an unconfirmed item to the waitlist, it gets a code
+// and a place in the queue once confirmed.
 func CreateWaitlistItem(
 	q Q,
 	emailAddress,
-	ownerWaitlistCode string,
+	confirmationToken string,
 	originalReferralCodeID,
 	originalWaitlistCodeID *int,
 ) (*WaitlistItem, error) {
@@ -15,7 +18,7 @@ func CreateWaitlistItem(
 		`
 			INSERT INTO ggwp.waitlist
 			(
-				email_address, owner_waitlist_code, original_referral_code_id, original_waitlist_code_id
+				email_address, confirmation_token, original_referral_code_id, original_waitlist_code_id
 			)
 			VALUES
 			(
@@ -23,23 +26,28 @@ func CreateWaitlistItem(
 			)
 		`,
 		emailAddress,
-		ownerWaitlistCode,
+		confirmationToken,
 		originalReferralCodeID,
 		originalWaitlistCodeID,
 	); err != nil {
 		return nil, err
 	}
 
-	var rI WaitlistItem
+	return GetWaitlistItemByEmail(q, emailAddress)
+}
+
+func GetWaitlistItemByEmail(q Q, emailAddress string) (*WaitlistItem, error) {
+	var i WaitlistItem
 	if err := q.Get(
-		&rI,
+		&i,
 		`
 			SELECT
 				id,
 				email_address,
-				owner_waitlist_code,
+				COALESCE(owner_waitlist_code, '') owner_waitlist_code,
 				original_referral_code_id,
 				original_waitlist_code_id,
+				confirmed_at,
 				created_at,
 				updated_at
 			FROM
@@ -52,7 +60,66 @@ func CreateWaitlistItem(
 		return nil, err
 	}
 
-	return &rI, nil
+	return &i, nil
+}
+
+// GetWaitlistConfirmationToken gives the token confirming an unconfirmed
+// waitlist item, sql.ErrNoRows once it is confirmed.
+func GetWaitlistConfirmationToken(q Q, emailAddress string) (string, error) {
+	var token string
+	if err := q.Get(
+		&token,
+		`
+			SELECT confirmation_token
+			FROM ggwp.waitlist
+			WHERE email_address = $1
+				AND confirmed_at IS NULL
+				AND confirmation_token IS NOT NULL
+		`,
+		strings.ToLower(emailAddress),
+	); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConfirmWaitlistItem confirms the waitlist item of a token, it gives the
+// email address confirmed or sql.ErrNoRows for unknown tokens.
+func ConfirmWaitlistItem(q Q, token string) (string, error) {
+	var emailAddress string
+	if err := q.Get(
+		&emailAddress,
+		`
+			UPDATE ggwp.waitlist
+			SET confirmed_at = COALESCE(confirmed_at, NOW()), updated_at = NOW()
+			WHERE confirmation_token = $1
+			RETURNING email_address
+		`,
+		token,
+	); err != nil {
+		return "", err
+	}
+
+	return emailAddress, nil
+}
+
+// PurgeUnconfirmedWaitlistItems deletes the waitlist items which were never
+// confirmed since before a time.
+func PurgeUnconfirmedWaitlistItems(q Q, before time.Time) (int64, error) {
+	res, err := q.Exec(
+		`
+			DELETE FROM ggwp.waitlist
+			WHERE confirmed_at IS NULL
+				AND created_at < $1
+		`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
 }
 
 func UpdateWaitlistCode(
@@ -103,31 +170,26 @@ func GetWaitlistItemByCode(
 	return &i, nil
 }
 
-func GetWaitlist(q Q) ([]WaitlistItem, error) {
-	var items []WaitlistItem
-	if err := q.Select(
-		&items,
+// GetWaitlistSummary gives the aggregate counts of the confirmed waitlist.
+func GetWaitlistSummary(q Q) (*WaitlistSummary, error) {
+	var s WaitlistSummary
+	if err := q.Get(
+		&s,
 		`
 			SELECT
-				id,
-				-- email_address, intentionally not returning email_address
-				owner_waitlist_code,
-				original_referral_code_id,
-				original_waitlist_code_id,
-				created_at,
-				updated_at
+				COUNT(*) total,
+				COUNT(original_waitlist_code_id) referred,
+				COUNT(*) FILTER (WHERE created_at > NOW() - INTERVAL '7 days') joined_last_week
 			FROM
 				ggwp.waitlist
 			WHERE
-				owner_waitlist_code IS NOT NULL
-			ORDER BY
-				created_at
+				confirmed_at IS NOT NULL
 		`,
 	); err != nil {
 		return nil, err
 	}
 
-	return items, nil
+	return &s, nil
 }
 
 func GetEmailsMissingWaitlistCodes(q Q) ([]string, error) {
@@ -141,6 +203,7 @@ func GetEmailsMissingWaitlistCodes(q Q) ([]string, error) {
         ggwp.waitlist
       WHERE
 				owner_waitlist_code IS NULL
+				AND confirmed_at IS NOT NULL
 		`,
 	); err != nil {
 		return nil, err
@@ -149,6 +212,9 @@ func GetEmailsMissingWaitlistCodes(q Q) ([]string, error) {
 	return emails, nil
 }
 
+// GetWaitlistItemsWithoutAQueuedEmail gives the confirmed items yet to get
+// their waitlist email, leaving out suppressed addresses and users who
+// unsubscribed from waitlist emails.
 func GetWaitlistItemsWithoutAQueuedEmail(q Q) ([]WaitlistItem, error) {
 	var i []WaitlistItem
 	if err := q.Select(
@@ -170,13 +236,99 @@ func GetWaitlistItemsWithoutAQueuedEmail(q Q) ([]WaitlistItem, error) {
 				AND e.type = $1
 			WHERE
 				e.email_address IS NULL
+				AND w.confirmed_at IS NOT NULL
+				AND w.owner_waitlist_code IS NOT NULL
+				AND NOT EXISTS (
+					SELECT 1
+					FROM ggwp.email_suppressions s
+					WHERE s.email_address = lower(w.email_address)
+				)
+				AND NOT EXISTS (
+					SELECT 1
+					FROM ggwp.user_email_preferences p
+					JOIN ggwp.users u ON u.id = p.user_id
+					WHERE
+						u.email = lower(w.email_address)
+						AND p.category = $2
+						AND NOT p.subscribed
+				)
 			ORDER BY
 				w.created_at
 		`,
 		EmailType_Waitlist,
+		EmailCategory_Waitlist,
 	); err != nil {
 		return nil, err
 	}
 
 	return i, nil
 }
+
+// waitlistPositions ranks the confirmed items of the waitlist. Items queue in
+// the order they joined, each confirmed item that joined with their code moves
+// the owner up by $1 places.
+const waitlistPositions = `
+	WITH referrals AS (
+		SELECT
+			original_waitlist_code_id id,
+			COUNT(*) referral_count
+		FROM
+			ggwp.waitlist
+		WHERE
+			original_waitlist_code_id IS NOT NULL
+			AND confirmed_at IS NOT NULL
+		GROUP BY
+			original_waitlist_code_id
+	), scored AS (
+		SELECT
+			w.id,
+			w.owner_waitlist_code,
+			w.created_at,
+			COALESCE(r.referral_count, 0) referral_count,
+			ROW_NUMBER() OVER (ORDER BY w.created_at, w.id)
+				- COALESCE(r.referral_count, 0) * $1 score
+		FROM
+			ggwp.waitlist w
+		LEFT JOIN
+			referrals r
+			ON r.id = w.id
+		WHERE
+			w.confirmed_at IS NOT NULL
+	), positioned AS (
+		SELECT
+			id,
+			owner_waitlist_code,
+			referral_count,
+			ROW_NUMBER() OVER (ORDER BY score, created_at, id) AS position,
+			COUNT(*) OVER () total
+		FROM
+			scored
+	)
+`
+
+// GetWaitlistStatus gives the place in the queue of the waitlist item with a
+// code.
+func GetWaitlistStatus(q Q, code string, jump int) (*WaitlistStatus, error) {
+	var s WaitlistStatus
+	if err := q.Get(
+		&s,
+		waitlistPositions+`
+			SELECT
+				owner_waitlist_code,
+				position,
+				referral_count,
+				total - position people_behind,
+				total
+			FROM
+				positioned
+			WHERE
+				owner_waitlist_code = $2
+		`,
+		jump,
+		code,
+	); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,150 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// the most people a single wave invites
+var WaitlistInviteWaveMaxSize = 1000
+
+func (e *External) waitlistInviteURL(token string) string {
+	return fmt.Sprintf("%s/signup?invite=%s", e.baseURL, url.QueryEscape(token))
+}
+
+// redeemWaitlistInvite uses up the invite a user signed up with. It returns
+// false when the waitlist gate is up and the invite is missing or not valid.
+func (e *External) redeemWaitlistInvite(q Q, token string, userID int) (bool, error) {
+	if token == "" {
+		return !e.waitlistGateEnabled, nil
+	}
+	redeemed, err := RedeemWaitlistInvite(q, token, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return redeemed || !e.waitlistGateEnabled, nil
+}
+
+func (w *WaitlistInviteWave) setConversionRate() {
+	w.ConversionRate = decimal.Zero
+	if w.Invited > 0 {
+		w.ConversionRate = decimal.New(int64(w.SignedUp), 0).DivRound(decimal.New(int64(w.Invited), 0), 4)
+	}
+}
+
+// HandleCreateWaitlistInviteWave invites either the top of the waitlist queue
+// or a list of people in it, skipping anyone already invited or signed up.
+func (e *External) HandleCreateWaitlistInviteWave(w http.ResponseWriter, r *http.Request) {
+	req := &WaitlistInviteWaveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid request"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("missing name"))
+		return
+	}
+	if (req.Top > 0) == (len(req.Emails) > 0) {
+		e.writeError(w, r, http.StatusBadRequest, fmt.Errorf("either top or emails is needed"))
+		return
+	}
+	if req.Top > WaitlistInviteWaveMaxSize || len(req.Emails) > WaitlistInviteWaveMaxSize {
+		e.writeError(
+			w, r, http.StatusBadRequest,
+			fmt.Errorf("too many invites, at most %d per wave", WaitlistInviteWaveMaxSize),
+		)
+		return
+	}
+
+	tx, err := e.dao.GetTx(r.Context())
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "begin tx"))
+		return
+	}
+	defer tx.Rollback()
+
+	var items []*WaitlistItem
+	if req.Top > 0 {
+		items, err = GetUninvitedWaitlistTop(tx, req.Top, waitlistReferralJump())
+	} else {
+		items, err = GetUninvitedWaitlistItemsByEmails(tx, req.Emails)
+	}
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting people to invite"))
+		return
+	}
+
+	wave, err := CreateWaitlistInviteWave(tx, req.Name, r.Context().Value("user_id").(int))
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "creating invite wave"))
+		return
+	}
+	for _, i := range items {
+		token, err := newSecretToken()
+		if err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "generating invite token"))
+			return
+		}
+		if err := CreateWaitlistInvite(tx, wave.ID, i.ID, token); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "creating invite for %d", i.ID))
+			return
+		}
+		if err := CreateEmail(
+			tx,
+			nil,
+			i.EmailAddress,
+			templateInfo[EmailType_WaitlistInvite].name,
+			HStoreMap{
+				"SignUpURL": e.waitlistInviteURL(token),
+			},
+			EmailType_WaitlistInvite,
+			EmailStatus_Pending,
+		); err != nil {
+			e.writeError(w, r, http.StatusInternalServerError, errors.Wrapf(err, "queueing invite email for %d", i.ID))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "commiting invite wave"))
+		return
+	}
+
+	wave.Invited = len(items)
+	wave.setConversionRate()
+	e.returnJSON(w, wave)
+}
+
+// HandleGetWaitlistInviteWaves gives every wave with how many of the people it
+// invited went on to sign up.
+func (e *External) HandleGetWaitlistInviteWaves(w http.ResponseWriter, r *http.Request) {
+	waves, err := GetWaitlistInviteWaves(e.dao.ReadDB)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting invite waves"))
+		return
+	}
+
+	total := &WaitlistInviteWave{Name: "all"}
+	for _, v := range waves {
+		v.setConversionRate()
+		total.Invited += v.Invited
+		total.SignedUp += v.SignedUp
+	}
+	total.setConversionRate()
+
+	e.returnJSON(w, struct {
+		Waves []*WaitlistInviteWave `json:"waves"`
+		Total *WaitlistInviteWave   `json:"total"`
+	}{
+		Waves: waves,
+		Total: total,
+	})
+}
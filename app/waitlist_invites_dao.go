@@ -0,0 +1,183 @@
+package external
+
+import (
+	"github.com/lib/pq"
+)
+
+func CreateWaitlistInviteWave(q Q, name string, createdBy int) (*WaitlistInviteWave, error) {
+	var w WaitlistInviteWave
+	if err := q.Get(
+		&w,
+		`
+			INSERT INTO ggwp.waitlist_invite_waves
+			(
+				name, created_by, created_at
+			)
+			VALUES
+			(
+				$1, $2, NOW()
+			)
+			RETURNING id, name, created_by, created_at
+		`,
+		name,
+		createdBy,
+	); err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+// GetUninvitedWaitlistTop gives the first n people in the waitlist queue who
+// neither signed up nor got an invite yet.
+func GetUninvitedWaitlistTop(q Q, n, jump int) ([]*WaitlistItem, error) {
+	var i []*WaitlistItem
+	if err := q.Select(
+		&i,
+		waitlistPositions+`
+			SELECT
+				w.id,
+				w.email_address,
+				COALESCE(w.owner_waitlist_code, '') owner_waitlist_code,
+				w.original_referral_code_id,
+				w.original_waitlist_code_id,
+				w.created_at,
+				w.updated_at
+			FROM
+				positioned p
+			JOIN
+				ggwp.waitlist w
+				ON w.id = p.id
+			LEFT JOIN
+				ggwp.waitlist_invites i
+				ON i.waitlist_id = w.id
+			WHERE
+				i.id IS NULL
+				AND w.user_id IS NULL
+			ORDER BY
+				p.position
+			LIMIT $2
+		`,
+		jump,
+		n,
+	); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// GetUninvitedWaitlistItemsByEmails gives the people in the waitlist with the
+// email addresses who neither signed up nor got an invite yet.
+func GetUninvitedWaitlistItemsByEmails(q Q, emails []string) ([]*WaitlistItem, error) {
+	var i []*WaitlistItem
+	if err := q.Select(
+		&i,
+		`
+			SELECT
+				w.id,
+				w.email_address,
+				COALESCE(w.owner_waitlist_code, '') owner_waitlist_code,
+				w.original_referral_code_id,
+				w.original_waitlist_code_id,
+				w.created_at,
+				w.updated_at
+			FROM
+				ggwp.waitlist w
+			LEFT JOIN
+				ggwp.waitlist_invites i
+				ON i.waitlist_id = w.id
+			WHERE
+				w.email_address = ANY(
+					SELECT lower(e) FROM unnest($1::TEXT[]) e
+				)
+				AND w.confirmed_at IS NOT NULL
+				AND i.id IS NULL
+				AND w.user_id IS NULL
+			ORDER BY
+				w.created_at
+		`,
+		pq.Array(emails),
+	); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func CreateWaitlistInvite(q Q, waveID, waitlistID int, token string) error {
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.waitlist_invites
+			(
+				wave_id, waitlist_id, token, created_at
+			)
+			VALUES
+			(
+				$1, $2, $3, NOW()
+			)
+		`,
+		waveID,
+		waitlistID,
+		token,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RedeemWaitlistInvite uses up an invite for a user who signed up with it, it
+// returns false when the token is unknown or was used already.
+func RedeemWaitlistInvite(q Q, token string, userID int) (bool, error) {
+	res, err := q.Exec(
+		`
+			UPDATE ggwp.waitlist_invites
+			SET user_id = $2, redeemed_at = NOW()
+			WHERE token = $1
+				AND redeemed_at IS NULL
+		`,
+		token,
+		userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetWaitlistInviteWaves gives every wave, newest first, with how many of the
+// people invited signed up.
+func GetWaitlistInviteWaves(q Q) ([]*WaitlistInviteWave, error) {
+	var w []*WaitlistInviteWave
+	if err := q.Select(
+		&w,
+		`
+			SELECT
+				v.id,
+				v.name,
+				v.created_by,
+				v.created_at,
+				COUNT(i.id) invited,
+				COUNT(i.redeemed_at) signed_up
+			FROM
+				ggwp.waitlist_invite_waves v
+			LEFT JOIN
+				ggwp.waitlist_invites i
+				ON i.wave_id = v.id
+			GROUP BY
+				v.id
+			ORDER BY
+				v.created_at DESC, v.id DESC
+		`,
+	); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
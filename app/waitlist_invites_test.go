@@ -0,0 +1,101 @@
+package external_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestWaitlistInviteWaves(t *testing.T) {
+	f := NewWaitlistGatedFixture(t)
+	defer f.Close()
+
+	admin := f.GetAuthToken("admin.user@ggwpacademy.com")
+	f.MakeAdmin(admin.UserID)
+
+	for _, email := range []string{"first@ggwpacademy.com", "second@ggwpacademy.com", "third@ggwpacademy.com"} {
+		rr := f.UnAuthedRequest(http.MethodPost, "/waitlist/user", `{"email_address": "`+email+`"}`)
+		f.ExpectStatus(rr, http.StatusOK)
+		var token string
+		f.ExpectNoError(f.DAO.DB.Get(&token, `SELECT confirmation_token FROM ggwp.waitlist WHERE email_address = $1`, email))
+		rr = f.UnAuthedRequest(http.MethodGet, "/waitlist/confirm?token="+token, "")
+		f.ExpectStatus(rr, http.StatusOK)
+	}
+
+	// the top of the queue is invited
+	rr := f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/waitlist/invitations",
+		`{"name": "first wave", "top": 2}`,
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	wave := &external.WaitlistInviteWave{}
+	f.Bind(rr, wave)
+	f.ExpectDeepEq(wave.Invited, 2)
+	f.ExpectRowCountWhere("ggwp.emails", "type = 'WAITLIST_INVITE'", 2)
+	f.ExpectRowCountWhere(
+		"ggwp.waitlist_invites i JOIN ggwp.waitlist w ON w.id = i.waitlist_id",
+		"w.email_address = 'third@ggwpacademy.com'",
+		0,
+	)
+
+	// nobody is invited twice
+	rr = f.AuthedRequest(
+		http.MethodPost,
+		"/api/v0.1/waitlist/invitations",
+		`{"name": "again", "emails": ["FIRST@ggwpacademy.com"]}`,
+		admin.AccessToken,
+	)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, wave)
+	f.ExpectDeepEq(wave.Invited, 0)
+
+	// signing up needs an invite
+	signUp := func(email, token string) int {
+		j, err := json.Marshal(&external.NewUser{
+			Email:       email,
+			Password:    "test123",
+			FirstName:   "Test",
+			LastName:    "User",
+			InviteToken: token,
+		})
+		f.ExpectNoError(err)
+		return f.UnAuthedRequest(http.MethodPost, "/user", string(j)).Code
+	}
+	var token string
+	f.ExpectNoError(f.DAO.DB.Get(
+		&token,
+		`
+			SELECT i.token
+			FROM ggwp.waitlist_invites i
+			JOIN ggwp.waitlist w ON w.id = i.waitlist_id
+			WHERE w.email_address = 'first@ggwpacademy.com'
+		`,
+	))
+	f.ExpectDeepEq(signUp("third@ggwpacademy.com", ""), http.StatusForbidden)
+	f.ExpectDeepEq(signUp("third@ggwpacademy.com", "not-a-token"), http.StatusForbidden)
+	f.ExpectDeepEq(signUp("first@ggwpacademy.com", token), http.StatusOK)
+	// invites are single use
+	f.ExpectDeepEq(signUp("third@ggwpacademy.com", token), http.StatusForbidden)
+	f.ExpectRowCountWhere("ggwp.users", "email = 'third@ggwpacademy.com'", 0)
+
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/invitations", "", admin.AccessToken)
+	f.ExpectStatus(rr, http.StatusOK)
+	var res struct {
+		Waves []*external.WaitlistInviteWave `json:"waves"`
+		Total *external.WaitlistInviteWave   `json:"total"`
+	}
+	f.Bind(rr, &res)
+	f.ExpectDeepEq(len(res.Waves), 2)
+	f.ExpectDeepEq(res.Total.Invited, 2)
+	f.ExpectDeepEq(res.Total.SignedUp, 1)
+	f.ExpectDeepEq(res.Total.ConversionRate.String(), "0.5")
+
+	// only admins manage invites
+	player := f.GetAuthToken("player.user@ggwpacademy.com")
+	rr = f.AuthedRequest(http.MethodGet, "/api/v0.1/waitlist/invitations", "", player.AccessToken)
+	f.ExpectStatus(rr, http.StatusForbidden)
+}
@@ -0,0 +1,148 @@
+package external_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	external "github.com/johankaito/api.external/app"
+)
+
+func TestGetWaitlistStatus(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	joined := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	join := func(email, code string, referredBy *int) int {
+		var id int
+		f.ExpectNoError(f.DAO.DB.Get(
+			&id,
+			`
+				INSERT INTO ggwp.waitlist
+				(
+					email_address, owner_waitlist_code, original_waitlist_code_id, confirmed_at, created_at, updated_at
+				)
+				VALUES
+				(
+					$1, $2, $3, $4, $4, $4
+				)
+				RETURNING id
+			`,
+			email, code, referredBy, joined,
+		))
+		joined = joined.Add(time.Hour)
+		return id
+	}
+	join("a@ggwpacademy.com", "WAIT-000001", nil)
+	join("b@ggwpacademy.com", "WAIT-000002", nil)
+	join("c@ggwpacademy.com", "WAIT-000003", nil)
+	d := join("d@ggwpacademy.com", "WAIT-000004", nil)
+	join("e@ggwpacademy.com", "WAIT-000005", &d)
+
+	// d jumps the queue with their referral
+	rr := f.UnAuthedRequest(http.MethodGet, "/waitlist/status?code=WAIT-000004", "")
+	f.ExpectStatus(rr, http.StatusOK)
+	s := &external.WaitlistStatus{}
+	f.Bind(rr, s)
+	f.ExpectDeepEq(s, &external.WaitlistStatus{
+		WaitlistCode:  "WAIT-000004",
+		Position:      1,
+		ReferralCount: 1,
+		PeopleBehind:  4,
+		Total:         5,
+	})
+
+	rr = f.UnAuthedRequest(http.MethodGet, "/waitlist/status?code=WAIT-000002", "")
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, s)
+	f.ExpectDeepEq(s.Position, 3)
+	f.ExpectDeepEq(s.ReferralCount, 0)
+	f.ExpectDeepEq(s.PeopleBehind, 2)
+
+	rr = f.UnAuthedRequest(http.MethodGet, "/waitlist/status?code=WAIT-999999", "")
+	f.ExpectStatus(rr, http.StatusNotFound)
+	rr = f.UnAuthedRequest(http.MethodGet, "/waitlist/status", "")
+	f.ExpectStatus(rr, http.StatusBadRequest)
+}
+
+func TestWaitlistConfirmation(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	rr := f.UnAuthedRequest(http.MethodPost, "/waitlist/user", `{"email_address": "new.user@ggwpacademy.com"}`)
+	f.ExpectStatus(rr, http.StatusOK)
+	item := &external.WaitlistItem{}
+	f.Bind(rr, item)
+	f.ExpectDeepEq(item.OwnerWaitlistCode, "")
+	f.ExpectDeepEq(item.ConfirmedAt, (*time.Time)(nil))
+	f.ExpectRowCountWhere("ggwp.emails", "type = 'WAITLIST_CONFIRMATION'", 1)
+
+	// signing up again doesn't send another while one is on its way
+	rr = f.UnAuthedRequest(http.MethodPost, "/waitlist/user", `{"email_address": "new.user@ggwpacademy.com"}`)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCountWhere("ggwp.emails", "type = 'WAITLIST_CONFIRMATION'", 1)
+	_, err := f.DAO.DB.Exec(`UPDATE ggwp.emails SET status = 'SENT'`)
+	f.ExpectNoError(err)
+	rr = f.UnAuthedRequest(http.MethodPost, "/waitlist/user", `{"email_address": "new.user@ggwpacademy.com"}`)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCountWhere("ggwp.emails", "type = 'WAITLIST_CONFIRMATION'", 1)
+
+	// or was sent recently
+	_, err = f.DAO.DB.Exec(`UPDATE ggwp.emails SET created_at = NOW() - INTERVAL '1 hour'`)
+	f.ExpectNoError(err)
+	rr = f.UnAuthedRequest(http.MethodPost, "/waitlist/user", `{"email_address": "new.user@ggwpacademy.com"}`)
+	f.ExpectStatus(rr, http.StatusOK)
+	f.ExpectRowCountWhere("ggwp.emails", "type = 'WAITLIST_CONFIRMATION'", 2)
+	f.ExpectRowCountWhere("ggwp.waitlist", "owner_waitlist_code IS NOT NULL", 0)
+
+	var token string
+	f.ExpectNoError(f.DAO.DB.Get(&token, `SELECT confirmation_token FROM ggwp.waitlist`))
+	rr = f.UnAuthedRequest(http.MethodGet, "/waitlist/confirm?token="+token, "")
+	f.ExpectStatus(rr, http.StatusOK)
+	f.Bind(rr, item)
+	if item.OwnerWaitlistCode == "" || item.ConfirmedAt == nil {
+		t.Fatalf("expected a confirmed item with a code, got %+v", item)
+	}
+
+	rr = f.UnAuthedRequest(http.MethodGet, "/waitlist/status?code="+item.OwnerWaitlistCode, "")
+	f.ExpectStatus(rr, http.StatusOK)
+	rr = f.UnAuthedRequest(http.MethodGet, "/waitlist/confirm?token=unknown", "")
+	f.ExpectStatus(rr, http.StatusNotFound)
+}
+
+func TestPurgeUnconfirmedWaitlist(t *testing.T) {
+	f := NewFixture(t)
+	defer f.Close()
+
+	now := time.Now()
+	for _, i := range []struct {
+		email       string
+		confirmedAt *time.Time
+		createdAt   time.Time
+	}{
+		{"stale@ggwpacademy.com", nil, now.Add(-8 * 24 * time.Hour)},
+		{"recent@ggwpacademy.com", nil, now.Add(-time.Hour)},
+		{"confirmed@ggwpacademy.com", &now, now.Add(-30 * 24 * time.Hour)},
+	} {
+		_, err := f.DAO.DB.Exec(
+			`
+				INSERT INTO ggwp.waitlist
+				(
+					email_address, confirmation_token, confirmed_at, created_at, updated_at
+				)
+				VALUES
+				(
+					$1, $1, $2, $3, $3
+				)
+			`,
+			i.email, i.confirmedAt, i.createdAt,
+		)
+		f.ExpectNoError(err)
+	}
+
+	purged, err := f.Server.PurgeUnconfirmedWaitlist(now)
+	f.ExpectNoError(err)
+	f.ExpectDeepEq(purged, int64(1))
+	f.ExpectRowCountWhere("ggwp.waitlist", "email_address = 'stale@ggwpacademy.com'", 0)
+	f.ExpectRowCount("ggwp.waitlist", 2)
+}
@@ -0,0 +1,530 @@
+package external
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	XAPIVersion = "1.0.3"
+
+	xapiVersionHeader           = "X-Experience-API-Version"
+	xapiConsistentThroughHeader = "X-Experience-API-Consistent-Through"
+)
+
+var (
+	// default and maximum number of statements served per page
+	xapiDefaultStatementsLimit = 100
+	xapiMaxStatementsLimit     = 500
+
+	XAPIVerb_Progressed = &XAPIVerb{
+		ID:      "http://adlnet.gov/expapi/verbs/progressed",
+		Display: map[string]string{"en-US": "progressed"},
+	}
+	XAPIVerb_Completed = &XAPIVerb{
+		ID:      "http://adlnet.gov/expapi/verbs/completed",
+		Display: map[string]string{"en-US": "completed"},
+	}
+	XAPIVerb_Passed = &XAPIVerb{
+		ID:      "http://adlnet.gov/expapi/verbs/passed",
+		Display: map[string]string{"en-US": "passed"},
+	}
+	XAPIVerb_Failed = &XAPIVerb{
+		ID:      "http://adlnet.gov/expapi/verbs/failed",
+		Display: map[string]string{"en-US": "failed"},
+	}
+
+	xapiActivityType_Module     = "http://adlnet.gov/expapi/activities/module"
+	xapiActivityType_Media      = "http://adlnet.gov/expapi/activities/media"
+	xapiActivityType_Assessment = "http://adlnet.gov/expapi/activities/assessment"
+	xapiActivityType_Goal       = "http://id.tincanapi.com/activitytype/goal"
+)
+
+type XAPIAccount struct {
+	HomePage string `json:"homePage"`
+	Name     string `json:"name"`
+}
+
+type XAPIActor struct {
+	ObjectType string       `json:"objectType"`
+	Name       string       `json:"name,omitempty"`
+	Account    *XAPIAccount `json:"account,omitempty"`
+}
+
+type XAPIVerb struct {
+	ID      string            `json:"id"`
+	Display map[string]string `json:"display,omitempty"`
+}
+
+type XAPIActivityDefinition struct {
+	Type        string            `json:"type,omitempty"`
+	Name        map[string]string `json:"name,omitempty"`
+	Description map[string]string `json:"description,omitempty"`
+}
+
+type XAPIActivity struct {
+	ObjectType string                  `json:"objectType"`
+	ID         string                  `json:"id"`
+	Definition *XAPIActivityDefinition `json:"definition,omitempty"`
+}
+
+type XAPIScore struct {
+	Scaled float64 `json:"scaled"`
+	Raw    float64 `json:"raw"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+type XAPIResult struct {
+	Score      *XAPIScore             `json:"score,omitempty"`
+	Success    *bool                  `json:"success,omitempty"`
+	Completion *bool                  `json:"completion,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type XAPIContextActivities struct {
+	Parent []*XAPIActivity `json:"parent,omitempty"`
+}
+
+type XAPIContext struct {
+	Platform          string                 `json:"platform,omitempty"`
+	ContextActivities *XAPIContextActivities `json:"contextActivities,omitempty"`
+}
+
+// XAPIStatement is an xAPI (Tin Can) statement as served by the statements
+// endpoint and pushed to an external LRS.
+type XAPIStatement struct {
+	ID        string        `json:"id"`
+	Actor     *XAPIActor    `json:"actor"`
+	Verb      *XAPIVerb     `json:"verb"`
+	Object    *XAPIActivity `json:"object"`
+	Result    *XAPIResult   `json:"result,omitempty"`
+	Context   *XAPIContext  `json:"context,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	Stored    *time.Time    `json:"stored,omitempty"`
+	Version   string        `json:"version,omitempty"`
+
+	// not part of the statement, used to index it
+	UserID int `json:"-"`
+}
+
+type XAPIStatementResult struct {
+	Statements []*XAPIStatement `json:"statements"`
+	More       string           `json:"more"`
+}
+
+// XAPIStatementsQuery holds the filters supported by the statements endpoint.
+type XAPIStatementsQuery struct {
+	StatementID string
+	UserID      int
+	VerbID      string
+	ActivityID  string
+	Since       *time.Time
+	Until       *time.Time
+	Limit       int
+	Ascending   bool
+	Cursor      *XAPICursor
+}
+
+// XAPICursor points at the last statement of a page.
+type XAPICursor struct {
+	Stored time.Time
+	ID     string
+}
+
+func xapiHomePage() string {
+	if h := os.Getenv("XAPI_HOME_PAGE"); h != "" {
+		return strings.TrimRight(h, "/")
+	}
+	return "https://ggwpacademy.com"
+}
+
+func xapiActor(userID int) *XAPIActor {
+	return &XAPIActor{
+		ObjectType: "Agent",
+		Account: &XAPIAccount{
+			HomePage: xapiHomePage(),
+			Name:     strconv.Itoa(userID),
+		},
+	}
+}
+
+func xapiModuleActivity(moduleID int) *XAPIActivity {
+	return &XAPIActivity{
+		ObjectType: "Activity",
+		ID:         fmt.Sprintf("%s/modules/%d", xapiHomePage(), moduleID),
+		Definition: &XAPIActivityDefinition{Type: xapiActivityType_Module},
+	}
+}
+
+func xapiModuleFileActivity(moduleID, moduleFileRanking int) *XAPIActivity {
+	return &XAPIActivity{
+		ObjectType: "Activity",
+		ID:         fmt.Sprintf("%s/modules/%d/files/%d", xapiHomePage(), moduleID, moduleFileRanking),
+		Definition: &XAPIActivityDefinition{Type: xapiActivityType_Media},
+	}
+}
+
+func xapiExtension(name string) string {
+	return fmt.Sprintf("%s/xapi/extensions/%s", xapiHomePage(), name)
+}
+
+func xapiModuleContext(moduleID int) *XAPIContext {
+	return &XAPIContext{
+		Platform: "GGWP Academy",
+		ContextActivities: &XAPIContextActivities{
+			Parent: []*XAPIActivity{xapiModuleActivity(moduleID)},
+		},
+	}
+}
+
+// newXAPIStatementID gives a random (version 4) UUID.
+func newXAPIStatementID() (string, error) {
+	b, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func NewProgressedStatement(p *LearningProgress) *XAPIStatement {
+	seek, _ := p.Seek.Float64()
+	return &XAPIStatement{
+		UserID: int(p.UserID.Int64),
+		Actor:  xapiActor(int(p.UserID.Int64)),
+		Verb:   XAPIVerb_Progressed,
+		Object: xapiModuleFileActivity(p.ModuleID, p.ModuleFileRanking),
+		Result: &XAPIResult{
+			Extensions: map[string]interface{}{
+				xapiExtension("seek"): seek,
+			},
+		},
+		Context:   xapiModuleContext(p.ModuleID),
+		Timestamp: time.Now(),
+	}
+}
+
+func NewModuleFileCompletedStatement(p *ModuleFileProgress, moduleFileRanking int) *XAPIStatement {
+	completion := true
+	percentage, _ := p.CompletionPercentage.Float64()
+	watched, _ := p.WatchedSeconds.Float64()
+	timestamp := time.Now()
+	if p.CompletedAt != nil && p.CompletedAt.Valid {
+		timestamp = p.CompletedAt.Time
+	}
+	return &XAPIStatement{
+		UserID: int(p.UserID.Int64),
+		Actor:  xapiActor(int(p.UserID.Int64)),
+		Verb:   XAPIVerb_Completed,
+		Object: xapiModuleFileActivity(p.ModuleID, moduleFileRanking),
+		Result: &XAPIResult{
+			Completion: &completion,
+			Extensions: map[string]interface{}{
+				xapiExtension("completion_percentage"): percentage,
+				xapiExtension("watched_seconds"):       watched,
+			},
+		},
+		Context:   xapiModuleContext(p.ModuleID),
+		Timestamp: timestamp,
+	}
+}
+
+// NewQuizAttemptStatement summarises the gradings of a single quiz take.
+func NewQuizAttemptStatement(userID, moduleID int, quiz *Quiz, gradings []*QuizGrading) *XAPIStatement {
+	correct, total := QuizScore(gradings)
+	passed := QuizPassed(quiz, correct, total)
+	completion := true
+	verb := XAPIVerb_Failed
+	if passed {
+		verb = XAPIVerb_Passed
+	}
+	var scaled float64
+	if total > 0 {
+		scaled, _ = decimal.New(int64(correct), 0).
+			DivRound(decimal.New(int64(total), 0), 4).
+			Float64()
+	}
+	var takeNumber int
+	if len(gradings) > 0 {
+		takeNumber = gradings[0].TakeNumber
+	}
+
+	return &XAPIStatement{
+		UserID: userID,
+		Actor:  xapiActor(userID),
+		Verb:   verb,
+		Object: &XAPIActivity{
+			ObjectType: "Activity",
+			ID:         fmt.Sprintf("%s/modules/%d/quizzes/%d", xapiHomePage(), moduleID, quiz.ID),
+			Definition: &XAPIActivityDefinition{
+				Type: xapiActivityType_Assessment,
+				Name: map[string]string{"en-US": quiz.Name},
+			},
+		},
+		Result: &XAPIResult{
+			Score: &XAPIScore{
+				Scaled: scaled,
+				Raw:    float64(correct),
+				Min:    0,
+				Max:    float64(total),
+			},
+			Success:    &passed,
+			Completion: &completion,
+			Extensions: map[string]interface{}{
+				xapiExtension("take_number"): takeNumber,
+			},
+		},
+		Context:   xapiModuleContext(moduleID),
+		Timestamp: time.Now(),
+	}
+}
+
+func NewGoalCompletedStatement(g *UserGoal) *XAPIStatement {
+	completion := true
+	timestamp := time.Now()
+	if g.CompletedAt != nil && g.CompletedAt.Valid {
+		timestamp = g.CompletedAt.Time
+	}
+	return &XAPIStatement{
+		UserID: g.UserID,
+		Actor:  xapiActor(g.UserID),
+		Verb:   XAPIVerb_Completed,
+		Object: &XAPIActivity{
+			ObjectType: "Activity",
+			ID:         fmt.Sprintf("%s/goals/%d", xapiHomePage(), g.ID),
+			Definition: &XAPIActivityDefinition{
+				Type: xapiActivityType_Goal,
+				Name: map[string]string{"en-US": g.Description},
+			},
+		},
+		Result: &XAPIResult{
+			Completion: &completion,
+		},
+		Timestamp: timestamp,
+	}
+}
+
+// recordXAPIStatement stores a statement for a user, it is queued for the
+// external LRS at the same time. Statements without a user are ignored.
+func recordXAPIStatement(q Q, s *XAPIStatement) error {
+	if s.UserID <= 0 {
+		return nil
+	}
+	if s.ID == "" {
+		id, err := newXAPIStatementID()
+		if err != nil {
+			return errors.Wrap(err, "generating statement id")
+		}
+		s.ID = id
+	}
+	s.Version = XAPIVersion
+
+	if err := CreateXAPIStatement(q, s); err != nil {
+		return errors.Wrap(err, "creating xapi statement")
+	}
+
+	return nil
+}
+
+func (e *External) HandleGetXAPIStatements(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	user, err := GetUserByID(e.dao.ReadDB, userID)
+	if err != nil {
+		e.writeError(w, r, http.StatusForbidden, errors.Wrapf(err, "unknown user"))
+		return
+	}
+
+	query, err := parseXAPIStatementsQuery(r.URL.Query())
+	if err != nil {
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	// non admins only ever see their own statements
+	if strings.ToLower(user.UserAdminLevel) != "admin" {
+		query.UserID = userID
+	}
+
+	consistentThrough, err := GetServerTime(e.dao.ReadDB)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting server time"))
+		return
+	}
+
+	// fetch one extra statement to know if there is another page
+	limit := query.Limit
+	query.Limit++
+	statements, err := GetXAPIStatements(e.dao.ReadDB, query)
+	if err != nil {
+		e.writeError(w, r, http.StatusInternalServerError, errors.Wrap(err, "getting xapi statements"))
+		return
+	}
+
+	res := &XAPIStatementResult{Statements: statements}
+	if len(statements) > limit {
+		res.Statements = statements[:limit]
+		last := res.Statements[limit-1]
+		more := *r.URL
+		params := more.Query()
+		params.Set("cursor", encodeXAPICursor(&XAPICursor{Stored: *last.Stored, ID: last.ID}))
+		more.RawQuery = params.Encode()
+		res.More = more.RequestURI()
+	}
+
+	if query.StatementID != "" {
+		if len(res.Statements) == 0 {
+			e.writeError(w, r, http.StatusNotFound, fmt.Errorf("unknown statement: %s", query.StatementID))
+			return
+		}
+		e.returnXAPI(w, consistentThrough, res.Statements[0])
+		return
+	}
+
+	e.returnXAPI(w, consistentThrough, res)
+}
+
+// returnXAPI writes a bare (not wrapped in a message) xAPI resource.
+func (e *External) returnXAPI(w http.ResponseWriter, consistentThrough time.Time, a interface{}) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		b = []byte("json marshal error")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(xapiVersionHeader, XAPIVersion)
+	w.Header().Set(xapiConsistentThroughHeader, consistentThrough.UTC().Format(time.RFC3339Nano))
+	if _, err := w.Write(b); err != nil {
+		e.log.WithError(err).Error("writing xapi json")
+	}
+}
+
+func parseXAPIStatementsQuery(v url.Values) (*XAPIStatementsQuery, error) {
+	query := &XAPIStatementsQuery{
+		StatementID: v.Get("statementId"),
+		VerbID:      v.Get("verb"),
+		ActivityID:  v.Get("activity"),
+		Limit:       xapiDefaultStatementsLimit,
+		Ascending:   v.Get("ascending") == "true",
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"since", &query.Since},
+		{"until", &query.Until},
+	} {
+		raw := v.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s timestamp: %q", p.name, raw)
+		}
+		*p.dst = &t
+	}
+
+	if raw := v.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit: %q", raw)
+		}
+		// zero means the server maximum
+		if limit == 0 || limit > xapiMaxStatementsLimit {
+			limit = xapiMaxStatementsLimit
+		}
+		query.Limit = limit
+	}
+
+	if raw := v.Get("cursor"); raw != "" {
+		c, err := decodeXAPICursor(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %q", raw)
+		}
+		query.Cursor = c
+	}
+
+	return query, nil
+}
+
+func encodeXAPICursor(c *XAPICursor) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%d:%s", c.Stored.UnixNano(), c.ID)),
+	)
+}
+
+func decodeXAPICursor(raw string) (*XAPICursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	n, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &XAPICursor{Stored: time.Unix(0, n), ID: parts[1]}, nil
+}
+
+// PushXAPIStatements sends a batch of pending statements to the external LRS.
+// Failed batches are retried later with an exponential backoff.
+func (e *External) PushXAPIStatements(ctx context.Context) (int, error) {
+	if e.lrs == nil {
+		return 0, nil
+	}
+
+	tx, err := e.dao.GetTx(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin tx")
+	}
+	defer tx.Rollback()
+
+	statements, err := ClaimPendingXAPIStatements(tx, XAPI_PUSH_BATCH_SIZE, XAPI_PUSH_MAX_ATTEMPTS)
+	if err != nil {
+		return 0, errors.Wrap(err, "claiming pending xapi statements")
+	}
+	if len(statements) == 0 {
+		return 0, nil
+	}
+
+	ids := []string{}
+	for _, s := range statements {
+		ids = append(ids, s.ID)
+	}
+
+	if sendErr := e.lrs.SendStatements(ctx, statements); sendErr != nil {
+		if err := MarkXAPIStatementsPushFailed(tx, ids, sendErr.Error(), XAPI_PUSH_BACKOFF, XAPI_PUSH_MAX_BACKOFF); err != nil {
+			return 0, errors.Wrap(err, "marking xapi statements as failed")
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, errors.Wrap(err, "commiting failed xapi push")
+		}
+		return 0, errors.Wrap(sendErr, "sending statements to lrs")
+	}
+
+	if err := MarkXAPIStatementsPushed(tx, ids); err != nil {
+		return 0, errors.Wrap(err, "marking xapi statements as pushed")
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commiting xapi push")
+	}
+
+	return len(statements), nil
+}
@@ -0,0 +1,225 @@
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type storedXAPIStatement struct {
+	Statement []byte    `db:"statement"`
+	Stored    time.Time `db:"stored"`
+}
+
+func (s *storedXAPIStatement) toStatement() (*XAPIStatement, error) {
+	st := &XAPIStatement{}
+	if err := json.Unmarshal(s.Statement, st); err != nil {
+		return nil, err
+	}
+	stored := s.Stored
+	st.Stored = &stored
+
+	return st, nil
+}
+
+func CreateXAPIStatement(q Q, s *XAPIStatement) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.Exec(
+		`
+			INSERT INTO ggwp.xapi_statements
+			(
+				id, user_id, verb_id, activity_id, statement, timestamp, stored
+			)
+			VALUES
+			(
+				$1, $2, $3, $4, $5, $6, NOW()
+			)
+		`,
+		s.ID,
+		s.UserID,
+		s.Verb.ID,
+		s.Object.ID,
+		b,
+		s.Timestamp,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetXAPIStatements(q Q, query *XAPIStatementsQuery) ([]*XAPIStatement, error) {
+	where := []string{"TRUE"}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.StatementID != "" {
+		where = append(where, fmt.Sprintf("CAST(id AS TEXT) = %s", arg(query.StatementID)))
+	}
+	if query.UserID > 0 {
+		where = append(where, fmt.Sprintf("user_id = %s", arg(query.UserID)))
+	}
+	if query.VerbID != "" {
+		where = append(where, fmt.Sprintf("verb_id = %s", arg(query.VerbID)))
+	}
+	if query.ActivityID != "" {
+		where = append(where, fmt.Sprintf("activity_id = %s", arg(query.ActivityID)))
+	}
+	if query.Since != nil {
+		where = append(where, fmt.Sprintf("stored > %s", arg(*query.Since)))
+	}
+	if query.Until != nil {
+		where = append(where, fmt.Sprintf("stored <= %s", arg(*query.Until)))
+	}
+
+	order := "DESC"
+	cmp := "<"
+	if query.Ascending {
+		order = "ASC"
+		cmp = ">"
+	}
+	if query.Cursor != nil {
+		where = append(where, fmt.Sprintf(
+			"(stored, CAST(id AS TEXT)) %s (%s, %s)",
+			cmp,
+			arg(query.Cursor.Stored),
+			arg(query.Cursor.ID),
+		))
+	}
+
+	var rows []*storedXAPIStatement
+	if err := q.Select(
+		&rows,
+		fmt.Sprintf(
+			`
+				SELECT
+					statement,
+					stored
+				FROM
+					ggwp.xapi_statements
+				WHERE
+					%s
+				ORDER BY
+					stored %s, CAST(id AS TEXT) %s
+				LIMIT %s
+			`,
+			strings.Join(where, " AND "),
+			order,
+			order,
+			arg(query.Limit),
+		),
+		args...,
+	); err != nil {
+		return nil, err
+	}
+
+	statements := []*XAPIStatement{}
+	for _, r := range rows {
+		s, err := r.toStatement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, s)
+	}
+
+	return statements, nil
+}
+
+// ClaimPendingXAPIStatements locks statements waiting to be pushed to the LRS,
+// skipping rows another worker is already pushing.
+func ClaimPendingXAPIStatements(q Q, limit, maxAttempts int) ([]*XAPIStatement, error) {
+	var rows []*storedXAPIStatement
+	if err := q.Select(
+		&rows,
+		`
+			SELECT
+				statement,
+				stored
+			FROM
+				ggwp.xapi_statements
+			WHERE
+				lrs_pushed_at IS NULL
+				AND lrs_attempts < $2
+				AND lrs_next_attempt_at <= NOW()
+			ORDER BY
+				stored
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		`,
+		limit,
+		maxAttempts,
+	); err != nil {
+		return nil, err
+	}
+
+	statements := []*XAPIStatement{}
+	for _, r := range rows {
+		s, err := r.toStatement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, s)
+	}
+
+	return statements, nil
+}
+
+func MarkXAPIStatementsPushed(q Q, ids []string) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.xapi_statements
+			SET
+				lrs_pushed_at = NOW(),
+				lrs_attempts = lrs_attempts + 1,
+				lrs_last_error = NULL
+			WHERE CAST(id AS TEXT) = ANY($1)
+		`,
+		pq.Array(ids),
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkXAPIStatementsPushFailed records a failed push and schedules the next
+// attempt, doubling the wait after every attempt up to maxBackoff.
+func MarkXAPIStatementsPushFailed(
+	q Q,
+	ids []string,
+	lastError string,
+	backoff,
+	maxBackoff time.Duration,
+) error {
+	if _, err := q.Exec(
+		`
+			UPDATE ggwp.xapi_statements
+			SET
+				lrs_attempts = lrs_attempts + 1,
+				lrs_last_error = $2,
+				lrs_next_attempt_at = NOW() + LEAST(
+					$3 * POWER(2, lrs_attempts),
+					$4
+				) * INTERVAL '1 second'
+			WHERE CAST(id AS TEXT) = ANY($1)
+		`,
+		pq.Array(ids),
+		lastError,
+		backoff.Seconds(),
+		maxBackoff.Seconds(),
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -1,6 +1,12 @@
 package main
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
 
 type Config struct {
 	listenPort            string
@@ -12,9 +18,37 @@ type Config struct {
 	twitterConsumerSecret string
 	twitterTokenURL       string
 	allowedOrigins        []string
+	lrsEndpoint           string
+	lrsUsername           string
+	lrsPassword           string
+	emailBackend          string
+	mailgunDomain         string
+	mailgunAPIKey         string
+	smtpAddr              string
+	smtpUsername          string
+	smtpPassword          string
+	maildir               string
+	emailUnsubscribeKey   string
+	baseURL               string
+	waitlistGateEnabled   bool
 }
 
 func getConfig() (*Config, error) {
+	var waitlistGateEnabled bool
+	if v := os.Getenv("WAITLIST_GATE_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing WAITLIST_GATE_ENABLED")
+		}
+		waitlistGateEnabled = enabled
+	}
+
+	// the web app is served at the base url and the api under /api/v0.1
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://www.ggwpacademy.com"
+	}
+
 	return &Config{
 		listenPort:            os.Getenv("LISTEN_PORT"),
 		tlsCertFile:           os.Getenv("TLS_CERT"),
@@ -24,5 +58,18 @@ func getConfig() (*Config, error) {
 		twitterConsumerKey:    os.Getenv("TWITTER_CONSUMER_KEY"),
 		twitterConsumerSecret: os.Getenv("TWITTER_CONSUMER_SECRET"),
 		twitterTokenURL:       os.Getenv("TWITTER_TOKEN_URL"),
+		lrsEndpoint:           os.Getenv("LRS_ENDPOINT"),
+		lrsUsername:           os.Getenv("LRS_USERNAME"),
+		lrsPassword:           os.Getenv("LRS_PASSWORD"),
+		emailBackend:          os.Getenv("EMAIL_BACKEND"),
+		mailgunDomain:         os.Getenv("MAIL_GUN_DOMAIN"),
+		mailgunAPIKey:         os.Getenv("MAIL_GUN_API_KEY"),
+		smtpAddr:              os.Getenv("SMTP_ADDR"),
+		smtpUsername:          os.Getenv("SMTP_USERNAME"),
+		smtpPassword:          os.Getenv("SMTP_PASSWORD"),
+		maildir:               os.Getenv("MAILDIR"),
+		emailUnsubscribeKey:   os.Getenv("EMAIL_UNSUBSCRIBE_KEY"),
+		baseURL:               strings.TrimSuffix(baseURL, "/"),
+		waitlistGateEnabled:   waitlistGateEnabled,
 	}, nil
 }
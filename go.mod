@@ -1,9 +1,8 @@
 module github.com/johankaito/api.external
 
-go 1.13
+go 1.16
 
 require (
-	github.com/AmirSoleimani/VoucherCodeGenerator v0.0.0-20181028081015-0c43963f4349
 	github.com/DATA-DOG/go-txdb v0.1.3
 	github.com/DataDog/datadog-go v3.2.0+incompatible // indirect
 	github.com/Go-SQL-Driver/MySQL v1.4.1 // indirect
@@ -8,6 +8,7 @@ import (
 	"github.com/dghubble/oauth1"
 	fb "github.com/huandu/facebook"
 	external "github.com/johankaito/api.external/app"
+	"github.com/mailgun/mailgun-go/v3"
 	"github.com/sirupsen/logrus"
 )
 
@@ -44,7 +45,46 @@ func main() {
 		},
 	}
 
-	e := external.New(logger, dao, facebook, twitter)
+	// statements are only pushed when an external lrs is configured
+	var lrs external.LRS
+	if cfg.lrsEndpoint != "" {
+		lrs = &external.LRSClient{
+			Endpoint: cfg.lrsEndpoint,
+			Username: cfg.lrsUsername,
+			Password: cfg.lrsPassword,
+		}
+	}
+
+	// emails go out through mailgun unless another backend is configured,
+	// the sink keeps them in memory, or in a maildir when MAILDIR is set
+	var email external.EmailSender
+	switch cfg.emailBackend {
+	case "smtp":
+		email = &external.SMTPSender{
+			Addr:     cfg.smtpAddr,
+			Username: cfg.smtpUsername,
+			Password: cfg.smtpPassword,
+		}
+	case "sink":
+		email = external.NewEmailSink(cfg.maildir)
+	case "", "mailgun":
+		email = &external.MailgunSender{
+			MG: mailgun.NewMailgun(cfg.mailgunDomain, cfg.mailgunAPIKey),
+		}
+	default:
+		logger.Fatalf("unknown email backend: %s", cfg.emailBackend)
+	}
+
+	// unsubscribe links are signed with their own key, they can't be made
+	// without one
+	if cfg.emailUnsubscribeKey == "" {
+		logger.Warn("no EMAIL_UNSUBSCRIBE_KEY, unsubscribe links won't work")
+	}
+
+	e := external.New(
+		logger, dao, facebook, twitter, lrs, email,
+		cfg.emailUnsubscribeKey, cfg.baseURL, cfg.waitlistGateEnabled,
+	)
 	h, _, err := external.Router(e, logger, cfg.allowedOrigins)
 	if err != nil {
 		logger.WithError(err).Fatal("listening and serving")
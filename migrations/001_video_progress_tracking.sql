@@ -0,0 +1,63 @@
+-- Heartbeat style video progress events, folded into one aggregate row per
+-- user (or anonymous device) and module file.
+
+CREATE TABLE ggwp.learning_progress_events (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES ggwp.users(id),
+	device_unique_id TEXT,
+	module_id INTEGER NOT NULL REFERENCES ggwp.modules(id),
+	module_file_id INTEGER NOT NULL REFERENCES ggwp.module_files(id),
+	type TEXT NOT NULL CHECK (type IN ('PLAY', 'PAUSE', 'SEEK', 'ENDED', 'RATE_CHANGE', 'HEARTBEAT')),
+	position NUMERIC NOT NULL DEFAULT 0,
+	playback_rate NUMERIC,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	folded_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX learning_progress_events_unfolded_idx
+	ON ggwp.learning_progress_events (module_file_id, user_id, device_unique_id)
+	WHERE folded_at IS NULL;
+CREATE INDEX learning_progress_events_folded_at_idx
+	ON ggwp.learning_progress_events (folded_at)
+	WHERE folded_at IS NOT NULL;
+
+CREATE TABLE ggwp.module_file_progresses (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES ggwp.users(id),
+	device_unique_id TEXT,
+	module_id INTEGER NOT NULL REFERENCES ggwp.modules(id),
+	module_file_id INTEGER NOT NULL REFERENCES ggwp.module_files(id),
+	watched_intervals JSONB NOT NULL DEFAULT '[]',
+	watched_seconds NUMERIC NOT NULL DEFAULT 0,
+	completion_percentage NUMERIC NOT NULL DEFAULT 0,
+	resume_position NUMERIC NOT NULL DEFAULT 0,
+	playback_rate NUMERIC NOT NULL DEFAULT 1,
+	is_playing BOOLEAN NOT NULL DEFAULT FALSE,
+	last_event_at TIMESTAMPTZ,
+	completed_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX module_file_progresses_user_idx
+	ON ggwp.module_file_progresses (user_id, module_file_id)
+	WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX module_file_progresses_device_idx
+	ON ggwp.module_file_progresses (device_unique_id, module_file_id)
+	WHERE user_id IS NULL;
+
+-- learning_progresses now holds a single, latest row per owner and module file
+DELETE FROM ggwp.learning_progresses l
+USING ggwp.learning_progresses newer
+WHERE l.module_id = newer.module_id
+	AND l.module_file_ranking = newer.module_file_ranking
+	AND (
+		(l.user_id IS NOT NULL AND l.user_id = newer.user_id)
+		OR (
+			l.user_id IS NULL
+			AND newer.user_id IS NULL
+			AND l.device_unique_id = newer.device_unique_id
+		)
+	)
+	AND (l.created_at, l.id) < (newer.created_at, newer.id);
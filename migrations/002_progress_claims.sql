@@ -0,0 +1,17 @@
+-- Audit trail of anonymous device progress attached to a user on sign up or
+-- login.
+
+CREATE TABLE ggwp.progress_claims (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	device_unique_id TEXT NOT NULL,
+	source TEXT NOT NULL CHECK (source IN ('SIGN_UP', 'SOCIAL_SIGN_UP', 'LOGIN', 'SOCIAL_LOGIN')),
+	learning_progress_claimed INTEGER NOT NULL DEFAULT 0,
+	learning_progress_merged INTEGER NOT NULL DEFAULT 0,
+	file_progress_claimed INTEGER NOT NULL DEFAULT 0,
+	file_progress_merged INTEGER NOT NULL DEFAULT 0,
+	progress_events_claimed INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX progress_claims_user_id_idx ON ggwp.progress_claims (user_id);
@@ -0,0 +1,18 @@
+-- Idempotency log of events applied through the offline batch sync.
+
+CREATE TABLE ggwp.sync_events (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	idempotency_key TEXT NOT NULL,
+	type TEXT NOT NULL CHECK (type IN ('PROGRESS_UPDATE', 'QUIZ_SUBMISSION', 'GOAL_COMPLETION')),
+	payload JSONB,
+	result JSONB,
+	client_created_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, idempotency_key)
+);
+
+-- sync deltas are read by owner and last update
+CREATE INDEX IF NOT EXISTS learning_progresses_user_updated_idx ON ggwp.learning_progresses (user_id, updated_at);
+CREATE INDEX IF NOT EXISTS quiz_gradings_user_updated_idx ON ggwp.quiz_gradings (user_id, updated_at);
+CREATE INDEX IF NOT EXISTS user_goals_user_updated_idx ON ggwp.user_goals (user_id, updated_at);
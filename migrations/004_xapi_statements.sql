@@ -0,0 +1,21 @@
+-- xAPI statements generated from learning progress, quiz attempts and goal
+-- completions. Rows not yet pushed to the external LRS form its outbox.
+
+CREATE TABLE ggwp.xapi_statements (
+	id UUID PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	verb_id TEXT NOT NULL,
+	activity_id TEXT NOT NULL,
+	statement JSONB NOT NULL,
+	timestamp TIMESTAMPTZ NOT NULL,
+	stored TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	lrs_pushed_at TIMESTAMPTZ,
+	lrs_attempts INTEGER NOT NULL DEFAULT 0,
+	lrs_next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	lrs_last_error TEXT
+);
+
+CREATE INDEX xapi_statements_stored_idx ON ggwp.xapi_statements (stored, id);
+CREATE INDEX xapi_statements_user_stored_idx ON ggwp.xapi_statements (user_id, stored);
+CREATE INDEX xapi_statements_outbox_idx ON ggwp.xapi_statements (lrs_next_attempt_at)
+	WHERE lrs_pushed_at IS NULL;
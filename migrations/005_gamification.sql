@@ -0,0 +1,71 @@
+-- XP rewarded for domain events, levels reached with it and badges unlocked
+-- as events arrive. A source (module file, quiz, goal, referral) is rewarded
+-- once per user and event type.
+
+CREATE TABLE ggwp.xp_rules (
+	event_type TEXT PRIMARY KEY,
+	xp INTEGER NOT NULL CHECK (xp >= 0),
+	is_active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO ggwp.xp_rules (event_type, xp) VALUES
+	('MODULE_FILE_COMPLETED', 10),
+	('QUIZ_PASSED_FIRST_TAKE', 50),
+	('GOAL_COMPLETED', 25),
+	('REFERRAL_REDEEMED', 100);
+
+CREATE TABLE ggwp.levels (
+	level INTEGER PRIMARY KEY CHECK (level > 0),
+	name TEXT NOT NULL,
+	xp_required INTEGER NOT NULL UNIQUE CHECK (xp_required >= 0)
+);
+
+INSERT INTO ggwp.levels (level, name, xp_required) VALUES
+	(1, 'Rookie', 0),
+	(2, 'Amateur', 100),
+	(3, 'Semi-Pro', 300),
+	(4, 'Pro', 700),
+	(5, 'Captain', 1500),
+	(6, 'Legend', 3000);
+
+CREATE TABLE ggwp.badges (
+	id SERIAL PRIMARY KEY,
+	code TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	condition_type TEXT NOT NULL,
+	event_type TEXT,
+	threshold INTEGER NOT NULL,
+	is_active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO ggwp.badges (code, name, description, condition_type, event_type, threshold) VALUES
+	('FIRST_STEPS', 'First Steps', 'Finish your first module file', 'EVENT_COUNT', 'MODULE_FILE_COMPLETED', 1),
+	('BINGE_WATCHER', 'Binge Watcher', 'Finish 25 module files', 'EVENT_COUNT', 'MODULE_FILE_COMPLETED', 25),
+	('QUIZ_WHIZ', 'Quiz Whiz', 'Pass 5 quizzes on the first take', 'EVENT_COUNT', 'QUIZ_PASSED_FIRST_TAKE', 5),
+	('GOAL_GETTER', 'Goal Getter', 'Complete a goal', 'EVENT_COUNT', 'GOAL_COMPLETED', 1),
+	('TEAM_BUILDER', 'Team Builder', 'Have 3 friends redeem your referral code', 'EVENT_COUNT', 'REFERRAL_REDEEMED', 3),
+	('PRO', 'Pro', 'Reach level 4', 'LEVEL', NULL, 4);
+
+CREATE TABLE ggwp.xp_ledger (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	event_type TEXT NOT NULL,
+	source_key TEXT NOT NULL,
+	xp INTEGER NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, event_type, source_key)
+);
+
+CREATE INDEX xp_ledger_user_created_idx ON ggwp.xp_ledger (user_id, created_at);
+
+CREATE TABLE ggwp.user_badges (
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	badge_id INTEGER NOT NULL REFERENCES ggwp.badges(id),
+	earned_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, badge_id)
+);
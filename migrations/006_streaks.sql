@@ -0,0 +1,18 @@
+-- Daily learning streaks, counted in the timezone of each user.
+
+ALTER TABLE ggwp.users ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+
+CREATE TABLE ggwp.user_streaks (
+	user_id INTEGER PRIMARY KEY REFERENCES ggwp.users(id),
+	current_streak INTEGER NOT NULL DEFAULT 0,
+	longest_streak INTEGER NOT NULL DEFAULT 0,
+	freezes_available INTEGER NOT NULL DEFAULT 0,
+	freezes_used INTEGER NOT NULL DEFAULT 0,
+	last_activity_date DATE,
+	reminder_sent_on DATE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX user_streaks_last_activity_date_idx ON ggwp.user_streaks (last_activity_date)
+	WHERE current_streak > 0;
@@ -0,0 +1,36 @@
+-- Leaderboard scores maintained as learning events arrive, per metric, scope
+-- (global, module, category) and period. Team boards rank the global scores of
+-- their members.
+
+ALTER TABLE ggwp.users ADD COLUMN leaderboard_opt_out BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE ggwp.teams (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE ggwp.team_members (
+	team_id INTEGER NOT NULL REFERENCES ggwp.teams(id),
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (team_id, user_id)
+);
+
+CREATE INDEX team_members_user_id_idx ON ggwp.team_members (user_id);
+
+CREATE TABLE ggwp.leaderboard_scores (
+	metric TEXT NOT NULL CHECK (metric IN ('XP', 'QUIZZES_PASSED', 'WATCH_TIME')),
+	scope TEXT NOT NULL CHECK (scope IN ('GLOBAL', 'MODULE', 'CATEGORY')),
+	scope_id INTEGER NOT NULL DEFAULT 0,
+	period TEXT NOT NULL CHECK (period IN ('WEEKLY', 'MONTHLY', 'ALL_TIME')),
+	period_start DATE NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	score DOUBLE PRECISION NOT NULL DEFAULT 0,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (metric, scope, scope_id, period, period_start, user_id)
+);
+
+CREATE INDEX leaderboard_scores_rank_idx ON ggwp.leaderboard_scores
+	(metric, scope, scope_id, period, period_start, score DESC);
@@ -0,0 +1,29 @@
+-- Quantities checked in toward goals and the periods they add up in. Daily and
+-- weekly goals get a period per day or week, completed or missed once it ends,
+-- one off goals a single period.
+
+CREATE TABLE ggwp.goal_checkins (
+	id SERIAL PRIMARY KEY,
+	goal_id INTEGER NOT NULL REFERENCES ggwp.user_goals(id),
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	quantity NUMERIC NOT NULL CHECK (quantity > 0),
+	note TEXT NOT NULL DEFAULT '',
+	period_start DATE NOT NULL,
+	checked_in_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX goal_checkins_goal_period_idx ON ggwp.goal_checkins (goal_id, period_start);
+
+CREATE TABLE ggwp.goal_periods (
+	goal_id INTEGER NOT NULL REFERENCES ggwp.user_goals(id),
+	period_start DATE NOT NULL,
+	period_end DATE,
+	total NUMERIC NOT NULL DEFAULT 0,
+	target NUMERIC NOT NULL DEFAULT 0,
+	status TEXT NOT NULL CHECK (status IN ('IN_PROGRESS', 'COMPLETED', 'MISSED')),
+	completed_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (goal_id, period_start)
+);
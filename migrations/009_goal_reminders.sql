@@ -0,0 +1,23 @@
+-- Reminders of goal deadlines and of recurring goal periods about to close,
+-- sent through the email queue. Times of day are minutes since midnight in
+-- the user's timezone.
+
+CREATE TABLE ggwp.goal_reminder_settings (
+	user_id INTEGER PRIMARY KEY REFERENCES ggwp.users(id),
+	reminder_time INTEGER NOT NULL DEFAULT 1080 CHECK (reminder_time BETWEEN 0 AND 1439),
+	quiet_hours_start INTEGER CHECK (quiet_hours_start BETWEEN 0 AND 1439),
+	quiet_hours_end INTEGER CHECK (quiet_hours_end BETWEEN 0 AND 1439),
+	unsubscribed BOOLEAN NOT NULL DEFAULT FALSE,
+	unsubscribe_token TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE ggwp.goal_reminders (
+	id SERIAL PRIMARY KEY,
+	goal_id INTEGER NOT NULL REFERENCES ggwp.user_goals(id),
+	kind TEXT NOT NULL CHECK (kind IN ('DEADLINE', 'PERIOD_CLOSING')),
+	reminder_key DATE NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (goal_id, kind, reminder_key)
+);
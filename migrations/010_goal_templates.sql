@@ -0,0 +1,15 @@
+-- Goal templates managed by admins, with the defaults of the goals created
+-- from them.
+
+ALTER TABLE ggwp.user_goal_templates
+	ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS default_value NUMERIC NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS default_rate TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS unit TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS suggested_duration_days INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE ggwp.user_goals
+	ADD COLUMN template_id INTEGER REFERENCES ggwp.user_goal_templates(id);
+
+CREATE INDEX user_goals_template_id_idx ON ggwp.user_goals (template_id);
@@ -0,0 +1,8 @@
+-- Deleted goals are archived by clearing is_active. No goal was archived
+-- before, so every existing goal is active.
+
+UPDATE ggwp.user_goals SET is_active = TRUE WHERE is_active IS NOT TRUE;
+
+ALTER TABLE ggwp.user_goals
+	ALTER COLUMN is_active SET DEFAULT TRUE,
+	ALTER COLUMN is_active SET NOT NULL;
@@ -0,0 +1,15 @@
+-- Secret tokened calendar feeds of goals and module unlocks. Regenerating the
+-- token revokes the previous feed url.
+
+CREATE TABLE ggwp.calendar_feeds (
+	user_id INTEGER PRIMARY KEY REFERENCES ggwp.users(id),
+	token TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- modules can be scheduled to unlock in the future
+ALTER TABLE ggwp.modules
+	ADD COLUMN unlocks_at TIMESTAMPTZ;
+
+CREATE INDEX modules_unlocks_at_idx ON ggwp.modules (unlocks_at) WHERE unlocks_at IS NOT NULL;
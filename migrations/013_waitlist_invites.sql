@@ -0,0 +1,21 @@
+-- Waves of invitations letting people off the waitlist. Every invite carries
+-- a single use token which signing up redeems.
+
+CREATE TABLE ggwp.waitlist_invite_waves (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_by INTEGER REFERENCES ggwp.users(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE ggwp.waitlist_invites (
+	id SERIAL PRIMARY KEY,
+	wave_id INTEGER NOT NULL REFERENCES ggwp.waitlist_invite_waves(id),
+	waitlist_id INTEGER NOT NULL UNIQUE REFERENCES ggwp.waitlist(id),
+	token TEXT NOT NULL UNIQUE,
+	user_id INTEGER REFERENCES ggwp.users(id),
+	redeemed_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX waitlist_invites_wave_id_idx ON ggwp.waitlist_invites (wave_id);
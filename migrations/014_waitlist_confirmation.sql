@@ -0,0 +1,10 @@
+-- Waitlist entries are confirmed through a link emailed to them before they
+-- get a code or a place in the queue. Entries from before are confirmed.
+
+ALTER TABLE ggwp.waitlist
+	ADD COLUMN confirmation_token TEXT UNIQUE,
+	ADD COLUMN confirmed_at TIMESTAMPTZ;
+
+UPDATE ggwp.waitlist SET confirmed_at = created_at WHERE confirmed_at IS NULL;
+
+CREATE INDEX waitlist_unconfirmed_created_at_idx ON ggwp.waitlist (created_at) WHERE confirmed_at IS NULL;
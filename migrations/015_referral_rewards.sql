@@ -0,0 +1,49 @@
+-- Benefits granted when someone signs up with a referral code, to the owner of
+-- the code (REFERRER) and to whoever signed up with it (REFEREE). XP rewards
+-- take their amount from xp_rules, module unlocks name the module unlocked.
+
+CREATE TABLE ggwp.referral_reward_rules (
+	id SERIAL PRIMARY KEY,
+	recipient TEXT NOT NULL,
+	reward_type TEXT NOT NULL,
+	module_id INTEGER REFERENCES ggwp.modules(id),
+	is_active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	CHECK ((reward_type = 'MODULE_UNLOCK') = (module_id IS NOT NULL))
+);
+
+INSERT INTO ggwp.referral_reward_rules (recipient, reward_type) VALUES
+	('REFERRER', 'XP'),
+	('REFEREE', 'XP');
+
+INSERT INTO ggwp.xp_rules (event_type, xp) VALUES
+	('REFERRAL_SIGN_UP', 50);
+
+CREATE TABLE ggwp.referral_rewards (
+	id SERIAL PRIMARY KEY,
+	redemption_id INTEGER NOT NULL REFERENCES ggwp.referral_redemptions(id),
+	rule_id INTEGER NOT NULL REFERENCES ggwp.referral_reward_rules(id),
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	recipient TEXT NOT NULL,
+	reward_type TEXT NOT NULL,
+	xp INTEGER NOT NULL DEFAULT 0,
+	module_id INTEGER REFERENCES ggwp.modules(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (redemption_id, rule_id)
+);
+
+CREATE INDEX referral_rewards_user_id_idx ON ggwp.referral_rewards (user_id);
+
+-- modules yet to unlock, see modules.unlocks_at, the user was given early
+CREATE TABLE ggwp.user_module_unlocks (
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id),
+	module_id INTEGER NOT NULL REFERENCES ggwp.modules(id),
+	source_key TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, module_id)
+);
+
+-- a user signs up with at most one referral code
+CREATE UNIQUE INDEX referral_redemptions_referral_user_id_idx
+	ON ggwp.referral_redemptions (referral_user_id);
@@ -0,0 +1,59 @@
+-- Codes are generated at random and retried on collision, which needs the
+-- database to turn duplicates down.
+
+-- Referral and waitlist codes are shared and have redemptions and sign ups
+-- pointing at them, so duplicates can't be dropped here. List them with
+--
+--   SELECT referral_code, array_agg(id) FROM ggwp.referral_codes
+--   GROUP BY referral_code HAVING COUNT(*) > 1;
+--
+--   SELECT owner_waitlist_code, array_agg(id) FROM ggwp.waitlist
+--   GROUP BY owner_waitlist_code HAVING COUNT(*) > 1;
+--
+-- and give all but one of each a new code before running this again.
+DO $$
+DECLARE
+	duplicates TEXT;
+BEGIN
+	SELECT string_agg(referral_code || ' (ids ' || ids || ')', ', ')
+	INTO duplicates
+	FROM (
+		SELECT referral_code, array_to_string(array_agg(id ORDER BY id), ', ') AS ids
+		FROM ggwp.referral_codes
+		GROUP BY referral_code
+		HAVING COUNT(*) > 1
+	) d;
+	IF duplicates IS NOT NULL THEN
+		RAISE EXCEPTION 'duplicate referral codes: %', duplicates;
+	END IF;
+
+	SELECT string_agg(owner_waitlist_code || ' (ids ' || ids || ')', ', ')
+	INTO duplicates
+	FROM (
+		SELECT owner_waitlist_code, array_to_string(array_agg(id ORDER BY id), ', ') AS ids
+		FROM ggwp.waitlist
+		GROUP BY owner_waitlist_code
+		HAVING COUNT(*) > 1
+	) d;
+	IF duplicates IS NOT NULL THEN
+		RAISE EXCEPTION 'duplicate waitlist codes: %', duplicates;
+	END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS referral_codes_referral_code_idx
+	ON ggwp.referral_codes (referral_code);
+
+CREATE UNIQUE INDEX IF NOT EXISTS waitlist_owner_waitlist_code_idx
+	ON ggwp.waitlist (owner_waitlist_code);
+
+-- A password reset token is only good for a while and a new one can be asked
+-- for, so of duplicates only the newest is kept.
+DELETE FROM ggwp.user_password_reset a
+USING ggwp.user_password_reset b
+WHERE
+	a.token = b.token
+	AND a.id < b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_password_reset_token_idx
+	ON ggwp.user_password_reset (token);
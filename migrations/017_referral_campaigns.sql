@@ -0,0 +1,21 @@
+-- Referral codes defined by admins next to the one every user gets: campaign
+-- codes, owned by nobody, and vanity codes owned by an influencer. Either may
+-- run between dates and unlock a module for whoever signs up with it.
+
+ALTER TABLE ggwp.referral_codes
+	ALTER COLUMN user_id DROP NOT NULL,
+	ADD COLUMN kind TEXT NOT NULL DEFAULT 'USER',
+	ADD COLUMN name TEXT,
+	ADD COLUMN starts_at TIMESTAMPTZ,
+	ADD COLUMN ends_at TIMESTAMPTZ,
+	ADD COLUMN module_id INTEGER REFERENCES ggwp.modules(id),
+	ADD COLUMN created_by INTEGER REFERENCES ggwp.users(id),
+	ADD CHECK (starts_at IS NULL OR ends_at IS NULL OR starts_at < ends_at);
+
+CREATE INDEX referral_codes_kind_idx ON ggwp.referral_codes (kind) WHERE kind <> 'USER';
+
+-- campaign module unlocks aren't granted by a rule
+ALTER TABLE ggwp.referral_rewards
+	ALTER COLUMN rule_id DROP NOT NULL;
+
+CREATE INDEX waitlist_original_referral_code_id_idx ON ggwp.waitlist (original_referral_code_id);
@@ -0,0 +1,16 @@
+-- The emails table is an outbox. Senders claim pending rows by marking them
+-- PROCESSING until processing_expires_at, so an instance dying mid send
+-- doesn't hold them forever. Failed sends back off exponentially until they
+-- run out of attempts and end up FAILED, waiting for an admin to retry or
+-- discard them.
+
+ALTER TABLE ggwp.emails
+	ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	ADD COLUMN processing_expires_at TIMESTAMPTZ,
+	ADD COLUMN last_error TEXT;
+
+CREATE INDEX emails_outbox_idx ON ggwp.emails (next_attempt_at, id)
+	WHERE status IN ('PENDING', 'PROCESSING');
+CREATE INDEX emails_failed_idx ON ggwp.emails (updated_at)
+	WHERE status = 'FAILED';
@@ -0,0 +1,37 @@
+-- Delivery events reported through the Mailgun webhook. Each event is kept
+-- once, Mailgun retries webhooks which weren't acknowledged, and rolled up on
+-- the email it is about. Bounces, complaints and unsubscribes put the address
+-- on the suppression list, which the email queue checks before sending.
+
+ALTER TABLE ggwp.emails
+	ADD COLUMN delivered_at TIMESTAMPTZ,
+	ADD COLUMN opened_at TIMESTAMPTZ,
+	ADD COLUMN open_count INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN clicked_at TIMESTAMPTZ,
+	ADD COLUMN click_count INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN bounced_at TIMESTAMPTZ,
+	ADD COLUMN complained_at TIMESTAMPTZ,
+	ADD COLUMN unsubscribed_at TIMESTAMPTZ;
+
+CREATE TABLE ggwp.email_events (
+	id SERIAL PRIMARY KEY,
+	provider_event_id TEXT NOT NULL UNIQUE,
+	email_id INTEGER REFERENCES ggwp.emails(id) ON DELETE SET NULL,
+	email_address TEXT NOT NULL,
+	event TEXT NOT NULL,
+	severity TEXT,
+	reason TEXT,
+	url TEXT,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX email_events_email_id_idx ON ggwp.email_events (email_id);
+CREATE INDEX email_events_email_address_idx ON ggwp.email_events (lower(email_address), occurred_at);
+
+CREATE TABLE ggwp.email_suppressions (
+	email_address TEXT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	email_event_id INTEGER REFERENCES ggwp.email_events(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
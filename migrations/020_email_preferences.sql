@@ -0,0 +1,11 @@
+-- The categories of email users turned off or back on, users get every
+-- category without a row. Transactional emails, like password resets, have
+-- no category to turn off.
+
+CREATE TABLE ggwp.user_email_preferences (
+	user_id INTEGER NOT NULL REFERENCES ggwp.users(id) ON DELETE CASCADE,
+	category TEXT NOT NULL,
+	subscribed BOOLEAN NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, category)
+);
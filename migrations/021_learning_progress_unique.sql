@@ -0,0 +1,24 @@
+-- learning_progresses keeps a single row per owner and module file, which is
+-- now enforced so concurrent progress updates can't both insert. Rows
+-- duplicated since 001 are removed first, keeping the latest.
+
+DELETE FROM ggwp.learning_progresses l
+USING ggwp.learning_progresses newer
+WHERE l.module_id = newer.module_id
+	AND l.module_file_ranking = newer.module_file_ranking
+	AND (
+		(l.user_id IS NOT NULL AND l.user_id = newer.user_id)
+		OR (
+			l.user_id IS NULL
+			AND newer.user_id IS NULL
+			AND l.device_unique_id = newer.device_unique_id
+		)
+	)
+	AND (l.created_at, l.id) < (newer.created_at, newer.id);
+
+CREATE UNIQUE INDEX learning_progresses_user_idx
+	ON ggwp.learning_progresses (user_id, module_id, module_file_ranking)
+	WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX learning_progresses_device_idx
+	ON ggwp.learning_progresses (device_unique_id, module_id, module_file_ranking)
+	WHERE user_id IS NULL;
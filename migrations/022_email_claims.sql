@@ -0,0 +1,8 @@
+-- Each claim of an email gets a token, and the sender only records the
+-- outcome of emails it still holds the claim of. A sender whose lease ran out
+-- can't overwrite what the instance that claimed the email after it did.
+-- Attempts are counted when claimed, so emails crashing their sender run out
+-- of attempts too.
+
+ALTER TABLE ggwp.emails
+	ADD COLUMN claim_token TEXT;
@@ -0,0 +1,16 @@
+-- Goal reminders are unsubscribed from through the REMINDERS email
+-- preference, like every other reminder email. Users who stopped goal
+-- reminders through the old link keep them stopped.
+
+INSERT INTO ggwp.user_email_preferences
+(
+	user_id, category, subscribed, updated_at
+)
+SELECT user_id, 'REMINDERS', FALSE, NOW()
+FROM ggwp.goal_reminder_settings
+WHERE unsubscribed
+ON CONFLICT (user_id, category) DO NOTHING;
+
+ALTER TABLE ggwp.goal_reminder_settings
+	DROP COLUMN unsubscribed,
+	DROP COLUMN unsubscribe_token;
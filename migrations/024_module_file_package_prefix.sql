@@ -0,0 +1,5 @@
+-- The assets of imported packages are uploaded under a folder per module,
+-- keeping their paths within the package, so launch files can link to them.
+
+ALTER TABLE ggwp.module_files
+	ADD COLUMN package_prefix TEXT;
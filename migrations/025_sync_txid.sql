@@ -0,0 +1,32 @@
+-- Sync deltas are read by the transaction that last wrote each row rather than
+-- by updated_at. NOW() is when a transaction started, so a sync could hand out
+-- a token past the rows of a transaction that started before it but committed
+-- after, and those rows were never sent. Sync tokens now hold the oldest
+-- transaction still running, rows at or past it are sent whatever order their
+-- transactions commit in, clients drop what they already have.
+
+CREATE FUNCTION ggwp.set_sync_txid() RETURNS TRIGGER AS $$
+BEGIN
+	NEW.sync_txid := txid_current();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE ggwp.learning_progresses ADD COLUMN sync_txid BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ggwp.module_file_progresses ADD COLUMN sync_txid BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ggwp.quiz_gradings ADD COLUMN sync_txid BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ggwp.user_goals ADD COLUMN sync_txid BIGINT NOT NULL DEFAULT 0;
+
+CREATE TRIGGER learning_progresses_sync_txid BEFORE INSERT OR UPDATE ON ggwp.learning_progresses
+	FOR EACH ROW EXECUTE PROCEDURE ggwp.set_sync_txid();
+CREATE TRIGGER module_file_progresses_sync_txid BEFORE INSERT OR UPDATE ON ggwp.module_file_progresses
+	FOR EACH ROW EXECUTE PROCEDURE ggwp.set_sync_txid();
+CREATE TRIGGER quiz_gradings_sync_txid BEFORE INSERT OR UPDATE ON ggwp.quiz_gradings
+	FOR EACH ROW EXECUTE PROCEDURE ggwp.set_sync_txid();
+CREATE TRIGGER user_goals_sync_txid BEFORE INSERT OR UPDATE ON ggwp.user_goals
+	FOR EACH ROW EXECUTE PROCEDURE ggwp.set_sync_txid();
+
+CREATE INDEX learning_progresses_user_sync_txid_idx ON ggwp.learning_progresses (user_id, sync_txid);
+CREATE INDEX module_file_progresses_user_sync_txid_idx ON ggwp.module_file_progresses (user_id, sync_txid);
+CREATE INDEX quiz_gradings_user_sync_txid_idx ON ggwp.quiz_gradings (user_id, sync_txid);
+CREATE INDEX user_goals_user_sync_txid_idx ON ggwp.user_goals (user_id, sync_txid);